@@ -0,0 +1,113 @@
+package api
+
+import (
+	"backend/db"
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"time"
+)
+
+// accountDeletionGracePeriod is how long a user has to change their mind after delete_account
+const accountDeletionGracePeriod = 30 * 24 * time.Hour
+
+// deactivateAccount hides the authenticated user's profile, posts and comments
+// and logs them out everywhere. Logging in again reactivates the account.
+func (ar *apiRequest) deactivateAccount(request *ConfirmPasswordRequest) {
+	user, ok := ar.reauthenticate(request.Password)
+	if !ok {
+		return
+	}
+
+	if err := ar.db.DeactivateUser(user.Id); err != nil {
+		log.Printf("Failed to deactivate user %d: %v", user.Id, err)
+		ar.setError(http.StatusInternalServerError, "Failed to deactivate account")
+		return
+	}
+
+	ar.logOutEverywhere(user.Id)
+	log.Printf("User %d deactivated their account", user.Id)
+	ar.recordEvent(user.Id, EventAccountDeactivated, nil)
+
+	response := map[string]string{
+		"message": "Account deactivated, log in again to reactivate it",
+	}
+
+	responseJSON, err := json.Marshal(response)
+	if err != nil {
+		log.Printf("Error marshalling deactivate account response: %v", err)
+		ar.setError(http.StatusInternalServerError, "Internal server error")
+		return
+	}
+
+	ar.response = string(responseJSON)
+}
+
+// deleteAccount deactivates the authenticated user's account and deletes it for good
+// once the grace period is over. Logging in before then cancels the deletion.
+func (ar *apiRequest) deleteAccount(request *ConfirmPasswordRequest) {
+	user, ok := ar.reauthenticate(request.Password)
+	if !ok {
+		return
+	}
+
+	deleteAfter := time.Now().Add(accountDeletionGracePeriod)
+	if err := ar.db.ScheduleUserDeletion(user.Id, deleteAfter); err != nil {
+		log.Printf("Failed to schedule deletion of user %d: %v", user.Id, err)
+		ar.setError(http.StatusInternalServerError, "Failed to delete account")
+		return
+	}
+
+	ar.logOutEverywhere(user.Id)
+
+	sendEmail(Email{
+		To:      user.Email,
+		Subject: "Your account will be deleted",
+		Body: fmt.Sprintf("Hi %s,\n\nYour account and everything you posted will be deleted on %s. "+
+			"If you change your mind, just log in before then.", user.FirstName, deleteAfter.Format("January 2, 2006")),
+	})
+
+	log.Printf("User %d scheduled their account for deletion after %s", user.Id, deleteAfter.Format(time.RFC3339))
+	ar.recordEvent(user.Id, EventAccountDeletionScheduled, map[string]interface{}{"deleteAfter": deleteAfter})
+
+	response := map[string]interface{}{
+		"message":     "Account scheduled for deletion, log in before then to cancel",
+		"deleteAfter": deleteAfter.UTC(),
+	}
+
+	responseJSON, err := json.Marshal(response)
+	if err != nil {
+		log.Printf("Error marshalling delete account response: %v", err)
+		ar.setError(http.StatusInternalServerError, "Internal server error")
+		return
+	}
+
+	ar.response = string(responseJSON)
+}
+
+// logOutEverywhere revokes every session and token of a user and closes their websockets
+func (ar *apiRequest) logOutEverywhere(userID int) {
+	if err := revokeOtherLogins(userID, 0); err != nil {
+		log.Printf("Failed to revoke logins of user %d: %v", userID, err)
+	}
+	ClearSessionCookie(ar.httpWriter)
+}
+
+// DeleteScheduledAccounts permanently deletes the accounts whose deletion grace period is over
+func DeleteScheduledAccounts() {
+	ids, err := db.Connection.FetchUsersDueForDeletion()
+	if err != nil {
+		log.Printf("Error fetching accounts due for deletion: %v", err)
+		return
+	}
+
+	for _, id := range ids {
+		if err := db.Connection.DeleteUserAccount(id); err != nil {
+			log.Printf("Error deleting account of user %d: %v", id, err)
+			continue
+		}
+		Sessions.uncacheWhere(func(s *Session) bool { return s.UserID == id })
+		log.Printf("Deleted account of user %d", id)
+	}
+}
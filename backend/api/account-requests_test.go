@@ -0,0 +1,145 @@
+package api
+
+import (
+	"backend/db"
+	"fmt"
+	"net/http"
+	"strings"
+	"testing"
+	"time"
+)
+
+// TestAccountDeletionCleansUp gives a user rows in every table accountDeletionSteps cleans,
+// deletes the account and checks that only what belongs to others is left
+func TestAccountDeletionCleansUp(t *testing.T) {
+	raw := rawDatabase(t)
+	insert := func(query string, args ...any) int64 {
+		t.Helper()
+		result, err := raw.Exec(query, args...)
+		if err != nil {
+			t.Fatalf("Failed to set up %q: %v", query, err)
+		}
+		id, _ := result.LastInsertId()
+		return id
+	}
+	count := func(query string, args ...any) int {
+		t.Helper()
+		var n int
+		if err := raw.QueryRow(query, args...).Scan(&n); err != nil {
+			t.Fatalf("Failed to check %q: %v", query, err)
+		}
+		return n
+	}
+	file := func() string {
+		t.Helper()
+		return fmt.Sprint(insert(`INSERT INTO file (data, filename, mimetype) VALUES (x'00', 'a.png', 'image/png')`))
+	}
+
+	userID, email := newUser(t)
+	otherID, _ := newUser(t)
+	invitedID, _ := newUser(t)
+	// Several user columns are TEXT, the rows are written the way the app writes them
+	user, other, invited := fmt.Sprint(userID), fmt.Sprint(otherID), fmt.Sprint(invitedID)
+
+	deleted, session := login(t, email)
+	w := callAction(t, map[string]string{"action": "delete_account", "password": "123"}, sessionHeader(deleted), session)
+	if w.Code != http.StatusOK {
+		t.Fatalf("Expected the deletion to be scheduled, got %d: %s", w.Code, w.Body.String())
+	}
+
+	picture, postImage, commentImage := file(), file(), file()
+	insert(`UPDATE user SET profile_picture = ? WHERE id = ?`, picture, userID)
+
+	post := insert(`INSERT INTO posts (user_id, content, image_path) VALUES (?, 'mine', ?)`, user, "/file?id="+postImage)
+	otherPost := insert(`INSERT INTO posts (user_id, content) VALUES (?, 'theirs')`, other)
+	insert(`INSERT INTO comments (post_id, user_id, content, image_path) VALUES (?, ?, 'mine', ?)`, fmt.Sprint(otherPost), user, "/file?id="+commentImage)
+	insert(`INSERT INTO comments (post_id, user_id, content) VALUES (?, ?, 'theirs on mine')`, fmt.Sprint(post), other)
+	insert(`INSERT INTO likes (post_id, user_id) VALUES (?, ?), (?, ?)`, otherPost, userID, post, otherID)
+	insert(`INSERT INTO post_permissions (post_id, user_id) VALUES (?, ?), (?, ?)`, post, otherID, otherPost, userID)
+	insert(`INSERT INTO notifications (user_id, type, message, sender_id) VALUES (?, 'like', '', ?), (?, 'comment', '', ?)`,
+		userID, otherID, otherID, userID)
+	insert(`INSERT INTO follows (follower_id, followed_id, status) VALUES (?, ?, 'accepted'), (?, ?, 'pending')`, user, other, other, user)
+
+	group := insert(`INSERT INTO groups (creator_id, title, description) VALUES (?, 'mine', '')`, user)
+	otherGroup := insert(`INSERT INTO groups (creator_id, title, description) VALUES (?, 'theirs', '')`, other)
+	insert(`INSERT INTO group_members (group_id, user_id, status) VALUES (?, ?, 'accepted'), (?, ?, 'accepted')`,
+		fmt.Sprint(group), other, fmt.Sprint(otherGroup), user)
+	insert(`INSERT INTO group_members (group_id, user_id, status, invited_by) VALUES (?, ?, 'invited', ?)`, fmt.Sprint(otherGroup), invited, user)
+	event := insert(`INSERT INTO events (group_id, creator_id, title, description, event_date) VALUES (?, ?, 'in my group', '', datetime('now'))`,
+		fmt.Sprint(group), other)
+	myEvent := insert(`INSERT INTO events (group_id, creator_id, title, description, event_date) VALUES (?, ?, 'mine', '', datetime('now'))`,
+		fmt.Sprint(otherGroup), user)
+	insert(`INSERT INTO notifications (user_id, type, message, related_id) VALUES (?, 'group_invitation', '', ?), (?, 'event_created', '', ?), (?, 'event_created', '', ?)`,
+		invitedID, group, invitedID, event, invitedID, myEvent)
+
+	shared := insert(`INSERT INTO conversation (type) VALUES ('direct')`)
+	alone := insert(`INSERT INTO conversation (type) VALUES ('direct')`)
+	insert(`INSERT INTO conversation_participant (user, conversation) VALUES (?, ?), (?, ?), (?, ?)`, userID, shared, otherID, shared, userID, alone)
+	insert(`INSERT INTO message (conversation, sender, content) VALUES (?, ?, 'mine'), (?, ?, 'theirs'), (?, ?, 'to nobody')`,
+		shared, userID, shared, otherID, alone, userID)
+
+	// Logins made after the deletion was scheduled, e.g. with a token issued just before
+	expires := time.Now().Add(time.Hour).UTC().Format("2006-01-02 15:04:05")
+	insert(`INSERT INTO sessions (user_id, data, expires_at, token_hash) VALUES (?, '{}', ?, ?)`, user, expires, GenerateSessionID())
+	insert(`INSERT INTO password_reset_tokens (user_id, token_hash, expires_at) VALUES (?, ?, ?)`, userID, GenerateSessionID(), expires)
+	insert(`INSERT INTO email_verification_tokens (user_id, email, token_hash, expires_at) VALUES (?, ?, ?, ?)`, userID, email, GenerateSessionID(), expires)
+	insert(`INSERT INTO recovery_codes (user_id, code_hash) VALUES (?, ?)`, userID, GenerateSessionID())
+	insert(`INSERT INTO user_totp (user_id, secret) VALUES (?, 'JBSWY3DPEHPK3PXP')`, userID)
+	insert(`INSERT INTO personal_access_tokens (user_id, name, token_hash, scopes) VALUES (?, 'bot', ?, 'posts:read')`, userID, GenerateSessionID())
+	insert(`INSERT INTO user_identities (user_id, issuer, subject) VALUES (?, 'https://idp.test', ?)`, userID, GenerateSessionID())
+	insert(`INSERT INTO login_attempts (email, ip) VALUES (?, '192.0.2.1')`, strings.ToLower(email))
+
+	// The grace period is over
+	if err := db.Connection.ScheduleUserDeletion(userID, time.Now().Add(-time.Minute)); err != nil {
+		t.Fatal(err)
+	}
+	DeleteScheduledAccounts()
+
+	files := strings.Join([]string{picture, postImage, commentImage}, ",")
+	tests := []struct {
+		name  string
+		query string
+		args  []any
+		want  int
+	}{
+		{"profile picture and images", `SELECT COUNT(*) FROM file WHERE id IN (` + files + `)`, nil, 0},
+		{"notifications", `SELECT COUNT(*) FROM notifications WHERE user_id = ? OR sender_id = ?`, []any{userID, userID}, 0},
+		{"likes", `SELECT COUNT(*) FROM likes WHERE user_id = ? OR post_id = ?`, []any{userID, post}, 0},
+		{"comments", `SELECT COUNT(*) FROM comments WHERE user_id = ? OR post_id = ?`, []any{user, fmt.Sprint(post)}, 0},
+		{"post permissions", `SELECT COUNT(*) FROM post_permissions WHERE user_id = ? OR post_id = ?`, []any{userID, post}, 0},
+		{"posts", `SELECT COUNT(*) FROM posts WHERE user_id = ?`, []any{user}, 0},
+		{"follows", `SELECT COUNT(*) FROM follows WHERE follower_id = ? OR followed_id = ?`, []any{user, user}, 0},
+		{"group and event notifications", `SELECT COUNT(*) FROM notifications WHERE user_id = ?`, []any{invitedID}, 0},
+		{"events", `SELECT COUNT(*) FROM events WHERE id IN (?, ?)`, []any{event, myEvent}, 0},
+		{"created groups and their members", `SELECT COUNT(*) FROM group_members WHERE group_id = ?`, []any{fmt.Sprint(group)}, 0},
+		{"groups", `SELECT COUNT(*) FROM groups WHERE creator_id = ?`, []any{user}, 0},
+		{"group memberships", `SELECT COUNT(*) FROM group_members WHERE user_id = ?`, []any{user}, 0},
+		{"group invitations", `SELECT COUNT(*) FROM group_members WHERE invited_by = ?`, []any{user}, 0},
+		{"sent messages", `SELECT COUNT(*) FROM message WHERE sender = ?`, []any{userID}, 0},
+		{"conversation participation", `SELECT COUNT(*) FROM conversation_participant WHERE user = ?`, []any{userID}, 0},
+		{"abandoned messages", `SELECT COUNT(*) FROM message WHERE conversation = ?`, []any{alone}, 0},
+		{"abandoned conversations", `SELECT COUNT(*) FROM conversation WHERE id = ?`, []any{alone}, 0},
+		{"sessions", `SELECT COUNT(*) FROM sessions WHERE user_id = ?`, []any{user}, 0},
+		{"password reset tokens", `SELECT COUNT(*) FROM password_reset_tokens WHERE user_id = ?`, []any{userID}, 0},
+		{"email verification tokens", `SELECT COUNT(*) FROM email_verification_tokens WHERE user_id = ?`, []any{userID}, 0},
+		{"recovery codes", `SELECT COUNT(*) FROM recovery_codes WHERE user_id = ?`, []any{userID}, 0},
+		{"totp secret", `SELECT COUNT(*) FROM user_totp WHERE user_id = ?`, []any{userID}, 0},
+		{"personal access tokens", `SELECT COUNT(*) FROM personal_access_tokens WHERE user_id = ?`, []any{userID}, 0},
+		{"linked identities", `SELECT COUNT(*) FROM user_identities WHERE user_id = ?`, []any{userID}, 0},
+		{"login attempts", `SELECT COUNT(*) FROM login_attempts WHERE email = ?`, []any{strings.ToLower(email)}, 0},
+		{"user", `SELECT COUNT(*) FROM user WHERE id = ?`, []any{userID}, 0},
+
+		// What belongs to others stays
+		{"post of another user", `SELECT COUNT(*) FROM posts WHERE id = ?`, []any{otherPost}, 1},
+		{"group of another user", `SELECT COUNT(*) FROM groups WHERE id = ?`, []any{otherGroup}, 1},
+		{"invitation to another group, without the inviter", `SELECT COUNT(*) FROM group_members WHERE user_id = ? AND invited_by IS NULL`, []any{invited}, 1},
+		{"shared conversation, without the sender", `SELECT COUNT(*) FROM message WHERE conversation = ? AND (sender IS NULL OR sender = ?)`, []any{shared, otherID}, 2},
+		{"security events", `SELECT COUNT(*) FROM security_events WHERE user_id = ? AND type = ?`, []any{userID, EventAccountDeletionScheduled}, 1},
+	}
+
+	for _, test := range tests {
+		if got := count(test.query, test.args...); got != test.want {
+			t.Errorf("%s: expected %d rows, got %d", test.name, test.want, got)
+		}
+	}
+}
@@ -0,0 +1,286 @@
+package api
+
+import (
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"reflect"
+)
+
+// Auth is who may call an action
+type Auth string
+
+const (
+	AuthUser     Auth = "user"     // authenticated users, the default
+	AuthPublic   Auth = "public"   // anyone, also without credentials
+	AuthVerified Auth = "verified" // authenticated users who verified their email address
+)
+
+// actionSpec describes an action of the API: what it takes and answers, and who may call it
+type actionSpec struct {
+	name       string
+	summary    string
+	auth       Auth
+	permission Permission // role permission the caller needs, if any
+	scope      string     // personal access token scope that allows the action, tokens can't call it without one
+
+	// transactional actions make all their changes through apiRequest.db, so they can run in an atomic batch
+	transactional bool
+	// setsCookies actions answer with a cookie the client needs, like the session of a login.
+	// A batch has no place for the cookies of its actions, so they can't be part of one.
+	setsCookies bool
+
+	request  reflect.Type // nil if the action takes no parameters
+	response reflect.Type // what the handler answers with when it succeeds
+	run      func(ar *apiRequest)
+}
+
+// actionRegistry holds the actions by name, in the order they were registered
+type actionRegistry struct {
+	specs map[string]*actionSpec
+	names []string
+}
+
+// actions is filled by init below, the Router and REST routes dispatch through it
+var actions = &actionRegistry{specs: map[string]*actionSpec{}}
+
+func (reg *actionRegistry) add(spec actionSpec) {
+	if _, ok := reg.specs[spec.name]; ok {
+		panic(fmt.Sprintf("action %s is registered twice", spec.name))
+	}
+	if spec.auth == "" {
+		spec.auth = AuthUser
+	}
+	if spec.auth == AuthPublic && spec.permission != "" {
+		panic(fmt.Sprintf("public action %s can't require a permission", spec.name))
+	}
+
+	reg.specs[spec.name] = &spec
+	reg.names = append(reg.names, spec.name)
+}
+
+func (reg *actionRegistry) lookup(name string) (*actionSpec, bool) {
+	spec, ok := reg.specs[name]
+	return spec, ok
+}
+
+// all returns the actions in registration order
+func (reg *actionRegistry) all() []*actionSpec {
+	specs := make([]*actionSpec, len(reg.names))
+	for i, name := range reg.names {
+		specs[i] = reg.specs[name]
+	}
+	return specs
+}
+
+// registerAction adds an action whose handler takes a request of type Req and answers with Resp.
+// The request is decoded and checked against its validate tags before the handler runs.
+func registerAction[Resp any, Req any](reg *actionRegistry, spec actionSpec, handler func(*apiRequest, *Req)) {
+	spec.request = reflect.TypeFor[Req]()
+	spec.response = reflect.TypeFor[Resp]()
+	spec.run = func(ar *apiRequest) {
+		var request Req
+		if err := json.Unmarshal([]byte(ar.requestBody), &request); err != nil {
+			ar.setErrorCode(http.StatusBadRequest, CodeInvalidBody, "Invalid request body")
+			return
+		}
+
+		if err := validateRequest(&request); err != nil {
+			ar.setValidationError(err)
+			return
+		}
+
+		handler(ar, &request)
+	}
+	reg.add(spec)
+}
+
+// registerSimpleAction adds an action that takes no parameters and answers with Resp
+func registerSimpleAction[Resp any](reg *actionRegistry, spec actionSpec, handler func(*apiRequest)) {
+	spec.response = reflect.TypeFor[Resp]()
+	spec.run = handler
+	reg.add(spec)
+}
+
+func init() {
+	reg := actions
+
+	// Accounts and logins
+	registerAction[loginResponse](reg, actionSpec{name: "signup", auth: AuthPublic, setsCookies: true,
+		summary: "Create an account and log in"}, (*apiRequest).signup)
+	registerAction[loginResponse](reg, actionSpec{name: "login", auth: AuthPublic, setsCookies: true,
+		summary: "Log in with email and password. With 2FA enabled the answer is a 2fa_required challenge for login_2fa instead"}, (*apiRequest).login)
+	registerAction[loginResponse](reg, actionSpec{name: "login_2fa", auth: AuthPublic, setsCookies: true,
+		summary: "Finish a login with the challenge token and a TOTP or recovery code"}, (*apiRequest).login2FA)
+	registerSimpleAction[oidcStartResponse](reg, actionSpec{name: "oidc_start", auth: AuthPublic, setsCookies: true,
+		summary: "Start a login with the OIDC provider"}, (*apiRequest).oidcStart)
+	registerAction[loginResponse](reg, actionSpec{name: "oidc_callback", auth: AuthPublic, setsCookies: true,
+		summary: "Finish a login with the OIDC provider"}, (*apiRequest).oidcCallback)
+	registerSimpleAction[csrfTokenResponse](reg, actionSpec{name: "get_csrf_token", auth: AuthPublic,
+		summary: "Get the CSRF token of the session cookie"}, (*apiRequest).getCSRFToken)
+	registerAction[messageResponse](reg, actionSpec{name: "logout",
+		summary: "Log out the session and revoke the tokens sent with the request"}, (*apiRequest).logout)
+	registerAction[tokenResponse](reg, actionSpec{name: "refresh_token", auth: AuthPublic,
+		summary: "Exchange a refresh token for new tokens, each refresh token works once"}, (*apiRequest).refreshToken)
+	registerAction[messageResponse](reg, actionSpec{name: "request_password_reset", auth: AuthPublic,
+		summary: "Email a password reset link"}, (*apiRequest).requestPasswordReset)
+	registerAction[messageResponse](reg, actionSpec{name: "reset_password", auth: AuthPublic,
+		summary: "Set a new password with a reset token, logging out everywhere"}, (*apiRequest).resetPassword)
+	registerAction[messageResponse](reg, actionSpec{name: "verify_email", auth: AuthPublic,
+		summary: "Verify an email address with the token from the verification email"}, (*apiRequest).verifyEmail)
+	registerSimpleAction[messageResponse](reg, actionSpec{name: "resend_verification",
+		summary: "Send a new verification email"}, (*apiRequest).resendVerification)
+	registerAction[newTokensResponse](reg, actionSpec{name: "change_password",
+		summary: "Change the password, logging out the other sessions"}, (*apiRequest).changePassword)
+	registerAction[newTokensResponse](reg, actionSpec{name: "change_email",
+		summary: "Change the email address once the new one is verified"}, (*apiRequest).changeEmail)
+	registerAction[messageResponse](reg, actionSpec{name: "deactivate_account",
+		summary: "Deactivate the account until the next login"}, (*apiRequest).deactivateAccount)
+	registerAction[accountDeletionResponse](reg, actionSpec{name: "delete_account",
+		summary: "Delete the account after a grace period, logging in cancels it"}, (*apiRequest).deleteAccount)
+	registerSimpleAction[enrollTwoFactorResponse](reg, actionSpec{name: "enroll_2fa",
+		summary: "Start enabling 2FA, returning the secret for the authenticator app"}, (*apiRequest).enroll2FA)
+	registerAction[recoveryCodesResponse](reg, actionSpec{name: "confirm_2fa",
+		summary: "Enable 2FA with a first code, returning the recovery codes"}, (*apiRequest).confirm2FA)
+	registerAction[messageResponse](reg, actionSpec{name: "disable_2fa",
+		summary: "Disable 2FA, with the password and a TOTP or recovery code"}, (*apiRequest).disable2FA)
+
+	// Sessions, tokens and the security log
+	registerSimpleAction[sessionsResponse](reg, actionSpec{name: "list_sessions",
+		summary: "List the active sessions"}, (*apiRequest).listSessions)
+	registerAction[messageResponse](reg, actionSpec{name: "revoke_session",
+		summary: "Log out one session"}, (*apiRequest).revokeSession)
+	registerSimpleAction[revokedSessionsResponse](reg, actionSpec{name: "revoke_all_other_sessions",
+		summary: "Log out every session except this one"}, (*apiRequest).revokeAllOtherSessions)
+	registerAction[createdTokenResponse](reg, actionSpec{name: "create_token",
+		summary: "Create a personal access token, returned only this once"}, (*apiRequest).createToken)
+	registerSimpleAction[tokensResponse](reg, actionSpec{name: "list_tokens", transactional: true,
+		summary: "List the personal access tokens"}, (*apiRequest).listTokens)
+	registerAction[messageResponse](reg, actionSpec{name: "revoke_token", transactional: true,
+		summary: "Revoke a personal access token"}, (*apiRequest).revokeToken)
+	registerAction[securityEventsResponse](reg, actionSpec{name: "get_security_events", transactional: true,
+		summary: "List your own security events, newest first"}, (*apiRequest).getSecurityEvents)
+
+	// Moderation
+	registerAction[usersResponse](reg, actionSpec{name: "list_users", permission: PermissionListUsers,
+		summary: "List or search users"}, (*apiRequest).listUsers)
+	registerAction[messageResponse](reg, actionSpec{name: "suspend_user", permission: PermissionSuspendUser,
+		summary: "Suspend a user or lift their suspension"}, (*apiRequest).suspendUser)
+	registerAction[roleResponse](reg, actionSpec{name: "set_user_role", permission: PermissionEditRoles,
+		summary: "Change the role of a user"}, (*apiRequest).setUserRole)
+	registerAction[securityEventsResponse](reg, actionSpec{name: "query_security_events", permission: PermissionQuerySecurityEvents,
+		summary: "Search the security events of every user"}, (*apiRequest).querySecurityEvents)
+
+	// Profiles and follows
+	registerAction[userProfileResponse](reg, actionSpec{name: "get_user_profile", transactional: true, scope: ScopeProfileRead,
+		summary: "Get the profile of a user"}, (*apiRequest).getUserProfile)
+	registerAction[avatarResponse](reg, actionSpec{name: "upload_avatar",
+		summary: "Upload a new profile picture"}, (*apiRequest).uploadAvatar)
+	registerAction[messageResponse](reg, actionSpec{name: "update_profile", transactional: true,
+		summary: "Update your profile"}, (*apiRequest).updateProfile)
+	registerAction[followersResponse](reg, actionSpec{name: "get_followers", transactional: true, scope: ScopeProfileRead,
+		summary: "List the followers of a user, or your own"}, (*apiRequest).getFollowersForUser)
+	registerAction[followingResponse](reg, actionSpec{name: "get_following", transactional: true, scope: ScopeProfileRead,
+		summary: "List who a user follows, or who you follow"}, (*apiRequest).getFollowing)
+	registerAction[toggleFollowResponse](reg, actionSpec{name: "toggle_follow", transactional: true,
+		summary: "Follow or unfollow a user, private profiles get a follow request"}, (*apiRequest).toggleFollow)
+	registerSimpleAction[followRequestsResponse](reg, actionSpec{name: "get_follow_requests", transactional: true, scope: ScopeNotificationsRead,
+		summary: "List the pending follow requests to you"}, (*apiRequest).getFollowRequests)
+	registerAction[messageResponse](reg, actionSpec{name: "accept_follow_request", transactional: true,
+		summary: "Accept a follow request"}, (*apiRequest).acceptFollowRequest)
+	registerAction[messageResponse](reg, actionSpec{name: "decline_follow_request", transactional: true,
+		summary: "Decline a follow request"}, (*apiRequest).declineFollowRequest)
+
+	// Posts
+	registerAction[PostResponse](reg, actionSpec{name: "create_post", transactional: true, auth: AuthVerified, scope: ScopePostsWrite,
+		summary: "Create a post"}, (*apiRequest).createPost)
+	registerSimpleAction[PostsResponse](reg, actionSpec{name: "get_posts", transactional: true, scope: ScopePostsRead,
+		summary: "List the posts you can see, newest first"}, (*apiRequest).getPosts)
+	registerSimpleAction[PostsResponse](reg, actionSpec{name: "get_liked_posts", transactional: true, scope: ScopePostsRead,
+		summary: "List the posts you liked"}, (*apiRequest).getLikedPosts)
+	registerSimpleAction[PostsResponse](reg, actionSpec{name: "get_user_posts", scope: ScopePostsRead,
+		summary: "Not implemented yet"}, (*apiRequest).getUserPosts)
+	registerAction[CommentResponse](reg, actionSpec{name: "create_comment", transactional: true, auth: AuthVerified, scope: ScopePostsWrite,
+		summary: "Comment on a post"}, (*apiRequest).createComment)
+	registerAction[CommentsResponse](reg, actionSpec{name: "get_comments", transactional: true, scope: ScopePostsRead,
+		summary: "List the comments of a post"}, (*apiRequest).getComments)
+	registerAction[toggleLikeResponse](reg, actionSpec{name: "toggle_like", transactional: true, scope: ScopePostsWrite,
+		summary: "Like or unlike a post"}, (*apiRequest).toggleLike)
+
+	// Notifications
+	registerSimpleAction[any](reg, actionSpec{name: "get_notifications", scope: ScopeNotificationsRead,
+		summary: "Not implemented yet"}, (*apiRequest).getNotifications)
+	registerSimpleAction[any](reg, actionSpec{name: "mark_notification_read", scope: ScopeNotificationsWrite,
+		summary: "Not implemented yet"}, (*apiRequest).markNotificationRead)
+
+	// The API itself
+	registerSimpleAction[actionsDescription](reg, actionSpec{name: "describe_actions", transactional: true, auth: AuthPublic,
+		summary: "Describe every action with JSON Schemas of its request and response"}, (*apiRequest).describeActions)
+
+	registerAction[batchResponse](reg, actionSpec{name: "batch", auth: AuthPublic,
+		summary: "Call up to 20 actions in order, optionally all or nothing"}, (*apiRequest).batch)
+
+	// Every REST route needs an action to call
+	for _, route := range restRoutes {
+		if _, ok := reg.lookup(route.action); !ok {
+			panic(fmt.Sprintf("REST route %s %s calls unknown action %s", route.method, route.path, route.action))
+		}
+	}
+}
+
+type actionDescription struct {
+	Name       string     `json:"name"`
+	Summary    string     `json:"summary"`
+	Auth       Auth       `json:"auth"`
+	Permission Permission `json:"permission,omitempty"`
+	Scope      string     `json:"scope,omitempty"` // personal access tokens need it, without one they can't call the action
+
+	Transactional bool        `json:"transactional"` // can run in an atomic batch
+	Batchable     bool        `json:"batchable"`     // can run in a batch
+	Request       *jsonSchema `json:"request,omitempty"`
+	Response      *jsonSchema `json:"response"`
+}
+
+type actionsDescription struct {
+	Actions []actionDescription    `json:"actions"`
+	Schemas map[string]*jsonSchema `json:"schemas"` // the structs referenced as #/schemas/<name>
+}
+
+// describe returns the actions with JSON Schemas of their requests and responses
+func (reg *actionRegistry) describe() actionsDescription {
+	builder := newSchemaBuilder("#/schemas/")
+	description := actionsDescription{Actions: []actionDescription{}}
+
+	for _, spec := range reg.all() {
+		action := actionDescription{
+			Name:          spec.name,
+			Summary:       spec.summary,
+			Auth:          spec.auth,
+			Permission:    spec.permission,
+			Scope:         spec.scope,
+			Transactional: spec.transactional,
+			Batchable:     spec.batchable(),
+			Response:      builder.schema(spec.response),
+		}
+		if spec.request != nil {
+			action.Request = builder.schema(spec.request)
+		}
+		description.Actions = append(description.Actions, action)
+	}
+
+	description.Schemas = builder.schemas
+	return description
+}
+
+// describeActions lets clients discover the actions, what they take and what they answer with
+func (ar *apiRequest) describeActions() {
+	responseJSON, err := json.Marshal(actions.describe())
+	if err != nil {
+		log.Printf("Error marshalling actions description: %v", err)
+		ar.setError(http.StatusInternalServerError, "Internal server error")
+		return
+	}
+
+	ar.response = string(responseJSON)
+}
@@ -0,0 +1,145 @@
+package api
+
+import (
+	"backend/db"
+	"encoding/json"
+	"log"
+	"net/http"
+	"strings"
+)
+
+const (
+	defaultListUsersLimit = 50
+	maxListUsersLimit     = 200
+)
+
+// listUsers lists users for moderators and admins, optionally searching by email or name
+func (ar *apiRequest) listUsers(request *ListUsersRequest) {
+	if request.Limit <= 0 {
+		request.Limit = defaultListUsersLimit
+	}
+	request.Limit = min(request.Limit, maxListUsersLimit)
+
+	users, err := ar.db.ListUsers(strings.TrimSpace(request.Search), request.Limit, request.Offset)
+	if err != nil {
+		log.Printf("Failed to list users: %v", err)
+		ar.setError(http.StatusInternalServerError, "Internal server error")
+		return
+	}
+
+	response := map[string]interface{}{
+		"users": users,
+	}
+
+	responseJSON, err := json.Marshal(response)
+	if err != nil {
+		log.Printf("Error marshalling list users response: %v", err)
+		ar.setError(http.StatusInternalServerError, "Internal server error")
+		return
+	}
+
+	ar.response = string(responseJSON)
+}
+
+// suspendUser suspends a user, logging them out everywhere, or lifts their suspension
+func (ar *apiRequest) suspendUser(request *SuspendUserRequest) {
+	if _, ok := ar.manageableUser(request.UserID); !ok {
+		return
+	}
+
+	if err := ar.db.SetUserSuspended(request.UserID, request.Suspended); err != nil {
+		log.Printf("Failed to update suspension of user %d: %v", request.UserID, err)
+		ar.setError(http.StatusInternalServerError, "Internal server error")
+		return
+	}
+
+	message := "User unsuspended"
+	if request.Suspended {
+		if err := revokeOtherLogins(request.UserID, 0); err != nil {
+			log.Printf("Failed to revoke logins of user %d: %v", request.UserID, err)
+		}
+		hub.disconnectUser(request.UserID)
+		message = "User suspended"
+	}
+
+	log.Printf("User %d set suspension of user %d to %t", ar.claims.Id, request.UserID, request.Suspended)
+	event := EventUserUnsuspended
+	if request.Suspended {
+		event = EventUserSuspended
+	}
+	ar.recordEvent(request.UserID, event, map[string]interface{}{"by": ar.claims.Id})
+
+	response := map[string]string{
+		"message": message,
+	}
+
+	responseJSON, err := json.Marshal(response)
+	if err != nil {
+		log.Printf("Error marshalling suspend user response: %v", err)
+		ar.setError(http.StatusInternalServerError, "Internal server error")
+		return
+	}
+
+	ar.response = string(responseJSON)
+}
+
+// setUserRole changes the role of a user. Admins can't change the role of other admins,
+// that is left to the set-role command on the server.
+func (ar *apiRequest) setUserRole(request *SetUserRoleRequest) {
+	user, ok := ar.manageableUser(request.UserID)
+	if !ok {
+		return
+	}
+
+	if err := ar.db.SetUserRole(request.UserID, request.Role); err != nil {
+		log.Printf("Failed to set role of user %d: %v", request.UserID, err)
+		ar.setError(http.StatusInternalServerError, "Internal server error")
+		return
+	}
+
+	log.Printf("User %d set the role of user %d to %s", ar.claims.Id, request.UserID, request.Role)
+	ar.recordEvent(request.UserID, EventRoleChanged, map[string]interface{}{"by": ar.claims.Id, "from": user.Role, "to": request.Role})
+
+	response := map[string]string{
+		"message": "Role updated",
+		"role":    request.Role,
+	}
+
+	responseJSON, err := json.Marshal(response)
+	if err != nil {
+		log.Printf("Error marshalling set user role response: %v", err)
+		ar.setError(http.StatusInternalServerError, "Internal server error")
+		return
+	}
+
+	ar.response = string(responseJSON)
+}
+
+// manageableUser fetches a user the authenticated user may act on: someone else
+// with a lower role. Returns false if the response was already set.
+func (ar *apiRequest) manageableUser(userID int) (*db.User, bool) {
+	if userID == ar.claims.Id {
+		ar.setError(http.StatusBadRequest, "You can't change your own account this way")
+		return nil, false
+	}
+
+	actorRole, err := ar.db.FetchUserRole(ar.claims.Id)
+	if err != nil {
+		log.Printf("Failed to fetch role of user %d: %v", ar.claims.Id, err)
+		ar.setError(http.StatusInternalServerError, "Internal server error")
+		return nil, false
+	}
+
+	user, err := ar.db.FetchUser(userID)
+	if err != nil {
+		ar.setError(http.StatusNotFound, "User not found")
+		return nil, false
+	}
+
+	if roleRank[user.Role] >= roleRank[actorRole] {
+		ar.setError(http.StatusForbidden, "You can only manage users with a lower role than yours")
+		return nil, false
+	}
+
+	return user, true
+}
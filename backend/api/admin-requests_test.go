@@ -0,0 +1,97 @@
+package api
+
+import (
+	"backend/db"
+	"net/http"
+	"testing"
+)
+
+// userWithRole creates a user with a role and logs them in
+func userWithRole(t *testing.T, role string) (int, loginResponse) {
+	t.Helper()
+
+	userID, email := newUser(t)
+	if err := db.Connection.SetUserRole(userID, role); err != nil {
+		t.Fatalf("Failed to set role: %v", err)
+	}
+	user, _ := login(t, email)
+	return userID, user
+}
+
+func TestManageableUser(t *testing.T) {
+	_, admin := userWithRole(t, RoleAdmin)
+	_, moderator := userWithRole(t, RoleModerator)
+	_, user := userWithRole(t, RoleUser)
+
+	tests := []struct {
+		name   string
+		actor  loginResponse
+		target string // role of the target, "self" for the actor
+		action map[string]any
+		status int
+	}{
+		{"moderator suspends a user", moderator, RoleUser, map[string]any{"action": "suspend_user", "suspended": true}, http.StatusOK},
+		{"moderator suspends a moderator", moderator, RoleModerator, map[string]any{"action": "suspend_user", "suspended": true}, http.StatusForbidden},
+		{"moderator suspends an admin", moderator, RoleAdmin, map[string]any{"action": "suspend_user", "suspended": true}, http.StatusForbidden},
+		{"moderator suspends themselves", moderator, "self", map[string]any{"action": "suspend_user", "suspended": true}, http.StatusBadRequest},
+		{"admin suspends a moderator", admin, RoleModerator, map[string]any{"action": "suspend_user", "suspended": true}, http.StatusOK},
+		{"admin suspends an admin", admin, RoleAdmin, map[string]any{"action": "suspend_user", "suspended": true}, http.StatusForbidden},
+		{"user suspends a user", user, RoleUser, map[string]any{"action": "suspend_user", "suspended": true}, http.StatusForbidden},
+		{"admin promotes a user", admin, RoleUser, map[string]any{"action": "set_user_role", "role": RoleModerator}, http.StatusOK},
+		{"admin demotes a moderator", admin, RoleModerator, map[string]any{"action": "set_user_role", "role": RoleUser}, http.StatusOK},
+		{"admin demotes an admin", admin, RoleAdmin, map[string]any{"action": "set_user_role", "role": RoleUser}, http.StatusForbidden},
+		{"admin demotes themselves", admin, "self", map[string]any{"action": "set_user_role", "role": RoleUser}, http.StatusBadRequest},
+		{"moderator promotes a user", moderator, RoleUser, map[string]any{"action": "set_user_role", "role": RoleModerator}, http.StatusForbidden},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			targetID := test.actor.User.Id
+			if test.target != "self" {
+				targetID, _ = userWithRole(t, test.target)
+			}
+			before, err := db.Connection.FetchUser(targetID)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			test.action["userId"] = targetID
+			w := callAction(t, test.action, bearerHeader(test.actor))
+			if w.Code != test.status {
+				t.Fatalf("Expected status %d, got %d: %s", test.status, w.Code, w.Body.String())
+			}
+
+			after, err := db.Connection.FetchUser(targetID)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if changed := after.Role != before.Role || after.Suspended != before.Suspended; changed != (test.status == http.StatusOK) {
+				t.Errorf("Expected the target to change only if the action succeeded, went from %+v to %+v", before, after)
+			}
+		})
+	}
+
+	w := callAction(t, map[string]any{"action": "suspend_user", "userId": 1 << 30, "suspended": true}, bearerHeader(admin))
+	decodeResponse[errorResponse](t, w, http.StatusNotFound)
+}
+
+func TestSuspendedUserIsLoggedOut(t *testing.T) {
+	_, moderator := userWithRole(t, RoleModerator)
+	userID, email := newUser(t)
+	user, session := login(t, email)
+
+	suspend := map[string]any{"action": "suspend_user", "userId": userID, "suspended": true}
+	decodeResponse[messageResponse](t, callAction(t, suspend, bearerHeader(moderator)), http.StatusOK)
+
+	if w := callAction(t, map[string]string{"action": "list_sessions"}, sessionHeader(user), session); w.Code != http.StatusUnauthorized {
+		t.Errorf("Expected the session of a suspended user to be revoked, got %d", w.Code)
+	}
+	w := callAction(t, map[string]string{"action": "login", "email": email, "password": "123"}, nil)
+	if response := decodeResponse[errorResponse](t, w, http.StatusForbidden); response.Code != CodeAccountSuspended {
+		t.Errorf("Expected the login of a suspended user to fail with %s, got %s", CodeAccountSuspended, w.Body.String())
+	}
+
+	suspend["suspended"] = false
+	decodeResponse[messageResponse](t, callAction(t, suspend, bearerHeader(moderator)), http.StatusOK)
+	login(t, email)
+}
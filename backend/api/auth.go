@@ -1,19 +1,52 @@
 package api
 
 import (
+	"backend/db"
+	"crypto/rand"
 	"encoding/base64"
+	"encoding/hex"
 	"encoding/json"
 	"fmt"
+	"log"
+	"time"
+)
+
+const (
+	tokenTypeAccess             = "access"
+	tokenTypeRefresh            = "refresh"
+	tokenTypeTwoFactorChallenge = "2fa_challenge"
+
+	accessTokenLifetime             = 15 * time.Minute
+	refreshTokenLifetime            = 30 * 24 * time.Hour
+	twoFactorChallengeTokenLifetime = 5 * time.Minute
 )
 
 type Claims struct {
-	Id    int    `json:"id,omitempty"`
-	Email string `json:"email,omitempty"`
+	Id        int     `json:"id,omitempty"`
+	Email     string  `json:"email,omitempty"`
+	IssuedAt  float64 `json:"iat,omitempty"` // unix seconds, with milliseconds to compare with an invalidation
+	ExpiresAt int64   `json:"exp,omitempty"` // unix seconds
+	TokenID   string  `json:"jti,omitempty"` // unique token id, used for revocation
+	Type      string  `json:"typ,omitempty"` // "access", "refresh" or "2fa_challenge"
+
+	// Personal access tokens may only do what their scopes allow, claims from logins may do anything
+	PersonalAccessToken bool     `json:"-"`
+	Scopes              []string `json:"-"`
 }
 
 type signedClaims struct {
-	Claims    string `json:"claims"`    // JSON-encoded claims
-	Signature string `json:"signature"` // Using the sign/verify
+	Claims    string `json:"claims"`        // JSON-encoded claims
+	KeyID     string `json:"kid,omitempty"` // id of the keyring key that made the signature
+	Signature string `json:"signature"`     // Using the sign/verify
+}
+
+// generateTokenID creates a new random token id
+func generateTokenID() (string, error) {
+	bytes := make([]byte, 16)
+	if _, err := rand.Read(bytes); err != nil {
+		return "", fmt.Errorf("failed to generate token id: %w", err)
+	}
+	return hex.EncodeToString(bytes), nil
 }
 
 func (c *Claims) generate() (*signedClaims, error) {
@@ -23,16 +56,17 @@ func (c *Claims) generate() (*signedClaims, error) {
 		return nil, fmt.Errorf("failed to marshal claims to JSON: %w", err)
 	}
 
-	signature := sign(claimsJSON)
+	keyID, signature := sign(claimsJSON)
 
 	return &signedClaims{
 		Claims:    string(claimsJSON),
+		KeyID:     keyID,
 		Signature: signature,
 	}, nil
 }
 
 func (sc *signedClaims) verify() (*Claims, error) {
-	validSignature := verify(sc.Claims, sc.Signature)
+	validSignature := verify(sc.KeyID, sc.Claims, sc.Signature)
 
 	if !validSignature {
 		return nil, fmt.Errorf("signature verification failed")
@@ -71,8 +105,24 @@ func (sc *signedClaims) unmarshal(base64String *string) error {
 	return nil
 }
 
-func (c *Claims) GetBearer() (*string, error) {
-	sc, err := c.generate()
+// issue signs a copy of the claims as a token of the given type and lifetime
+func (c *Claims) issue(tokenType string, lifetime time.Duration) (*string, error) {
+	tokenID, err := generateTokenID()
+	if err != nil {
+		return nil, err
+	}
+
+	now := time.Now()
+	issued := Claims{
+		Id:        c.Id,
+		Email:     c.Email,
+		IssuedAt:  unixSeconds(now),
+		ExpiresAt: now.Add(lifetime).Unix(),
+		TokenID:   tokenID,
+		Type:      tokenType,
+	}
+
+	sc, err := issued.generate()
 
 	if err != nil {
 		return nil, err
@@ -87,7 +137,55 @@ func (c *Claims) GetBearer() (*string, error) {
 	return sig, nil
 }
 
-func UnmarshalBearer(raw *string) (*Claims, error) {
+// GetBearer issues a short-lived access token for the claims
+func (c *Claims) GetBearer() (*string, error) {
+	return c.issue(tokenTypeAccess, accessTokenLifetime)
+}
+
+// GetRefreshToken issues a long-lived refresh token for the claims,
+// which can only be exchanged for new tokens with the refresh_token action
+func (c *Claims) GetRefreshToken() (*string, error) {
+	return c.issue(tokenTypeRefresh, refreshTokenLifetime)
+}
+
+// GetTwoFactorChallenge issues a short-lived token proving that the password was correct,
+// which login_2fa exchanges together with a second factor for a session
+func (c *Claims) GetTwoFactorChallenge() (*string, error) {
+	return c.issue(tokenTypeTwoFactorChallenge, twoFactorChallengeTokenLifetime)
+}
+
+// newTokenPair issues a new access and refresh token for the claims
+func newTokenPair(c *Claims) (*tokenResponse, error) {
+	token, err := c.GetBearer()
+	if err != nil {
+		return nil, fmt.Errorf("failed to get bearer token: %w", err)
+	}
+
+	refreshToken, err := c.GetRefreshToken()
+	if err != nil {
+		return nil, fmt.Errorf("failed to get refresh token: %w", err)
+	}
+
+	return &tokenResponse{
+		Token:        *token,
+		RefreshToken: *refreshToken,
+		ExpiresIn:    int(accessTokenLifetime.Seconds()),
+	}, nil
+}
+
+// unixSeconds returns a time as the iat claim holds it
+func unixSeconds(t time.Time) float64 {
+	return float64(t.UnixMilli()) / 1000
+}
+
+// Expiry returns the expiry time of a token's claims
+func (c *Claims) Expiry() time.Time {
+	return time.Unix(c.ExpiresAt, 0)
+}
+
+// parseToken verifies a token and checks that it has the expected type,
+// has not expired and has not been revoked
+func parseToken(raw *string, tokenType string) (*Claims, error) {
 	var signedClaims signedClaims
 	err := signedClaims.unmarshal(raw)
 
@@ -95,11 +193,84 @@ func UnmarshalBearer(raw *string) (*Claims, error) {
 		return nil, fmt.Errorf("failed to unmarshal signedClaims from JSON: %w", err)
 	}
 
-	valid, err := signedClaims.verify()
+	claims, err := signedClaims.verify()
 
 	if err != nil {
 		return nil, err
 	}
 
-	return valid, err
+	// Tokens signed before the keyring have no type, expiry or id. They are access tokens that
+	// expire at the cutoff of the imported legacy key and can't be revoked one by one before it.
+	legacy := signedClaims.KeyID == "" && claims.Type == "" && claims.ExpiresAt == 0
+	if legacy && tokenType == tokenTypeAccess {
+		cutoff, ok := legacyCutoff()
+		if !ok || !time.Now().Before(cutoff) {
+			return nil, fmt.Errorf("legacy token expired")
+		}
+		claims.Type = tokenTypeAccess
+		claims.ExpiresAt = cutoff.Unix()
+	} else {
+		if claims.Type != tokenType {
+			return nil, fmt.Errorf("expected %s token, got %q", tokenType, claims.Type)
+		}
+
+		if claims.ExpiresAt == 0 || !time.Now().Before(claims.Expiry()) {
+			return nil, fmt.Errorf("token expired")
+		}
+
+		revoked, err := db.Connection.IsTokenRevoked(claims.TokenID)
+		if err != nil {
+			return nil, fmt.Errorf("failed to check token revocation: %w", err)
+		}
+		if revoked {
+			return nil, fmt.Errorf("token revoked")
+		}
+	}
+
+	// A password reset invalidates every token issued to the user before it
+	validAfter, err := db.Connection.TokensValidAfter(claims.Id)
+	if err != nil {
+		return nil, fmt.Errorf("failed to check token validity: %w", err)
+	}
+	if claims.IssuedAt < unixSeconds(validAfter) {
+		return nil, fmt.Errorf("token revoked")
+	}
+
+	return claims, nil
+}
+
+// UnmarshalBearer verifies an access token and returns its claims
+func UnmarshalBearer(raw *string) (*Claims, error) {
+	return parseToken(raw, tokenTypeAccess)
+}
+
+// UnmarshalRefreshToken verifies a refresh token and returns its claims
+func UnmarshalRefreshToken(raw *string) (*Claims, error) {
+	return parseToken(raw, tokenTypeRefresh)
+}
+
+// UnmarshalTwoFactorChallenge verifies a 2FA challenge token and returns its claims
+func UnmarshalTwoFactorChallenge(raw *string) (*Claims, error) {
+	return parseToken(raw, tokenTypeTwoFactorChallenge)
+}
+
+// RevokeToken puts a token on the revocation list until it expires. Reports whether this call
+// revoked it, false if it already was: of concurrent requests using a single-use token only one wins.
+func RevokeToken(c *Claims) (bool, error) {
+	if c.TokenID == "" {
+		return false, nil // not a token, e.g. claims from a session
+	}
+	return db.Connection.RevokeToken(c.TokenID, c.Expiry())
+}
+
+// CleanupRevokedTokens removes revocation entries for tokens that expired anyway
+func CleanupRevokedTokens() {
+	count, err := db.Connection.DeleteExpiredRevocations()
+	if err != nil {
+		log.Printf("Error cleaning up revoked tokens: %v", err)
+		return
+	}
+	if count > 0 {
+		log.Printf("Cleaned up %d expired token revocations", count)
+	}
 }
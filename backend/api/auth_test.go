@@ -0,0 +1,226 @@
+package api
+
+import (
+	"backend/db"
+	"crypto/ed25519"
+	"encoding/hex"
+	"encoding/json"
+	"net/http"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+// signToken signs claims as they are, unlike issue which sets the times, id and type
+func signToken(t *testing.T, claims Claims) string {
+	t.Helper()
+
+	sc, err := claims.generate()
+	if err != nil {
+		t.Fatalf("Failed to sign claims: %v", err)
+	}
+	raw, err := sc.marshal()
+	if err != nil {
+		t.Fatalf("Failed to marshal token: %v", err)
+	}
+	return *raw
+}
+
+func TestParseToken(t *testing.T) {
+	now := time.Now()
+	valid := func(change func(*Claims)) Claims {
+		claims := Claims{Id: 1, Email: "user1@test.dev", IssuedAt: unixSeconds(now), ExpiresAt: now.Add(time.Minute).Unix(),
+			TokenID: "valid-token", Type: tokenTypeAccess}
+		if change != nil {
+			change(&claims)
+		}
+		return claims
+	}
+
+	revoked := valid(func(c *Claims) { c.TokenID = "revoked-token" })
+	if _, err := RevokeToken(&revoked); err != nil {
+		t.Fatalf("Failed to revoke token: %v", err)
+	}
+
+	// Tokens of user 4 issued before this are invalid, e.g. after a password reset
+	if err := db.Connection.InvalidateUserTokens(4); err != nil {
+		t.Fatalf("Failed to invalidate tokens: %v", err)
+	}
+
+	tampered := signToken(t, valid(nil))
+	sc := signedClaims{}
+	sc.unmarshal(&tampered)
+	sc.Claims = strings.Replace(sc.Claims, `"id":1`, `"id":3`, 1)
+	forged, _ := sc.marshal()
+
+	tests := []struct {
+		name      string
+		token     string
+		tokenType string
+		err       string // part of the expected error, "" if the token is valid
+	}{
+		{"valid", signToken(t, valid(nil)), tokenTypeAccess, ""},
+		{"valid refresh token", signToken(t, valid(func(c *Claims) { c.Type = tokenTypeRefresh })), tokenTypeRefresh, ""},
+		{"expired", signToken(t, valid(func(c *Claims) { c.ExpiresAt = now.Add(-time.Second).Unix() })), tokenTypeAccess, "expired"},
+		{"expires now", signToken(t, valid(func(c *Claims) { c.ExpiresAt = now.Unix() })), tokenTypeAccess, "expired"},
+		{"without expiry", signToken(t, valid(func(c *Claims) { c.ExpiresAt = 0 })), tokenTypeAccess, "expired"},
+		{"refresh token as access token", signToken(t, valid(func(c *Claims) { c.Type = tokenTypeRefresh })), tokenTypeAccess, "expected access"},
+		{"access token as refresh token", signToken(t, valid(nil)), tokenTypeRefresh, "expected refresh"},
+		{"access token as 2FA challenge", signToken(t, valid(nil)), tokenTypeTwoFactorChallenge, "expected 2fa"},
+		{"revoked", signToken(t, revoked), tokenTypeAccess, "revoked"},
+		{"issued before the tokens of the user were invalidated",
+			signToken(t, valid(func(c *Claims) { c.Id, c.IssuedAt = 4, unixSeconds(now.Add(-time.Minute)) })), tokenTypeAccess, "revoked"},
+		{"user that doesn't exist", signToken(t, valid(func(c *Claims) { c.Id = 1 << 30 })), tokenTypeAccess, "not found"},
+		{"changed claims", *forged, tokenTypeAccess, "signature"},
+		{"not base64", "not a token!", tokenTypeAccess, "base64"},
+		{"not JSON", "bm90IEpTT04=", tokenTypeAccess, "JSON"},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			claims, err := parseToken(&test.token, test.tokenType)
+			switch {
+			case test.err == "" && err != nil:
+				t.Errorf("Expected a valid token, got %v", err)
+			case test.err == "" && claims.Type != test.tokenType:
+				t.Errorf("Expected a %s token, got %q", test.tokenType, claims.Type)
+			case test.err != "" && err == nil:
+				t.Errorf("Expected an error about %q, the token was accepted", test.err)
+			case test.err != "" && !strings.Contains(err.Error(), test.err):
+				t.Errorf("Expected an error about %q, got %v", test.err, err)
+			}
+		})
+	}
+}
+
+// useLegacyKey adds a legacy key with the given retirement to the keyring for the test and
+// returns a token of user id signed with it the way servers from before the keyring did
+func useLegacyKey(t *testing.T, id int, retiredAt, verifyUntil *time.Time) string {
+	t.Helper()
+
+	_, priv, err := ed25519.GenerateKey(nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	keysMutex.Lock()
+	saved := keys
+	keys.Keys = append([]signingKey{{ID: legacyKeyID, PrivateKey: priv, RetiredAt: retiredAt, VerifyUntil: verifyUntil}},
+		saved.Keys...)
+	keysMutex.Unlock()
+	t.Cleanup(func() {
+		keysMutex.Lock()
+		keys = saved
+		keysMutex.Unlock()
+	})
+
+	claims, err := json.Marshal(Claims{Id: id, Email: "legacy@test.dev"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	raw, err := (&signedClaims{Claims: string(claims), Signature: hex.EncodeToString(ed25519.Sign(priv, claims))}).marshal()
+	if err != nil {
+		t.Fatal(err)
+	}
+	return *raw
+}
+
+func TestLegacyTokenCutoff(t *testing.T) {
+	now := time.Now()
+	at := func(d time.Duration) *time.Time {
+		when := now.Add(d)
+		return &when
+	}
+
+	if err := db.Connection.InvalidateUserTokens(4); err != nil {
+		t.Fatalf("Failed to invalidate tokens: %v", err)
+	}
+
+	tests := []struct {
+		name        string
+		id          int
+		retiredAt   *time.Time
+		verifyUntil *time.Time
+		tokenType   string
+		err         string
+	}{
+		{"retired, before the cutoff", 1, at(-time.Hour), at(time.Hour), tokenTypeAccess, ""},
+		{"retired, after the cutoff", 1, at(-2 * time.Hour), at(-time.Hour), tokenTypeAccess, "signature"},
+		{"still the active key", 1, nil, nil, tokenTypeAccess, "legacy token expired"},
+		{"cutoff moved past the grace period", 1, at(-DefaultKeyGracePeriod - time.Hour), at(time.Hour), tokenTypeAccess, "legacy token expired"},
+		{"as refresh token", 1, at(-time.Hour), at(time.Hour), tokenTypeRefresh, "expected refresh"},
+		{"after the tokens of the user were invalidated", 4, at(-time.Hour), at(time.Hour), tokenTypeAccess, "revoked"},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			token := useLegacyKey(t, test.id, test.retiredAt, test.verifyUntil)
+
+			claims, err := parseToken(&token, test.tokenType)
+			switch {
+			case test.err == "" && err != nil:
+				t.Errorf("Expected a valid token, got %v", err)
+			case test.err == "" && claims.Expiry().Unix() != test.verifyUntil.Unix():
+				t.Errorf("Expected the token to expire at the cutoff %v, got %v", test.verifyUntil, claims.Expiry())
+			case test.err != "" && err == nil:
+				t.Errorf("Expected an error about %q, the token was accepted", test.err)
+			case test.err != "" && !strings.Contains(err.Error(), test.err):
+				t.Errorf("Expected an error about %q, got %v", test.err, err)
+			}
+		})
+	}
+}
+
+func TestRevokeTokenReportsFirstRevocation(t *testing.T) {
+	tokenID, err := generateTokenID()
+	if err != nil {
+		t.Fatal(err)
+	}
+	claims := &Claims{Id: 1, TokenID: tokenID, ExpiresAt: time.Now().Add(time.Minute).Unix()}
+
+	if revoked, err := RevokeToken(claims); err != nil || !revoked {
+		t.Fatalf("Expected the first revocation to revoke the token, got %v, %v", revoked, err)
+	}
+	if revoked, err := RevokeToken(claims); err != nil || revoked {
+		t.Errorf("Expected the second revocation to find the token revoked already, got %v, %v", revoked, err)
+	}
+}
+
+func TestRefreshTokenCanOnlyBeUsedOnce(t *testing.T) {
+	user, _ := login(t, "user1@test.dev")
+	refresh := map[string]string{"action": "refresh_token", "refreshToken": user.RefreshToken}
+
+	// Every attempt races to revoke the same token, only one may get new tokens
+	const attempts = 5
+	statuses := make(chan int, attempts)
+	var wg sync.WaitGroup
+	for range attempts {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			statuses <- callAction(t, refresh, nil).Code
+		}()
+	}
+	wg.Wait()
+	close(statuses)
+
+	refreshed := 0
+	for status := range statuses {
+		switch status {
+		case http.StatusOK:
+			refreshed++
+		case http.StatusUnauthorized:
+		default:
+			t.Errorf("Unexpected status %d", status)
+		}
+	}
+	if refreshed != 1 {
+		t.Errorf("Expected exactly one refresh to succeed, %d did", refreshed)
+	}
+
+	w := callAction(t, refresh, nil)
+	if response := decodeResponse[errorResponse](t, w, http.StatusUnauthorized); response.Code != CodeInvalidToken {
+		t.Errorf("Expected a reused refresh token to fail with %q, got %q", CodeInvalidToken, response.Code)
+	}
+}
@@ -0,0 +1,190 @@
+package api
+
+import (
+	"backend/db"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"log/slog"
+	"net/http"
+	"runtime/debug"
+)
+
+// BatchRequest calls several actions with one request
+type BatchRequest struct {
+	Actions []json.RawMessage `json:"actions" validate:"required,max=20"` // each like a request to /api, with its action
+	Atomic  bool              `json:"atomic"`                             // all or nothing, only for transactional actions
+}
+
+type batchResult struct {
+	Action string          `json:"action"`
+	Status int             `json:"status"`
+	Body   json.RawMessage `json:"body"` // what the action answered, the error envelope if it failed
+}
+
+type batchResponse struct {
+	Results    []batchResult `json:"results"`              // in the order of the actions
+	RolledBack bool          `json:"rolledBack,omitempty"` // an action of an atomic batch failed and nothing was saved
+}
+
+// batchCall is an action of a batch, checked before any of them runs
+type batchCall struct {
+	spec *actionSpec
+	body json.RawMessage
+}
+
+// batchable reports whether an action can be part of a batch
+func (spec *actionSpec) batchable() bool {
+	return spec.name != "batch" && !spec.setsCookies
+}
+
+// errBatchFailed rolls back the transaction of an atomic batch
+var errBatchFailed = errors.New("an action of the batch failed")
+
+// batch runs actions in order under the claims of the batch, each answering with its own status.
+// Each action is authorized like a request of its own. In an atomic batch the actions run in one
+// transaction: after the first failure the rest are skipped and every change is rolled back.
+func (ar *apiRequest) batch(request *BatchRequest) {
+	calls, invalid := parseBatch(request)
+	if len(invalid) > 0 {
+		ar.setValidationError(invalid)
+		return
+	}
+
+	response := batchResponse{}
+	if !request.Atomic {
+		response.Results, _ = ar.runBatch(calls, ar.db, false)
+	} else {
+		err := ar.db.InTransaction(func(tx *db.Database) error {
+			var failed bool
+			response.Results, failed = ar.runBatch(calls, tx, true)
+			if failed {
+				return errBatchFailed
+			}
+			return nil
+		})
+		if err != nil && err != errBatchFailed {
+			log.Printf("Failed to commit batch of user %d: %v", ar.claims.Id, err)
+			ar.setError(http.StatusInternalServerError, "Internal server error")
+			return
+		}
+		response.RolledBack = err != nil
+	}
+
+	responseJSON, err := json.Marshal(response)
+	if err != nil {
+		log.Printf("Error marshalling batch response: %v", err)
+		ar.setError(http.StatusInternalServerError, "Internal server error")
+		return
+	}
+
+	ar.response = string(responseJSON)
+}
+
+// parseBatch looks up the action of every call, a batch with an invalid one runs nothing
+func parseBatch(request *BatchRequest) ([]batchCall, validationErrors) {
+	var invalid validationErrors
+	calls := make([]batchCall, len(request.Actions))
+
+	for i, body := range request.Actions {
+		field := fmt.Sprintf("actions[%d]", i)
+
+		var call API
+		if err := json.Unmarshal(body, &call); err != nil {
+			invalid = append(invalid, fieldError{Field: field, Message: "must be an object with an action"})
+			continue
+		}
+
+		spec, ok := actions.lookup(call.Action)
+		switch {
+		case !ok:
+			invalid = append(invalid, fieldError{Field: field + ".action", Message: "is not an action"})
+		case spec.name == "batch":
+			invalid = append(invalid, fieldError{Field: field + ".action", Message: "can't be a batch"})
+		case !spec.batchable():
+			invalid = append(invalid, fieldError{Field: field + ".action", Message: "sets cookies, it can't be part of a batch"})
+		case request.Atomic && !spec.transactional:
+			invalid = append(invalid, fieldError{Field: field + ".action", Message: "can't run in an atomic batch"})
+		}
+
+		calls[i] = batchCall{spec: spec, body: body}
+	}
+
+	return calls, invalid
+}
+
+// runBatch runs the calls with database. With stopOnFailure the calls after a failed one are skipped.
+// Reports whether a call failed.
+func (ar *apiRequest) runBatch(calls []batchCall, database *db.Database, stopOnFailure bool) ([]batchResult, bool) {
+	results := make([]batchResult, len(calls))
+	failed := false
+
+	for i, call := range calls {
+		if failed && stopOnFailure {
+			skipped := newErrorResponse(ar.httpRequest, CodeBatchAborted, "Not run, an earlier action of the atomic batch failed")
+			results[i] = batchResult{Action: call.spec.name, Status: http.StatusFailedDependency, Body: skipped.marshal()}
+			continue
+		}
+
+		results[i] = ar.runBatched(call, database)
+		failed = failed || results[i].Status >= 300
+	}
+
+	return results, failed
+}
+
+// runBatched authorizes and runs one call of a batch. A panic of the call only fails that call,
+// with a 500 like the one recoverPanic answers for a request of its own.
+func (ar *apiRequest) runBatched(call batchCall, database *db.Database) (result batchResult) {
+	var claims *Claims
+	if ar.authenticated {
+		claims = &ar.claims
+	}
+
+	if refusal := authorize(call.spec, claims, ar.authError); refusal != nil {
+		body := newErrorResponse(ar.httpRequest, refusal.code, refusal.message)
+		return batchResult{Action: call.spec.name, Status: refusal.status, Body: body.marshal()}
+	}
+
+	defer func() {
+		recovered := recover()
+		if recovered == nil {
+			return
+		}
+		if recovered == http.ErrAbortHandler {
+			panic(recovered)
+		}
+
+		slog.Error("panic",
+			slog.String("request_id", requestID(ar.httpRequest)),
+			slog.String("action", call.spec.name),
+			slog.String("panic", fmt.Sprint(recovered)),
+			slog.String("stack", string(debug.Stack())))
+
+		body := newErrorResponse(ar.httpRequest, CodeInternal, "Internal server error")
+		result = batchResult{Action: call.spec.name, Status: http.StatusInternalServerError, Body: body.marshal()}
+	}()
+
+	request := newAPIRequest(&callWriter{header: http.Header{}}, ar.httpRequest, claims, ar.authError, database, call.body)
+	call.spec.run(request)
+
+	body := json.RawMessage(request.response)
+	if len(body) == 0 {
+		body = json.RawMessage("null")
+	}
+	return batchResult{Action: call.spec.name, Status: request.responseCode, Body: body}
+}
+
+// callWriter is the response writer of a call in a batch. Handlers only set headers on it,
+// like Retry-After, which are dropped: they belong to the call, not to the batch. Actions that
+// answer with cookies aren't batchable, so none are lost.
+type callWriter struct {
+	header http.Header
+}
+
+func (w *callWriter) Header() http.Header { return w.header }
+
+func (w *callWriter) Write(b []byte) (int, error) { return len(b), nil }
+
+func (w *callWriter) WriteHeader(int) {}
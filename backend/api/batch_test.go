@@ -0,0 +1,144 @@
+package api
+
+import (
+	"backend/db"
+	"context"
+	"net/http"
+	"reflect"
+	"testing"
+	"time"
+)
+
+func TestBatch(t *testing.T) {
+	registerTestAction(t, actionSpec{name: "test_panic", transactional: true}, func(ar *apiRequest) {
+		panic("unimplemented")
+	})
+
+	user, _ := login(t, "user4@test.dev")
+	bearer := http.Header{"Authorization": {"Bearer " + user.Token}}
+
+	post := map[string]string{"action": "create_post", "content": "In a batch"}
+	badPost := map[string]string{"action": "create_post", "content": "In a batch", "privacy": "secret"}
+
+	tests := []struct {
+		name       string
+		atomic     bool
+		actions    []any
+		statuses   []int
+		rolledBack bool
+		newPosts   int // posts of the user after the batch
+	}{
+		{"atomic", true, []any{post, post}, []int{200, 200}, false, 2},
+		{"atomic with a failure", true, []any{post, badPost, post}, []int{200, 400, 424}, true, 0},
+		{"atomic with a failure first", true, []any{badPost, post}, []int{400, 424}, true, 0},
+		{"not atomic with a failure", false, []any{post, badPost, post}, []int{200, 400, 200}, false, 2},
+		{"panic", false, []any{map[string]string{"action": "get_notifications"}, post}, []int{500, 200}, false, 1},
+		{"panic in atomic batch", true, []any{post, map[string]string{"action": "test_panic"}, post}, []int{200, 500, 424}, true, 0},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			before, err := db.Connection.GetPostsCount(user.User.Id)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			w := callAction(t, map[string]any{"action": "batch", "atomic": test.atomic, "actions": test.actions}, bearer)
+			response := decodeResponse[batchResponse](t, w, http.StatusOK)
+
+			statuses := make([]int, len(response.Results))
+			for i, result := range response.Results {
+				statuses[i] = result.Status
+			}
+			if !reflect.DeepEqual(statuses, test.statuses) {
+				t.Errorf("Expected statuses %v, got %v: %s", test.statuses, statuses, w.Body.String())
+			}
+			if response.RolledBack != test.rolledBack {
+				t.Errorf("Expected rolledBack %v, got %v", test.rolledBack, response.RolledBack)
+			}
+
+			after, err := db.Connection.GetPostsCount(user.User.Id)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if after-before != test.newPosts {
+				t.Errorf("Expected %d new posts, got %d", test.newPosts, after-before)
+			}
+		})
+	}
+}
+
+func TestBatchRefusesActionsThatSetCookies(t *testing.T) {
+	sessionsBefore, err := db.Connection.CountActiveSessions()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for _, action := range []string{"signup", "login", "login_2fa", "oidc_start", "oidc_callback"} {
+		t.Run(action, func(t *testing.T) {
+			w := callAction(t, map[string]any{"action": "batch", "actions": []any{
+				map[string]string{"action": "get_csrf_token"},
+				map[string]string{"action": action, "email": "user5@test.dev", "password": "123"},
+			}}, nil)
+
+			response := decodeResponse[errorResponse](t, w, http.StatusBadRequest)
+			want := []fieldError{{Field: "actions[1].action", Message: "sets cookies, it can't be part of a batch"}}
+			if response.Code != CodeValidationFailed || !reflect.DeepEqual(response.Details, want) {
+				t.Errorf("Expected %s with %v, got %s", CodeValidationFailed, want, w.Body.String())
+			}
+			if cookies := w.Result().Cookies(); len(cookies) > 0 {
+				t.Errorf("Expected the batch to set no cookies, got %v", cookies)
+			}
+		})
+	}
+
+	// A refused batch runs nothing, the login above left no session behind
+	if sessionsAfter, err := db.Connection.CountActiveSessions(); err != nil || sessionsAfter != sessionsBefore {
+		t.Errorf("Expected %d sessions, got %d (%v)", sessionsBefore, sessionsAfter, err)
+	}
+}
+
+// TestShutdownWaitsForBatch checks that a shutdown lets an atomic batch in progress finish
+// before the database is closed
+func TestShutdownWaitsForBatch(t *testing.T) {
+	started, release := make(chan struct{}), make(chan struct{})
+	registerTestAction(t, actionSpec{name: "test_slow", transactional: true}, func(ar *apiRequest) {
+		close(started)
+		<-release
+		ar.response = `{"message":"done"}`
+	})
+	user, _ := login(t, "user4@test.dev")
+
+	done := make(chan int)
+	go func() {
+		r := callAction(t, map[string]any{"action": "batch", "atomic": true, "actions": []any{map[string]string{"action": "test_slow"}}},
+			bearerHeader(user))
+		done <- r.Code
+	}()
+	<-started
+
+	ctx, cancel := context.WithTimeout(context.Background(), 100*time.Millisecond)
+	defer cancel()
+	if err := db.Connection.WaitForWrites(ctx); err != context.DeadlineExceeded {
+		t.Errorf("Expected to wait for the batch, got %v", err)
+	}
+
+	close(release)
+	ctx, cancel = context.WithTimeout(context.Background(), 2*time.Second)
+	defer cancel()
+	if err := db.Connection.WaitForWrites(ctx); err != nil {
+		t.Errorf("Expected the wait to end with the batch, got %v", err)
+	}
+	if status := <-done; status != http.StatusOK {
+		t.Errorf("Expected the batch to finish, got %d", status)
+	}
+}
+
+// registerTestAction adds an action for the duration of a test
+func registerTestAction(t *testing.T, spec actionSpec, run func(*apiRequest)) {
+	registerSimpleAction[any](actions, spec, run)
+	t.Cleanup(func() {
+		delete(actions.specs, spec.name)
+		actions.names = actions.names[:len(actions.names)-1]
+	})
+}
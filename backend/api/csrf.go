@@ -0,0 +1,121 @@
+package api
+
+import (
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"log"
+	"net/http"
+	"net/url"
+	"strings"
+)
+
+// csrfHeader carries the CSRF token on requests authenticated with the session cookie
+const csrfHeader = "X-CSRF-Token"
+
+// AllowedOrigins are the origins the frontend is served from.
+// Browser requests from any other origin are rejected.
+var AllowedOrigins = []string{"http://localhost:3000"}
+
+var errCSRFTokenInvalid = errors.New("missing or invalid CSRF token")
+
+// csrfTokenFor derives the CSRF token of a session. Only the session ID is secret,
+// and the hash doesn't reveal it, so the token can be handed to scripts.
+func csrfTokenFor(sessionID string) string {
+	sum := sha256.Sum256([]byte("csrf:" + sessionID))
+	return hex.EncodeToString(sum[:])
+}
+
+// validCSRFToken checks the CSRF header of a request made with the given session
+func validCSRFToken(r *http.Request, sessionID string) bool {
+	token := r.Header.Get(csrfHeader)
+	if token == "" {
+		return false
+	}
+	return subtle.ConstantTimeCompare([]byte(token), []byte(csrfTokenFor(sessionID))) == 1
+}
+
+// authenticateAPI authenticates an API call. Browsers attach the session cookie to requests
+// other sites make as well, so the cookie only counts together with the CSRF token.
+// Without it the call needs a valid bearer token, which another site can't send.
+func authenticateAPI(r *http.Request) (*Claims, error) {
+	session, ok := currentSession(r)
+	if !ok || validCSRFToken(r, session.ID) {
+		return authenticate(r)
+	}
+
+	token := bearerFromRequest(r)
+	if token == "" {
+		log.Printf("Rejected cookie authenticated request of user %d without CSRF token", session.UserID)
+		return nil, errCSRFTokenInvalid
+	}
+
+	return authenticateBearer(r, token)
+}
+
+// requestOrigin returns the origin a browser request was made from, taken from the Origin
+// header or else the Referer. Returns "" for clients that send neither, i.e. not browsers.
+func requestOrigin(r *http.Request) string {
+	if origin := r.Header.Get("Origin"); origin != "" {
+		return origin
+	}
+
+	referer, err := url.Parse(r.Header.Get("Referer"))
+	if err != nil || referer.Scheme == "" || referer.Host == "" {
+		return ""
+	}
+	return referer.Scheme + "://" + referer.Host
+}
+
+// originAllowed reports whether a request comes from an allowed origin or from a non-browser client
+func originAllowed(r *http.Request) bool {
+	origin := requestOrigin(r)
+	if origin == "" {
+		return true
+	}
+
+	for _, allowed := range AllowedOrigins {
+		if strings.EqualFold(origin, allowed) {
+			return true
+		}
+	}
+	return false
+}
+
+// setCORSHeaders allows the requesting origin to call methods with credentials, if it is allowed
+func setCORSHeaders(w http.ResponseWriter, r *http.Request, methods string) {
+	w.Header().Add("Vary", "Origin")
+	w.Header().Set("Access-Control-Allow-Methods", methods)
+	w.Header().Set("Access-Control-Allow-Headers", "Content-Type, Authorization, "+csrfHeader+", "+requestIDHeader)
+	w.Header().Set("Access-Control-Expose-Headers", requestIDHeader+", Retry-After")
+	w.Header().Set("Access-Control-Allow-Credentials", "true")
+
+	if origin := r.Header.Get("Origin"); origin != "" && originAllowed(r) {
+		w.Header().Set("Access-Control-Allow-Origin", origin)
+	}
+}
+
+// getCSRFToken returns the CSRF token of the session the request was made with,
+// for clients that reloaded and only have the session cookie left
+func (ar *apiRequest) getCSRFToken() {
+	session, ok := currentSession(ar.httpRequest)
+	if !ok {
+		ar.setError(http.StatusUnauthorized, "No session")
+		return
+	}
+
+	response := map[string]string{
+		"csrfToken": csrfTokenFor(session.ID),
+	}
+
+	responseJSON, err := json.Marshal(response)
+	if err != nil {
+		log.Printf("Error marshalling CSRF token response: %v", err)
+		ar.setError(http.StatusInternalServerError, "Internal server error")
+		return
+	}
+
+	ar.response = string(responseJSON)
+}
@@ -0,0 +1,96 @@
+package api
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestRequestOrigin(t *testing.T) {
+	tests := []struct {
+		name    string
+		origin  string
+		referer string
+		want    string
+		allowed bool
+	}{
+		{"no origin or referer", "", "", "", true},
+		{"allowed origin", "http://localhost:3000", "", "http://localhost:3000", true},
+		{"allowed origin in other case", "HTTP://LOCALHOST:3000", "", "HTTP://LOCALHOST:3000", true},
+		{"other origin", "https://evil.example", "", "https://evil.example", false},
+		{"other port", "http://localhost:3001", "", "http://localhost:3001", false},
+		{"origin wins over referer", "https://evil.example", "http://localhost:3000/feed", "https://evil.example", false},
+		{"allowed referer", "", "http://localhost:3000/feed?page=2", "http://localhost:3000", true},
+		{"other referer", "", "https://evil.example/page", "https://evil.example", false},
+		{"relative referer", "", "/feed", "", true},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			r := httptest.NewRequest(http.MethodPost, "/api", nil)
+			if test.origin != "" {
+				r.Header.Set("Origin", test.origin)
+			}
+			if test.referer != "" {
+				r.Header.Set("Referer", test.referer)
+			}
+
+			if got := requestOrigin(r); got != test.want {
+				t.Errorf("requestOrigin() = %q, want %q", got, test.want)
+			}
+			if got := originAllowed(r); got != test.allowed {
+				t.Errorf("originAllowed() = %v, want %v", got, test.allowed)
+			}
+		})
+	}
+}
+
+func TestCSRFProtection(t *testing.T) {
+	user, session := login(t, "user1@test.dev")
+	bearer := "Bearer " + user.Token
+
+	tests := []struct {
+		name    string
+		header  http.Header
+		session bool // send the session cookie
+		status  int
+		code    string // error code, "" for a successful call
+	}{
+		{"session with CSRF token", http.Header{"X-Csrf-Token": {user.CSRFToken}}, true, http.StatusOK, ""},
+		{"session without CSRF token", nil, true, http.StatusForbidden, CodeCSRFFailed},
+		{"session with wrong CSRF token", http.Header{"X-Csrf-Token": {csrfTokenFor("another session")}}, true,
+			http.StatusForbidden, CodeCSRFFailed},
+		{"session without CSRF token but bearer", http.Header{"Authorization": {bearer}}, true, http.StatusOK, ""},
+		{"session without CSRF token and bad bearer", http.Header{"Authorization": {"Bearer nope"}}, true,
+			http.StatusUnauthorized, CodeInvalidToken},
+		{"bearer only", http.Header{"Authorization": {bearer}}, false, http.StatusOK, ""},
+		{"CSRF token without session", http.Header{"X-Csrf-Token": {user.CSRFToken}}, false,
+			http.StatusUnauthorized, CodeUnauthorized},
+		{"allowed origin", http.Header{"X-Csrf-Token": {user.CSRFToken}, "Origin": {"http://localhost:3000"}}, true,
+			http.StatusOK, ""},
+		{"other origin", http.Header{"X-Csrf-Token": {user.CSRFToken}, "Origin": {"https://evil.example"}}, true,
+			http.StatusForbidden, CodeOriginNotAllowed},
+		{"other origin with bearer", http.Header{"Authorization": {bearer}, "Referer": {"https://evil.example/"}}, false,
+			http.StatusForbidden, CodeOriginNotAllowed},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			var cookies []*http.Cookie
+			if test.session {
+				cookies = append(cookies, session)
+			}
+
+			w := callAction(t, map[string]string{"action": "list_sessions"}, test.header, cookies...)
+			if test.code == "" {
+				decodeResponse[sessionsResponse](t, w, test.status)
+				return
+			}
+
+			response := decodeResponse[errorResponse](t, w, test.status)
+			if response.Code != test.code {
+				t.Errorf("Expected error code %q, got %q", test.code, response.Code)
+			}
+		})
+	}
+}
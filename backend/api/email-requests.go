@@ -0,0 +1,224 @@
+package api
+
+import (
+	"backend/db"
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"net/mail"
+	"net/url"
+	"strings"
+	"time"
+)
+
+const emailVerificationLifetime = 24 * time.Hour
+
+// Verification emails can be resent once a minute, and at most five times an hour
+const (
+	verificationResendInterval = time.Minute
+	verificationResendLimit    = 5
+	verificationResendWindow   = time.Hour
+)
+
+// sendVerificationEmail emails a token proving that the user owns the address
+func sendVerificationEmail(userID int, firstName string, email string) error {
+	// Verification tokens are generated and stored like session IDs, only the hash is kept
+	token := GenerateSessionID()
+	if token == "" {
+		return fmt.Errorf("failed to generate verification token")
+	}
+
+	if err := db.Connection.CreateEmailVerificationToken(userID, email, hashSessionID(token), time.Now().Add(emailVerificationLifetime)); err != nil {
+		return err
+	}
+
+	link := PublicURL + "/verify-email?token=" + url.QueryEscape(token)
+	sendEmail(Email{
+		To:      email,
+		Subject: "Verify your email address",
+		Body: fmt.Sprintf("Hi %s,\n\nTo confirm that this is your email address, open this link within %d hours:\n\n%s\n\n"+
+			"Or use this verification code: %s\n\n"+
+			"If you didn't create an account, ignore this email.",
+			firstName, int(emailVerificationLifetime.Hours()), link, token),
+	})
+
+	return nil
+}
+
+// verifyEmail marks the user's email address as verified with a token from a verification email
+func (ar *apiRequest) verifyEmail(request *VerifyEmailRequest) {
+	userID, email, err := ar.db.ConsumeEmailVerificationToken(hashSessionID(request.Token))
+	if err != nil {
+		if err != sql.ErrNoRows {
+			log.Printf("Failed to check verification token: %v", err)
+			ar.setError(http.StatusInternalServerError, "Failed to verify email")
+			return
+		}
+		ar.setError(http.StatusBadRequest, "Invalid or expired verification token")
+		return
+	}
+
+	user, err := ar.db.FetchUser(userID)
+	if err != nil {
+		log.Printf("Failed to fetch user %d: %v", userID, err)
+		ar.setError(http.StatusInternalServerError, "Failed to verify email")
+		return
+	}
+
+	if strings.EqualFold(user.Email, email) {
+		_, err = ar.db.MarkUserVerified(userID, user.Email)
+	} else {
+		// The token was sent by change_email to the new address
+		err = ar.db.ChangeUserEmail(userID, email)
+		if err != nil && strings.Contains(err.Error(), "email already exists") {
+			ar.setError(http.StatusConflict, "Email already exists")
+			return
+		}
+	}
+
+	if err != nil {
+		log.Printf("Failed to verify email for user %d: %v", userID, err)
+		ar.setError(http.StatusInternalServerError, "Failed to verify email")
+		return
+	}
+
+	log.Printf("User %d verified %s", userID, email)
+	ar.recordEvent(userID, EventEmailVerified, map[string]interface{}{"email": email})
+
+	response := map[string]string{
+		"message": "Email address verified",
+	}
+
+	responseJSON, err := json.Marshal(response)
+	if err != nil {
+		log.Printf("Error marshalling verify email response: %v", err)
+		ar.setError(http.StatusInternalServerError, "Internal server error")
+		return
+	}
+
+	ar.response = string(responseJSON)
+}
+
+// resendVerification sends a new verification email to the authenticated user
+func (ar *apiRequest) resendVerification() {
+	user, err := ar.db.FetchUser(ar.claims.Id)
+	if err != nil {
+		log.Printf("Failed to fetch user %d: %v", ar.claims.Id, err)
+		ar.setError(http.StatusInternalServerError, "Failed to send verification email")
+		return
+	}
+
+	if user.Verified {
+		ar.setError(http.StatusConflict, "Email address is already verified")
+		return
+	}
+
+	count, sinceLast, err := ar.db.EmailVerificationsSent(user.Id, verificationResendWindow)
+	if err != nil {
+		log.Printf("Failed to check verification emails sent: %v", err)
+		ar.setError(http.StatusInternalServerError, "Failed to send verification email")
+		return
+	}
+
+	if count >= verificationResendLimit {
+		ar.setRetryAfter(verificationResendWindow-sinceLast, CodeTooManyRequests, "Too many verification emails, try again later")
+		return
+	}
+	if count > 0 && sinceLast < verificationResendInterval {
+		ar.setRetryAfter(verificationResendInterval-sinceLast, CodeTooManyRequests, "A verification email was just sent, try again in a minute")
+		return
+	}
+
+	if err := sendVerificationEmail(user.Id, user.FirstName, user.Email); err != nil {
+		log.Printf("Failed to send verification email: %v", err)
+		ar.setError(http.StatusInternalServerError, "Failed to send verification email")
+		return
+	}
+
+	response := map[string]string{
+		"message": "Verification email sent",
+	}
+
+	responseJSON, err := json.Marshal(response)
+	if err != nil {
+		log.Printf("Error marshalling resend verification response: %v", err)
+		ar.setError(http.StatusInternalServerError, "Internal server error")
+		return
+	}
+
+	ar.response = string(responseJSON)
+}
+
+// changeEmail starts changing the email address of the authenticated user.
+// The change takes effect once the new address is verified with verify_email,
+// the old address is told about it. Every other session and token is revoked.
+func (ar *apiRequest) changeEmail(request *ChangeEmailRequest) {
+	user, ok := ar.reauthenticate(request.Password)
+	if !ok {
+		return
+	}
+
+	newEmail := strings.TrimSpace(request.NewEmail)
+	if address, err := mail.ParseAddress(newEmail); err != nil || address.Address != newEmail {
+		ar.setError(http.StatusBadRequest, "Invalid email address")
+		return
+	}
+
+	if strings.EqualFold(newEmail, user.Email) {
+		ar.setError(http.StatusBadRequest, "This is already your email address")
+		return
+	}
+
+	if _, err := ar.db.FetchUserByEmail(newEmail); err == nil {
+		ar.setError(http.StatusConflict, "Email already exists")
+		return
+	}
+
+	if err := sendVerificationEmail(user.Id, user.FirstName, newEmail); err != nil {
+		log.Printf("Failed to send verification email: %v", err)
+		ar.setError(http.StatusInternalServerError, "Failed to change email")
+		return
+	}
+
+	sendEmail(Email{
+		To:      user.Email,
+		Subject: "Your email address is being changed",
+		Body: fmt.Sprintf("Hi %s,\n\nSomeone asked to change the email address of your account to %s. "+
+			"The change takes effect once the new address is verified.\n\n"+
+			"If it wasn't you, reset your password right away, which also cancels the change.", user.FirstName, newEmail),
+	})
+
+	if err := revokeOtherLogins(user.Id, ar.currentSessionRecordID()); err != nil {
+		log.Printf("Failed to revoke other logins for user %d: %v", user.Id, err)
+		ar.setError(http.StatusInternalServerError, "Failed to change email")
+		return
+	}
+
+	log.Printf("User %d asked to change their email to %s", user.Id, newEmail)
+	ar.recordEvent(user.Id, EventEmailChangeRequested, map[string]interface{}{"newEmail": newEmail})
+	ar.respondWithNewTokens(user, "Verification email sent to the new address")
+}
+
+// isVerified checks if a user may use the actions reserved for verified accounts
+func isVerified(userID int) bool {
+	verified, err := db.Connection.IsUserVerified(userID)
+	if err != nil {
+		log.Printf("Failed to check if user %d is verified: %v", userID, err)
+		return false
+	}
+	return verified
+}
+
+// CleanupEmailVerificationTokens removes verification tokens that have expired
+func CleanupEmailVerificationTokens() {
+	count, err := db.Connection.DeleteExpiredEmailVerificationTokens()
+	if err != nil {
+		log.Printf("Error cleaning up email verification tokens: %v", err)
+		return
+	}
+	if count > 0 {
+		log.Printf("Cleaned up %d expired email verification tokens", count)
+	}
+}
@@ -0,0 +1,122 @@
+package api
+
+import (
+	"backend/db"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+// signup registers a new user and returns the signup response and the verification code emailed to them
+func signup(t *testing.T, inbox *mailbox) (loginResponse, string) {
+	t.Helper()
+
+	email := fmt.Sprintf("signup.%d@test.dev", time.Now().UnixNano())
+	body := map[string]any{
+		"action":   "signup",
+		"user":     map[string]string{"email": email, "firstName": "Sig", "lastName": "Nup"},
+		"password": "glacier-umbrella-tandem",
+	}
+	user := decodeResponse[loginResponse](t, callAction(t, body, nil), http.StatusOK)
+	return user, codeIn(t, inbox.receive(t, email), "verification code: ")
+}
+
+func TestSignupNeedsVerification(t *testing.T) {
+	inbox := captureMail(t)
+	user, code := signup(t, inbox)
+	if user.User.Verified {
+		t.Fatal("Expected a new account to be unverified")
+	}
+
+	post := map[string]string{"action": "create_post", "content": "Hello"}
+	w := callAction(t, post, bearerHeader(user))
+	if response := decodeResponse[errorResponse](t, w, http.StatusForbidden); response.Code != CodeEmailNotVerified {
+		t.Errorf("Expected an unverified account to be refused with %s, got %s", CodeEmailNotVerified, w.Body.String())
+	}
+
+	conn := connectHub(t, newHubServer(t), bearerHeader(user))
+	if err := conn.WriteJSON(Message{Type: "message", To: 1, Content: "Hello"}); err != nil {
+		t.Fatal(err)
+	}
+	var refused Message
+	conn.SetReadDeadline(time.Now().Add(2 * time.Second))
+	if err := conn.ReadJSON(&refused); err != nil || refused.Type != "error" {
+		t.Errorf("Expected an unverified account to be refused sending messages, got %+v, %v", refused, err)
+	}
+
+	verify := map[string]string{"action": "verify_email", "token": code}
+	decodeResponse[messageResponse](t, callAction(t, verify, nil), http.StatusOK)
+
+	decodeResponse[PostResponse](t, callAction(t, post, bearerHeader(user)), http.StatusOK)
+	decodeResponse[errorResponse](t, callAction(t, verify, nil), http.StatusBadRequest)
+
+	resend := map[string]string{"action": "resend_verification"}
+	decodeResponse[errorResponse](t, callAction(t, resend, bearerHeader(user)), http.StatusConflict)
+}
+
+func TestVerificationTokenExpires(t *testing.T) {
+	userID, email := newUser(t)
+
+	token := GenerateSessionID()
+	if err := db.Connection.CreateEmailVerificationToken(userID, email, hashSessionID(token), time.Now().Add(-time.Minute)); err != nil {
+		t.Fatalf("Failed to create verification token: %v", err)
+	}
+
+	decodeResponse[errorResponse](t, callAction(t, map[string]string{"action": "verify_email", "token": token}, nil), http.StatusBadRequest)
+}
+
+func TestResendVerificationIsRateLimited(t *testing.T) {
+	email := fmt.Sprintf("unverified.resend.%d@test.dev", time.Now().UnixNano())
+	if _, err := db.Connection.CreateUser(db.User{Email: email, Password: "123", FirstName: "Una", LastName: "Verified"}); err != nil {
+		t.Fatalf("Failed to create user: %v", err)
+	}
+	user, session := login(t, email)
+	resend := map[string]string{"action": "resend_verification"}
+
+	decodeResponse[messageResponse](t, callAction(t, resend, sessionHeader(user), session), http.StatusOK)
+
+	w := callAction(t, resend, sessionHeader(user), session)
+	response := decodeResponse[errorResponse](t, w, http.StatusTooManyRequests)
+	if response.Code != CodeTooManyRequests || response.RetryAfter < 1 {
+		t.Errorf("Expected %s with a retry delay, got %s", CodeTooManyRequests, w.Body.String())
+	}
+}
+
+func TestChangeEmail(t *testing.T) {
+	inbox := captureMail(t)
+	_, email := newUser(t)
+	user, session := login(t, email)
+	other, _ := login(t, email)
+	newEmail := "changed." + email
+	change := func(password string, to string) *httptest.ResponseRecorder {
+		return callAction(t, map[string]string{"action": "change_email", "password": password, "newEmail": to}, sessionHeader(user), session)
+	}
+
+	decodeResponse[errorResponse](t, change("wrong", newEmail), http.StatusUnauthorized)
+	decodeResponse[errorResponse](t, change("123", "not an email"), http.StatusBadRequest)
+	decodeResponse[errorResponse](t, change("123", "user2@test.dev"), http.StatusConflict)
+	Throttle.Succeeded(email)
+
+	decodeResponse[newTokensResponse](t, change("123", newEmail), http.StatusOK)
+	code := codeIn(t, inbox.receive(t, newEmail), "verification code: ")
+	if notice := inbox.receive(t, email); !strings.Contains(notice.Body, newEmail) {
+		t.Errorf("Expected the old address to be told about the change, got %q", notice.Body)
+	}
+	if w := callAction(t, map[string]string{"action": "list_sessions"}, bearerHeader(other)); w.Code != http.StatusUnauthorized {
+		t.Errorf("Expected the other logins to be revoked, got %d", w.Code)
+	}
+
+	// The address only changes once the new one is verified
+	w := callAction(t, map[string]string{"action": "login", "email": newEmail, "password": "123"}, nil)
+	decodeResponse[errorResponse](t, w, http.StatusUnauthorized)
+	Throttle.Succeeded(newEmail)
+
+	decodeResponse[messageResponse](t, callAction(t, map[string]string{"action": "verify_email", "token": code}, nil), http.StatusOK)
+	login(t, newEmail)
+	w = callAction(t, map[string]string{"action": "login", "email": email, "password": "123"}, nil)
+	decodeResponse[errorResponse](t, w, http.StatusUnauthorized)
+	Throttle.Succeeded(email)
+}
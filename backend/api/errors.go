@@ -0,0 +1,117 @@
+package api
+
+import (
+	"encoding/json"
+	"errors"
+	"log"
+	"net/http"
+	"strings"
+)
+
+// Error codes of failed requests. Clients branch on them, so don't rename them.
+// Errors without a specific code get the one of their status, see statusErrorCode.
+const (
+	CodeBadRequest         = "bad_request"
+	CodeInvalidBody        = "invalid_body"
+	CodeValidationFailed   = "validation_failed"
+	CodeInvalidAction      = "invalid_action"
+	CodeUnauthorized       = "unauthorized"
+	CodeInvalidToken       = "invalid_token"
+	CodeInvalidCredentials = "invalid_credentials"
+	CodeForbidden          = "forbidden"
+	CodeNotFound           = "not_found"
+	CodeMethodNotAllowed   = "method_not_allowed"
+	CodeConflict           = "conflict"
+	CodeTooManyRequests    = "too_many_requests"
+	CodeLoginThrottled     = "login_throttled" // too many failed logins, wait for Retry-After
+	CodeAccountLocked      = "account_locked"  // so many failed logins that the account is locked for a while
+	CodeInternal           = "internal_server_error"
+
+	CodeOriginNotAllowed  = "origin_not_allowed"
+	CodeCSRFFailed        = "csrf_failed"
+	CodeEmailNotVerified  = "email_not_verified"
+	CodeInsufficientScope = "insufficient_scope"
+	CodeAccountSuspended  = "account_suspended"
+	CodeEmailTaken        = "email_taken"
+	CodeWeakPassword      = "weak_password"
+	CodeInvalidImage      = "invalid_image"
+	CodeBatchAborted      = "batch_aborted"
+)
+
+// apiError is a refusal answered with the error envelope
+type apiError struct {
+	status  int
+	code    string
+	message string
+}
+
+func (e *apiError) Error() string {
+	return e.message
+}
+
+// statusErrorCode is the code of errors that have nothing more specific to say than their status,
+// e.g. not_found for 404
+func statusErrorCode(status int) string {
+	text := http.StatusText(status)
+	if text == "" {
+		return CodeInternal
+	}
+	return strings.ToLower(strings.NewReplacer(" ", "_", "-", "_", "'", "").Replace(text))
+}
+
+// newErrorResponse builds the error envelope of a request
+func newErrorResponse(r *http.Request, code string, message string) errorResponse {
+	return errorResponse{
+		Error:     message,
+		Code:      code,
+		RequestID: requestID(r),
+	}
+}
+
+// marshal encodes the envelope, it can't fail for these field types
+func (e errorResponse) marshal() []byte {
+	responseJSON, err := json.Marshal(e)
+	if err != nil {
+		log.Printf("Error marshalling error response: %v", err)
+		return []byte(`{"error":"Internal server error","code":"internal_server_error"}`)
+	}
+	return responseJSON
+}
+
+// writeError answers a request with the error envelope, for refusals before an action runs
+func writeError(w http.ResponseWriter, r *http.Request, status int, code string, message string) {
+	writeErrorResponse(w, status, newErrorResponse(r, code, message))
+}
+
+func writeErrorResponse(w http.ResponseWriter, status int, response errorResponse) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	w.Write(response.marshal())
+}
+
+// setError fails the request with the code of its status
+func (ar *apiRequest) setError(statusCode int, message string) {
+	ar.setErrorCode(statusCode, statusErrorCode(statusCode), message)
+}
+
+// setErrorCode fails the request with a specific code
+func (ar *apiRequest) setErrorCode(statusCode int, code string, message string) {
+	ar.setErrorResponse(statusCode, newErrorResponse(ar.httpRequest, code, message))
+}
+
+func (ar *apiRequest) setErrorResponse(statusCode int, response errorResponse) {
+	ar.responseCode = statusCode
+	ar.response = string(response.marshal())
+}
+
+// setValidationError fails the request because of invalid fields, listing what is wrong with each
+func (ar *apiRequest) setValidationError(err error) {
+	response := newErrorResponse(ar.httpRequest, CodeValidationFailed, err.Error())
+
+	var invalid validationErrors
+	if errors.As(err, &invalid) {
+		response.Details = invalid
+	}
+
+	ar.setErrorResponse(http.StatusBadRequest, response)
+}
@@ -0,0 +1,98 @@
+package api
+
+import (
+	"backend/db"
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"runtime/debug"
+)
+
+// Endpoints for Docker and orchestrators, they are not logged so probes don't flood the logs
+
+type healthResponse struct {
+	Status string `json:"status"`
+}
+
+type versionResponse struct {
+	GoVersion     string `json:"goVersion"`
+	Revision      string `json:"revision,omitempty"`     // VCS commit the binary was built from
+	RevisionTime  string `json:"revisionTime,omitempty"` // when it was committed
+	Modified      bool   `json:"modified,omitempty"`     // built with uncommitted changes
+	SchemaVersion uint   `json:"schemaVersion"`          // last database migration applied
+	SchemaDirty   bool   `json:"schemaDirty,omitempty"`  // the last migration failed halfway
+}
+
+// Healthz answers 200 while the process serves requests
+func Healthz(w http.ResponseWriter, r *http.Request) {
+	writeJSON(w, http.StatusOK, healthResponse{Status: "ok"})
+}
+
+// Readyz answers 200 when the server can take traffic: the database answers and every
+// migration is applied cleanly. Otherwise 503 with the failed checks in the details.
+func Readyz(w http.ResponseWriter, r *http.Request) {
+	var failed validationErrors
+
+	if err := db.Connection.Ping(); err != nil {
+		failed = append(failed, fieldError{Field: "database", Message: err.Error()})
+	} else if status, err := db.Connection.MigrationStatus(); err != nil {
+		failed = append(failed, fieldError{Field: "migrations", Message: err.Error()})
+	} else if status.Dirty {
+		failed = append(failed, fieldError{Field: "migrations", Message: fmt.Sprintf("version %d is dirty", status.Version)})
+	} else if !status.UpToDate() {
+		failed = append(failed, fieldError{Field: "migrations",
+			Message: fmt.Sprintf("version %d is not the latest, %d", status.Version, status.Latest)})
+	}
+
+	if len(failed) > 0 {
+		log.Printf("Not ready: %v", failed)
+		response := newErrorResponse(r, statusErrorCode(http.StatusServiceUnavailable), "Not ready")
+		response.Details = failed
+		writeErrorResponse(w, http.StatusServiceUnavailable, response)
+		return
+	}
+
+	writeJSON(w, http.StatusOK, healthResponse{Status: "ready"})
+}
+
+// Version tells which build is running and the schema version of its database
+func Version(w http.ResponseWriter, r *http.Request) {
+	response := versionResponse{}
+
+	if info, ok := debug.ReadBuildInfo(); ok {
+		response.GoVersion = info.GoVersion
+		for _, setting := range info.Settings {
+			switch setting.Key {
+			case "vcs.revision":
+				response.Revision = setting.Value
+			case "vcs.time":
+				response.RevisionTime = setting.Value
+			case "vcs.modified":
+				response.Modified = setting.Value == "true"
+			}
+		}
+	}
+
+	if status, err := db.Connection.MigrationStatus(); err != nil {
+		log.Printf("Failed to read schema version: %v", err)
+	} else {
+		response.SchemaVersion = status.Version
+		response.SchemaDirty = status.Dirty
+	}
+
+	writeJSON(w, http.StatusOK, response)
+}
+
+func writeJSON(w http.ResponseWriter, status int, response any) {
+	responseJSON, err := json.Marshal(response)
+	if err != nil {
+		log.Printf("Error marshalling response: %v", err)
+		status, responseJSON = http.StatusInternalServerError, []byte(`{"error":"Internal server error","code":"internal_server_error"}`)
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Cache-Control", "no-store")
+	w.WriteHeader(status)
+	w.Write(responseJSON)
+}
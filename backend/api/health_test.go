@@ -0,0 +1,81 @@
+package api
+
+import (
+	"backend/db"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func serveHealth(handler http.HandlerFunc, path string) *httptest.ResponseRecorder {
+	w := httptest.NewRecorder()
+	handler(w, httptest.NewRequest(http.MethodGet, path, nil))
+	return w
+}
+
+func TestReadyz(t *testing.T) {
+	status, err := db.Connection.MigrationStatus()
+	if err != nil || !status.UpToDate() {
+		t.Fatalf("Expected the test database to be migrated, got %+v: %v", status, err)
+	}
+
+	raw := rawDatabase(t)
+	setSchema := func(version uint, dirty bool) {
+		t.Helper()
+		if _, err := raw.Exec(`UPDATE schema_migrations SET version = ?, dirty = ?`, version, dirty); err != nil {
+			t.Fatalf("Failed to set the schema version: %v", err)
+		}
+	}
+	t.Cleanup(func() { setSchema(status.Version, false) })
+
+	tests := []struct {
+		name    string
+		version uint
+		dirty   bool
+		failure string // part of the failed check, "" if ready
+	}{
+		{"up to date", status.Version, false, ""},
+		{"dirty", status.Version, true, "dirty"},
+		{"behind", status.Version - 1, false, "is not the latest"},
+		{"behind and dirty", status.Version - 1, true, "dirty"},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			setSchema(test.version, test.dirty)
+			w := serveHealth(Readyz, "/readyz")
+
+			if test.failure == "" {
+				if response := decodeResponse[healthResponse](t, w, http.StatusOK); response.Status != "ready" {
+					t.Errorf("Expected to be ready, got %+v", response)
+				}
+				if cache := w.Header().Get("Cache-Control"); cache != "no-store" {
+					t.Errorf("Expected probes not to be cached, got %q", cache)
+				}
+				return
+			}
+
+			response := decodeResponse[errorResponse](t, w, http.StatusServiceUnavailable)
+			if response.Code != "service_unavailable" || len(response.Details) != 1 ||
+				response.Details[0].Field != "migrations" || !strings.Contains(response.Details[0].Message, test.failure) {
+				t.Errorf("Expected the migrations check to fail with %q, got %+v", test.failure, response)
+			}
+		})
+	}
+}
+
+func TestHealthzAndVersion(t *testing.T) {
+	if response := decodeResponse[healthResponse](t, serveHealth(Healthz, "/healthz"), http.StatusOK); response.Status != "ok" {
+		t.Errorf("Expected ok, got %+v", response)
+	}
+
+	status, err := db.Connection.MigrationStatus()
+	if err != nil {
+		t.Fatal(err)
+	}
+	response := decodeResponse[versionResponse](t, serveHealth(Version, "/version"), http.StatusOK)
+	if response.GoVersion == "" || response.SchemaVersion != status.Version || response.SchemaDirty {
+		t.Errorf("Expected the Go version and schema version %d, got %+v", status.Version, response)
+	}
+}
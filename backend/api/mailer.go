@@ -0,0 +1,101 @@
+package api
+
+import (
+	"fmt"
+	"log"
+	"net"
+	"net/smtp"
+	"os"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Email is a plain text message to a single recipient
+type Email struct {
+	To      string
+	Subject string
+	Body    string
+}
+
+// Mailer delivers emails to users
+type Mailer interface {
+	Send(email Email) error
+}
+
+// SMTPMailer sends emails through an SMTP server
+type SMTPMailer struct {
+	Host     string
+	Port     string
+	Username string // no authentication if empty
+	Password string
+	From     string
+}
+
+func (m *SMTPMailer) Send(email Email) error {
+	var auth smtp.Auth
+	if m.Username != "" {
+		auth = smtp.PlainAuth("", m.Username, m.Password, m.Host)
+	}
+
+	message := "From: " + m.From + "\r\n" +
+		"To: " + email.To + "\r\n" +
+		"Subject: " + email.Subject + "\r\n" +
+		"Date: " + time.Now().Format(time.RFC1123Z) + "\r\n" +
+		"MIME-Version: 1.0\r\n" +
+		"Content-Type: text/plain; charset=UTF-8\r\n" +
+		"\r\n" +
+		strings.ReplaceAll(email.Body, "\n", "\r\n")
+
+	err := smtp.SendMail(net.JoinHostPort(m.Host, m.Port), auth, m.From, []string{email.To}, []byte(message))
+	if err != nil {
+		return fmt.Errorf("failed to send email: %w", err)
+	}
+	return nil
+}
+
+// FileMailer appends emails to a file instead of sending them, for development and tests.
+// With an empty Path the emails are only logged.
+type FileMailer struct {
+	Path  string
+	mutex sync.Mutex
+}
+
+func (m *FileMailer) Send(email Email) error {
+	entry := fmt.Sprintf("Date: %s\nTo: %s\nSubject: %s\n\n%s\n\n", time.Now().Format(time.RFC1123Z), email.To, email.Subject, email.Body)
+
+	if m.Path == "" {
+		log.Printf("Email not sent (no mailer configured):\n%s", entry)
+		return nil
+	}
+
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+
+	file, err := os.OpenFile(m.Path, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0600)
+	if err != nil {
+		return fmt.Errorf("failed to open mail file: %w", err)
+	}
+	defer file.Close()
+
+	if _, err := file.WriteString(entry); err != nil {
+		return fmt.Errorf("failed to write mail file: %w", err)
+	}
+	return nil
+}
+
+// Mail is the mailer used to send emails, set up by main from the environment
+var Mail Mailer = &FileMailer{}
+
+// PublicURL is the address users open the site at, used for links in emails
+var PublicURL = "http://localhost:3000"
+
+// sendEmail sends an email in the background, so the time it takes
+// does not show in the response (or reveal whether an account exists)
+func sendEmail(email Email) {
+	go func() {
+		if err := Mail.Send(email); err != nil {
+			log.Printf("Failed to send email to %s: %v", email.To, err)
+		}
+	}()
+}
@@ -0,0 +1,200 @@
+package api
+
+import (
+	"backend/db"
+	"bytes"
+	"database/sql"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io"
+	"log"
+	"log/slog"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"strings"
+	"testing"
+	"time"
+)
+
+// TestMain runs the tests against a fresh database with every migration and the seed users
+// of 000003_create_testing_users (user1@test.dev to user5@test.dev, password "123")
+func TestMain(m *testing.M) {
+	flag.Parse()
+
+	dataDir, err := os.MkdirTemp("", "api-test")
+	if err != nil {
+		log.Fatalf("Failed to create data dir: %v", err)
+	}
+
+	if !testing.Verbose() {
+		log.SetOutput(io.Discard)
+		slog.SetDefault(slog.New(slog.NewTextHandler(io.Discard, nil)))
+	}
+
+	GenOrLoadKey(dataDir)
+	Mail = &FileMailer{Path: dataDir + "/mail.log"}
+	testDatabasePath = dataDir + "/test.db"
+	if err := db.Connection.OpenOrCreate(testDatabasePath, "../database-migrations"); err != nil {
+		log.Fatalf("Failed to open database: %v", err)
+	}
+
+	code := m.Run()
+
+	db.Connection.Close()
+	os.RemoveAll(dataDir)
+	os.Exit(code)
+}
+
+// testDatabasePath is the database of the tests, see rawDatabase
+var testDatabasePath string
+
+// rawDatabase opens a second connection to the test database, for tests that set up
+// or check rows no db.Database method reads or writes
+func rawDatabase(t *testing.T) *sql.DB {
+	t.Helper()
+
+	conn, err := sql.Open("sqlite", testDatabasePath+"?_pragma=busy_timeout(5000)")
+	if err != nil {
+		t.Fatalf("Failed to open the test database: %v", err)
+	}
+	t.Cleanup(func() { conn.Close() })
+	return conn
+}
+
+// callAction posts a request to the action endpoint, with the headers and cookies given
+func callAction(t *testing.T, body any, header http.Header, cookies ...*http.Cookie) *httptest.ResponseRecorder {
+	t.Helper()
+
+	requestJSON, err := json.Marshal(body)
+	if err != nil {
+		t.Fatalf("Failed to marshal request: %v", err)
+	}
+
+	r := httptest.NewRequest(http.MethodPost, "/api", bytes.NewReader(requestJSON))
+	for name, values := range header {
+		r.Header[name] = values
+	}
+	for _, cookie := range cookies {
+		r.AddCookie(cookie)
+	}
+
+	w := httptest.NewRecorder()
+	Router(w, r)
+	return w
+}
+
+// decodeResponse decodes the body of a response, failing the test if the status isn't the expected one
+func decodeResponse[T any](t *testing.T, w *httptest.ResponseRecorder, status int) T {
+	t.Helper()
+
+	var response T
+	if w.Code != status {
+		t.Fatalf("Expected status %d, got %d: %s", status, w.Code, w.Body.String())
+	}
+	if err := json.Unmarshal(w.Body.Bytes(), &response); err != nil {
+		t.Fatalf("Failed to decode response %q: %v", w.Body.String(), err)
+	}
+	return response
+}
+
+// responseCookie returns a cookie the response sets, nil if it sets none with that name
+func responseCookie(w *httptest.ResponseRecorder, name string) *http.Cookie {
+	for _, cookie := range w.Result().Cookies() {
+		if cookie.Name == name {
+			return cookie
+		}
+	}
+	return nil
+}
+
+// login logs a seed user in, returning the login response and the session cookie
+func login(t *testing.T, email string) (loginResponse, *http.Cookie) {
+	t.Helper()
+
+	w := callAction(t, map[string]string{"action": "login", "email": email, "password": "123"}, nil)
+	response := decodeResponse[loginResponse](t, w, http.StatusOK)
+
+	session := responseCookie(w, "session_id")
+	if session == nil {
+		t.Fatalf("Login of %s set no session cookie", email)
+	}
+	return response, session
+}
+
+// newUser creates a verified user with the password "123", for tests that change or delete the account
+func newUser(t *testing.T) (int, string) {
+	t.Helper()
+
+	email := fmt.Sprintf("%s.%d@test.dev", strings.ToLower(strings.ReplaceAll(t.Name(), "/", ".")), time.Now().UnixNano())
+	userID, err := db.Connection.CreateUser(db.User{Email: email, Password: "123", FirstName: "Test", LastName: "User"})
+	if err != nil {
+		t.Fatalf("Failed to create user: %v", err)
+	}
+	if _, err := db.Connection.MarkUserVerified(userID, email); err != nil {
+		t.Fatalf("Failed to verify user: %v", err)
+	}
+	return userID, email
+}
+
+// sessionHeader is what a browser sends with the session cookie of a login
+func sessionHeader(user loginResponse) http.Header {
+	return http.Header{"X-Csrf-Token": {user.CSRFToken}}
+}
+
+// mailbox is a mailer that keeps the emails for the test to read
+type mailbox struct {
+	emails chan Email
+	held   []Email // emails received while waiting for another address
+}
+
+func (m *mailbox) Send(email Email) error {
+	m.emails <- email
+	return nil
+}
+
+// captureMail sends the emails of the test to a mailbox instead of the mail log
+func captureMail(t *testing.T) *mailbox {
+	previous := Mail
+	inbox := &mailbox{emails: make(chan Email, 16)}
+	Mail = inbox
+	t.Cleanup(func() { Mail = previous })
+	return inbox
+}
+
+// receive waits for the next email to an address
+func (m *mailbox) receive(t *testing.T, to string) Email {
+	t.Helper()
+
+	for i, email := range m.held {
+		if email.To == to {
+			m.held = append(m.held[:i], m.held[i+1:]...)
+			return email
+		}
+	}
+
+	timeout := time.After(2 * time.Second)
+	for {
+		select {
+		case email := <-m.emails:
+			if email.To == to {
+				return email
+			}
+			m.held = append(m.held, email)
+		case <-timeout:
+			t.Fatalf("No email was sent to %s", to)
+		}
+	}
+}
+
+// codeIn returns the code that follows label in an email, e.g. "reset code: "
+func codeIn(t *testing.T, email Email, label string) string {
+	t.Helper()
+
+	_, rest, found := strings.Cut(email.Body, label)
+	if !found {
+		t.Fatalf("Expected %q in the email %q", label, email.Body)
+	}
+	return strings.Fields(rest)[0]
+}
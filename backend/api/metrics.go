@@ -0,0 +1,74 @@
+package api
+
+import (
+	"backend/db"
+	"backend/metrics"
+	"crypto/subtle"
+	"log"
+	"math"
+	"net/http"
+	"strconv"
+	"time"
+)
+
+// MetricsToken, if set, must be sent as a bearer token to read /metrics
+var MetricsToken string
+
+var (
+	apiRequests = metrics.NewCounter("socialnetwork_api_requests_total",
+		"Requests to the actions, by action and status", "action", "status")
+	apiRequestDuration = metrics.NewHistogram("socialnetwork_api_request_duration_seconds",
+		"How long the actions took to answer, by action", metrics.DefaultBuckets, "action")
+	wsMessagesRouted = metrics.NewCounter("socialnetwork_ws_messages_routed_total",
+		"Messages the hub sent to websocket clients, by type", "type")
+)
+
+func init() {
+	metrics.NewGaugeFunc("socialnetwork_ws_clients", "Connected websocket clients", func() float64 {
+		return float64(hub.clientCount.Load())
+	})
+
+	metrics.NewGaugeFunc("socialnetwork_sessions_active", "Sessions that have not expired", func() float64 {
+		count, err := db.Connection.CountActiveSessions()
+		if err != nil {
+			log.Printf("Failed to count sessions for metrics: %v", err)
+			return math.NaN()
+		}
+		return float64(count)
+	})
+
+	metrics.NewGaugeFunc("socialnetwork_sessions_cached", "Sessions in the memory cache of the session manager", func() float64 {
+		return float64(Sessions.cachedCount())
+	})
+}
+
+// observeRequest counts a request to an action, requests that never named one are not counted
+func observeRequest(action string, status int, duration time.Duration) {
+	if action == "" {
+		return
+	}
+	apiRequests.Inc(action, strconv.Itoa(status))
+	apiRequestDuration.ObserveDuration(duration, action)
+}
+
+// Metrics serves the metrics in the Prometheus text format
+func Metrics(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		w.Header().Set("Allow", "GET")
+		writeError(w, r, http.StatusMethodNotAllowed, CodeMethodNotAllowed, "Method not allowed")
+		return
+	}
+
+	if MetricsToken != "" {
+		token := bearerFromRequest(r)
+		if subtle.ConstantTimeCompare([]byte(token), []byte(MetricsToken)) != 1 {
+			writeError(w, r, http.StatusUnauthorized, CodeUnauthorized, "Unauthorized")
+			return
+		}
+	}
+
+	w.Header().Set("Content-Type", "text/plain; version=0.0.4; charset=utf-8")
+	if _, err := metrics.Default.WriteTo(w); err != nil {
+		log.Printf("Error writing metrics: %v", err)
+	}
+}
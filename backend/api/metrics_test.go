@@ -0,0 +1,89 @@
+package api
+
+import (
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestMetricsToken(t *testing.T) {
+	previous := MetricsToken
+	MetricsToken = "scrape-me"
+	t.Cleanup(func() { MetricsToken = previous })
+
+	tests := []struct {
+		name   string
+		method string
+		token  string
+		status int
+	}{
+		{"right token", http.MethodGet, "scrape-me", http.StatusOK},
+		{"no token", http.MethodGet, "", http.StatusUnauthorized},
+		{"wrong token", http.MethodGet, "scrape-you", http.StatusUnauthorized},
+		{"other method", http.MethodPost, "scrape-me", http.StatusMethodNotAllowed},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			r := httptest.NewRequest(test.method, "/metrics", nil)
+			if test.token != "" {
+				r.Header.Set("Authorization", "Bearer "+test.token)
+			}
+			w := httptest.NewRecorder()
+			Metrics(w, r)
+
+			if w.Code != test.status {
+				t.Fatalf("Expected status %d, got %d: %s", test.status, w.Code, w.Body.String())
+			}
+			if test.status == http.StatusOK && !strings.Contains(w.Body.String(), "# TYPE socialnetwork_api_requests_total counter") {
+				t.Errorf("Expected the API metrics, got %s", w.Body.String())
+			}
+		})
+	}
+}
+
+// scrapeMetrics returns the metrics as Prometheus would read them
+func scrapeMetrics(t *testing.T) string {
+	t.Helper()
+
+	w := httptest.NewRecorder()
+	Metrics(w, httptest.NewRequest(http.MethodGet, "/metrics", nil))
+	if w.Code != http.StatusOK {
+		t.Fatalf("Expected the metrics, got %d: %s", w.Code, w.Body.String())
+	}
+	return w.Body.String()
+}
+
+func TestWebSocketClientsMetric(t *testing.T) {
+	url := newHubServer(t)
+	before := hub.clientCount.Load()
+
+	user, _ := login(t, "user1@test.dev")
+	conn := connectHub(t, url, bearerHeader(user))
+
+	if gauge := fmt.Sprintf("socialnetwork_ws_clients %d\n", before+1); !strings.Contains(scrapeMetrics(t), gauge) {
+		t.Errorf("Expected %q in the metrics", gauge)
+	}
+
+	conn.Close()
+	for deadline := time.Now().Add(2 * time.Second); hub.clientCount.Load() != before; time.Sleep(10 * time.Millisecond) {
+		if time.Now().After(deadline) {
+			t.Fatalf("Expected %d clients after disconnecting, got %d", before, hub.clientCount.Load())
+		}
+	}
+}
+
+func TestQueryDurationByStatement(t *testing.T) {
+	login(t, "user1@test.dev") // selects the user and inserts a session
+
+	scraped := scrapeMetrics(t)
+	for _, statement := range []string{"select", "insert"} {
+		series := fmt.Sprintf(`socialnetwork_db_query_duration_seconds_count{statement=%q}`, statement)
+		if !strings.Contains(scraped, series) {
+			t.Errorf("Expected %s in the metrics", series)
+		}
+	}
+}
@@ -0,0 +1,209 @@
+package api
+
+import (
+	"bufio"
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"net"
+	"net/http"
+	"runtime/debug"
+	"strings"
+	"time"
+)
+
+// Middleware wraps the handlers of the API: every request gets an ID, is logged when it is done,
+// and a panicking handler answers 500 with the error envelope instead of dropping the connection.
+func Middleware(next http.Handler) http.Handler {
+	return chain(next, assignRequestID, logRequest, recoverPanic)
+}
+
+// chain wraps a handler in middlewares, the first one is the outermost
+func chain(handler http.Handler, middlewares ...func(http.Handler) http.Handler) http.Handler {
+	for i := len(middlewares) - 1; i >= 0; i-- {
+		handler = middlewares[i](handler)
+	}
+	return handler
+}
+
+func assignRequestID(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		next.ServeHTTP(w, withRequestID(w, r))
+	})
+}
+
+// requestInfo is what the handlers found out about a request, for its log line
+type requestInfo struct {
+	userID int
+	action string
+}
+
+type requestInfoKey struct{}
+
+// noteRequest adds the caller and the action to the log line of a request
+func noteRequest(r *http.Request, userID int, action string) {
+	if info, ok := r.Context().Value(requestInfoKey{}).(*requestInfo); ok {
+		if userID != 0 {
+			info.userID = userID
+		}
+		if action != "" {
+			info.action = action
+		}
+	}
+}
+
+// claimsUserID is the user of the claims, 0 for anonymous requests
+func claimsUserID(claims *Claims) int {
+	if claims == nil {
+		return 0
+	}
+	return claims.Id
+}
+
+// logRequest logs every request as it finishes, with its status and how long it took,
+// and counts it in the metrics of its action
+func logRequest(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		start := time.Now()
+		info := &requestInfo{}
+		recorder := &statusRecorder{ResponseWriter: w}
+
+		next.ServeHTTP(recorder, r.WithContext(context.WithValue(r.Context(), requestInfoKey{}, info)))
+
+		status := recorder.statusCode()
+		duration := time.Since(start)
+		observeRequest(info.action, status, duration)
+
+		attrs := []slog.Attr{
+			slog.String("request_id", requestID(r)),
+			slog.String("method", r.Method),
+			slog.String("path", r.URL.Path),
+			slog.Int("status", status),
+			slog.Float64("duration_ms", float64(duration.Microseconds())/1000),
+			slog.Int("bytes", recorder.bytes),
+			slog.String("ip", clientIP(r)),
+		}
+		if info.userID != 0 {
+			attrs = append(attrs, slog.Int("user_id", info.userID))
+		}
+		if info.action != "" {
+			attrs = append(attrs, slog.String("action", info.action))
+		}
+
+		level := slog.LevelInfo
+		if status >= 500 {
+			level = slog.LevelError
+		}
+		slog.LogAttrs(r.Context(), level, "request", attrs...)
+	})
+}
+
+// recoverPanic turns a panic of a handler into 500 Internal Server Error
+func recoverPanic(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		recorder, ok := w.(*statusRecorder)
+		if !ok {
+			recorder = &statusRecorder{ResponseWriter: w}
+		}
+
+		defer func() {
+			recovered := recover()
+			if recovered == nil {
+				return
+			}
+			if recovered == http.ErrAbortHandler {
+				panic(recovered) // the handler meant to abort, net/http handles it quietly
+			}
+
+			slog.Error("panic",
+				slog.String("request_id", requestID(r)),
+				slog.String("panic", fmt.Sprint(recovered)),
+				slog.String("stack", string(debug.Stack())))
+
+			// Too late to answer if the handler already started the response or took over the connection
+			if !recorder.wroteHeader && !recorder.hijacked {
+				writeError(recorder, r, http.StatusInternalServerError, CodeInternal, "Internal server error")
+			}
+		}()
+
+		next.ServeHTTP(recorder, r)
+	})
+}
+
+// statusRecorder remembers the status and size of a response
+type statusRecorder struct {
+	http.ResponseWriter
+	status      int
+	bytes       int
+	wroteHeader bool
+	hijacked    bool
+}
+
+func (sr *statusRecorder) WriteHeader(status int) {
+	if !sr.wroteHeader {
+		sr.status = status
+		sr.wroteHeader = true
+	}
+	sr.ResponseWriter.WriteHeader(status)
+}
+
+func (sr *statusRecorder) Write(b []byte) (int, error) {
+	if !sr.wroteHeader {
+		sr.WriteHeader(http.StatusOK)
+	}
+	n, err := sr.ResponseWriter.Write(b)
+	sr.bytes += n
+	return n, err
+}
+
+// Hijack lets the websocket take over the connection
+func (sr *statusRecorder) Hijack() (net.Conn, *bufio.ReadWriter, error) {
+	hijacker, ok := sr.ResponseWriter.(http.Hijacker)
+	if !ok {
+		return nil, nil, errors.New("response writer can't be hijacked")
+	}
+	conn, rw, err := hijacker.Hijack()
+	if err == nil {
+		sr.hijacked = true
+	}
+	return conn, rw, err
+}
+
+func (sr *statusRecorder) Flush() {
+	if flusher, ok := sr.ResponseWriter.(http.Flusher); ok {
+		flusher.Flush()
+	}
+}
+
+// Unwrap lets http.ResponseController reach the underlying writer
+func (sr *statusRecorder) Unwrap() http.ResponseWriter {
+	return sr.ResponseWriter
+}
+
+// statusCode is the status the client got, 101 for a websocket
+func (sr *statusRecorder) statusCode() int {
+	switch {
+	case sr.hijacked:
+		return http.StatusSwitchingProtocols
+	case !sr.wroteHeader:
+		return http.StatusOK
+	default:
+		return sr.status
+	}
+}
+
+// secretLogKeys are attributes whose values must never reach the logs
+var secretLogKeys = []string{"authorization", "cookie", "password", "token", "secret"}
+
+// RedactSecrets is a slog ReplaceAttr that hides the values of secret attributes, like an
+// Authorization header or a refresh token
+func RedactSecrets(groups []string, a slog.Attr) slog.Attr {
+	key := strings.ToLower(a.Key)
+	for _, secret := range secretLogKeys {
+		if strings.Contains(key, secret) {
+			return slog.String(a.Key, "[REDACTED]")
+		}
+	}
+	return a
+}
@@ -0,0 +1,262 @@
+package api
+
+import (
+	"backend/db"
+	"crypto/sha256"
+	"crypto/subtle"
+	"database/sql"
+	"encoding/hex"
+	"encoding/json"
+	"log"
+	"net/http"
+	"strings"
+	"time"
+)
+
+// oidcStart begins a login with the OIDC provider. The frontend redirects the user
+// to authorizationUrl and, when the provider sends them back, passes code and state to oidc_callback.
+func (ar *apiRequest) oidcStart() {
+	if OIDC == nil {
+		ar.setError(http.StatusNotFound, "OIDC login is not configured")
+		return
+	}
+
+	state, err := randomURLString(32)
+	if err != nil {
+		log.Printf("Failed to generate OIDC state: %v", err)
+		ar.setError(http.StatusInternalServerError, "Internal server error")
+		return
+	}
+	nonce, err := randomURLString(32)
+	if err != nil {
+		log.Printf("Failed to generate OIDC nonce: %v", err)
+		ar.setError(http.StatusInternalServerError, "Internal server error")
+		return
+	}
+	codeVerifier, err := randomURLString(48)
+	if err != nil {
+		log.Printf("Failed to generate PKCE verifier: %v", err)
+		ar.setError(http.StatusInternalServerError, "Internal server error")
+		return
+	}
+
+	authorizationURL, err := OIDC.AuthorizationURL(state, nonce, codeVerifier)
+	if err != nil {
+		log.Printf("Failed to build OIDC authorization URL: %v", err)
+		ar.setError(http.StatusBadGateway, "Login provider is unavailable")
+		return
+	}
+
+	if err := ar.db.CreateOIDCLoginState(state, nonce, codeVerifier, time.Now().Add(oidcLoginLifetime)); err != nil {
+		log.Printf("Failed to store OIDC login state: %v", err)
+		ar.setError(http.StatusInternalServerError, "Internal server error")
+		return
+	}
+
+	setOIDCStateCookie(ar.httpWriter, state)
+
+	response := map[string]string{
+		"authorizationUrl": authorizationURL,
+		"state":            state,
+	}
+
+	responseJSON, err := json.Marshal(response)
+	if err != nil {
+		log.Printf("Error marshalling OIDC start response: %v", err)
+		ar.setError(http.StatusInternalServerError, "Internal server error")
+		return
+	}
+
+	ar.response = string(responseJSON)
+}
+
+// oidcCallback finishes a login with the OIDC provider and logs the user in like login does.
+// Provider accounts are linked to existing users by verified email, or get a new user.
+func (ar *apiRequest) oidcCallback(request *OIDCCallbackRequest) {
+	if OIDC == nil {
+		ar.setError(http.StatusNotFound, "OIDC login is not configured")
+		return
+	}
+
+	// Only the browser that started the login may finish it, otherwise an attacker could send
+	// a victim the callback of the attacker's own login and log them into the attacker's account
+	if !oidcStateCookieMatches(ar.httpRequest, request.State) {
+		log.Printf("OIDC callback without the state cookie of its login")
+		ar.setError(http.StatusBadRequest, "Invalid or expired login, start again")
+		return
+	}
+	clearOIDCStateCookie(ar.httpWriter)
+
+	// The state is single-use, so a replayed callback fails here
+	nonce, codeVerifier, err := ar.db.ConsumeOIDCLoginState(request.State)
+	if err != nil {
+		if err == sql.ErrNoRows {
+			ar.setError(http.StatusBadRequest, "Invalid or expired login, start again")
+			return
+		}
+		log.Printf("Failed to consume OIDC login state: %v", err)
+		ar.setError(http.StatusInternalServerError, "Internal server error")
+		return
+	}
+
+	idToken, err := OIDC.Exchange(request.Code, codeVerifier)
+	if err != nil {
+		log.Printf("OIDC code exchange failed: %v", err)
+		ar.setError(http.StatusUnauthorized, "Login with the provider failed")
+		return
+	}
+
+	claims, err := OIDC.VerifyIDToken(idToken, nonce)
+	if err != nil {
+		log.Printf("Invalid OIDC id token: %v", err)
+		ar.setError(http.StatusUnauthorized, "Login with the provider failed")
+		return
+	}
+
+	user, ok := ar.oidcUser(claims)
+	if !ok {
+		return
+	}
+
+	enabled, err := ar.db.IsTwoFactorEnabled(user.Id)
+	if err != nil {
+		log.Printf("Failed to check 2FA for user %d: %v", user.Id, err)
+		ar.setError(http.StatusInternalServerError, "Internal server error")
+		return
+	}
+
+	if enabled {
+		ar.twoFactorChallenge(user)
+		return
+	}
+
+	ar.completeLogin(user, "oidc")
+}
+
+// oidcUser finds the user a provider account belongs to, linking or creating one on first login
+func (ar *apiRequest) oidcUser(claims *idTokenClaims) (*db.User, bool) {
+	userID, err := ar.db.FetchUserIDByIdentity(OIDC.Issuer, claims.Subject)
+	if err == nil {
+		user, err := ar.db.FetchUser(userID)
+		if err != nil {
+			log.Printf("Failed to fetch user %d: %v", userID, err)
+			ar.setError(http.StatusInternalServerError, "Internal server error")
+			return nil, false
+		}
+		return user, true
+	}
+	if err != sql.ErrNoRows {
+		log.Printf("Failed to fetch OIDC identity: %v", err)
+		ar.setError(http.StatusInternalServerError, "Internal server error")
+		return nil, false
+	}
+
+	// Linking by email is only safe if both sides proved they own the address
+	if claims.Email == "" || !bool(claims.EmailVerified) {
+		ar.setError(http.StatusForbidden, "The provider did not confirm your email address")
+		return nil, false
+	}
+
+	user, err := ar.db.FetchUserByEmail(claims.Email)
+	if err == nil {
+		if !user.Verified {
+			ar.setError(http.StatusConflict, "An account with this email exists, log in with your password and verify your email first")
+			return nil, false
+		}
+	} else {
+		user, err = createOIDCUser(claims)
+		if err != nil {
+			log.Printf("Failed to create user for OIDC login: %v", err)
+			ar.setError(http.StatusInternalServerError, "Internal server error")
+			return nil, false
+		}
+	}
+
+	if err := ar.db.LinkIdentity(user.Id, OIDC.Issuer, claims.Subject, claims.Email); err != nil {
+		log.Printf("Failed to link OIDC identity to user %d: %v", user.Id, err)
+		ar.setError(http.StatusInternalServerError, "Internal server error")
+		return nil, false
+	}
+
+	log.Printf("Linked OIDC identity %s to user %d", claims.Subject, user.Id)
+	ar.recordEvent(user.Id, EventOIDCIdentityLinked, map[string]interface{}{"issuer": OIDC.Issuer})
+	return user, true
+}
+
+// createOIDCUser creates a verified user for a new provider account.
+// The random password can't be guessed, the user can set one with a password reset.
+func createOIDCUser(claims *idTokenClaims) (*db.User, error) {
+	firstName, lastName := claims.GivenName, claims.FamilyName
+	if firstName == "" && lastName == "" {
+		firstName, lastName, _ = strings.Cut(claims.Name, " ")
+	}
+
+	userID, err := db.Connection.CreateUser(db.User{
+		Email:     claims.Email,
+		Password:  GenerateSessionID(),
+		FirstName: firstName,
+		LastName:  lastName,
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	if _, err := db.Connection.MarkUserVerified(userID, claims.Email); err != nil {
+		return nil, err
+	}
+
+	log.Printf("Created user %d for OIDC login of %s", userID, claims.Email)
+	return db.Connection.FetchUser(userID)
+}
+
+// oidcStateCookie holds the hash of the state of the login the browser started
+const oidcStateCookie = "oidc_state"
+
+func hashOIDCState(state string) string {
+	sum := sha256.Sum256([]byte("oidc-state:" + state))
+	return hex.EncodeToString(sum[:])
+}
+
+func setOIDCStateCookie(w http.ResponseWriter, state string) {
+	http.SetCookie(w, &http.Cookie{
+		Name:     oidcStateCookie,
+		Value:    hashOIDCState(state),
+		Path:     "/",
+		HttpOnly: true,
+		Secure:   false, // Set to true in production with HTTPS
+		SameSite: http.SameSiteLaxMode,
+		MaxAge:   int(oidcLoginLifetime.Seconds()),
+	})
+}
+
+func clearOIDCStateCookie(w http.ResponseWriter) {
+	http.SetCookie(w, &http.Cookie{
+		Name:     oidcStateCookie,
+		Value:    "",
+		Path:     "/",
+		HttpOnly: true,
+		SameSite: http.SameSiteLaxMode,
+		MaxAge:   -1,
+	})
+}
+
+// oidcStateCookieMatches reports whether the request comes from the browser that started the login of state
+func oidcStateCookieMatches(r *http.Request, state string) bool {
+	cookie, err := r.Cookie(oidcStateCookie)
+	if err != nil {
+		return false
+	}
+	return subtle.ConstantTimeCompare([]byte(cookie.Value), []byte(hashOIDCState(state))) == 1
+}
+
+// CleanupOIDCLoginStates removes logins that were started but never finished
+func CleanupOIDCLoginStates() {
+	count, err := db.Connection.DeleteExpiredOIDCLoginStates()
+	if err != nil {
+		log.Printf("Error cleaning up OIDC login states: %v", err)
+		return
+	}
+	if count > 0 {
+		log.Printf("Cleaned up %d expired OIDC login states", count)
+	}
+}
@@ -0,0 +1,392 @@
+package api
+
+import (
+	"backend/db"
+	"crypto"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+const (
+	mockClientID = "social-network"
+	mockKeyID    = "mock-key"
+)
+
+// mockProvider is an OpenID Connect provider for the tests. Its token endpoint checks the
+// PKCE verifier against the challenge of the authorization request, like a real provider.
+type mockProvider struct {
+	*httptest.Server
+	key *rsa.PrivateKey
+
+	mutex      sync.Mutex
+	challenges map[string]string // code -> code_challenge
+	claims     map[string]any    // claims of the next id token, besides iss, aud, exp, iat and nonce
+}
+
+func newMockProvider(t *testing.T) *mockProvider {
+	t.Helper()
+
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatalf("Failed to generate provider key: %v", err)
+	}
+
+	p := &mockProvider{key: key, challenges: map[string]string{}}
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("/.well-known/openid-configuration", func(w http.ResponseWriter, r *http.Request) {
+		json.NewEncoder(w).Encode(map[string]string{
+			"issuer":                 p.URL,
+			"authorization_endpoint": p.URL + "/authorize",
+			"token_endpoint":         p.URL + "/token",
+			"jwks_uri":               p.URL + "/jwks",
+		})
+	})
+	mux.HandleFunc("/jwks", func(w http.ResponseWriter, r *http.Request) {
+		json.NewEncoder(w).Encode(map[string]any{"keys": []map[string]string{{
+			"kty": "RSA",
+			"kid": mockKeyID,
+			"use": "sig",
+			"n":   base64.RawURLEncoding.EncodeToString(key.N.Bytes()),
+			"e":   base64.RawURLEncoding.EncodeToString(big.NewInt(int64(key.E)).Bytes()),
+		}}})
+	})
+	mux.HandleFunc("/token", p.token)
+
+	p.Server = httptest.NewServer(mux)
+	t.Cleanup(p.Close)
+	return p
+}
+
+// authorize does what the provider's login page does: it remembers the PKCE challenge and the
+// nonce of an authorization URL, and returns the code the browser is sent back with
+func (p *mockProvider) authorize(t *testing.T, authorizationURL string, claims map[string]any) (code string, state string) {
+	t.Helper()
+
+	parsed, err := url.Parse(authorizationURL)
+	if err != nil {
+		t.Fatalf("Invalid authorization URL: %v", err)
+	}
+	query := parsed.Query()
+	if query.Get("code_challenge_method") != "S256" || query.Get("code_challenge") == "" {
+		t.Fatalf("Authorization URL without a S256 PKCE challenge: %s", authorizationURL)
+	}
+
+	code, err = randomURLString(16)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+
+	p.challenges[code] = query.Get("code_challenge")
+	p.claims = map[string]any{"nonce": query.Get("nonce")}
+	for name, value := range claims {
+		p.claims[name] = value
+	}
+	return code, query.Get("state")
+}
+
+func (p *mockProvider) token(w http.ResponseWriter, r *http.Request) {
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+
+	code := r.FormValue("code")
+	challenge, ok := p.challenges[code]
+	delete(p.challenges, code)
+
+	sum := sha256.Sum256([]byte(r.FormValue("code_verifier")))
+	if !ok || base64.RawURLEncoding.EncodeToString(sum[:]) != challenge {
+		w.WriteHeader(http.StatusBadRequest)
+		json.NewEncoder(w).Encode(map[string]string{"error": "invalid_grant"})
+		return
+	}
+
+	json.NewEncoder(w).Encode(map[string]string{"id_token": p.idToken(p.claims)})
+}
+
+// idToken signs an id token for the test client, claims override the defaults
+func (p *mockProvider) idToken(claims map[string]any) string {
+	return signJWT(p.key, mockKeyID, p.idTokenClaims(claims))
+}
+
+func (p *mockProvider) idTokenClaims(claims map[string]any) map[string]any {
+	now := time.Now()
+	payload := map[string]any{
+		"iss": p.URL,
+		"aud": mockClientID,
+		"exp": now.Add(time.Hour).Unix(),
+		"iat": now.Unix(),
+	}
+	for name, value := range claims {
+		payload[name] = value
+	}
+	return payload
+}
+
+func signJWT(key *rsa.PrivateKey, kid string, claims map[string]any) string {
+	header, _ := json.Marshal(map[string]string{"alg": "RS256", "kid": kid, "typ": "JWT"})
+	payload, _ := json.Marshal(claims)
+
+	signed := base64.RawURLEncoding.EncodeToString(header) + "." + base64.RawURLEncoding.EncodeToString(payload)
+	digest := sha256.Sum256([]byte(signed))
+	signature, _ := rsa.SignPKCS1v15(rand.Reader, key, crypto.SHA256, digest[:])
+	return signed + "." + base64.RawURLEncoding.EncodeToString(signature)
+}
+
+// useProvider makes p the OIDC provider of the server for the test
+func useProvider(t *testing.T, p *mockProvider) {
+	previous := OIDC
+	OIDC = NewOIDCProvider(p.URL, mockClientID, "", "http://localhost:3000/oidc-callback")
+	t.Cleanup(func() { OIDC = previous })
+}
+
+// oidcLogin starts a login, logs in at the provider with claims and calls oidc_callback
+// with the state cookie of the start
+func oidcLogin(t *testing.T, p *mockProvider, claims map[string]any) *httptest.ResponseRecorder {
+	t.Helper()
+
+	w := callAction(t, map[string]string{"action": "oidc_start"}, nil)
+	start := decodeResponse[oidcStartResponse](t, w, http.StatusOK)
+
+	stateCookie := responseCookie(w, oidcStateCookie)
+	if stateCookie == nil || !stateCookie.HttpOnly || stateCookie.SameSite != http.SameSiteLaxMode {
+		t.Fatalf("oidc_start must set an HttpOnly SameSite=Lax state cookie, got %v", stateCookie)
+	}
+	if stateCookie.Value == start.State {
+		t.Fatalf("The state cookie must hold a hash of the state, not the state")
+	}
+
+	code, state := p.authorize(t, start.AuthorizationURL, claims)
+	if state != start.State {
+		t.Fatalf("Authorization URL has state %q, oidc_start answered %q", state, start.State)
+	}
+
+	return callAction(t, map[string]string{"action": "oidc_callback", "code": code, "state": state}, nil, stateCookie)
+}
+
+func TestOIDCLoginCreatesUser(t *testing.T) {
+	p := newMockProvider(t)
+	useProvider(t, p)
+
+	w := oidcLogin(t, p, map[string]any{
+		"sub": "new-user", "email": "new.oidc@test.dev", "email_verified": true,
+		"given_name": "Nora", "family_name": "New",
+	})
+	response := decodeResponse[loginResponse](t, w, http.StatusOK)
+
+	if response.User.Email != "new.oidc@test.dev" || !response.User.Verified {
+		t.Errorf("Expected a new verified user for new.oidc@test.dev, got %+v", response.User)
+	}
+	if response.Token == "" || responseCookie(w, "session_id") == nil {
+		t.Errorf("Expected a token and a session cookie, got %s", w.Body.String())
+	}
+
+	userID, err := db.Connection.FetchUserIDByIdentity(p.URL, "new-user")
+	if err != nil || userID != response.User.Id {
+		t.Errorf("Expected the identity to be linked to user %d, got %d (%v)", response.User.Id, userID, err)
+	}
+
+	// The next login with the same provider account finds the same user
+	w = oidcLogin(t, p, map[string]any{"sub": "new-user", "email": "new.oidc@test.dev", "email_verified": true})
+	if again := decodeResponse[loginResponse](t, w, http.StatusOK); again.User.Id != response.User.Id {
+		t.Errorf("Expected user %d again, got %d", response.User.Id, again.User.Id)
+	}
+}
+
+func TestOIDCLoginLinksByVerifiedEmail(t *testing.T) {
+	p := newMockProvider(t)
+	useProvider(t, p)
+
+	unverifiedEmail := fmt.Sprintf("unverified.%d@test.dev", time.Now().UnixNano()) // new for every -count
+	unverifiedID, err := db.Connection.CreateUser(db.User{
+		Email: unverifiedEmail, Password: "correct-horse-7", FirstName: "Una", LastName: "Verified",
+	})
+	if err != nil {
+		t.Fatalf("Failed to create user: %v", err)
+	}
+
+	tests := []struct {
+		name   string
+		claims map[string]any
+		status int
+		userID int // the user logged in, 0 if the login fails
+	}{
+		{"verified email of a verified user", map[string]any{
+			"sub": "alice", "email": "user1@test.dev", "email_verified": true}, http.StatusOK, 1},
+		{"email the provider did not verify", map[string]any{
+			"sub": "bob", "email": "user2@test.dev", "email_verified": false}, http.StatusForbidden, 0},
+		{"email_verified as a string", map[string]any{
+			"sub": "carol", "email": "user3@test.dev", "email_verified": "true"}, http.StatusOK, 3},
+		{"email of a user who did not verify it", map[string]any{
+			"sub": "una", "email": unverifiedEmail, "email_verified": true}, http.StatusConflict, 0},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			w := oidcLogin(t, p, test.claims)
+			if w.Code != test.status {
+				t.Fatalf("Expected status %d, got %d: %s", test.status, w.Code, w.Body.String())
+			}
+
+			linkedID, err := db.Connection.FetchUserIDByIdentity(p.URL, test.claims["sub"].(string))
+			if test.userID == 0 {
+				if err == nil {
+					t.Errorf("Expected no linked identity, got user %d", linkedID)
+				}
+				return
+			}
+
+			response := decodeResponse[loginResponse](t, w, http.StatusOK)
+			if response.User.Id != test.userID || linkedID != test.userID {
+				t.Errorf("Expected user %d to log in and be linked, got %d and %d", test.userID, response.User.Id, linkedID)
+			}
+		})
+	}
+
+	if _, err := db.Connection.FetchUserIDByIdentity(p.URL, "una"); err == nil {
+		t.Errorf("The identity must not be linked to unverified user %d", unverifiedID)
+	}
+}
+
+func TestOIDCCallbackNeedsStateCookie(t *testing.T) {
+	p := newMockProvider(t)
+	useProvider(t, p)
+
+	w := callAction(t, map[string]string{"action": "oidc_start"}, nil)
+	start := decodeResponse[oidcStartResponse](t, w, http.StatusOK)
+	code, state := p.authorize(t, start.AuthorizationURL, map[string]any{
+		"sub": "victim", "email": "user5@test.dev", "email_verified": true,
+	})
+
+	// The callback of someone else's login, e.g. sent to a victim in a link
+	w = callAction(t, map[string]string{"action": "oidc_callback", "code": code, "state": state}, nil)
+	if w.Code != http.StatusBadRequest {
+		t.Errorf("Expected 400 without the state cookie, got %d: %s", w.Code, w.Body.String())
+	}
+
+	otherCookie := &http.Cookie{Name: oidcStateCookie, Value: hashOIDCState("another state")}
+	w = callAction(t, map[string]string{"action": "oidc_callback", "code": code, "state": state}, nil, otherCookie)
+	if w.Code != http.StatusBadRequest {
+		t.Errorf("Expected 400 with the state cookie of another login, got %d: %s", w.Code, w.Body.String())
+	}
+}
+
+func TestOIDCCallbackIsSingleUse(t *testing.T) {
+	p := newMockProvider(t)
+	useProvider(t, p)
+
+	w := callAction(t, map[string]string{"action": "oidc_start"}, nil)
+	start := decodeResponse[oidcStartResponse](t, w, http.StatusOK)
+	stateCookie := responseCookie(w, oidcStateCookie)
+	code, state := p.authorize(t, start.AuthorizationURL, map[string]any{
+		"sub": "alice", "email": "user1@test.dev", "email_verified": true,
+	})
+
+	callback := map[string]string{"action": "oidc_callback", "code": code, "state": state}
+	if w := callAction(t, callback, nil, stateCookie); w.Code != http.StatusOK {
+		t.Fatalf("Expected the first callback to log in, got %d: %s", w.Code, w.Body.String())
+	}
+	if w := callAction(t, callback, nil, stateCookie); w.Code != http.StatusBadRequest {
+		t.Errorf("Expected a replayed callback to fail with 400, got %d: %s", w.Code, w.Body.String())
+	}
+}
+
+func TestOIDCTokenExchangeChecksPKCE(t *testing.T) {
+	p := newMockProvider(t)
+	useProvider(t, p)
+
+	authorizationURL, err := OIDC.AuthorizationURL("state", "nonce", "the-verifier-of-this-login")
+	if err != nil {
+		t.Fatalf("Failed to build authorization URL: %v", err)
+	}
+	code, _ := p.authorize(t, authorizationURL, map[string]any{"sub": "alice"})
+
+	if _, err := OIDC.Exchange(code, "another-verifier"); err == nil {
+		t.Errorf("Expected the exchange to fail with the wrong PKCE verifier")
+	}
+
+	code, _ = p.authorize(t, authorizationURL, map[string]any{"sub": "alice"})
+	if _, err := OIDC.Exchange(code, "the-verifier-of-this-login"); err != nil {
+		t.Errorf("Expected the exchange to work with the right verifier: %v", err)
+	}
+}
+
+func TestVerifyIDToken(t *testing.T) {
+	p := newMockProvider(t)
+	useProvider(t, p)
+
+	otherKey, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatal(err)
+	}
+	now := time.Now()
+
+	tests := []struct {
+		name  string
+		token string
+		err   string // part of the expected error, "" if the token is valid
+	}{
+		{"valid", p.idToken(map[string]any{"sub": "alice", "nonce": "n"}), ""},
+		{"audience list with azp", p.idToken(map[string]any{
+			"sub": "alice", "nonce": "n", "aud": []string{mockClientID, "other"}, "azp": mockClientID}), ""},
+		{"wrong issuer", p.idToken(map[string]any{"sub": "alice", "nonce": "n", "iss": "https://evil.example"}), "issued by"},
+		{"wrong audience", p.idToken(map[string]any{"sub": "alice", "nonce": "n", "aud": "other-client"}), "not meant for this client"},
+		{"audience list without azp", p.idToken(map[string]any{
+			"sub": "alice", "nonce": "n", "aud": []string{mockClientID, "other"}}), "another party"},
+		{"wrong nonce", p.idToken(map[string]any{"sub": "alice", "nonce": "other"}), "nonce"},
+		{"expired", p.idToken(map[string]any{"sub": "alice", "nonce": "n", "exp": now.Add(-time.Hour).Unix()}), "expired"},
+		{"without expiry", p.idToken(map[string]any{"sub": "alice", "nonce": "n", "exp": 0}), "expired"},
+		{"issued in the future", p.idToken(map[string]any{"sub": "alice", "nonce": "n", "iat": now.Add(time.Hour).Unix()}), "future"},
+		{"without subject", p.idToken(map[string]any{"nonce": "n"}), "subject"},
+		{"signed with another key", signJWT(otherKey, mockKeyID, p.idTokenClaims(map[string]any{"sub": "alice", "nonce": "n"})), "signature"},
+		{"unknown key", signJWT(otherKey, "other-key", p.idTokenClaims(map[string]any{"sub": "alice", "nonce": "n"})), "unknown"},
+		{"tampered payload", tamper(p.idToken(map[string]any{"sub": "alice", "nonce": "n"})), "signature"},
+		{"unsigned", unsigned(p.idTokenClaims(map[string]any{"sub": "alice", "nonce": "n"})), "alg"},
+		{"malformed", "not.a-token", "malformed"},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			claims, err := OIDC.VerifyIDToken(test.token, "n")
+			switch {
+			case test.err == "" && err != nil:
+				t.Errorf("Expected a valid token, got %v", err)
+			case test.err == "" && claims.Subject != "alice":
+				t.Errorf("Expected subject alice, got %q", claims.Subject)
+			case test.err != "" && err == nil:
+				t.Errorf("Expected an error about %q, the token was accepted", test.err)
+			case test.err != "" && !strings.Contains(err.Error(), test.err):
+				t.Errorf("Expected an error about %q, got %v", test.err, err)
+			}
+		})
+	}
+}
+
+// tamper swaps the payload of a token for one with another subject, keeping the signature
+func tamper(token string) string {
+	parts := strings.Split(token, ".")
+	payload, _ := base64.RawURLEncoding.DecodeString(parts[1])
+	payload = []byte(strings.Replace(string(payload), `"alice"`, `"mallory"`, 1))
+	return parts[0] + "." + base64.RawURLEncoding.EncodeToString(payload) + "." + parts[2]
+}
+
+// unsigned makes a token with alg none
+func unsigned(claims map[string]any) string {
+	header, _ := json.Marshal(map[string]string{"alg": "none", "kid": mockKeyID})
+	payload, _ := json.Marshal(claims)
+	return base64.RawURLEncoding.EncodeToString(header) + "." + base64.RawURLEncoding.EncodeToString(payload) + "."
+}
@@ -0,0 +1,212 @@
+package api
+
+import (
+	"encoding/json"
+	"log"
+	"net/http"
+	"reflect"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+// The OpenAPI document of the REST routes, generated from restRoutes and the action registry
+
+type openAPIDocument struct {
+	OpenAPI    string                                  `json:"openapi"`
+	Info       openAPIInfo                             `json:"info"`
+	Servers    []openAPIServer                         `json:"servers"`
+	Paths      map[string]map[string]*openAPIOperation `json:"paths"`
+	Components openAPIComponents                       `json:"components"`
+	Security   []map[string][]string                   `json:"security"`
+}
+
+type openAPIInfo struct {
+	Title       string `json:"title"`
+	Description string `json:"description"`
+	Version     string `json:"version"`
+}
+
+type openAPIServer struct {
+	URL string `json:"url"`
+}
+
+type openAPIComponents struct {
+	Schemas         map[string]*jsonSchema           `json:"schemas"`
+	SecuritySchemes map[string]openAPISecurityScheme `json:"securitySchemes"`
+}
+
+type openAPISecurityScheme struct {
+	Type        string `json:"type"`
+	Scheme      string `json:"scheme,omitempty"`
+	In          string `json:"in,omitempty"`
+	Name        string `json:"name,omitempty"`
+	Description string `json:"description,omitempty"`
+}
+
+type openAPIOperation struct {
+	OperationID string                     `json:"operationId"`
+	Summary     string                     `json:"summary"`
+	Tags        []string                   `json:"tags"`
+	Parameters  []openAPIParameter         `json:"parameters,omitempty"`
+	RequestBody *openAPIRequestBody        `json:"requestBody,omitempty"`
+	Responses   map[string]openAPIResponse `json:"responses"`
+	Security    []map[string][]string      `json:"security,omitempty"` // empty for public routes
+	Permission  Permission                 `json:"x-permission,omitempty"`
+	Scope       string                     `json:"x-token-scope,omitempty"`
+}
+
+type openAPIParameter struct {
+	Name     string      `json:"name"`
+	In       string      `json:"in"`
+	Required bool        `json:"required,omitempty"`
+	Schema   *jsonSchema `json:"schema"`
+}
+
+type openAPIRequestBody struct {
+	Required bool                        `json:"required"`
+	Content  map[string]openAPIMediaType `json:"content"`
+}
+
+type openAPIResponse struct {
+	Description string                      `json:"description"`
+	Content     map[string]openAPIMediaType `json:"content,omitempty"`
+}
+
+type openAPIMediaType struct {
+	Schema *jsonSchema `json:"schema"`
+}
+
+// openAPI builds the document once, everything it's made of is fixed at startup
+var openAPI = sync.OnceValue(func() []byte {
+	document, err := json.Marshal(buildOpenAPI())
+	if err != nil {
+		log.Fatalf("Failed to marshal OpenAPI document: %v", err)
+	}
+	return document
+})
+
+// serveOpenAPI serves the OpenAPI document of the REST routes
+func serveOpenAPI(w http.ResponseWriter, r *http.Request) {
+	setCORSHeaders(w, r, "GET, POST, PUT, DELETE, OPTIONS")
+	w.Header().Set("Content-Type", "application/json")
+	w.Write(openAPI())
+}
+
+func buildOpenAPI() openAPIDocument {
+	builder := newSchemaBuilder("#/components/schemas/")
+	errorSchema := builder.schema(reflect.TypeFor[errorResponse]())
+
+	document := openAPIDocument{
+		OpenAPI: "3.0.3",
+		Info: openAPIInfo{
+			Title:       "SocialNetwork API",
+			Description: "Every route calls an action of the action endpoint at /api, see describe_actions.",
+			Version:     "1",
+		},
+		Servers: []openAPIServer{{URL: restPrefix}},
+		Paths:   map[string]map[string]*openAPIOperation{},
+		Components: openAPIComponents{
+			SecuritySchemes: map[string]openAPISecurityScheme{
+				"bearer": {Type: "http", Scheme: "bearer",
+					Description: "An access token from login, or a personal access token"},
+				"session": {Type: "apiKey", In: "cookie", Name: "session_id",
+					Description: "The session cookie of a login, requests also need the " + csrfHeader + " header"},
+			},
+		},
+		Security: []map[string][]string{{"bearer": {}}, {"session": {}}},
+	}
+
+	for _, route := range restRoutes {
+		spec, _ := actions.lookup(route.action)
+
+		operation := &openAPIOperation{
+			OperationID: spec.name,
+			Summary:     spec.summary,
+			Tags:        []string{routeTag(route.path)},
+			Permission:  spec.permission,
+			Scope:       spec.scope,
+			Responses: map[string]openAPIResponse{
+				"default": {Description: "Error", Content: jsonContent(errorSchema)},
+			},
+		}
+		if spec.auth == AuthPublic {
+			operation.Security = []map[string][]string{{}}
+		}
+
+		wildcards := make([]string, 0, len(route.params))
+		omit := map[string]bool{}
+		for wildcard, field := range route.params {
+			wildcards = append(wildcards, wildcard)
+			omit[field] = true
+		}
+		sort.Strings(wildcards)
+		for _, wildcard := range wildcards {
+			operation.Parameters = append(operation.Parameters, openAPIParameter{
+				Name: wildcard, In: "path", Required: true, Schema: &jsonSchema{Type: "integer"},
+			})
+		}
+
+		names := make([]string, 0, len(route.query))
+		for name := range route.query {
+			names = append(names, name)
+		}
+		sort.Strings(names)
+		for _, name := range names {
+			operation.Parameters = append(operation.Parameters, openAPIParameter{
+				Name: name, In: "query", Schema: queryParameterSchema(route.query[name]),
+			})
+		}
+
+		// The body is the action's request without the fields the path fills in
+		if route.method != http.MethodGet && spec.request != nil {
+			body := builder.structSchema(spec.request, omit)
+			if len(body.Properties) > 0 {
+				operation.RequestBody = &openAPIRequestBody{
+					Required: len(body.Required) > 0,
+					Content:  jsonContent(body),
+				}
+			}
+		}
+
+		status := route.status
+		if status == 0 {
+			status = http.StatusOK
+		}
+		operation.Responses[strconv.Itoa(status)] = openAPIResponse{
+			Description: http.StatusText(status),
+			Content:     jsonContent(builder.schema(spec.response)),
+		}
+
+		path := route.path
+		if document.Paths[path] == nil {
+			document.Paths[path] = map[string]*openAPIOperation{}
+		}
+		document.Paths[path][strings.ToLower(route.method)] = operation
+	}
+
+	document.Components.Schemas = builder.schemas
+	return document
+}
+
+func jsonContent(schema *jsonSchema) map[string]openAPIMediaType {
+	return map[string]openAPIMediaType{"application/json": {Schema: schema}}
+}
+
+func queryParameterSchema(kind string) *jsonSchema {
+	switch kind {
+	case "int":
+		return &jsonSchema{Type: "integer"}
+	case "strings":
+		return &jsonSchema{Type: "array", Items: &jsonSchema{Type: "string"}}
+	default:
+		return &jsonSchema{Type: "string"}
+	}
+}
+
+// routeTag groups the routes by their first path segment
+func routeTag(path string) string {
+	segment, _, _ := strings.Cut(strings.TrimPrefix(path, "/"), "/")
+	return segment
+}
@@ -0,0 +1,132 @@
+package api
+
+import (
+	"backend/db"
+	"bufio"
+	"crypto/sha1"
+	"encoding/hex"
+	"fmt"
+	"os"
+	"sort"
+	"strings"
+)
+
+const maxPasswordLength = 72 // bcrypt ignores everything after 72 bytes
+
+// PasswordPolicy is what new passwords must satisfy
+type PasswordPolicy struct {
+	MinLength int
+	MinScore  int // 0 to 4, see passwordStrength
+}
+
+var Passwords = PasswordPolicy{
+	MinLength: 8,
+	MinScore:  2,
+}
+
+// validatePassword checks a new password against the password policy.
+// user is whose password it will be, their email and name must not be part of it.
+// The returned error is meant to be shown to the user.
+func validatePassword(password string, user *db.User) error {
+	if len(password) < Passwords.MinLength {
+		return fmt.Errorf("Password must be at least %d characters long", Passwords.MinLength)
+	}
+
+	if len(password) > maxPasswordLength {
+		return fmt.Errorf("Password must be at most %d bytes long", maxPasswordLength)
+	}
+
+	var userInputs []string
+	if user != nil {
+		localPart, _, _ := strings.Cut(user.Email, "@")
+		userInputs = []string{user.Email, localPart, user.FirstName, user.LastName, user.Nickname}
+	}
+
+	lower := strings.ToLower(password)
+	for _, input := range userInputs {
+		if input = strings.ToLower(strings.TrimSpace(input)); len(input) >= 3 && strings.Contains(lower, input) {
+			return fmt.Errorf("Password must not contain your email address or name")
+		}
+	}
+
+	if BreachedPasswords.Contains(password) {
+		return fmt.Errorf("This password has appeared in a data breach, choose another one")
+	}
+
+	if passwordStrength(password, userInputs) < Passwords.MinScore {
+		return fmt.Errorf("Password is too easy to guess, try a longer one or add unusual words")
+	}
+
+	return nil
+}
+
+// BreachedPasswordList holds SHA-1 hashes of passwords known from data breaches. They are grouped
+// by their first five hex characters like the k-anonymity range API of Have I Been Pwned,
+// so a lookup only searches the hashes sharing its prefix.
+type BreachedPasswordList struct {
+	ranges map[string][]string // hash prefix -> sorted hash suffixes
+	count  int
+}
+
+// BreachedPasswords is empty until LoadBreachedPasswords finds a list
+var BreachedPasswords = &BreachedPasswordList{}
+
+// LoadBreachedPasswords reads a list of SHA-1 password hashes, one per line, in hex and optionally
+// followed by ":count" as in the Have I Been Pwned downloads. Empty lines and lines starting with # are skipped.
+func LoadBreachedPasswords(path string) (*BreachedPasswordList, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+
+	list := &BreachedPasswordList{ranges: map[string][]string{}}
+
+	scanner := bufio.NewScanner(file)
+	for line := 1; scanner.Scan(); line++ {
+		text := strings.TrimSpace(scanner.Text())
+		if text == "" || strings.HasPrefix(text, "#") {
+			continue
+		}
+
+		hash, _, _ := strings.Cut(text, ":")
+		hash = strings.ToUpper(hash)
+		if len(hash) != 40 {
+			return nil, fmt.Errorf("%s:%d: not a SHA-1 hash", path, line)
+		}
+		if _, err := hex.DecodeString(hash); err != nil {
+			return nil, fmt.Errorf("%s:%d: not a SHA-1 hash", path, line)
+		}
+
+		list.ranges[hash[:5]] = append(list.ranges[hash[:5]], hash[5:])
+		list.count++
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, fmt.Errorf("failed to read %s: %w", path, err)
+	}
+
+	for _, suffixes := range list.ranges {
+		sort.Strings(suffixes)
+	}
+
+	return list, nil
+}
+
+// Len returns the number of hashes in the list
+func (b *BreachedPasswordList) Len() int {
+	return b.count
+}
+
+// Contains reports whether a password is on the list
+func (b *BreachedPasswordList) Contains(password string) bool {
+	if b.count == 0 {
+		return false
+	}
+
+	sum := sha1.Sum([]byte(password))
+	hash := strings.ToUpper(hex.EncodeToString(sum[:]))
+
+	suffixes := b.ranges[hash[:5]]
+	i := sort.SearchStrings(suffixes, hash[5:])
+	return i < len(suffixes) && suffixes[i] == hash[5:]
+}
@@ -0,0 +1,200 @@
+package api
+
+import (
+	"backend/db"
+	"crypto/sha1"
+	"encoding/hex"
+	"os"
+	"strings"
+	"testing"
+)
+
+func TestPasswordStrength(t *testing.T) {
+	userInputs := []string{"ada.lovelace@test.dev", "ada.lovelace", "Ada", "Lovelace"}
+
+	tests := []struct {
+		password string
+		weak     bool // scores below the minimum of the password policy
+	}{
+		{"password", true},
+		{"p@ssw0rd", true},
+		{"Password1", true},
+		{"qwerty123", true},
+		{"qwertyuiop", true},
+		{"aaaaaaaa", true},
+		{"abcdefgh", true},
+		{"1987", true},
+		{"summer1987", true},
+		{"jordan2010", true},
+		{"lovelace2024", true},
+		{"mL4vq9Zt", false},
+		{"xk7#Qm2!vR9p", false},
+		{"Tr0ub4dour&3", false},
+		{"glacier-umbrella-tandem", false},
+		{"correct horse battery staple", false},
+	}
+
+	for _, test := range tests {
+		t.Run(test.password, func(t *testing.T) {
+			score := passwordStrength(test.password, userInputs)
+			if score < 0 || score > 4 {
+				t.Fatalf("Expected a score from 0 to 4, got %d", score)
+			}
+			if weak := score < Passwords.MinScore; weak != test.weak {
+				t.Errorf("Expected weak to be %v, got score %d", test.weak, score)
+			}
+		})
+	}
+}
+
+func TestPasswordStrengthUsesUserInputs(t *testing.T) {
+	const password = "Zephyrine1815"
+
+	without := passwordStrength(password, nil)
+	with := passwordStrength(password, []string{"zephyrine@test.dev", "Zephyrine"})
+	if with >= without {
+		t.Errorf("Expected the user's name to weaken the password, scored %d with it and %d without", with, without)
+	}
+}
+
+func TestValidatePassword(t *testing.T) {
+	breached := "Vr8#lantern-quiet"
+	BreachedPasswords = breachedList(t, breached)
+	t.Cleanup(func() { BreachedPasswords = &BreachedPasswordList{} })
+
+	user := &db.User{Email: "ada.lovelace@test.dev", FirstName: "Ada", LastName: "Lovelace", Nickname: "Countess"}
+
+	tests := []struct {
+		name     string
+		password string
+		err      string // part of the expected error, "" if the password is accepted
+	}{
+		{"strong", "glacier-umbrella-tandem", ""},
+		{"too short", "mL4vq9Z", "at least 8 characters"},
+		{"shortest allowed", "mL4vq9Zt", ""},
+		{"longest allowed", strings.Repeat("xk7#Qm2!vR9p", 6), ""},
+		{"too long", strings.Repeat("xk7#Qm2!vR9p", 6) + "a", "at most 72 bytes"},
+		{"contains the email", "ada.lovelace@test.dev!", "email address or name"},
+		{"contains the local part of the email", "Ada.Lovelace#77x", "email address or name"},
+		{"contains the first name", "quietADAharbour9", "email address or name"},
+		{"contains the last name", "9harbour-lovelace", "email address or name"},
+		{"contains the nickname", "Countess-of-9harbours", "email address or name"},
+		{"breached", breached, "data breach"},
+		{"easy to guess", "summer1987", "too easy to guess"},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			err := validatePassword(test.password, user)
+			switch {
+			case test.err == "" && err != nil:
+				t.Errorf("Expected the password to be accepted, got %v", err)
+			case test.err != "" && err == nil:
+				t.Errorf("Expected an error about %q, the password was accepted", test.err)
+			case test.err != "" && !strings.Contains(err.Error(), test.err):
+				t.Errorf("Expected an error about %q, got %v", test.err, err)
+			}
+		})
+	}
+}
+
+// breachedList loads a breached password list containing passwords
+func breachedList(t *testing.T, passwords ...string) *BreachedPasswordList {
+	t.Helper()
+
+	var lines []string
+	for _, password := range passwords {
+		lines = append(lines, sha1Hex(password))
+	}
+	path := writeBreachedList(t, strings.Join(lines, "\n"))
+
+	list, err := LoadBreachedPasswords(path)
+	if err != nil {
+		t.Fatalf("Failed to load breached passwords: %v", err)
+	}
+	return list
+}
+
+func writeBreachedList(t *testing.T, content string) string {
+	t.Helper()
+
+	path := t.TempDir() + "/breached.txt"
+	if err := os.WriteFile(path, []byte(content), 0600); err != nil {
+		t.Fatal(err)
+	}
+	return path
+}
+
+func sha1Hex(password string) string {
+	sum := sha1.Sum([]byte(password))
+	return hex.EncodeToString(sum[:])
+}
+
+func TestLoadBreachedPasswords(t *testing.T) {
+	content := strings.Join([]string{
+		"# downloaded from Have I Been Pwned",
+		strings.ToUpper(sha1Hex("password")) + ":9659365",
+		"",
+		"  " + sha1Hex("letmein") + "  ",
+		strings.ToUpper(sha1Hex("hunter2")),
+		// Shares the first five characters with "password", but isn't it
+		sha1Hex("password")[:5] + strings.Repeat("0", 35) + ":1",
+	}, "\n")
+
+	list, err := LoadBreachedPasswords(writeBreachedList(t, content))
+	if err != nil {
+		t.Fatalf("Failed to load breached passwords: %v", err)
+	}
+	if list.Len() != 4 {
+		t.Errorf("Expected 4 hashes, got %d", list.Len())
+	}
+
+	for password, want := range map[string]bool{
+		"password": true,
+		"letmein":  true,
+		"hunter2":  true,
+		"Password": false,
+		"hunter3":  false,
+		"":         false,
+	} {
+		if got := list.Contains(password); got != want {
+			t.Errorf("Contains(%q) = %v, expected %v", password, got, want)
+		}
+	}
+}
+
+func TestLoadBreachedPasswordsRejectsMalformedLines(t *testing.T) {
+	tests := []struct {
+		name string
+		line string
+	}{
+		{"too short", sha1Hex("password")[:39]},
+		{"too long", sha1Hex("password") + "0"},
+		{"not hex", "Z" + sha1Hex("password")[1:]},
+		{"count without hash", ":42"},
+		{"plain password", "password"},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			path := writeBreachedList(t, sha1Hex("letmein")+"\n"+test.line)
+			_, err := LoadBreachedPasswords(path)
+			if err == nil || !strings.Contains(err.Error(), ":2: not a SHA-1 hash") {
+				t.Errorf("Expected line 2 to be rejected, got %v", err)
+			}
+		})
+	}
+}
+
+func TestEmptyBreachedPasswordList(t *testing.T) {
+	list, err := LoadBreachedPasswords(writeBreachedList(t, "# nothing yet\n"))
+	if err != nil {
+		t.Fatalf("Failed to load breached passwords: %v", err)
+	}
+	if list.Len() != 0 || list.Contains("password") {
+		t.Errorf("Expected an empty list, got %d hashes", list.Len())
+	}
+	if (&BreachedPasswordList{}).Contains("password") {
+		t.Error("Expected the list without a file to contain nothing")
+	}
+}
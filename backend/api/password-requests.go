@@ -0,0 +1,235 @@
+package api
+
+import (
+	"backend/db"
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+)
+
+const passwordResetLifetime = time.Hour
+
+// requestPasswordReset emails a single-use reset token to the user.
+// The response is the same whether or not the email is registered.
+func (ar *apiRequest) requestPasswordReset(request *PasswordResetRequest) {
+	email := strings.TrimSpace(request.Email)
+
+	response := map[string]string{
+		"message": "If the email is registered, a password reset link has been sent to it",
+	}
+	responseJSON, _ := json.Marshal(response)
+
+	user, err := ar.db.FetchUserByEmail(email)
+	if err != nil {
+		log.Printf("Password reset requested for unknown email %s", email)
+		ar.response = string(responseJSON)
+		return
+	}
+
+	// Reset tokens are generated and stored like session IDs, only the hash is kept
+	token := GenerateSessionID()
+	if token == "" {
+		ar.setError(http.StatusInternalServerError, "Failed to create reset token")
+		return
+	}
+
+	if err := ar.db.CreatePasswordResetToken(user.Id, hashSessionID(token), time.Now().Add(passwordResetLifetime)); err != nil {
+		log.Printf("Failed to store reset token: %v", err)
+		ar.setError(http.StatusInternalServerError, "Failed to create reset token")
+		return
+	}
+
+	link := PublicURL + "/reset-password?token=" + url.QueryEscape(token)
+	sendEmail(Email{
+		To:      user.Email,
+		Subject: "Reset your password",
+		Body: fmt.Sprintf("Hi %s,\n\nSomeone asked to reset the password of your account. "+
+			"To choose a new password, open this link within %d minutes:\n\n%s\n\n"+
+			"Or use this reset code: %s\n\n"+
+			"If it wasn't you, ignore this email and your password stays the same.",
+			user.FirstName, int(passwordResetLifetime.Minutes()), link, token),
+	})
+
+	log.Printf("Password reset requested for user %d", user.Id)
+	ar.recordEvent(user.Id, EventPasswordResetRequested, nil)
+	ar.response = string(responseJSON)
+}
+
+// resetPassword sets a new password with a reset token, logging the user out everywhere
+func (ar *apiRequest) resetPassword(request *ResetPasswordRequest) {
+	tokenHash := hashSessionID(request.Token)
+
+	// The password is checked before the token is used up, so the user can try another one
+	userID, err := ar.db.PasswordResetTokenUser(tokenHash)
+	if err != nil {
+		if err != sql.ErrNoRows {
+			log.Printf("Failed to check reset token: %v", err)
+			ar.setError(http.StatusInternalServerError, "Failed to reset password")
+			return
+		}
+		ar.setError(http.StatusBadRequest, "Invalid or expired reset token")
+		return
+	}
+
+	user, err := ar.db.FetchUser(userID)
+	if err != nil {
+		log.Printf("Failed to fetch user %d: %v", userID, err)
+		ar.setError(http.StatusInternalServerError, "Failed to reset password")
+		return
+	}
+
+	if err := validatePassword(request.Password, user); err != nil {
+		ar.setErrorCode(http.StatusBadRequest, CodeWeakPassword, err.Error())
+		return
+	}
+
+	if _, err := ar.db.ConsumePasswordResetToken(tokenHash); err != nil {
+		if err != sql.ErrNoRows {
+			log.Printf("Failed to use reset token: %v", err)
+			ar.setError(http.StatusInternalServerError, "Failed to reset password")
+			return
+		}
+		ar.setError(http.StatusBadRequest, "Invalid or expired reset token")
+		return
+	}
+
+	if err := setUserPassword(userID, request.Password, 0); err != nil {
+		log.Printf("Failed to reset password for user %d: %v", userID, err)
+		ar.setError(http.StatusInternalServerError, "Failed to reset password")
+		return
+	}
+
+	ClearSessionCookie(ar.httpWriter)
+
+	// The user proved they own the email, failed logins shouldn't keep them locked out
+	Throttle.Succeeded(user.Email)
+
+	sendEmail(Email{
+		To:      user.Email,
+		Subject: "Your password was changed",
+		Body: fmt.Sprintf("Hi %s,\n\nThe password of your account was just reset and every device was logged out. "+
+			"If it wasn't you, reset your password again right away.", user.FirstName),
+	})
+
+	log.Printf("Password reset for user %d", userID)
+	ar.recordEvent(userID, EventPasswordReset, nil)
+
+	response := map[string]string{
+		"message": "Password has been reset, please log in again",
+	}
+
+	responseJSON, err := json.Marshal(response)
+	if err != nil {
+		log.Printf("Error marshalling reset password response: %v", err)
+		ar.setError(http.StatusInternalServerError, "Internal server error")
+		return
+	}
+
+	ar.response = string(responseJSON)
+}
+
+// reauthenticate checks the password of the authenticated user again before a sensitive change.
+// Wrong passwords count as failed logins. Returns false if the response was already set.
+func (ar *apiRequest) reauthenticate(password string) (*db.User, bool) {
+	user, err := ar.db.FetchUser(ar.claims.Id)
+	if err != nil {
+		log.Printf("Failed to fetch user %d: %v", ar.claims.Id, err)
+		ar.setError(http.StatusInternalServerError, "Internal server error")
+		return nil, false
+	}
+
+	ip := clientIP(ar.httpRequest)
+	if throttled := Throttle.Check(user.Email, ip); throttled.retryAfter > 0 {
+		ar.setRetryAfter(throttled.retryAfter, CodeLoginThrottled, "Too many failed password attempts, try again later")
+		return nil, false
+	}
+
+	if err := db.VerifyPassword(user.Password, password); err != nil {
+		Throttle.Failed(user.Email, ip)
+		ar.recordEvent(user.Id, EventPasswordCheckFailed, nil)
+		ar.setError(http.StatusUnauthorized, "Invalid password")
+		return nil, false
+	}
+
+	return user, true
+}
+
+// respondWithNewTokens answers a change that revoked the user's tokens with new ones,
+// so the client making the change stays logged in
+func (ar *apiRequest) respondWithNewTokens(user *db.User, message string) {
+	tokens, err := newTokenPair(&Claims{Id: user.Id, Email: user.Email})
+	if err != nil {
+		log.Printf("Failed to issue tokens: %v", err)
+		ar.setError(http.StatusInternalServerError, "Internal server error")
+		return
+	}
+
+	response := map[string]interface{}{
+		"message":      message,
+		"token":        tokens.Token,
+		"refreshToken": tokens.RefreshToken,
+		"expiresIn":    tokens.ExpiresIn,
+	}
+
+	responseJSON, err := json.Marshal(response)
+	if err != nil {
+		log.Printf("Error marshalling response: %v", err)
+		ar.setError(http.StatusInternalServerError, "Internal server error")
+		return
+	}
+
+	ar.response = string(responseJSON)
+}
+
+// changePassword sets a new password for the authenticated user after checking the current one.
+// Every other session and token is revoked.
+func (ar *apiRequest) changePassword(request *ChangePasswordRequest) {
+	user, ok := ar.reauthenticate(request.CurrentPassword)
+	if !ok {
+		return
+	}
+
+	if err := validatePassword(request.NewPassword, user); err != nil {
+		ar.setErrorCode(http.StatusBadRequest, CodeWeakPassword, err.Error())
+		return
+	}
+
+	if request.NewPassword == request.CurrentPassword {
+		ar.setError(http.StatusBadRequest, "New password must be different from the current one")
+		return
+	}
+
+	if err := setUserPassword(user.Id, request.NewPassword, ar.currentSessionRecordID()); err != nil {
+		log.Printf("Failed to change password for user %d: %v", user.Id, err)
+		ar.setError(http.StatusInternalServerError, "Failed to change password")
+		return
+	}
+
+	sendEmail(Email{
+		To:      user.Email,
+		Subject: "Your password was changed",
+		Body: fmt.Sprintf("Hi %s,\n\nThe password of your account was just changed and your other devices were logged out. "+
+			"If it wasn't you, reset your password right away.", user.FirstName),
+	})
+
+	log.Printf("User %d changed their password", user.Id)
+	ar.recordEvent(user.Id, EventPasswordChanged, nil)
+	ar.respondWithNewTokens(user, "Password changed")
+}
+
+// CleanupPasswordResetTokens removes reset tokens that have expired
+func CleanupPasswordResetTokens() {
+	count, err := db.Connection.DeleteExpiredPasswordResetTokens()
+	if err != nil {
+		log.Printf("Error cleaning up password reset tokens: %v", err)
+		return
+	}
+	if count > 0 {
+		log.Printf("Cleaned up %d expired password reset tokens", count)
+	}
+}
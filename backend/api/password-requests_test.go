@@ -0,0 +1,120 @@
+package api
+
+import (
+	"backend/db"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+const newPassword = "glacier-umbrella-tandem"
+
+// requestReset asks for a password reset of email and returns the code from the email
+func requestReset(t *testing.T, inbox *mailbox, email string) string {
+	t.Helper()
+
+	w := callAction(t, map[string]string{"action": "request_password_reset", "email": email}, nil)
+	decodeResponse[messageResponse](t, w, http.StatusOK)
+	return codeIn(t, inbox.receive(t, email), "reset code: ")
+}
+
+func resetPassword(t *testing.T, token string, password string) *httptest.ResponseRecorder {
+	t.Helper()
+	return callAction(t, map[string]string{"action": "reset_password", "token": token, "password": password}, nil)
+}
+
+func TestPasswordReset(t *testing.T) {
+	inbox := captureMail(t)
+	_, email := newUser(t)
+	user, session := login(t, email)
+
+	token := requestReset(t, inbox, email)
+
+	// A weak password doesn't use up the token
+	w := resetPassword(t, token, "password1")
+	if response := decodeResponse[errorResponse](t, w, http.StatusBadRequest); response.Code != CodeWeakPassword {
+		t.Errorf("Expected %s, got %s", CodeWeakPassword, w.Body.String())
+	}
+
+	decodeResponse[messageResponse](t, resetPassword(t, token, newPassword), http.StatusOK)
+	if changed := inbox.receive(t, email); changed.Subject != "Your password was changed" {
+		t.Errorf("Expected a notice of the change, got %q", changed.Subject)
+	}
+
+	if w := callAction(t, map[string]string{"action": "list_sessions"}, sessionHeader(user), session); w.Code != http.StatusUnauthorized {
+		t.Errorf("Expected the session to be logged out by the reset, got %d", w.Code)
+	}
+	if w := callAction(t, map[string]string{"action": "list_sessions"}, bearerHeader(user)); w.Code != http.StatusUnauthorized {
+		t.Errorf("Expected the bearer token to be revoked by the reset, got %d", w.Code)
+	}
+
+	w = callAction(t, map[string]string{"action": "login", "email": email, "password": newPassword}, nil)
+	decodeResponse[loginResponse](t, w, http.StatusOK)
+}
+
+func TestPasswordResetTokenCanOnlyBeUsedOnce(t *testing.T) {
+	inbox := captureMail(t)
+	_, email := newUser(t)
+
+	token := requestReset(t, inbox, email)
+	decodeResponse[messageResponse](t, resetPassword(t, token, newPassword), http.StatusOK)
+
+	decodeResponse[errorResponse](t, resetPassword(t, token, "another-"+newPassword), http.StatusBadRequest)
+	w := callAction(t, map[string]string{"action": "login", "email": email, "password": newPassword}, nil)
+	decodeResponse[loginResponse](t, w, http.StatusOK)
+}
+
+func TestPasswordResetTokenExpires(t *testing.T) {
+	userID, _ := newUser(t)
+
+	token := GenerateSessionID()
+	if err := db.Connection.CreatePasswordResetToken(userID, hashSessionID(token), time.Now().Add(-time.Minute)); err != nil {
+		t.Fatalf("Failed to create reset token: %v", err)
+	}
+
+	decodeResponse[errorResponse](t, resetPassword(t, token, newPassword), http.StatusBadRequest)
+}
+
+func TestPasswordResetOfUnknownEmail(t *testing.T) {
+	w := callAction(t, map[string]string{"action": "request_password_reset", "email": "nobody@test.dev"}, nil)
+	known := callAction(t, map[string]string{"action": "request_password_reset", "email": "user5@test.dev"}, nil)
+
+	if w.Code != known.Code || w.Body.String() != known.Body.String() {
+		t.Errorf("Expected the same answer whether or not the email is registered, got %s and %s", w.Body.String(), known.Body.String())
+	}
+}
+
+func TestChangePassword(t *testing.T) {
+	inbox := captureMail(t)
+	_, email := newUser(t)
+	t.Cleanup(func() { Throttle.Succeeded(email) })
+	user, session := login(t, email)
+	change := func(current string, password string) *httptest.ResponseRecorder {
+		body := map[string]string{"action": "change_password", "currentPassword": current, "newPassword": password}
+		return callAction(t, body, sessionHeader(user), session)
+	}
+
+	decodeResponse[errorResponse](t, change("wrong", newPassword), http.StatusUnauthorized)
+	w := change("123", "password1")
+	if response := decodeResponse[errorResponse](t, w, http.StatusBadRequest); response.Code != CodeWeakPassword {
+		t.Errorf("Expected %s, got %s", CodeWeakPassword, w.Body.String())
+	}
+
+	changed := decodeResponse[newTokensResponse](t, change("123", newPassword), http.StatusOK)
+	if notice := inbox.receive(t, email); notice.Subject != "Your password was changed" {
+		t.Errorf("Expected a notice of the change, got %q", notice.Subject)
+	}
+
+	// The tokens issued with the change work, the ones from before don't
+	if w := callAction(t, map[string]string{"action": "list_sessions"}, bearerHeader(user)); w.Code != http.StatusUnauthorized {
+		t.Errorf("Expected the old bearer token to be revoked, got %d", w.Code)
+	}
+	fresh := loginResponse{Token: changed.Token}
+	decodeResponse[sessionsResponse](t, callAction(t, map[string]string{"action": "list_sessions"}, bearerHeader(fresh)), http.StatusOK)
+
+	w = callAction(t, map[string]string{"action": "login", "email": email, "password": "123"}, nil)
+	decodeResponse[errorResponse](t, w, http.StatusUnauthorized)
+	w = callAction(t, map[string]string{"action": "login", "email": email, "password": newPassword}, nil)
+	decodeResponse[loginResponse](t, w, http.StatusOK)
+}
@@ -0,0 +1,238 @@
+package api
+
+import (
+	"math"
+	"strings"
+	"unicode"
+)
+
+// commonPasswords are among the most used passwords and password words, most common first.
+// The rank of a word is the number of guesses an attacker needs to reach it.
+var commonPasswords = []string{
+	"password", "123456", "qwerty", "letmein", "welcome", "admin", "login", "master",
+	"dragon", "monkey", "football", "baseball", "iloveyou", "sunshine", "princess", "shadow",
+	"superman", "batman", "trustno1", "starwars", "whatever", "freedom", "hello", "charlie",
+	"michael", "jennifer", "jordan", "hunter", "ranger", "buster", "soccer", "hockey",
+	"killer", "george", "andrew", "thomas", "robert", "daniel", "jessica", "pepper",
+	"ginger", "summer", "winter", "spring", "autumn", "secret", "access", "love",
+	"lovely", "flower", "cheese", "computer", "internet", "google", "samsung", "apple",
+	"orange", "banana", "chocolate", "cookie", "pokemon", "naruto", "matrix", "mustang",
+	"corvette", "ferrari", "harley", "yankees", "liverpool", "chelsea", "arsenal", "barcelona",
+	"tigger", "maggie", "bailey", "ashley", "nicole", "michelle", "amanda", "hannah",
+	"family", "friends", "forever", "angel", "baby", "happy", "money", "change",
+	"passw0rd", "abc123", "qwertyuiop", "asdfgh", "zxcvbn", "111111", "000000", "654321",
+	"socialnetwork", "social", "network", "facebook", "twitter", "instagram", "default", "guest",
+	"test", "user", "root", "changeme", "mypassword", "pass", "secure", "private",
+}
+
+// keyboardRows are walked by people who think "qwerty" looks random
+var keyboardRows = []string{"qwertyuiop", "asdfghjkl", "zxcvbnm", "1qaz2wsx3edc", "qazwsxedc"}
+
+// unleet undoes common character substitutions, so "p@ssw0rd" matches "password"
+var unleet = strings.NewReplacer("@", "a", "4", "a", "3", "e", "1", "i", "!", "i", "0", "o", "$", "s", "5", "s", "7", "t")
+
+// passwordMatch is a part of a password that follows a pattern, and the guesses it takes to find it
+type passwordMatch struct {
+	start, end int // byte offsets, end exclusive
+	guesses    float64
+}
+
+// passwordStrength estimates how hard a password is to guess, in the spirit of zxcvbn.
+// The password is split into the cheapest combination of known patterns (common words,
+// the user's own details, keyboard walks, sequences, repeats and years) and characters
+// that have to be brute-forced. The score goes from 0 (guessed in under a thousand tries)
+// to 4 (more than ten billion).
+func passwordStrength(password string, userInputs []string) int {
+	lower := strings.ToLower(password)
+	if !isASCII(lower) {
+		// The patterns are ASCII, other characters are simply brute-forced
+		lower = password
+	}
+	matches := passwordMatches(password, lower, userInputs)
+
+	// best[i] is the log10 of the fewest guesses for the first i bytes
+	best := make([]float64, len(lower)+1)
+	for i := 1; i <= len(lower); i++ {
+		best[i] = best[i-1] + math.Log10(bruteforceCardinality(lower[i-1]))
+		for _, m := range matches {
+			if m.end == i {
+				best[i] = math.Min(best[i], best[m.start]+math.Log10(math.Max(m.guesses, 1)))
+			}
+		}
+	}
+
+	switch guesses := best[len(lower)]; {
+	case guesses < 3:
+		return 0
+	case guesses < 6:
+		return 1
+	case guesses < 8:
+		return 2
+	case guesses < 10:
+		return 3
+	default:
+		return 4
+	}
+}
+
+func passwordMatches(password string, lower string, userInputs []string) []passwordMatch {
+	var matches []passwordMatch
+
+	// Common words and the user's own details, also with substitutions undone
+	unleeted := unleet.Replace(lower)
+	words := map[string]float64{}
+	for rank, word := range commonPasswords {
+		words[word] = float64(rank + 1)
+	}
+	for _, input := range userInputs {
+		if input = strings.ToLower(input); len(input) >= 3 {
+			words[input] = 1
+		}
+	}
+	for word, rank := range words {
+		for _, candidate := range []string{lower, unleeted} {
+			if len(candidate) != len(lower) {
+				continue // a substitution changed the length, offsets would not line up
+			}
+			for offset := 0; ; {
+				i := strings.Index(candidate[offset:], word)
+				if i < 0 {
+					break
+				}
+				start := offset + i
+				guesses := rank * capitalizationVariations(password[start:start+len(word)])
+				if candidate[start:start+len(word)] != lower[start:start+len(word)] {
+					guesses *= 2 // substitutions are among the first things tried
+				}
+				matches = append(matches, passwordMatch{start, start + len(word), guesses})
+				offset = start + 1
+			}
+		}
+	}
+
+	// Keyboard walks, forwards and backwards
+	for _, row := range keyboardRows {
+		for _, walk := range []string{row, reverse(row)} {
+			for length := 4; length <= len(walk); length++ {
+				for from := 0; from+length <= len(walk); from++ {
+					for offset := 0; ; {
+						i := strings.Index(lower[offset:], walk[from:from+length])
+						if i < 0 {
+							break
+						}
+						matches = append(matches, passwordMatch{offset + i, offset + i + length, 40 * float64(length)})
+						offset += i + 1
+					}
+				}
+			}
+		}
+	}
+
+	// Sequences like "abc" or "9876", and repeats like "aaa"
+	for start := 0; start < len(lower); {
+		end := start + 1
+		for end < len(lower) && lower[end] == lower[start] {
+			end++
+		}
+		if end-start >= 3 {
+			matches = append(matches, passwordMatch{start, end, bruteforceCardinality(lower[start]) * float64(end-start)})
+			start = end
+			continue
+		}
+
+		for _, step := range []int{1, -1} {
+			end = start + 1
+			for end < len(lower) && int(lower[end])-int(lower[end-1]) == step && sameClass(lower[end], lower[start]) {
+				end++
+			}
+			if end-start >= 3 {
+				guesses := 26.0
+				switch {
+				case strings.IndexByte("az019", lower[start]) >= 0:
+					guesses = 4 // obvious starting points
+				case unicode.IsDigit(rune(lower[start])):
+					guesses = 10
+				}
+				if step < 0 {
+					guesses *= 2
+				}
+				matches = append(matches, passwordMatch{start, end, guesses * float64(end-start)})
+			}
+		}
+		start++
+	}
+
+	// Years from 1900 to 2099
+	for i := 0; i+4 <= len(lower); i++ {
+		if year := lower[i : i+4]; (strings.HasPrefix(year, "19") || strings.HasPrefix(year, "20")) && isDigits(year) {
+			matches = append(matches, passwordMatch{i, i + 4, 120})
+		}
+	}
+
+	return matches
+}
+
+// capitalizationVariations is how many ways of capitalizing a word an attacker tries before this one
+func capitalizationVariations(word string) float64 {
+	upper := 0
+	for _, r := range word {
+		if unicode.IsUpper(r) {
+			upper++
+		}
+	}
+
+	switch {
+	case upper == 0:
+		return 1
+	case upper == len(word) || (upper == 1 && unicode.IsUpper(rune(word[0]))):
+		return 2 // "PASSWORD" and "Password" are tried early
+	default:
+		return math.Pow(2, float64(min(upper, len(word)-upper)))
+	}
+}
+
+// bruteforceCardinality is the number of characters an attacker tries in place of c
+func bruteforceCardinality(c byte) float64 {
+	switch {
+	case c >= '0' && c <= '9':
+		return 10
+	case c >= 'a' && c <= 'z':
+		return 26
+	case c >= 'A' && c <= 'Z':
+		return 26
+	case c < 128:
+		return 33
+	default:
+		return 100
+	}
+}
+
+func sameClass(a byte, b byte) bool {
+	return bruteforceCardinality(a) == bruteforceCardinality(b) && unicode.IsDigit(rune(a)) == unicode.IsDigit(rune(b))
+}
+
+func reverse(s string) string {
+	bytes := []byte(s)
+	for i, j := 0, len(bytes)-1; i < j; i, j = i+1, j-1 {
+		bytes[i], bytes[j] = bytes[j], bytes[i]
+	}
+	return string(bytes)
+}
+
+func isDigits(s string) bool {
+	for _, r := range s {
+		if !unicode.IsDigit(r) {
+			return false
+		}
+	}
+	return true
+}
+
+func isASCII(s string) bool {
+	for i := 0; i < len(s); i++ {
+		if s[i] >= 128 {
+			return false
+		}
+	}
+	return true
+}
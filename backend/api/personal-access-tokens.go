@@ -0,0 +1,199 @@
+package api
+
+import (
+	"backend/db"
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"sort"
+	"strings"
+	"time"
+)
+
+const (
+	// personalAccessTokenPrefix tells personal access tokens apart from signed bearer tokens
+	personalAccessTokenPrefix = "snp_"
+	maxPersonalAccessTokens   = 50
+	maxTokenNameLength        = 100
+)
+
+// Scopes a personal access token can be given
+const (
+	ScopePostsRead          = "posts:read"
+	ScopePostsWrite         = "posts:write"
+	ScopeProfileRead        = "profile:read"
+	ScopeNotificationsRead  = "notifications:read"
+	ScopeNotificationsWrite = "notifications:write"
+	ScopeChatRead           = "chat:read"  // connect to the websocket and receive messages
+	ScopeChatWrite          = "chat:write" // send messages over the websocket
+)
+
+var validScopes = []string{
+	ScopePostsRead, ScopePostsWrite, ScopeProfileRead,
+	ScopeNotificationsRead, ScopeNotificationsWrite, ScopeChatRead, ScopeChatWrite,
+}
+
+// allows reports whether the claims may use a scope. Claims from logins may do anything.
+func (c *Claims) allows(scope string) bool {
+	return !c.PersonalAccessToken || contains(c.Scopes, scope)
+}
+
+// allowsAction reports whether the claims may call an action. Actions without a scope,
+// like account settings and token management, can't be called with a personal access token.
+func (c *Claims) allowsAction(spec *actionSpec) bool {
+	if !c.PersonalAccessToken {
+		return true
+	}
+	return spec.scope != "" && c.allows(spec.scope)
+}
+
+// authenticatePersonalAccessToken returns the claims of a valid personal access token.
+// Its use is recorded as a security event at most once a minute, when its last use is updated.
+func authenticatePersonalAccessToken(r *http.Request, token string) (*Claims, error) {
+	pat, err := db.Connection.FetchPersonalAccessToken(hashSessionID(token))
+	if err != nil {
+		if err == sql.ErrNoRows {
+			return nil, fmt.Errorf("unknown, expired or revoked personal access token")
+		}
+		return nil, err
+	}
+
+	if pat.LastUsedAt == nil || time.Since(*pat.LastUsedAt) > time.Minute {
+		recordSecurityEvent(r, pat.UserID, EventPersonalAccessTokenUsed, map[string]interface{}{"tokenId": pat.ID, "name": pat.Name})
+	}
+
+	return &Claims{
+		Id:                  pat.UserID,
+		Email:               pat.Email,
+		PersonalAccessToken: true,
+		Scopes:              pat.Scopes,
+	}, nil
+}
+
+// createToken creates a personal access token for the authenticated user.
+// The token is only returned this once.
+func (ar *apiRequest) createToken(request *CreateTokenRequest) {
+	request.Name = strings.TrimSpace(request.Name)
+	if request.Name == "" || len(request.Name) > maxTokenNameLength {
+		ar.setError(http.StatusBadRequest, fmt.Sprintf("Name is required and must be at most %d characters", maxTokenNameLength))
+		return
+	}
+
+	if len(request.Scopes) == 0 {
+		ar.setError(http.StatusBadRequest, "At least one scope is required")
+		return
+	}
+
+	scopes := []string{}
+	for _, scope := range request.Scopes {
+		if !contains(validScopes, scope) {
+			ar.setError(http.StatusBadRequest, fmt.Sprintf("Unknown scope %s, valid scopes are %s", scope, strings.Join(validScopes, ", ")))
+			return
+		}
+		if !contains(scopes, scope) {
+			scopes = append(scopes, scope)
+		}
+	}
+	sort.Strings(scopes)
+
+	var expiresAt *time.Time
+	if request.ExpiresInDays > 0 {
+		t := time.Now().Add(time.Duration(request.ExpiresInDays) * 24 * time.Hour)
+		expiresAt = &t
+	}
+
+	count, err := ar.db.CountUserPersonalAccessTokens(ar.claims.Id)
+	if err != nil {
+		log.Printf("Failed to count personal access tokens: %v", err)
+		ar.setError(http.StatusInternalServerError, "Internal server error")
+		return
+	}
+	if count >= maxPersonalAccessTokens {
+		ar.setError(http.StatusConflict, fmt.Sprintf("You can have at most %d tokens, revoke one first", maxPersonalAccessTokens))
+		return
+	}
+
+	token := personalAccessTokenPrefix + GenerateSessionID()
+	id, err := ar.db.CreatePersonalAccessToken(ar.claims.Id, request.Name, hashSessionID(token), scopes, expiresAt)
+	if err != nil {
+		log.Printf("Failed to create personal access token: %v", err)
+		ar.setError(http.StatusInternalServerError, "Internal server error")
+		return
+	}
+
+	log.Printf("User %d created personal access token %d with scopes %v", ar.claims.Id, id, scopes)
+	ar.recordEvent(ar.claims.Id, EventPersonalAccessTokenCreated, map[string]interface{}{"tokenId": id, "name": request.Name, "scopes": scopes})
+
+	response := map[string]interface{}{
+		"id":        id,
+		"name":      request.Name,
+		"token":     token,
+		"scopes":    scopes,
+		"expiresAt": expiresAt,
+	}
+
+	responseJSON, err := json.Marshal(response)
+	if err != nil {
+		log.Printf("Error marshalling create token response: %v", err)
+		ar.setError(http.StatusInternalServerError, "Internal server error")
+		return
+	}
+
+	ar.response = string(responseJSON)
+}
+
+// listTokens lists the authenticated user's personal access tokens, without the tokens themselves
+func (ar *apiRequest) listTokens() {
+	tokens, err := ar.db.FetchUserPersonalAccessTokens(ar.claims.Id)
+	if err != nil {
+		log.Printf("Failed to list personal access tokens: %v", err)
+		ar.setError(http.StatusInternalServerError, "Internal server error")
+		return
+	}
+
+	response := map[string]interface{}{
+		"tokens": tokens,
+	}
+
+	responseJSON, err := json.Marshal(response)
+	if err != nil {
+		log.Printf("Error marshalling list tokens response: %v", err)
+		ar.setError(http.StatusInternalServerError, "Internal server error")
+		return
+	}
+
+	ar.response = string(responseJSON)
+}
+
+// revokeToken deletes one of the authenticated user's personal access tokens
+func (ar *apiRequest) revokeToken(request *RevokeTokenRequest) {
+	deleted, err := ar.db.DeletePersonalAccessToken(ar.claims.Id, request.ID)
+	if err != nil {
+		log.Printf("Failed to revoke personal access token: %v", err)
+		ar.setError(http.StatusInternalServerError, "Internal server error")
+		return
+	}
+
+	if !deleted {
+		ar.setError(http.StatusNotFound, "Token not found")
+		return
+	}
+
+	log.Printf("User %d revoked personal access token %d", ar.claims.Id, request.ID)
+	ar.recordEvent(ar.claims.Id, EventPersonalAccessTokenRevoked, map[string]interface{}{"tokenId": request.ID})
+
+	response := map[string]string{
+		"message": "Token revoked",
+	}
+
+	responseJSON, err := json.Marshal(response)
+	if err != nil {
+		log.Printf("Error marshalling revoke token response: %v", err)
+		ar.setError(http.StatusInternalServerError, "Internal server error")
+		return
+	}
+
+	ar.response = string(responseJSON)
+}
@@ -0,0 +1,102 @@
+package api
+
+import (
+	"backend/db"
+	"net/http"
+	"testing"
+)
+
+func TestClaimsAllowsAction(t *testing.T) {
+	postsRead := &actionSpec{name: "get_posts", scope: ScopePostsRead}
+	noScope := &actionSpec{name: "change_password"}
+
+	tests := []struct {
+		name   string
+		claims Claims
+		spec   *actionSpec
+		want   bool
+	}{
+		{"login", Claims{Id: 1}, postsRead, true},
+		{"login on action without scope", Claims{Id: 1}, noScope, true},
+		{"token with the scope", Claims{Id: 1, PersonalAccessToken: true, Scopes: []string{ScopePostsRead}}, postsRead, true},
+		{"token with other scopes", Claims{Id: 1, PersonalAccessToken: true, Scopes: []string{ScopePostsWrite}}, postsRead, false},
+		{"token without scopes", Claims{Id: 1, PersonalAccessToken: true}, postsRead, false},
+		{"token with empty scopes", Claims{Id: 1, PersonalAccessToken: true, Scopes: []string{}}, postsRead, false},
+		{"token on action without scope", Claims{Id: 1, PersonalAccessToken: true, Scopes: validScopes}, noScope, false},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			if got := test.claims.allowsAction(test.spec); got != test.want {
+				t.Errorf("allowsAction(%s) = %v, want %v", test.spec.name, got, test.want)
+			}
+		})
+	}
+}
+
+func TestPersonalAccessTokenScopes(t *testing.T) {
+	user, _ := login(t, "user2@test.dev")
+
+	w := callAction(t, map[string]any{"action": "create_token", "name": "followers", "scopes": []string{ScopeProfileRead}},
+		http.Header{"Authorization": {"Bearer " + user.Token}})
+	token := decodeResponse[createdTokenResponse](t, w, http.StatusOK)
+	bearer := http.Header{"Authorization": {"Bearer " + token.Token}}
+
+	w = callAction(t, map[string]string{"action": "get_followers"}, bearer)
+	decodeResponse[followersResponse](t, w, http.StatusOK)
+
+	for _, action := range []string{"get_posts", "list_sessions", "create_token"} {
+		w = callAction(t, map[string]string{"action": action, "name": "escalated"}, bearer)
+		if response := decodeResponse[errorResponse](t, w, http.StatusForbidden); response.Code != CodeInsufficientScope {
+			t.Errorf("Expected %s to be refused with %q, got %q", action, CodeInsufficientScope, response.Code)
+		}
+	}
+}
+
+func TestPersonalAccessTokenRevocation(t *testing.T) {
+	tests := []struct {
+		name   string
+		revoke func(t *testing.T, userID int, user loginResponse, session *http.Cookie)
+	}{
+		{"password change", func(t *testing.T, _ int, user loginResponse, session *http.Cookie) {
+			w := callAction(t, map[string]string{"action": "change_password", "currentPassword": "123",
+				"newPassword": "a much stronger passphrase 42"}, sessionHeader(user), session)
+			decodeResponse[newTokensResponse](t, w, http.StatusOK)
+		}},
+		{"deactivation", func(t *testing.T, _ int, user loginResponse, session *http.Cookie) {
+			w := callAction(t, map[string]string{"action": "deactivate_account", "password": "123"}, sessionHeader(user), session)
+			decodeResponse[messageResponse](t, w, http.StatusOK)
+		}},
+		{"deletion", func(t *testing.T, _ int, user loginResponse, session *http.Cookie) {
+			w := callAction(t, map[string]string{"action": "delete_account", "password": "123"}, sessionHeader(user), session)
+			decodeResponse[accountDeletionResponse](t, w, http.StatusOK)
+		}},
+		{"deactivated account", func(t *testing.T, userID int, _ loginResponse, _ *http.Cookie) {
+			// Without going through deactivate_account, tokens of a dormant account must not work
+			if err := db.Connection.DeactivateUser(userID); err != nil {
+				t.Fatal(err)
+			}
+		}},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			userID, email := newUser(t)
+			user, session := login(t, email)
+
+			w := callAction(t, map[string]any{"action": "create_token", "name": "bot", "scopes": []string{ScopePostsWrite}},
+				sessionHeader(user), session)
+			token := decodeResponse[createdTokenResponse](t, w, http.StatusOK)
+			bearer := http.Header{"Authorization": {"Bearer " + token.Token}}
+			post := map[string]string{"action": "create_post", "content": "From a bot"}
+
+			decodeResponse[PostResponse](t, callAction(t, post, bearer), http.StatusOK)
+
+			test.revoke(t, userID, user, session)
+
+			if w := callAction(t, post, bearer); w.Code != http.StatusUnauthorized {
+				t.Errorf("Expected the token to be refused with 401, got %d: %s", w.Code, w.Body.String())
+			}
+		})
+	}
+}
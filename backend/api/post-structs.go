@@ -3,22 +3,26 @@ package api
 import "time"
 
 type CreatePostRequest struct {
-	Content           string `json:"content"`
+	Content           string `json:"content" validate:"required"`
 	ImageData         string `json:"imageData,omitempty"`
 	ImageFilename     string `json:"imageFilename,omitempty"`
 	ImageMimetype     string `json:"imageMimetype,omitempty"`
-	Privacy           string `json:"privacy"`
+	Privacy           string `json:"privacy" validate:"oneof=public followers private"` // public if empty
 	SelectedFollowers []int  `json:"selectedFollowers,omitempty"`
 }
 
 type CreateCommentRequest struct {
-	PostID        int    `json:"postId"`
-	Content       string `json:"content"`
+	PostID        int    `json:"postId" validate:"required,min=1"`
+	Content       string `json:"content" validate:"required"`
 	ImageData     string `json:"imageData,omitempty"`
 	ImageFilename string `json:"imageFilename,omitempty"`
 	ImageMimetype string `json:"imageMimetype,omitempty"`
 }
 
+type PostIDRequest struct {
+	PostID int `json:"postId" validate:"required,min=1"`
+}
+
 type PostResponse struct {
 	ID             int       `json:"id"`
 	UserID         int       `json:"userId"`
@@ -11,29 +11,11 @@ import (
 
 // apiRequest struct is defined in requets.go, so do not redefine it here.
 
-func (ar *apiRequest) createPost() {
-	var request CreatePostRequest
-	if err := json.Unmarshal([]byte(ar.requestBody), &request); err != nil {
-		ar.setError(http.StatusBadRequest, "Invalid request body")
-		return
-	}
-
-	// Validate input
-	if request.Content == "" {
-		ar.setError(http.StatusBadRequest, "Post content cannot be empty")
-		return
-	}
-
+func (ar *apiRequest) createPost(request *CreatePostRequest) {
 	if request.Privacy == "" {
 		request.Privacy = "public"
 	}
 
-	// Validate privacy setting
-	if request.Privacy != "public" && request.Privacy != "followers" && request.Privacy != "private" {
-		ar.setError(http.StatusBadRequest, "Invalid privacy setting. Must be 'public', 'followers', or 'private'")
-		return
-	}
-
 	// Handle image upload if provided
 	var imageID int
 	if request.ImageData != "" && request.ImageFilename != "" && request.ImageMimetype != "" {
@@ -47,7 +29,7 @@ func (ar *apiRequest) createPost() {
 	}
 
 	// Create post
-	postID, err := db.Connection.CreatePost(ar.claims.Id, request.Content, imageID, request.Privacy, request.SelectedFollowers)
+	postID, err := ar.db.CreatePost(ar.claims.Id, request.Content, imageID, request.Privacy, request.SelectedFollowers)
 	if err != nil {
 		log.Printf("Failed to create post: %v", err)
 		ar.setError(http.StatusInternalServerError, "Failed to create post")
@@ -55,7 +37,7 @@ func (ar *apiRequest) createPost() {
 	}
 
 	// Get the created post with details
-	posts, err := db.Connection.GetPosts(ar.claims.Id)
+	posts, err := ar.db.GetPosts(ar.claims.Id)
 	if err != nil {
 		log.Printf("Failed to fetch posts: %v", err)
 		ar.setError(http.StatusInternalServerError, "Failed to fetch posts")
@@ -98,7 +80,7 @@ func (ar *apiRequest) createPost() {
 }
 
 func (ar *apiRequest) getPosts() {
-	posts, err := db.Connection.GetPosts(ar.claims.Id)
+	posts, err := ar.db.GetPosts(ar.claims.Id)
 	if err != nil {
 		log.Printf("Failed to fetch posts: %v", err)
 		ar.setError(http.StatusInternalServerError, "Failed to fetch posts")
@@ -137,7 +119,7 @@ func (ar *apiRequest) getPosts() {
 }
 
 func (ar *apiRequest) getLikedPosts() {
-	posts, err := db.Connection.GetLikedPosts(ar.claims.Id)
+	posts, err := ar.db.GetLikedPosts(ar.claims.Id)
 	if err != nil {
 		log.Printf("Failed to fetch liked posts: %v", err)
 		ar.setError(http.StatusInternalServerError, "Failed to fetch liked posts")
@@ -175,18 +157,7 @@ func (ar *apiRequest) getLikedPosts() {
 	ar.response = string(responseJSON)
 }
 
-func (ar *apiRequest) createComment() {
-	var request CreateCommentRequest
-	if err := json.Unmarshal([]byte(ar.requestBody), &request); err != nil {
-		ar.setError(http.StatusBadRequest, "Invalid request body")
-		return
-	}
-
-	if request.Content == "" {
-		ar.setError(http.StatusBadRequest, "Comment content cannot be empty")
-		return
-	}
-
+func (ar *apiRequest) createComment(request *CreateCommentRequest) {
 	// Handle image upload if provided
 	var imageID int
 	if request.ImageData != "" && request.ImageFilename != "" && request.ImageMimetype != "" {
@@ -199,7 +170,7 @@ func (ar *apiRequest) createComment() {
 		imageID = uploadedImageID
 	}
 
-	commentID, err := db.Connection.CreateComment(request.PostID, ar.claims.Id, request.Content, imageID)
+	commentID, err := ar.db.CreateComment(request.PostID, ar.claims.Id, request.Content, imageID)
 	if err != nil {
 		log.Printf("Failed to create comment: %v", err)
 		ar.setError(http.StatusInternalServerError, "Failed to create comment")
@@ -214,7 +185,7 @@ func (ar *apiRequest) createComment() {
 	// }
 
 	// Get the created comment
-	comments, err := db.Connection.GetComments(request.PostID)
+	comments, err := ar.db.GetComments(request.PostID)
 	if err != nil {
 		log.Printf("Failed to fetch comments: %v", err)
 		ar.setError(http.StatusInternalServerError, "Failed to fetch comments")
@@ -252,26 +223,10 @@ func (ar *apiRequest) createComment() {
 	ar.response = string(responseJSON)
 }
 
-func (ar *apiRequest) getComments() {
-	// Parse the request body to get postId
-	var request struct {
-		Action string `json:"action"`
-		PostID int    `json:"postId"`
-	}
-
-	if err := json.Unmarshal([]byte(ar.requestBody), &request); err != nil {
-		ar.setError(http.StatusBadRequest, "Invalid request body")
-		return
-	}
-
-	if request.PostID == 0 {
-		ar.setError(http.StatusBadRequest, "postId parameter is required")
-		return
-	}
-
+func (ar *apiRequest) getComments(request *PostIDRequest) {
 	postID := request.PostID
 
-	comments, err := db.Connection.GetComments(postID)
+	comments, err := ar.db.GetComments(postID)
 	if err != nil {
 		log.Printf("Failed to fetch comments: %v", err)
 		ar.setError(http.StatusInternalServerError, "Failed to fetch comments")
@@ -305,26 +260,10 @@ func (ar *apiRequest) getComments() {
 	ar.response = string(responseJSON)
 }
 
-func (ar *apiRequest) toggleLike() {
-	// Parse the request body to get postId
-	var request struct {
-		Action string `json:"action"`
-		PostID int    `json:"postId"`
-	}
-
-	if err := json.Unmarshal([]byte(ar.requestBody), &request); err != nil {
-		ar.setError(http.StatusBadRequest, "Invalid request body")
-		return
-	}
-
-	if request.PostID == 0 {
-		ar.setError(http.StatusBadRequest, "postId parameter is required")
-		return
-	}
-
+func (ar *apiRequest) toggleLike(request *PostIDRequest) {
 	postID := request.PostID
 
-	err := db.Connection.ToggleLike(postID, ar.claims.Id)
+	err := ar.db.ToggleLike(postID, ar.claims.Id)
 	if err != nil {
 		log.Printf("Failed to toggle like: %v", err)
 		ar.setError(http.StatusInternalServerError, "Failed to toggle like")
@@ -349,14 +288,14 @@ func (ar *apiRequest) toggleLike() {
 	// }
 
 	// Get updated like count
-	likeCount, err := db.Connection.GetLikeCount(postID)
+	likeCount, err := ar.db.GetLikeCount(postID)
 	if err != nil {
 		log.Printf("Failed to get like count: %v", err)
 		ar.setError(http.StatusInternalServerError, "Failed to get like count")
 		return
 	}
 		// Check if user liked the post
-	liked, err := db.Connection.IsPostLikedByUser(postID, ar.claims.Id)
+	liked, err := ar.db.IsPostLikedByUser(postID, ar.claims.Id)
 	if err != nil {
 		log.Printf("Failed to check like status: %v", err)
 		ar.setError(http.StatusInternalServerError, "Failed to check like status")
@@ -387,7 +326,7 @@ func (ar *apiRequest) uploadImageFromBase64(imageData, filename, mimetype string
 	}
 
 	// Store file in database
-	fileID, err := db.Connection.UploadImage(filename, mimetype, data)
+	fileID, err := ar.db.UploadImage(filename, mimetype, data)
 	if err != nil {
 		return 0, fmt.Errorf("failed to store file: %w", err)
 	}
@@ -395,40 +334,11 @@ func (ar *apiRequest) uploadImageFromBase64(imageData, filename, mimetype string
 	return fileID, nil
 }
 
-// getFollowers returns the list of followers for the current user (for private post selection)
-func (ar *apiRequest) getFollowers() {
-	followers, err := db.Connection.GetUserFollowers(ar.claims.Id)
-	if err != nil {
-		log.Printf("Failed to fetch followers: %v", err)
-		ar.setError(http.StatusInternalServerError, "Failed to fetch followers")
-		return
-	}
-
-	response := map[string]interface{}{
-		"followers": followers,
-	}
-
-	responseJSON, err := json.Marshal(response)
-	if err != nil {
-		log.Printf("Error marshalling response: %v", err)
-		ar.setError(http.StatusInternalServerError, "Internal server error")
-		return
-	}
-
-	ar.response = string(responseJSON)
-}
-
 // base64DecodeString decodes a base64 string
 func base64DecodeString(s string) ([]byte, error) {
 	return base64.StdEncoding.DecodeString(s)
 }
 
-// setError sets the error response and status code for the apiRequest.
-func (ar *apiRequest) setError(statusCode int, message string) {
-	ar.response = fmt.Sprintf(`{"error": "%s"}`, message)
-	ar.responseCode = statusCode
-}
-
 // Helper function to get query parameters
 func (ar *apiRequest) getQueryParam(key string) string {
 	if ar.httpRequest != nil {
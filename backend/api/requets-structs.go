@@ -8,13 +8,138 @@ type API struct {
 
 type RegistrationRequest struct {
 	User          db.User `json:"user"`
-	Password      string  `json:"password"`
+	Password      string  `json:"password" validate:"required"`
 	ImageFilename string  `json:"imageFilename"`
 	ImageMimetype string  `json:"imageMimetype"`
 	ImageData     string  `json:"imageData"` // Base64 string from frontend
 }
 
 type LoginRequest struct {
-	Email    string `json:"email"`
-	Password string `json:"password"`
+	Email    string `json:"email" validate:"required"`
+	Password string `json:"password" validate:"required"`
+}
+
+type RefreshTokenRequest struct {
+	RefreshToken string `json:"refreshToken" validate:"required"`
+}
+
+type LogoutRequest struct {
+	RefreshToken string `json:"refreshToken,omitempty"`
+}
+
+type PasswordResetRequest struct {
+	Email string `json:"email" validate:"required"`
+}
+
+type ResetPasswordRequest struct {
+	Token    string `json:"token" validate:"required"`
+	Password string `json:"password" validate:"required"`
+}
+
+type VerifyEmailRequest struct {
+	Token string `json:"token" validate:"required"`
+}
+
+type TwoFactorLoginRequest struct {
+	ChallengeToken string `json:"challengeToken" validate:"required"`
+	Code           string `json:"code" validate:"required"` // TOTP code or recovery code
+}
+
+type TwoFactorCodeRequest struct {
+	Code string `json:"code" validate:"required"`
+}
+
+type DisableTwoFactorRequest struct {
+	Password string `json:"password" validate:"required"`
+	Code     string `json:"code"` // TOTP code or recovery code, required once 2FA is enabled
+}
+
+type ChangePasswordRequest struct {
+	CurrentPassword string `json:"currentPassword" validate:"required"`
+	NewPassword     string `json:"newPassword" validate:"required"`
+}
+
+type ChangeEmailRequest struct {
+	Password string `json:"password" validate:"required"`
+	NewEmail string `json:"newEmail" validate:"required"`
+}
+
+type ConfirmPasswordRequest struct {
+	Password string `json:"password" validate:"required"`
+}
+
+type OIDCCallbackRequest struct {
+	Code  string `json:"code" validate:"required"`
+	State string `json:"state" validate:"required"`
+}
+
+type ListUsersRequest struct {
+	Search string `json:"search" validate:"max=100"`
+	Limit  int    `json:"limit"`
+	Offset int    `json:"offset" validate:"min=0"`
+}
+
+type SuspendUserRequest struct {
+	UserID    int  `json:"userId" validate:"required,min=1"`
+	Suspended bool `json:"suspended"`
+}
+
+type SetUserRoleRequest struct {
+	UserID int    `json:"userId" validate:"required,min=1"`
+	Role   string `json:"role" validate:"required,oneof=user moderator admin"`
+}
+
+type CreateTokenRequest struct {
+	Name          string   `json:"name" validate:"required"`
+	Scopes        []string `json:"scopes"`
+	ExpiresInDays int      `json:"expiresInDays" validate:"min=0"` // 0 for a token that doesn't expire
+}
+
+type RevokeTokenRequest struct {
+	ID int `json:"id" validate:"required,min=1"`
+}
+
+type GetSecurityEventsRequest struct {
+	BeforeID int `json:"beforeId" validate:"min=0"` // for paging, the oldest event ID of the previous page
+	Limit    int `json:"limit"`
+}
+
+type QuerySecurityEventsRequest struct {
+	UserID   int      `json:"userId" validate:"min=0"`
+	Types    []string `json:"types"`
+	Since    string   `json:"since"` // RFC 3339
+	Until    string   `json:"until"` // RFC 3339
+	BeforeID int      `json:"beforeId" validate:"min=0"`
+	Limit    int      `json:"limit"`
+}
+
+type RevokeSessionRequest struct {
+	SessionID int `json:"sessionId" validate:"required,min=1"`
+}
+
+type UserIDRequest struct {
+	UserID int `json:"userId" validate:"required,min=1"`
+}
+
+// FollowListRequest asks for the followers or followed users of userId, or of the authenticated user if it's 0
+type FollowListRequest struct {
+	UserID int `json:"userId,omitempty" validate:"min=0"`
+}
+
+type FollowRequestDecision struct {
+	RequestID int `json:"requestId" validate:"required,min=1"`
+}
+
+type UploadAvatarRequest struct {
+	ImageData     string `json:"imageData" validate:"required"` // Base64
+	ImageFilename string `json:"imageFilename" validate:"required"`
+	ImageMimetype string `json:"imageMimetype" validate:"required"`
+}
+
+type UpdateProfileRequest struct {
+	FirstName string `json:"firstName"`
+	LastName  string `json:"lastName"`
+	Nickname  string `json:"nickname"`
+	About     string `json:"about"`
+	IsPublic  bool   `json:"isPublic"`
 }
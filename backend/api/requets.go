@@ -13,6 +13,7 @@ import (
 // the server-side internal structure to manage and handler the request in its lifetime
 type apiRequest struct {
 	claims      Claims
+	db          *db.Database        // the database connection, or the transaction of an atomic batch
 	requestBody string              // http request body (unprocessed)
 	httpRequest *http.Request       // http request object for accessing query params
 	httpWriter  http.ResponseWriter // http response writer for setting cookies
@@ -21,55 +22,43 @@ type apiRequest struct {
 	Response    string
 
 	responseCode int // http response code (default 200)
+
+	authenticated bool  // claims belong to a caller, unauthenticated calls of public actions have empty claims
+	authError     error // why authenticating the caller failed, if it did
 }
 
-func (ar apiRequest) getUserPosts() {
+func (ar *apiRequest) getUserPosts() {
 	panic("unimplemented")
 }
 
-func (ar apiRequest) markNotificationRead() {
+func (ar *apiRequest) markNotificationRead() {
 	panic("unimplemented")
 }
 
-func (ar apiRequest) getNotifications() {
+func (ar *apiRequest) getNotifications() {
 	panic("unimplemented")
 }
 
-// signup handles user registration.  Modified to receive body string.
-func (ar *apiRequest) signup() {
-
-	var request RegistrationRequest
-	// Use bodyString to decode the JSON
-	if err := json.Unmarshal([]byte(ar.requestBody), &request); err != nil {
-		log.Printf("Invalid request body: %v\n", err)
-
-		ar.responseCode = http.StatusBadRequest
-		errorResponse := map[string]string{"error": "Invalid request body"}
-		responseJSON, _ := json.Marshal(errorResponse)
-		ar.response = string(responseJSON)
-		return
-	}
-
+// signup handles user registration
+func (ar *apiRequest) signup(request *RegistrationRequest) {
 	user := request.User
 	user.Password = request.Password
+	user.Verified = false // only a verification email can verify the account
 
-	// Input validation (basic - expand for production)
-	if strings.TrimSpace(user.Email) == "" || strings.TrimSpace(user.Password) == "" {
-		log.Println("Username and password are required")
+	// The user is also returned in responses, so its email isn't checked by a validate tag
+	if strings.TrimSpace(user.Email) == "" {
+		ar.setValidationError(validationErrors{{Field: "user.email", Message: "is required"}})
+		return
+	}
 
-		ar.responseCode = http.StatusBadRequest
-		errorResponse := map[string]string{"error": "Username and password are required"}
-		responseJSON, _ := json.Marshal(errorResponse)
-		ar.response = string(responseJSON)
+	if err := validatePassword(user.Password, &user); err != nil {
+		ar.setErrorCode(http.StatusBadRequest, CodeWeakPassword, err.Error())
 		return
 	}
 
 	// Check if the username already exists
-	if _, err := db.Connection.FetchUserByEmail(user.Email); err == nil {
-		ar.responseCode = http.StatusConflict // HTTP 409 Conflict
-		errorResponse := map[string]string{"error": "Email already exists"}
-		responseJSON, _ := json.Marshal(errorResponse)
-		ar.response = string(responseJSON)
+	if _, err := ar.db.FetchUserByEmail(user.Email); err == nil {
+		ar.setErrorCode(http.StatusConflict, CodeEmailTaken, "Email already exists")
 		return
 	}
 
@@ -78,10 +67,7 @@ func (ar *apiRequest) signup() {
 		imageID, err := Images.UploadImage(request.ImageFilename, request.ImageMimetype, request.ImageData)
 		if err != nil {
 			log.Printf("Failed to upload image: %v\n", err)
-			ar.responseCode = http.StatusBadRequest
-			errorResponse := map[string]string{"error": fmt.Sprintf("Image upload failed: %v", err)}
-			responseJSON, _ := json.Marshal(errorResponse)
-			ar.response = string(responseJSON)
+			ar.setErrorCode(http.StatusBadRequest, CodeInvalidImage, fmt.Sprintf("Image upload failed: %v", err))
 			return
 		}
 		user.ProfilePicture = imageID
@@ -89,19 +75,26 @@ func (ar *apiRequest) signup() {
 	}
 
 	// Create the user
-	userId, err := db.Connection.CreateUser(user)
+	userId, err := ar.db.CreateUser(user)
 	if err != nil {
-		ar.responseCode = http.StatusInternalServerError
-		errorResponse := map[string]string{"error": "Internal Server Error"}
-		responseJSON, _ := json.Marshal(errorResponse)
-		ar.response = string(responseJSON)
+		ar.setError(http.StatusInternalServerError, "Internal server error")
 		return
 	}
 
 	log.Printf("New user registered: %s\n", user.Email)
 
+	// The account is usable right away, but limited until the email is verified
+	if err := sendVerificationEmail(userId, user.FirstName, user.Email); err != nil {
+		log.Printf("Failed to send verification email: %v\n", err)
+	}
+
 	// Create a session for the user
-	session := Sessions.CreateSession(userId, user.Email)
+	session, err := Sessions.CreateSession(userId, user.Email, ar.httpRequest)
+	if err != nil {
+		log.Printf("Failed to create session: %v\n", err)
+		ar.setError(http.StatusInternalServerError, "Internal server error")
+		return
+	}
 
 	// Set session cookie
 	SetSessionCookie(ar.httpWriter, session.ID)
@@ -115,88 +108,139 @@ func (ar *apiRequest) signup() {
 
 	if err != nil {
 		log.Printf("failed to get bearer token: %v\n", err)
-		ar.responseCode = http.StatusInternalServerError
-		errorResponse := map[string]string{"error": "Internal server error"}
-		responseJSON, _ := json.Marshal(errorResponse)
-		ar.response = string(responseJSON)
+		ar.setError(http.StatusInternalServerError, "Internal server error")
+		return
+	}
+
+	refreshToken, err := c.GetRefreshToken()
+
+	if err != nil {
+		log.Printf("failed to get refresh token: %v\n", err)
+		ar.setError(http.StatusInternalServerError, "Internal server error")
 		return
 	}
 
 	response := loginResponse{
-		User:  user,
-		Token: *token,
+		User:         user,
+		Token:        *token,
+		RefreshToken: *refreshToken,
+		ExpiresIn:    int(accessTokenLifetime.Seconds()),
+		CSRFToken:    csrfTokenFor(session.ID),
 	}
 
 	responseJSON, err := json.Marshal(response)
 
 	if err != nil {
 		log.Printf("Error marshalling response: %v\n", err)
-		ar.responseCode = http.StatusInternalServerError
-		errorResponse := map[string]string{"error": "Internal server error"}
-		responseJSON, _ := json.Marshal(errorResponse)
-		ar.response = string(responseJSON)
+		ar.setError(http.StatusInternalServerError, "Internal server error")
 		return
 	}
 
 	ar.response = string(responseJSON)
 }
 
-// login handles user login.  Modified to receive body string.
-func (ar *apiRequest) login() {
-
-	var request LoginRequest
-	// Use bodyString to decode the JSON
-	if err := json.Unmarshal([]byte(ar.requestBody), &request); err != nil {
-		log.Printf("Invalid request body: %v\n", err)
-
-		ar.responseCode = http.StatusBadRequest
-		errorResponse := map[string]string{"error": "Invalid request body"}
-		responseJSON, _ := json.Marshal(errorResponse)
-		ar.response = string(responseJSON)
-		return
-	}
+// login handles user login
+func (ar *apiRequest) login(request *LoginRequest) {
+	// Refuse the attempt before running bcrypt if there were too many failures
+	ip := clientIP(ar.httpRequest)
+	if throttled := Throttle.Check(request.Email, ip); throttled.retryAfter > 0 {
+		log.Printf("Login throttled for %s from %s, retry after %s\n", request.Email, ip, throttled.retryAfter)
 
-	// Basic input validation
-	if strings.TrimSpace(request.Email) == "" || strings.TrimSpace(request.Password) == "" {
-		log.Println("Username and password are required")
+		code, message := CodeLoginThrottled, "Too many failed login attempts, try again later"
+		if throttled.locked {
+			code, message = CodeAccountLocked, "Account temporarily locked after too many failed login attempts"
+		}
 
-		ar.responseCode = http.StatusBadRequest
-		errorResponse := map[string]string{"error": "Username and password are required"}
-		responseJSON, _ := json.Marshal(errorResponse)
-		ar.response = string(responseJSON)
+		ar.recordEvent(0, EventLoginThrottled, map[string]interface{}{"email": request.Email})
+		ar.setRetryAfter(throttled.retryAfter, code, message)
 		return
 	}
 
 	// Find the user
-	user, err := db.Connection.FetchUserByEmail(request.Email)
+	user, err := ar.db.FetchUserByEmail(request.Email)
 
 	if err != nil {
+		Throttle.Failed(request.Email, ip)
+		ar.recordEvent(0, EventLoginFailed, map[string]interface{}{"email": request.Email, "reason": "unknown_email"})
 		log.Printf("Invalid credentials - user not found: %s\n", err)
-		ar.responseCode = http.StatusUnauthorized // 401 Unauthorized
-		errorResponse := map[string]string{"error": "Invalid credentials"}
-		responseJSON, _ := json.Marshal(errorResponse)
-		ar.response = string(responseJSON)
+		ar.setErrorCode(http.StatusUnauthorized, CodeInvalidCredentials, "Invalid credentials")
 		return
 	}
 
 	// Verify the password
 	err = db.VerifyPassword(user.Password, request.Password)
 	if err != nil {
+		Throttle.Failed(request.Email, ip)
+		ar.recordEvent(user.Id, EventLoginFailed, map[string]interface{}{"reason": "wrong_password"})
 		log.Printf("Password verification failed for %s: %v\n", request.Email, err)
-		ar.responseCode = http.StatusUnauthorized // 401 Unauthorized
-		errorResponse := map[string]string{"error": "Invalid credentials"}
-		responseJSON, _ := json.Marshal(errorResponse)
-		ar.response = string(responseJSON)
+		ar.setErrorCode(http.StatusUnauthorized, CodeInvalidCredentials, "Invalid credentials")
+		return
+	}
+
+	// With 2FA enabled the password alone is not enough, login_2fa finishes the login
+	twoFactor, err := ar.db.IsTwoFactorEnabled(user.Id)
+	if err != nil {
+		log.Printf("Failed to check 2FA for %s: %v\n", request.Email, err)
+		ar.setError(http.StatusInternalServerError, "Internal server error")
+		return
+	}
+
+	if twoFactor {
+		ar.twoFactorChallenge(user)
 		return
 	}
 
-	log.Printf("User %s logged in\n", request.Email)
+	ar.completeLogin(user, "password")
+}
+
+// refuseSuspended answers a login of a suspended user, returns true if it did
+func (ar *apiRequest) refuseSuspended(user *db.User) bool {
+	if !user.Suspended {
+		return false
+	}
+
+	log.Printf("Refused login of suspended user %s\n", user.Email)
+	ar.recordEvent(user.Id, EventLoginFailed, map[string]interface{}{"reason": "suspended"})
+	ar.setErrorCode(http.StatusForbidden, CodeAccountSuspended, "Your account has been suspended")
+	return true
+}
+
+// completeLogin logs in a user whose credentials were verified,
+// creating a session and issuing tokens. method is how they proved who they are,
+// "password", "2fa" or "oidc", and goes into the security log.
+func (ar *apiRequest) completeLogin(user *db.User, method string) {
+	if ar.refuseSuspended(user) {
+		return
+	}
+
+	log.Printf("User %s logged in\n", user.Email)
+	Throttle.Succeeded(user.Email)
+
+	// Logging in undoes a deactivation and cancels a scheduled deletion
+	reactivated := false
+	if user.Deactivated {
+		var err error
+		reactivated, err = ar.db.ReactivateUser(user.Id)
+		if err != nil {
+			log.Printf("Failed to reactivate user %d: %v\n", user.Id, err)
+			ar.setError(http.StatusInternalServerError, "Internal server error")
+			return
+		}
+		user.Deactivated = false
+		log.Printf("User %s reactivated their account\n", user.Email)
+	}
 
 	// Create a session for the user
-	session := Sessions.CreateSession(user.Id, user.Email)
+	session, err := Sessions.CreateSession(user.Id, user.Email, ar.httpRequest)
+	if err != nil {
+		log.Printf("Failed to create session: %v\n", err)
+		ar.setError(http.StatusInternalServerError, "Internal server error")
+		return
+	}
 
 	// Set session cookie
 	SetSessionCookie(ar.httpWriter, session.ID)
+	ar.recordEvent(user.Id, EventLoginSucceeded, map[string]interface{}{"method": method, "reactivated": reactivated})
 
 	// Successful login generating user claims and sending them
 	c := Claims{
@@ -208,26 +252,32 @@ func (ar *apiRequest) login() {
 
 	if err != nil {
 		log.Printf("failed to get bearer token: %v\n", err)
-		ar.responseCode = http.StatusInternalServerError
-		errorResponse := map[string]string{"error": "Internal server error"}
-		responseJSON, _ := json.Marshal(errorResponse)
-		ar.response = string(responseJSON)
+		ar.setError(http.StatusInternalServerError, "Internal server error")
+		return
+	}
+
+	refreshToken, err := c.GetRefreshToken()
+
+	if err != nil {
+		log.Printf("failed to get refresh token: %v\n", err)
+		ar.setError(http.StatusInternalServerError, "Internal server error")
 		return
 	}
 
 	response := loginResponse{
-		User:  *user,
-		Token: *token,
+		User:         *user,
+		Token:        *token,
+		RefreshToken: *refreshToken,
+		ExpiresIn:    int(accessTokenLifetime.Seconds()),
+		Reactivated:  reactivated,
+		CSRFToken:    csrfTokenFor(session.ID),
 	}
 
 	responseJSON, err := json.Marshal(response)
 
 	if err != nil {
 		log.Printf("Error marshalling response: %v\n", err)
-		ar.responseCode = http.StatusInternalServerError
-		errorResponse := map[string]string{"error": "Internal server error"}
-		responseJSON, _ := json.Marshal(errorResponse)
-		ar.response = string(responseJSON)
+		ar.setError(http.StatusInternalServerError, "Internal server error")
 		return
 	}
 
@@ -235,17 +285,39 @@ func (ar *apiRequest) login() {
 }
 
 // logout handles user logout
-func (ar *apiRequest) logout() {
+func (ar *apiRequest) logout(request *LogoutRequest) {
 	// Get session ID from cookie
 	sessionID, err := GetSessionFromRequest(ar.httpRequest)
 	if err == nil {
-		// Delete the session
+		// Delete the session and close the websockets opened with it
+		recordID := ar.currentSessionRecordID()
 		Sessions.DeleteSession(sessionID)
-		log.Printf("Logged out user session: %s", sessionID)
+		hub.disconnectSession(recordID)
+		log.Printf("Logged out user session")
+	}
+
+	// Revoke the bearer token the request was made with, if any
+	if token := bearerFromRequest(ar.httpRequest); token != "" {
+		if tokenClaims, err := UnmarshalBearer(&token); err == nil {
+			if _, err := RevokeToken(tokenClaims); err != nil {
+				log.Printf("Failed to revoke bearer token: %v", err)
+			}
+			hub.disconnectToken(tokenClaims.TokenID)
+		}
+	}
+
+	// Revoke the refresh token too, if the client sent it
+	if request.RefreshToken != "" {
+		if tokenClaims, err := UnmarshalRefreshToken(&request.RefreshToken); err == nil && tokenClaims.Id == ar.claims.Id {
+			if _, err := RevokeToken(tokenClaims); err != nil {
+				log.Printf("Failed to revoke refresh token: %v", err)
+			}
+		}
 	}
 
 	// Clear session cookie
 	ClearSessionCookie(ar.httpWriter)
+	ar.recordEvent(ar.claims.Id, EventLogout, nil)
 
 	// Return success response
 	response := map[string]string{
@@ -255,51 +327,77 @@ func (ar *apiRequest) logout() {
 	responseJSON, err := json.Marshal(response)
 	if err != nil {
 		log.Printf("Error marshalling logout response: %v\n", err)
-		ar.responseCode = http.StatusInternalServerError
-		ar.response = "Internal server error"
+		ar.setError(http.StatusInternalServerError, "Internal server error")
 		return
 	}
 
 	ar.response = string(responseJSON)
 }
 
-// uploadAvatar handles user avatar upload
-func (ar *apiRequest) uploadAvatar() {
-	var request struct {
-		ImageData     string `json:"imageData"`
-		ImageFilename string `json:"imageFilename"`
-		ImageMimetype string `json:"imageMimetype"`
+// refreshToken exchanges a refresh token for a new access token and refresh token.
+// The used refresh token is revoked, so each one can only be exchanged once.
+func (ar *apiRequest) refreshToken(request *RefreshTokenRequest) {
+	oldClaims, err := UnmarshalRefreshToken(&request.RefreshToken)
+	if err != nil {
+		log.Printf("Invalid refresh token: %v\n", err)
+		ar.recordEvent(0, EventLoginFailed, map[string]interface{}{"reason": "invalid_refresh_token"})
+		ar.setErrorCode(http.StatusUnauthorized, CodeInvalidToken, "Invalid refresh token")
+		return
 	}
 
-	if err := json.Unmarshal([]byte(ar.requestBody), &request); err != nil {
-		log.Printf("Invalid request body: %v\n", err)
-		ar.responseCode = http.StatusBadRequest
-		ar.response = "Invalid request body"
+	// A refresh token works only once. Revoking it is the check, so two concurrent refreshes
+	// with the same token can't both pass.
+	revoked, err := RevokeToken(oldClaims)
+	if err != nil {
+		log.Printf("Failed to revoke refresh token: %v\n", err)
+		ar.setError(http.StatusInternalServerError, "Internal server error")
+		return
+	}
+	if !revoked {
+		log.Printf("Refresh token of user %d was already used\n", oldClaims.Id)
+		ar.recordEvent(oldClaims.Id, EventLoginFailed, map[string]interface{}{"reason": "reused_refresh_token"})
+		ar.setErrorCode(http.StatusUnauthorized, CodeInvalidToken, "Invalid refresh token")
+		return
+	}
+
+	c := Claims{
+		Email: oldClaims.Email,
+		Id:    oldClaims.Id,
+	}
+
+	response, err := newTokenPair(&c)
+	if err != nil {
+		log.Printf("Failed to issue tokens: %v\n", err)
+		ar.setError(http.StatusInternalServerError, "Internal server error")
 		return
 	}
+	ar.recordEvent(c.Id, EventTokenRefreshed, nil)
 
-	// Validate input
-	if request.ImageData == "" || request.ImageFilename == "" || request.ImageMimetype == "" {
-		ar.responseCode = http.StatusBadRequest
-		ar.response = "Missing image data, filename, or mimetype"
+	responseJSON, err := json.Marshal(response)
+	if err != nil {
+		log.Printf("Error marshalling refresh response: %v\n", err)
+		ar.setError(http.StatusInternalServerError, "Internal server error")
 		return
 	}
 
+	ar.response = string(responseJSON)
+}
+
+// uploadAvatar handles user avatar upload
+func (ar *apiRequest) uploadAvatar(request *UploadAvatarRequest) {
 	// Upload image using the enhanced image handler
 	imageID, err := Images.UploadImage(request.ImageFilename, request.ImageMimetype, request.ImageData)
 	if err != nil {
 		log.Printf("Failed to upload avatar: %v\n", err)
-		ar.responseCode = http.StatusBadRequest
-		ar.response = fmt.Sprintf("Avatar upload failed: %v", err)
+		ar.setErrorCode(http.StatusBadRequest, CodeInvalidImage, fmt.Sprintf("Avatar upload failed: %v", err))
 		return
 	}
 
 	// Update user's profile picture in database
-	err = db.Connection.UpdateUserProfilePicture(ar.claims.Id, imageID)
+	err = ar.db.UpdateUserProfilePicture(ar.claims.Id, imageID)
 	if err != nil {
 		log.Printf("Failed to update user profile picture: %v\n", err)
-		ar.responseCode = http.StatusInternalServerError
-		ar.response = "Failed to update profile picture"
+		ar.setError(http.StatusInternalServerError, "Failed to update profile picture")
 		return
 	}
 
@@ -315,8 +413,7 @@ func (ar *apiRequest) uploadAvatar() {
 	responseJSON, err := json.Marshal(response)
 	if err != nil {
 		log.Printf("Error marshalling avatar upload response: %v\n", err)
-		ar.responseCode = http.StatusInternalServerError
-		ar.response = "Internal server error"
+		ar.setError(http.StatusInternalServerError, "Internal server error")
 		return
 	}
 
@@ -324,44 +421,27 @@ func (ar *apiRequest) uploadAvatar() {
 }
 
 // getUserProfile handles fetching user profile by ID
-func (ar *apiRequest) getUserProfile() {
-	var request struct {
-		UserID int `json:"userId"`
+func (ar *apiRequest) getUserProfile(request *UserIDRequest) {
+	// Get user profile from database, deactivated users are hidden from everyone else
+	user, err := ar.db.FetchUser(request.UserID)
+	if err == nil && user.Deactivated && user.Id != ar.claims.Id {
+		err = fmt.Errorf("user %d is deactivated", user.Id)
 	}
-
-	if err := json.Unmarshal([]byte(ar.requestBody), &request); err != nil {
-		log.Printf("Invalid request body: %v\n", err)
-		ar.responseCode = http.StatusBadRequest
-		ar.response = "Invalid request body"
-		return
-	}
-
-	if request.UserID <= 0 {
-		ar.responseCode = http.StatusBadRequest
-		ar.response = "Invalid user ID"
-		return
-	}
-
-	// Get user profile from database
-	user, err := db.Connection.FetchUser(request.UserID)
 	if err != nil {
 		log.Printf("Failed to fetch user profile: %v", err)
-		ar.responseCode = http.StatusNotFound
-		errorResponse := map[string]string{"error": "User not found"}
-		responseJSON, _ := json.Marshal(errorResponse)
-		ar.response = string(responseJSON)
+		ar.setError(http.StatusNotFound, "User not found")
 		return
 	}
 
 	// Check follow status
-	isFollowing, err := db.Connection.IsFollowing(ar.claims.Id, request.UserID)
+	isFollowing, err := ar.db.IsFollowing(ar.claims.Id, request.UserID)
 	if err != nil {
 		log.Printf("Failed to check follow status: %v", err)
 		isFollowing = false
 	}
 
 	// Get follow stats
-	followersCount, followingCount, err := db.Connection.GetFollowStats(request.UserID)
+	followersCount, followingCount, err := ar.db.GetFollowStats(request.UserID)
 	if err != nil {
 		log.Printf("Failed to get follow stats: %v", err)
 		followersCount = 0
@@ -369,7 +449,7 @@ func (ar *apiRequest) getUserProfile() {
 	}
 
 	// Get posts count
-	postsCount, err := db.Connection.GetPostsCount(request.UserID)
+	postsCount, err := ar.db.GetPostsCount(request.UserID)
 	if err != nil {
 		log.Printf("Failed to get posts count: %v", err)
 		postsCount = 0
@@ -394,8 +474,7 @@ func (ar *apiRequest) getUserProfile() {
 	responseJSON, err := json.Marshal(profileResponse)
 	if err != nil {
 		log.Printf("Error marshalling user profile response: %v\n", err)
-		ar.responseCode = http.StatusInternalServerError
-		ar.response = "Internal server error"
+		ar.setError(http.StatusInternalServerError, "Internal server error")
 		return
 	}
 
@@ -404,20 +483,17 @@ func (ar *apiRequest) getUserProfile() {
 
 // getFollowRequests handles fetching follow requests for the authenticated user
 func (ar *apiRequest) getFollowRequests() {
-	requests, err := db.Connection.GetFollowRequestsForUser(ar.claims.Id)
+	requests, err := ar.db.GetFollowRequestsForUser(ar.claims.Id)
 	if err != nil {
 		log.Printf("Failed to fetch follow requests: %v", err)
-		ar.responseCode = http.StatusInternalServerError
-		errorResponse := map[string]string{"error": "Failed to fetch follow requests"}
-		responseJSON, _ := json.Marshal(errorResponse)
-		ar.response = string(responseJSON)
+		ar.setError(http.StatusInternalServerError, "Failed to fetch follow requests")
 		return
 	}
 
 	// Enrich requests with user details
 	var enrichedRequests []map[string]interface{}
 	for _, request := range requests {
-		user, err := db.Connection.FetchUser(request.FollowerID)
+		user, err := ar.db.FetchUser(request.FollowerID)
 		if err != nil {
 			log.Printf("Failed to fetch user %d: %v", request.FollowerID, err)
 			continue
@@ -441,8 +517,7 @@ func (ar *apiRequest) getFollowRequests() {
 	responseJSON, err := json.Marshal(response)
 	if err != nil {
 		log.Printf("Error marshalling follow requests response: %v\n", err)
-		ar.responseCode = http.StatusInternalServerError
-		ar.response = "Internal server error"
+		ar.setError(http.StatusInternalServerError, "Internal server error")
 		return
 	}
 
@@ -450,53 +525,33 @@ func (ar *apiRequest) getFollowRequests() {
 }
 
 // acceptFollowRequest handles accepting a follow request
-func (ar *apiRequest) acceptFollowRequest() {
-	var request struct {
-		RequestID int `json:"requestId"`
-	}
-
-	if err := json.Unmarshal([]byte(ar.requestBody), &request); err != nil {
-		log.Printf("Invalid request body: %v\n", err)
-		ar.responseCode = http.StatusBadRequest
-		ar.response = "Invalid request body"
-		return
-	}
-
-	if request.RequestID <= 0 {
-		ar.responseCode = http.StatusBadRequest
-		ar.response = "Invalid request ID"
-		return
-	}
-
+func (ar *apiRequest) acceptFollowRequest(request *FollowRequestDecision) {
 	// Get the follow request details
-	followerID, followedID, err := db.Connection.GetFollowRequestDetails(request.RequestID)
+	followerID, followedID, err := ar.db.GetFollowRequestDetails(request.RequestID)
 	if err != nil {
 		if err == sql.ErrNoRows {
-			ar.responseCode = http.StatusNotFound
-			ar.response = "Follow request not found or already processed"
+			ar.setError(http.StatusNotFound, "Follow request not found or already processed")
 			return
 		}
 		log.Printf("Failed to fetch follow request: %v", err)
-		ar.responseCode = http.StatusInternalServerError
-		ar.response = "Internal server error"
+		ar.setError(http.StatusInternalServerError, "Internal server error")
 		return
 	}
 
 	// Verify that the authenticated user is the one being followed
 	if followedID != ar.claims.Id {
-		ar.responseCode = http.StatusForbidden
-		ar.response = "Not authorized to accept this follow request"
+		ar.setError(http.StatusForbidden, "Not authorized to accept this follow request")
 		return
 	}
 
 	// Accept the follow request
-	err = db.Connection.AcceptFollowRequest(followerID, followedID)
+	err = ar.db.AcceptFollowRequest(followerID, followedID)
 	if err != nil {
 		log.Printf("Failed to accept follow request: %v", err)
-		ar.responseCode = http.StatusInternalServerError
-		ar.response = "Internal server error"
+		ar.setError(http.StatusInternalServerError, "Internal server error")
 		return
 	}
+	ar.recordEvent(ar.claims.Id, EventFollowRequestAccepted, map[string]interface{}{"requestId": request.RequestID, "followerId": followerID})
 
 	response := map[string]string{
 		"message": "Follow request accepted",
@@ -505,8 +560,7 @@ func (ar *apiRequest) acceptFollowRequest() {
 	responseJSON, err := json.Marshal(response)
 	if err != nil {
 		log.Printf("Error marshalling accept response: %v\n", err)
-		ar.responseCode = http.StatusInternalServerError
-		ar.response = "Internal server error"
+		ar.setError(http.StatusInternalServerError, "Internal server error")
 		return
 	}
 
@@ -514,53 +568,33 @@ func (ar *apiRequest) acceptFollowRequest() {
 }
 
 // declineFollowRequest handles declining a follow request
-func (ar *apiRequest) declineFollowRequest() {
-	var request struct {
-		RequestID int `json:"requestId"`
-	}
-
-	if err := json.Unmarshal([]byte(ar.requestBody), &request); err != nil {
-		log.Printf("Invalid request body: %v\n", err)
-		ar.responseCode = http.StatusBadRequest
-		ar.response = "Invalid request body"
-		return
-	}
-
-	if request.RequestID <= 0 {
-		ar.responseCode = http.StatusBadRequest
-		ar.response = "Invalid request ID"
-		return
-	}
-
+func (ar *apiRequest) declineFollowRequest(request *FollowRequestDecision) {
 	// Get the follow request details
-	followerID, followedID, err := db.Connection.GetFollowRequestDetails(request.RequestID)
+	followerID, followedID, err := ar.db.GetFollowRequestDetails(request.RequestID)
 	if err != nil {
 		if err == sql.ErrNoRows {
-			ar.responseCode = http.StatusNotFound
-			ar.response = "Follow request not found or already processed"
+			ar.setError(http.StatusNotFound, "Follow request not found or already processed")
 			return
 		}
 		log.Printf("Failed to fetch follow request: %v", err)
-		ar.responseCode = http.StatusInternalServerError
-		ar.response = "Internal server error"
+		ar.setError(http.StatusInternalServerError, "Internal server error")
 		return
 	}
 
 	// Verify that the authenticated user is the one being followed
 	if followedID != ar.claims.Id {
-		ar.responseCode = http.StatusForbidden
-		ar.response = "Not authorized to decline this follow request"
+		ar.setError(http.StatusForbidden, "Not authorized to decline this follow request")
 		return
 	}
 
 	// Decline the follow request
-	err = db.Connection.DeclineFollowRequest(followerID, followedID)
+	err = ar.db.DeclineFollowRequest(followerID, followedID)
 	if err != nil {
 		log.Printf("Failed to decline follow request: %v", err)
-		ar.responseCode = http.StatusInternalServerError
-		ar.response = "Internal server error"
+		ar.setError(http.StatusInternalServerError, "Internal server error")
 		return
 	}
+	ar.recordEvent(ar.claims.Id, EventFollowRequestDeclined, map[string]interface{}{"requestId": request.RequestID, "followerId": followerID})
 
 	response := map[string]string{
 		"message": "Follow request declined",
@@ -569,8 +603,7 @@ func (ar *apiRequest) declineFollowRequest() {
 	responseJSON, err := json.Marshal(response)
 	if err != nil {
 		log.Printf("Error marshalling decline response: %v\n", err)
-		ar.responseCode = http.StatusInternalServerError
-		ar.response = "Internal server error"
+		ar.setError(http.StatusInternalServerError, "Internal server error")
 		return
 	}
 
@@ -664,22 +697,7 @@ func (ar *apiRequest) declineFollowRequest() {
 // }
 
 // updateProfile handles updating user profile information
-func (ar *apiRequest) updateProfile() {
-	var request struct {
-		FirstName string `json:"firstName"`
-		LastName  string `json:"lastName"`
-		Nickname  string `json:"nickname"`
-		About     string `json:"about"`
-		IsPublic  bool   `json:"isPublic"`
-	}
-
-	if err := json.Unmarshal([]byte(ar.requestBody), &request); err != nil {
-		log.Printf("Invalid request body: %v\n", err)
-		ar.responseCode = http.StatusBadRequest
-		ar.response = "Invalid request body"
-		return
-	}
-
+func (ar *apiRequest) updateProfile(request *UpdateProfileRequest) {
 	// Update user profile in database
 	user := db.User{
 		Id:        ar.claims.Id,
@@ -690,11 +708,10 @@ func (ar *apiRequest) updateProfile() {
 		Public:    request.IsPublic,
 	}
 
-	err := db.Connection.UpdateUser(user)
+	err := ar.db.UpdateUser(user)
 	if err != nil {
 		log.Printf("Failed to update user profile: %v", err)
-		ar.responseCode = http.StatusInternalServerError
-		ar.response = "Failed to update profile"
+		ar.setError(http.StatusInternalServerError, "Failed to update profile")
 		return
 	}
 
@@ -705,8 +722,7 @@ func (ar *apiRequest) updateProfile() {
 	responseJSON, err := json.Marshal(response)
 	if err != nil {
 		log.Printf("Error marshalling profile update response: %v\n", err)
-		ar.responseCode = http.StatusInternalServerError
-		ar.response = "Internal server error"
+		ar.setError(http.StatusInternalServerError, "Internal server error")
 		return
 	}
 
@@ -714,30 +730,16 @@ func (ar *apiRequest) updateProfile() {
 }
 
 // getFollowing handles fetching users that the current user is following
-func (ar *apiRequest) getFollowing() {
-	var request struct {
-		UserID int `json:"userId,omitempty"`
-	}
-
-	// Parse request body to get optional userId
-	if err := json.Unmarshal([]byte(ar.requestBody), &request); err != nil {
-		log.Printf("Invalid request body: %v\n", err)
-		// Use current user if parsing fails
-		request.UserID = ar.claims.Id
-	}
-
+func (ar *apiRequest) getFollowing(request *FollowListRequest) {
 	// If no userId specified, use current user
 	if request.UserID == 0 {
 		request.UserID = ar.claims.Id
 	}
 
-	following, err := db.Connection.GetFollowing(request.UserID)
+	following, err := ar.db.GetFollowing(request.UserID)
 	if err != nil {
 		log.Printf("Failed to fetch following: %v", err)
-		ar.responseCode = http.StatusInternalServerError
-		errorResponse := map[string]string{"error": "Failed to fetch following"}
-		responseJSON, _ := json.Marshal(errorResponse)
-		ar.response = string(responseJSON)
+		ar.setError(http.StatusInternalServerError, "Failed to fetch following")
 		return
 	}
 
@@ -748,8 +750,7 @@ func (ar *apiRequest) getFollowing() {
 	responseJSON, err := json.Marshal(response)
 	if err != nil {
 		log.Printf("Error marshalling following response: %v\n", err)
-		ar.responseCode = http.StatusInternalServerError
-		ar.response = "Internal server error"
+		ar.setError(http.StatusInternalServerError, "Internal server error")
 		return
 	}
 
@@ -757,30 +758,16 @@ func (ar *apiRequest) getFollowing() {
 }
 
 // getFollowersForUser handles fetching followers for a specific user
-func (ar *apiRequest) getFollowersForUser() {
-	var request struct {
-		UserID int `json:"userId,omitempty"`
-	}
-
-	// Parse request body to get optional userId
-	if err := json.Unmarshal([]byte(ar.requestBody), &request); err != nil {
-		log.Printf("Invalid request body: %v\n", err)
-		// Use current user if parsing fails
-		request.UserID = ar.claims.Id
-	}
-
+func (ar *apiRequest) getFollowersForUser(request *FollowListRequest) {
 	// If no userId specified, use current user
 	if request.UserID == 0 {
 		request.UserID = ar.claims.Id
 	}
 
-	followers, err := db.Connection.GetFollowers(request.UserID)
+	followers, err := ar.db.GetFollowers(request.UserID)
 	if err != nil {
 		log.Printf("Failed to fetch followers: %v", err)
-		ar.responseCode = http.StatusInternalServerError
-		errorResponse := map[string]string{"error": "Failed to fetch followers"}
-		responseJSON, _ := json.Marshal(errorResponse)
-		ar.response = string(responseJSON)
+		ar.setError(http.StatusInternalServerError, "Failed to fetch followers")
 		return
 	}
 
@@ -791,8 +778,7 @@ func (ar *apiRequest) getFollowersForUser() {
 	responseJSON, err := json.Marshal(response)
 	if err != nil {
 		log.Printf("Error marshalling followers response: %v\n", err)
-		ar.responseCode = http.StatusInternalServerError
-		ar.response = "Internal server error"
+		ar.setError(http.StatusInternalServerError, "Internal server error")
 		return
 	}
 
@@ -800,88 +786,62 @@ func (ar *apiRequest) getFollowersForUser() {
 }
 
 // toggleFollow handles follow/unfollow functionality
-func (ar *apiRequest) toggleFollow() {
-	var request struct {
-		UserID int `json:"userId"`
-	}
-
-	if err := json.Unmarshal([]byte(ar.requestBody), &request); err != nil {
-		log.Printf("Invalid request body: %v\n", err)
-		ar.responseCode = http.StatusBadRequest
-		ar.response = "Invalid request body"
-		return
-	}
-
-	if request.UserID <= 0 {
-		ar.responseCode = http.StatusBadRequest
-		ar.response = "Invalid user ID"
-		return
-	}
-
+func (ar *apiRequest) toggleFollow(request *UserIDRequest) {
 	// Prevent following yourself
 	if request.UserID == ar.claims.Id {
-		ar.responseCode = http.StatusBadRequest
-		errorResponse := map[string]string{"error": "Cannot follow yourself"}
-		responseJSON, _ := json.Marshal(errorResponse)
-		ar.response = string(responseJSON)
+		ar.setError(http.StatusBadRequest, "Cannot follow yourself")
 		return
 	}
 
 	// Check if already following
-	isFollowing, err := db.Connection.IsFollowing(ar.claims.Id, request.UserID)
+	isFollowing, err := ar.db.IsFollowing(ar.claims.Id, request.UserID)
 	if err != nil {
 		log.Printf("Failed to check follow status: %v", err)
-		ar.responseCode = http.StatusInternalServerError
-		ar.response = "Internal server error"
+		ar.setError(http.StatusInternalServerError, "Internal server error")
 		return
 	}
 
 	if isFollowing {
 		// Unfollow
-		err = db.Connection.Unfollow(ar.claims.Id, request.UserID)
+		err = ar.db.Unfollow(ar.claims.Id, request.UserID)
 		if err != nil {
 			log.Printf("Failed to unfollow: %v", err)
-			ar.responseCode = http.StatusInternalServerError
-			ar.response = "Internal server error"
+			ar.setError(http.StatusInternalServerError, "Internal server error")
 			return
 		}
 		isFollowing = false
 	} else {
 		// Check if there's a pending request
-		hasPending, err := db.Connection.HasPendingFollowRequest(ar.claims.Id, request.UserID)
+		hasPending, err := ar.db.HasPendingFollowRequest(ar.claims.Id, request.UserID)
 		if err != nil {
 			log.Printf("Failed to check pending follow request: %v", err)
-			ar.responseCode = http.StatusInternalServerError
-			ar.response = "Internal server error"
+			ar.setError(http.StatusInternalServerError, "Internal server error")
 			return
 		}
 
 		if hasPending {
 			// Cancel pending request
-			err = db.Connection.DeclineFollowRequest(ar.claims.Id, request.UserID)
+			err = ar.db.DeclineFollowRequest(ar.claims.Id, request.UserID)
 			if err != nil {
 				log.Printf("Failed to cancel follow request: %v", err)
-				ar.responseCode = http.StatusInternalServerError
-				ar.response = "Internal server error"
+				ar.setError(http.StatusInternalServerError, "Internal server error")
 				return
 			}
 			isFollowing = false
 		} else {
 			// Create follow request
-			err = db.Connection.CreateFollowRequest(ar.claims.Id, request.UserID)
+			err = ar.db.CreateFollowRequest(ar.claims.Id, request.UserID)
 			if err != nil {
 				log.Printf("Failed to create follow request: %v", err)
-				ar.responseCode = http.StatusInternalServerError
-				ar.response = "Internal server error"
+				ar.setError(http.StatusInternalServerError, "Internal server error")
 				return
 			}
 
 			// Check if it was automatically accepted (public profile)
-			isFollowing, err = db.Connection.IsFollowing(ar.claims.Id, request.UserID)
+			isFollowing, err = ar.db.IsFollowing(ar.claims.Id, request.UserID)
 			if err != nil {
 				log.Printf("Failed to check follow status after request: %v", err)
-				ar.responseCode = http.StatusInternalServerError
-				ar.response = "Internal server error"
+				ar.setError(http.StatusInternalServerError, "Internal server error")
 				return
 			}
 		}
@@ -894,8 +854,7 @@ func (ar *apiRequest) toggleFollow() {
 	responseJSON, err := json.Marshal(response)
 	if err != nil {
 		log.Printf("Error marshalling toggle follow response: %v\n", err)
-		ar.responseCode = http.StatusInternalServerError
-		ar.response = "Internal server error"
+		ar.setError(http.StatusInternalServerError, "Internal server error")
 		return
 	}
 
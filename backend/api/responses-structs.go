@@ -1,8 +1,168 @@
 package api
 
-import "backend/db"
+import (
+	"backend/db"
+	"time"
+)
 
 type loginResponse struct {
-	User  db.User `json:"user"`
-	Token string  `json:"token"`
+	User         db.User `json:"user"`
+	Token        string  `json:"token"`
+	RefreshToken string  `json:"refreshToken"`
+	ExpiresIn    int     `json:"expiresIn"`             // access token lifetime in seconds
+	Reactivated  bool    `json:"reactivated,omitempty"` // the login undid a deactivation or a scheduled deletion
+	CSRFToken    string  `json:"csrfToken"`             // send in the X-CSRF-Token header with the session cookie
+}
+
+type tokenResponse struct {
+	Token        string `json:"token"`
+	RefreshToken string `json:"refreshToken"`
+	ExpiresIn    int    `json:"expiresIn"` // access token lifetime in seconds
+}
+
+type twoFactorChallengeResponse struct {
+	Status         string `json:"status"` // always "2fa_required"
+	ChallengeToken string `json:"challengeToken"`
+	ExpiresIn      int    `json:"expiresIn"` // challenge token lifetime in seconds
+}
+
+// errorResponse is what every failed request answers with, see errors.go
+type errorResponse struct {
+	Error      string       `json:"error"`                // meant to be shown to people
+	Code       string       `json:"code"`                 // stable, for clients to tell errors apart
+	Details    []fieldError `json:"details,omitempty"`    // the invalid fields of a validation_failed request
+	RetryAfter int          `json:"retryAfter,omitempty"` // seconds to wait, with 429 Too Many Requests
+	RequestID  string       `json:"requestId,omitempty"`  // also in the X-Request-ID header, quote it when reporting problems
+}
+
+// The responses below describe what the actions answer with, see describe_actions
+
+type messageResponse struct {
+	Message string `json:"message"`
+}
+
+// newTokensResponse answers changes that revoked the user's other logins
+type newTokensResponse struct {
+	Message      string `json:"message"`
+	Token        string `json:"token"`
+	RefreshToken string `json:"refreshToken"`
+	ExpiresIn    int    `json:"expiresIn"` // access token lifetime in seconds
+}
+
+type csrfTokenResponse struct {
+	CSRFToken string `json:"csrfToken"`
+}
+
+type oidcStartResponse struct {
+	AuthorizationURL string `json:"authorizationUrl"` // where to send the browser
+	State            string `json:"state"`
+}
+
+type accountDeletionResponse struct {
+	Message     string    `json:"message"`
+	DeleteAfter time.Time `json:"deleteAfter"`
+}
+
+type enrollTwoFactorResponse struct {
+	Secret     string `json:"secret"`
+	OtpauthURI string `json:"otpauthUri"` // for a QR code
+}
+
+type recoveryCodesResponse struct {
+	Message       string   `json:"message"`
+	RecoveryCodes []string `json:"recoveryCodes"` // only shown this once
+}
+
+type sessionsResponse struct {
+	Sessions []sessionResponse `json:"sessions"`
+}
+
+type revokedSessionsResponse struct {
+	Message string `json:"message"`
+	Revoked int64  `json:"revoked"`
+}
+
+type usersResponse struct {
+	Users []db.UserSummary `json:"users"`
+}
+
+type roleResponse struct {
+	Message string `json:"message"`
+	Role    string `json:"role"`
+}
+
+type createdTokenResponse struct {
+	ID        int        `json:"id"`
+	Name      string     `json:"name"`
+	Token     string     `json:"token"` // only returned this once
+	Scopes    []string   `json:"scopes"`
+	ExpiresAt *time.Time `json:"expiresAt"`
+}
+
+type tokensResponse struct {
+	Tokens []db.PersonalAccessToken `json:"tokens"`
+}
+
+type securityEventsResponse struct {
+	Events []db.SecurityEvent `json:"events"`
+}
+
+type avatarResponse struct {
+	Message  string `json:"message"`
+	ImageID  int    `json:"imageId"`
+	ImageURL string `json:"imageUrl"`
+}
+
+type userProfileResponse struct {
+	ID             int    `json:"id"`
+	Email          string `json:"email"`
+	FirstName      string `json:"firstName"`
+	LastName       string `json:"lastName"`
+	Nickname       string `json:"nickname"`
+	About          string `json:"about"`
+	ProfilePicture int    `json:"profilePicture"`
+	IsPublic       bool   `json:"isPublic"`
+	IsFollowing    bool   `json:"isFollowing"`
+	FollowersCount int    `json:"followersCount"`
+	FollowingCount int    `json:"followingCount"`
+	PostsCount     int    `json:"postsCount"`
+}
+
+type followRequestResponse struct {
+	ID             int       `json:"id"`
+	FollowerID     int       `json:"followerId"`
+	FollowerName   string    `json:"followerName"`
+	FollowerNick   string    `json:"followerNick"`
+	ProfilePicture int       `json:"profilePicture"`
+	CreatedAt      time.Time `json:"createdAt"`
+}
+
+type followRequestsResponse struct {
+	Requests []followRequestResponse `json:"requests"`
+}
+
+type followUserResponse struct {
+	ID             int    `json:"id"`
+	Nickname       string `json:"nickname"`
+	FirstName      string `json:"firstName"`
+	LastName       string `json:"lastName"`
+	FullName       string `json:"fullName"`
+	ProfilePicture int    `json:"profilePicture"`
+}
+
+type followersResponse struct {
+	Followers []followUserResponse `json:"followers"`
+}
+
+type followingResponse struct {
+	Following []followUserResponse `json:"following"`
+}
+
+type toggleFollowResponse struct {
+	IsFollowing bool `json:"isFollowing"`
+}
+
+type toggleLikeResponse struct {
+	Liked     bool `json:"liked"`
+	LikeCount int  `json:"likeCount"`
 }
@@ -0,0 +1,223 @@
+package api
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"io"
+	"log"
+	"net/http"
+	"strconv"
+)
+
+// restPrefix is where the REST routes live, next to the legacy action endpoint at /api
+const restPrefix = "/api/v1"
+
+// restRoute maps a REST route to the action that handles it. The handler reads the same JSON
+// request as on the action endpoint, built from the request body (or the query for GET)
+// with the path wildcards filled in.
+type restRoute struct {
+	method string
+	path   string // after restPrefix, with http.ServeMux wildcards like {id}
+	action string
+	params map[string]string // path wildcard -> request field, IDs are numbers
+	query  map[string]string // query parameter -> kind: "string", "int" or "strings" (repeatable)
+	status int               // status of a successful response if not 200, e.g. 201 for creation
+}
+
+// restRoutes lists the resources of the REST API. get_user_posts, get_notifications and
+// mark_notification_read are left out until they are implemented.
+var restRoutes = []restRoute{
+	{method: "POST", path: "/auth/signup", action: "signup", status: http.StatusCreated},
+	{method: "POST", path: "/auth/login", action: "login"},
+	{method: "POST", path: "/auth/login/2fa", action: "login_2fa"},
+	{method: "POST", path: "/auth/oidc/start", action: "oidc_start"},
+	{method: "POST", path: "/auth/oidc/callback", action: "oidc_callback"},
+	{method: "POST", path: "/auth/logout", action: "logout"},
+	{method: "POST", path: "/auth/refresh", action: "refresh_token"},
+	{method: "GET", path: "/auth/csrf-token", action: "get_csrf_token"},
+	{method: "POST", path: "/auth/password-reset", action: "request_password_reset"},
+	{method: "POST", path: "/auth/password-reset/confirm", action: "reset_password"},
+	{method: "POST", path: "/auth/verify-email", action: "verify_email"},
+
+	{method: "POST", path: "/me/verification-email", action: "resend_verification"},
+	{method: "PUT", path: "/me/password", action: "change_password"},
+	{method: "PUT", path: "/me/email", action: "change_email"},
+	{method: "PUT", path: "/me/profile", action: "update_profile"},
+	{method: "PUT", path: "/me/avatar", action: "upload_avatar"},
+	{method: "POST", path: "/me/deactivate", action: "deactivate_account"},
+	{method: "DELETE", path: "/me", action: "delete_account"},
+	{method: "POST", path: "/me/2fa", action: "enroll_2fa"},
+	{method: "POST", path: "/me/2fa/confirm", action: "confirm_2fa"},
+	{method: "DELETE", path: "/me/2fa", action: "disable_2fa"},
+	{method: "GET", path: "/me/sessions", action: "list_sessions"},
+	{method: "DELETE", path: "/me/sessions", action: "revoke_all_other_sessions"},
+	{method: "DELETE", path: "/me/sessions/{id}", action: "revoke_session", params: map[string]string{"id": "sessionId"}},
+	{method: "GET", path: "/me/tokens", action: "list_tokens"},
+	{method: "POST", path: "/me/tokens", action: "create_token", status: http.StatusCreated},
+	{method: "DELETE", path: "/me/tokens/{id}", action: "revoke_token", params: map[string]string{"id": "id"}},
+	{method: "GET", path: "/me/security-events", action: "get_security_events", query: map[string]string{"beforeId": "int", "limit": "int"}},
+	{method: "GET", path: "/me/followers", action: "get_followers"},
+	{method: "GET", path: "/me/liked-posts", action: "get_liked_posts"},
+	{method: "GET", path: "/me/follow-requests", action: "get_follow_requests"},
+	{method: "POST", path: "/me/follow-requests/{id}/accept", action: "accept_follow_request", params: map[string]string{"id": "requestId"}},
+	{method: "POST", path: "/me/follow-requests/{id}/decline", action: "decline_follow_request", params: map[string]string{"id": "requestId"}},
+
+	{method: "GET", path: "/posts", action: "get_posts"},
+	{method: "POST", path: "/posts", action: "create_post", status: http.StatusCreated},
+	{method: "GET", path: "/posts/{id}/comments", action: "get_comments", params: map[string]string{"id": "postId"}},
+	{method: "POST", path: "/posts/{id}/comments", action: "create_comment", params: map[string]string{"id": "postId"}, status: http.StatusCreated},
+	{method: "POST", path: "/posts/{id}/like", action: "toggle_like", params: map[string]string{"id": "postId"}},
+
+	{method: "GET", path: "/users/{id}", action: "get_user_profile", params: map[string]string{"id": "userId"}},
+	{method: "GET", path: "/users/{id}/followers", action: "get_followers", params: map[string]string{"id": "userId"}},
+	{method: "GET", path: "/users/{id}/following", action: "get_following", params: map[string]string{"id": "userId"}},
+	{method: "POST", path: "/users/{id}/follow", action: "toggle_follow", params: map[string]string{"id": "userId"}},
+
+	{method: "POST", path: "/batch", action: "batch"},
+
+	{method: "GET", path: "/admin/users", action: "list_users", query: map[string]string{"search": "string", "limit": "int", "offset": "int"}},
+	{method: "PUT", path: "/admin/users/{id}/suspension", action: "suspend_user", params: map[string]string{"id": "userId"}},
+	{method: "PUT", path: "/admin/users/{id}/role", action: "set_user_role", params: map[string]string{"id": "userId"}},
+	{method: "GET", path: "/admin/security-events", action: "query_security_events",
+		query: map[string]string{"userId": "int", "types": "strings", "since": "string", "until": "string", "beforeId": "int", "limit": "int"}},
+}
+
+var restMux = newRESTMux()
+
+// REST serves the REST routes under /api/v1
+func REST(w http.ResponseWriter, r *http.Request) {
+	restMux.ServeHTTP(w, r)
+}
+
+func newRESTMux() *http.ServeMux {
+	mux := http.NewServeMux()
+	for _, route := range restRoutes {
+		mux.HandleFunc(route.method+" "+restPrefix+route.path, route.serve)
+	}
+
+	mux.HandleFunc("GET "+restPrefix+"/openapi.json", serveOpenAPI)
+
+	// Preflight requests and paths without a route
+	mux.HandleFunc(restPrefix+"/", func(w http.ResponseWriter, r *http.Request) {
+		setCORSHeaders(w, r, "GET, POST, PUT, DELETE, OPTIONS")
+		if r.Method == http.MethodOptions {
+			w.WriteHeader(http.StatusOK)
+			return
+		}
+		writeError(w, r, http.StatusNotFound, CodeNotFound, "Not found")
+	})
+
+	return mux
+}
+
+func (route restRoute) serve(w http.ResponseWriter, r *http.Request) {
+	setCORSHeaders(w, r, "GET, POST, PUT, DELETE, OPTIONS")
+
+	if !originAllowed(r) {
+		log.Printf("Rejected API request from origin %s", requestOrigin(r))
+		writeError(w, r, http.StatusForbidden, CodeOriginNotAllowed, "Origin not allowed")
+		return
+	}
+
+	body, ok := route.requestBody(w, r)
+	if !ok {
+		return
+	}
+
+	request := dispatch(w, r, route.action, body)
+	if request == nil {
+		return // refused, the response is written
+	}
+
+	status := request.responseCode
+	if status == http.StatusOK && route.status != 0 {
+		status = route.status
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+
+	// Reads can be revalidated, a client sending back the ETag gets 304 if nothing changed
+	if r.Method == http.MethodGet && status == http.StatusOK {
+		sum := sha256.Sum256([]byte(request.response))
+		etag := `"` + hex.EncodeToString(sum[:16]) + `"`
+		w.Header().Set("Cache-Control", "private, no-cache")
+		w.Header().Set("ETag", etag)
+		if r.Header.Get("If-None-Match") == etag {
+			w.WriteHeader(http.StatusNotModified)
+			return
+		}
+	}
+
+	w.WriteHeader(status)
+	w.Write([]byte(request.response))
+}
+
+// requestBody builds the JSON request of the action from the body or query and the path.
+// Returns false if the request was invalid, the error is already written.
+func (route restRoute) requestBody(w http.ResponseWriter, r *http.Request) ([]byte, bool) {
+	fields := map[string]interface{}{}
+
+	if r.Method == http.MethodGet {
+		query := r.URL.Query()
+		for name, kind := range route.query {
+			values, ok := query[name]
+			if !ok || len(values) == 0 {
+				continue
+			}
+
+			switch kind {
+			case "int":
+				n, err := strconv.Atoi(values[0])
+				if err != nil {
+					writeErrorResponse(w, http.StatusBadRequest, errorResponse{
+						Error:     "Query parameter " + name + " must be a number",
+						Code:      CodeValidationFailed,
+						Details:   []fieldError{{Field: name, Message: "must be a number"}},
+						RequestID: requestID(r),
+					})
+					return nil, false
+				}
+				fields[name] = n
+			case "strings":
+				fields[name] = values
+			default:
+				fields[name] = values[0]
+			}
+		}
+	} else {
+		body, err := io.ReadAll(r.Body)
+		_ = r.Body.Close()
+		if err != nil {
+			writeError(w, r, http.StatusBadRequest, CodeInvalidBody, "Failed to read request body")
+			return nil, false
+		}
+
+		if len(bytes.TrimSpace(body)) > 0 {
+			if err := json.Unmarshal(body, &fields); err != nil {
+				writeError(w, r, http.StatusBadRequest, CodeInvalidBody, "Request body must be a JSON object")
+				return nil, false
+			}
+		}
+	}
+
+	// The path decides which resource is meant, whatever the body says
+	for wildcard, field := range route.params {
+		id, err := strconv.Atoi(r.PathValue(wildcard))
+		if err != nil || id <= 0 {
+			writeError(w, r, http.StatusNotFound, CodeNotFound, "Not found")
+			return nil, false
+		}
+		fields[field] = id
+	}
+
+	body, err := json.Marshal(fields)
+	if err != nil {
+		log.Printf("Error marshalling request of %s: %v", route.action, err)
+		writeError(w, r, http.StatusInternalServerError, CodeInternal, "Internal server error")
+		return nil, false
+	}
+
+	return body, true
+}
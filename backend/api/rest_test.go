@@ -0,0 +1,125 @@
+package api
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+// callREST sends a request to a REST route, with a JSON body unless body is nil
+func callREST(t *testing.T, method string, path string, body any, header http.Header, cookies ...*http.Cookie) *httptest.ResponseRecorder {
+	t.Helper()
+
+	var requestBody []byte
+	if body != nil {
+		var err error
+		if requestBody, err = json.Marshal(body); err != nil {
+			t.Fatalf("Failed to marshal request: %v", err)
+		}
+	}
+
+	r := httptest.NewRequest(method, restPrefix+path, bytes.NewReader(requestBody))
+	for name, values := range header {
+		r.Header[name] = values
+	}
+	for _, cookie := range cookies {
+		r.AddCookie(cookie)
+	}
+
+	w := httptest.NewRecorder()
+	REST(w, r)
+	return w
+}
+
+func TestRESTGetUser(t *testing.T) {
+	userID, email := newUser(t)
+	user, _ := login(t, email)
+	path := fmt.Sprintf("/users/%d", userID)
+
+	w := callREST(t, http.MethodGet, path, nil, bearerHeader(user))
+	if profile := decodeResponse[userProfileResponse](t, w, http.StatusOK); profile.ID != userID || profile.Email != email {
+		t.Errorf("Expected the profile of user %d, got %+v", userID, profile)
+	}
+
+	// The ETag revalidates the same response
+	etag := w.Header().Get("ETag")
+	if etag == "" {
+		t.Fatal("Expected a GET to return an ETag")
+	}
+	w = callREST(t, http.MethodGet, path, nil, http.Header{"Authorization": {"Bearer " + user.Token}, "If-None-Match": {etag}})
+	if w.Code != http.StatusNotModified || w.Body.Len() != 0 {
+		t.Errorf("Expected 304 without a body for an unchanged profile, got %d: %s", w.Code, w.Body.String())
+	}
+
+	update := map[string]any{"firstName": "Changed", "lastName": "User", "nickname": "", "about": "", "isPublic": true}
+	if w = callREST(t, http.MethodPut, "/me/profile", update, bearerHeader(user)); w.Code != http.StatusOK {
+		t.Fatalf("Expected the profile to be updated, got %d: %s", w.Code, w.Body.String())
+	}
+	w = callREST(t, http.MethodGet, path, nil, http.Header{"Authorization": {"Bearer " + user.Token}, "If-None-Match": {etag}})
+	if profile := decodeResponse[userProfileResponse](t, w, http.StatusOK); profile.FirstName != "Changed" || w.Header().Get("ETag") == etag {
+		t.Errorf("Expected the changed profile with a new ETag, got %+v", profile)
+	}
+
+	for _, path := range []string{"/users/abc", "/users/0", "/users/1/unknown"} {
+		w = callREST(t, http.MethodGet, path, nil, bearerHeader(user))
+		if response := decodeResponse[errorResponse](t, w, http.StatusNotFound); response.Code != CodeNotFound {
+			t.Errorf("Expected %s to be %s, got %s", path, CodeNotFound, response.Code)
+		}
+	}
+}
+
+func TestRESTPathDecidesResource(t *testing.T) {
+	_, email := newUser(t)
+	laptop := loginFrom(t, email, "Laptop Browser")
+	phone := loginFrom(t, email, "Phone Browser")
+	tablet := loginFrom(t, email, "Tablet Browser")
+
+	var phoneID, tabletID int
+	for _, session := range laptop.sessions(t) {
+		switch session.UserAgent {
+		case "Phone Browser":
+			phoneID = session.ID
+		case "Tablet Browser":
+			tabletID = session.ID
+		}
+	}
+
+	// The body names another session, the one in the path is revoked
+	w := callREST(t, http.MethodDelete, fmt.Sprintf("/me/sessions/%d", phoneID), map[string]int{"sessionId": tabletID},
+		sessionHeader(laptop.user), laptop.session)
+	decodeResponse[messageResponse](t, w, http.StatusOK)
+
+	if !phone.loggedOut(t) || tablet.loggedOut(t) {
+		t.Error("Expected only the session in the path to be revoked")
+	}
+}
+
+func TestRESTStatusAndQuery(t *testing.T) {
+	_, email := newUser(t)
+	user, _ := login(t, email)
+
+	w := callREST(t, http.MethodPost, "/me/tokens", map[string]any{"name": "bot", "scopes": []string{ScopeProfileRead}}, bearerHeader(user))
+	if token := decodeResponse[createdTokenResponse](t, w, http.StatusCreated); token.Token == "" {
+		t.Errorf("Expected the created token, got %+v", token)
+	}
+
+	// Logging in and creating the token are two events
+	w = callREST(t, http.MethodGet, "/me/security-events?limit=1", nil, bearerHeader(user))
+	if events := decodeResponse[securityEventsResponse](t, w, http.StatusOK); len(events.Events) != 1 {
+		t.Errorf("Expected the limit to come from the query, got %d events", len(events.Events))
+	}
+
+	w = callREST(t, http.MethodGet, "/me/security-events?limit=ten", nil, bearerHeader(user))
+	response := decodeResponse[errorResponse](t, w, http.StatusBadRequest)
+	if response.Code != CodeValidationFailed || len(response.Details) != 1 || response.Details[0].Field != "limit" {
+		t.Errorf("Expected the query parameter to be rejected, got %+v", response)
+	}
+
+	w = callREST(t, http.MethodGet, "/admin/users", nil, bearerHeader(user))
+	if response := decodeResponse[errorResponse](t, w, http.StatusForbidden); response.Code != CodeForbidden {
+		t.Errorf("Expected the permission of the action to apply, got %s", response.Code)
+	}
+}
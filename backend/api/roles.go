@@ -0,0 +1,58 @@
+package api
+
+import (
+	"backend/db"
+	"log"
+)
+
+// Roles a user can have, every user starts as RoleUser
+const (
+	RoleUser      = "user"
+	RoleModerator = "moderator"
+	RoleAdmin     = "admin"
+)
+
+// Permission is something only some roles may do
+type Permission string
+
+const (
+	PermissionListUsers           Permission = "users:list"
+	PermissionSuspendUser         Permission = "users:suspend"
+	PermissionEditRoles           Permission = "users:edit_roles"
+	PermissionQuerySecurityEvents Permission = "security_events:read"
+)
+
+// rolePermissions lists what each role may do on top of what every user can
+var rolePermissions = map[string][]Permission{
+	RoleUser:      {},
+	RoleModerator: {PermissionListUsers, PermissionSuspendUser},
+	RoleAdmin:     {PermissionListUsers, PermissionSuspendUser, PermissionEditRoles, PermissionQuerySecurityEvents},
+}
+
+// roleRank orders the roles, moderators and admins can only act on users ranked below them
+var roleRank = map[string]int{
+	RoleUser:      0,
+	RoleModerator: 1,
+	RoleAdmin:     2,
+}
+
+// ValidRole reports whether role is one of the known roles
+func ValidRole(role string) bool {
+	_, ok := rolePermissions[role]
+	return ok
+}
+
+// roleHasPermission reports whether a role grants a permission
+func roleHasPermission(role string, permission Permission) bool {
+	return contains(rolePermissions[role], permission)
+}
+
+// hasPermission looks up the user's role on every call, so role changes apply at once
+func hasPermission(userID int, permission Permission) bool {
+	role, err := db.Connection.FetchUserRole(userID)
+	if err != nil {
+		log.Printf("Failed to fetch role of user %d: %v", userID, err)
+		return false
+	}
+	return roleHasPermission(role, permission)
+}
@@ -2,6 +2,7 @@ package api
 
 import (
 	"backend/db"
+	"database/sql"
 	"encoding/json"
 	"fmt"
 	"io"
@@ -23,22 +24,21 @@ func min(a, b int) int {
 func Router(w http.ResponseWriter, r *http.Request) {
 	// Handle preflight request for CORS
 	w.Header().Set("Allow", "POST, OPTIONS")
-	w.Header().Set("Access-Control-Allow-Methods", "POST, OPTIONS")
-	w.Header().Set("Access-Control-Allow-Origin", "http://localhost:3000")
-	w.Header().Set("Access-Control-Allow-Headers", "Content-Type, Authorization")
-	w.Header().Set("Access-Control-Allow-Credentials", "true")
+	setCORSHeaders(w, r, "POST, OPTIONS")
 
 	if r.Method == http.MethodOptions {
 		w.WriteHeader(http.StatusOK)
 		return // Preflight request handled
 	}
 
+	if !originAllowed(r) {
+		log.Printf("Rejected API request from origin %s", requestOrigin(r))
+		writeError(w, r, http.StatusForbidden, CodeOriginNotAllowed, "Origin not allowed")
+		return
+	}
+
 	if r.Method != http.MethodPost {
-		w.Header().Set("Content-Type", "application/json")
-		w.WriteHeader(http.StatusMethodNotAllowed)
-		errorResponse := map[string]string{"error": "Method not allowed"}
-		responseJSON, _ := json.Marshal(errorResponse)
-		w.Write(responseJSON)
+		writeError(w, r, http.StatusMethodNotAllowed, CodeMethodNotAllowed, "Method not allowed")
 		return
 	}
 
@@ -47,155 +47,158 @@ func Router(w http.ResponseWriter, r *http.Request) {
 
 	var api API
 	if err := json.Unmarshal(bodyBytes, &api); err != nil {
-		w.Header().Set("Content-Type", "application/json")
-		w.WriteHeader(http.StatusBadRequest)
-		errorResponse := map[string]string{"error": "Invalid JSON in request body or missing action"}
-		responseJSON, _ := json.Marshal(errorResponse)
-		w.Write(responseJSON)
+		writeError(w, r, http.StatusBadRequest, CodeInvalidBody, "Invalid JSON in request body or missing action")
 		return // exit if there was a json decode error
 	}
 
 	action := api.Action
 	if action == "" {
-		w.Header().Set("Content-Type", "application/json")
-		w.WriteHeader(http.StatusBadRequest)
-		errorResponse := map[string]string{"error": "Missing action in request body"}
-		responseJSON, _ := json.Marshal(errorResponse)
-		w.Write(responseJSON)
+		writeError(w, r, http.StatusBadRequest, CodeInvalidAction, "Missing action in request body")
 		return
 	}
 
-	bodyString := string(bodyBytes)
+	request := dispatch(w, r, action, bodyBytes)
+	if request == nil {
+		return // refused, the response is written
+	}
 
-	// Try session-based authentication first (preferred)
-	var claims *Claims
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(request.responseCode)
+	w.Write([]byte(request.response))
+}
 
-	// Check for session cookie authentication
-	if sessionClaims, sessionErr := ValidateSession(r); sessionErr == nil && sessionClaims != nil {
-		claims = sessionClaims
-		log.Printf("Authenticated user %d via session", claims.Id)
-	} else {
-		// Fallback to token-based authentication (for backwards compatibility)
-		auth := r.Header.Get("Authorization")
-
-		if auth == "" {
-			if action != "login" && action != "signup" {
-				w.Header().Set("Content-Type", "application/json")
-				w.WriteHeader(http.StatusUnauthorized)
-				errorResponse := map[string]string{"error": "Missing Authentication (no session or token)"}
-				responseJSON, _ := json.Marshal(errorResponse)
-				w.Write(responseJSON)
-				return
-			}
-		} else {
-			// Remove "Bearer " prefix if present
-			token := auth
-			if strings.HasPrefix(auth, "Bearer ") {
-				token = auth[7:] // Remove "Bearer " (7 characters)
-				log.Printf("Stripped Bearer prefix. Token length: %d", len(token))
-			} else {
-				log.Printf("No Bearer prefix found. Raw auth header: %s", auth[:min(50, len(auth))])
-			}
-
-			tokenClaims, err := UnmarshalBearer(&token)
-
-			if err != nil || tokenClaims == nil {
-				log.Printf("Error unmarshalling token: %v\n", err)
-				log.Printf("Token being parsed (first 50 chars): %s", token[:min(50, len(token))])
-				w.Header().Set("Content-Type", "application/json")
-				w.WriteHeader(http.StatusUnauthorized)
-				errorResponse := map[string]string{"error": "Bad Authorization Token"}
-				responseJSON, _ := json.Marshal(errorResponse)
-				w.Write(responseJSON)
-				return
-			}
-			claims = tokenClaims
-			log.Printf("Authenticated user %d via token", claims.Id)
-		}
+// dispatch authenticates and authorizes a call of an action and runs its handler, for both
+// the action endpoint and the REST routes. body is the JSON request the handler reads.
+// Returns nil if the call was refused, the refusal is already written.
+func dispatch(w http.ResponseWriter, r *http.Request, action string, body []byte) *apiRequest {
+	spec, ok := actions.lookup(action)
+	if !ok {
+		writeError(w, r, http.StatusBadRequest, CodeInvalidAction, "Invalid action")
+		return nil
+	}
+
+	claims, err := authenticateAPI(r)
+	noteRequest(r, claimsUserID(claims), action)
+
+	if refusal := authorize(spec, claims, err); refusal != nil {
+		writeError(w, r, refusal.status, refusal.code, refusal.message)
+		return nil
+	}
+
+	request := newAPIRequest(w, r, claims, err, &db.Connection, body)
+	spec.run(request)
+	return request
+}
+
+// authorize decides whether the caller may call an action. claims and authErr are the result of
+// authenticating the request, nil claims for requests without credentials.
+// Returns nil if the call is allowed.
+func authorize(spec *actionSpec, claims *Claims, authErr error) *apiError {
+	public := spec.auth == AuthPublic
+
+	if authErr == errCSRFTokenInvalid && !public {
+		return &apiError{http.StatusForbidden, CodeCSRFFailed, "Missing or invalid CSRF token"}
+	}
+
+	if authErr != nil && !public {
+		return &apiError{http.StatusUnauthorized, CodeInvalidToken, "Bad Authorization Token"}
+	}
+
+	if claims == nil && !public {
+		return &apiError{http.StatusUnauthorized, CodeUnauthorized, "Missing Authentication (no session or token)"}
+	}
+
+	if spec.auth == AuthVerified && !isVerified(claims.Id) {
+		return &apiError{http.StatusForbidden, CodeEmailNotVerified, "Verify your email address first"}
+	}
+
+	// Public actions need no credentials, so the scopes of a token can't restrict them
+	if claims != nil && !public && !claims.allowsAction(spec) {
+		return &apiError{http.StatusForbidden, CodeInsufficientScope, "The token's scopes don't allow this action"}
 	}
 
-	// Ensure claims is never nil for unauthenticated actions
-	if claims == nil {
-		claims = &Claims{}
+	if spec.permission != "" && !hasPermission(claims.Id, spec.permission) {
+		return &apiError{http.StatusForbidden, CodeForbidden, "You don't have permission to do this"}
 	}
 
-	request := apiRequest{
-		claims:       *claims,
-		requestBody:  bodyString,
+	return nil
+}
+
+// newAPIRequest prepares a call of an action by the authenticated caller, database is where its queries run
+func newAPIRequest(w http.ResponseWriter, r *http.Request, claims *Claims, authErr error, database *db.Database, body []byte) *apiRequest {
+	request := &apiRequest{
+		db:           database,
+		requestBody:  string(body),
 		httpRequest:  r,
 		httpWriter:   w,
 		response:     "",
 		responseCode: http.StatusOK,
+		authError:    authErr,
 	}
 
-	switch action {
-	case "signup":
-		request.signup()
-	case "login":
-		request.login()
-	case "logout":
-		request.logout()
-	case "upload_avatar":
-		request.uploadAvatar()
-	case "create_post":
-		request.createPost()
-	case "get_posts":
-		request.getPosts()
-	case "get_liked_posts":
-		request.getLikedPosts()
-	case "create_comment":
-		request.createComment()
-	case "get_comments":
-		request.getComments()
-	case "toggle_like":
-		request.toggleLike()
-	case "get_followers":
-		request.getFollowers()
-	case "get_user_profile":
-		request.getUserProfile()
-	case "get_user_posts":
-		request.getUserPosts()
-	case "toggle_follow":
-		request.toggleFollow()
-	case "get_notifications":
-		request.getNotifications()
-	case "mark_notification_read":
-		request.markNotificationRead()
-	case "get_follow_requests":
-		request.getFollowRequests()
-	case "accept_follow_request":
-		request.acceptFollowRequest()
-	case "decline_follow_request":
-		request.declineFollowRequest()
-	case "update_profile":
-		request.updateProfile()
-	case "get_following":
-		request.getFollowing()
-	case "get_followers":
-		request.getFollowersForUser()
-	default:
-		w.Header().Set("Content-Type", "application/json")
-		w.WriteHeader(http.StatusBadRequest)
-		errorResponse := map[string]string{"error": "Invalid action"}
-		responseJSON, _ := json.Marshal(errorResponse)
-		w.Write(responseJSON)
-		return
+	// Unauthenticated calls of public actions get empty claims
+	if claims != nil {
+		request.claims = *claims
+		request.authenticated = true
 	}
 
-	if request.responseCode < 300 {
-		w.Header().Add("Content-Type", "application/json")
+	return request
+}
+
+// bearerFromRequest returns the token from the Authorization header, if any
+func bearerFromRequest(r *http.Request) string {
+	auth := r.Header.Get("Authorization")
+	if auth == "" {
+		return ""
 	}
 
-	w.WriteHeader(request.responseCode)
-	w.Write([]byte(request.response))
+	// Remove "Bearer " prefix if present. Never log the header, it is a credential.
+	token, _ := strings.CutPrefix(auth, "Bearer ")
+	return token
+}
+
+// authenticate identifies the caller of a request. The session cookie is preferred,
+// with the Authorization header as a fallback (for backwards compatibility).
+// It returns nil claims and no error when the request carries no credentials.
+func authenticate(r *http.Request) (*Claims, error) {
+	// Try session-based authentication first (preferred)
+	if sessionClaims, sessionErr := ValidateSession(r); sessionErr == nil && sessionClaims != nil {
+		log.Printf("Authenticated user %d via session", sessionClaims.Id)
+		return sessionClaims, nil
+	}
+
+	token := bearerFromRequest(r)
+	if token == "" {
+		return nil, nil
+	}
+
+	return authenticateBearer(r, token)
+}
+
+// authenticateBearer returns the claims of a signed bearer token or a personal access token
+func authenticateBearer(r *http.Request, token string) (*Claims, error) {
+	if strings.HasPrefix(token, personalAccessTokenPrefix) {
+		tokenClaims, err := authenticatePersonalAccessToken(r, token)
+		if err != nil {
+			return nil, fmt.Errorf("bad personal access token: %w", err)
+		}
+		log.Printf("Authenticated user %d via personal access token", tokenClaims.Id)
+		return tokenClaims, nil
+	}
+
+	tokenClaims, err := UnmarshalBearer(&token)
+	if err != nil || tokenClaims == nil {
+		log.Printf("Error unmarshalling token: %v\n", err)
+		return nil, fmt.Errorf("bad authorization token: %w", err)
+	}
+
+	log.Printf("Authenticated user %d via token", tokenClaims.Id)
+	return tokenClaims, nil
 }
 
 func File(w http.ResponseWriter, r *http.Request) {
 	// Set CORS headers for file serving
-	w.Header().Set("Access-Control-Allow-Origin", "http://localhost:3000")
-	w.Header().Set("Access-Control-Allow-Credentials", "true")
-	w.Header().Set("Access-Control-Allow-Methods", "GET, OPTIONS")
-	w.Header().Set("Access-Control-Allow-Headers", "Content-Type, Authorization")
+	setCORSHeaders(w, r, "GET, OPTIONS")
 
 	if r.Method == http.MethodOptions {
 		w.WriteHeader(http.StatusOK)
@@ -203,27 +206,31 @@ func File(w http.ResponseWriter, r *http.Request) {
 	}
 
 	if r.Method != http.MethodGet {
-		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		writeError(w, r, http.StatusMethodNotAllowed, CodeMethodNotAllowed, "Method not allowed")
 		return
 	}
 
 	idStr := r.URL.Query().Get("id")
 
 	if idStr == "" {
-		http.Error(w, "Missing 'id' parameter", http.StatusBadRequest)
+		writeError(w, r, http.StatusBadRequest, CodeBadRequest, "Missing 'id' parameter")
 		return
 	}
 
 	id, err := strconv.Atoi(idStr)
 	if err != nil {
-		http.Error(w, "Invalid 'id' parameter: Must be an integer", http.StatusBadRequest)
+		writeError(w, r, http.StatusBadRequest, CodeBadRequest, "Invalid 'id' parameter: Must be an integer")
 		return
 	}
 
 	file, err := db.Connection.GetFileByID(id)
-
+	if err == sql.ErrNoRows {
+		writeError(w, r, http.StatusNotFound, CodeNotFound, "File not found")
+		return
+	}
 	if err != nil {
-		http.Error(w, err.Error(), http.StatusNotFound)
+		log.Printf("Error fetching file: %v", err)
+		writeError(w, r, http.StatusInternalServerError, CodeInternal, "Internal server error")
 		return
 	}
 
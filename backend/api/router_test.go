@@ -0,0 +1,74 @@
+package api
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestFileAndWebSocketErrors(t *testing.T) {
+	tests := []struct {
+		name    string
+		handler http.HandlerFunc
+		method  string
+		target  string
+		header  http.Header
+		status  int
+		code    string
+	}{
+		{"file with other method", File, http.MethodPost, "/file?id=1", nil, http.StatusMethodNotAllowed, CodeMethodNotAllowed},
+		{"file without id", File, http.MethodGet, "/file", nil, http.StatusBadRequest, CodeBadRequest},
+		{"file with bad id", File, http.MethodGet, "/file?id=one", nil, http.StatusBadRequest, CodeBadRequest},
+		{"file that doesn't exist", File, http.MethodGet, "/file?id=999999", nil, http.StatusNotFound, CodeNotFound},
+		{"websocket without credentials", HandleWebSocket, http.MethodGet, "/ws", nil, http.StatusUnauthorized, CodeUnauthorized},
+		{"websocket with bad token", HandleWebSocket, http.MethodGet, "/ws",
+			http.Header{"Authorization": {"Bearer nope"}}, http.StatusUnauthorized, CodeUnauthorized},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			r := httptest.NewRequest(test.method, test.target, nil)
+			for name, values := range test.header {
+				r.Header[name] = values
+			}
+			w := httptest.NewRecorder()
+			test.handler(w, r)
+
+			response := decodeResponse[errorResponse](t, w, test.status)
+			if response.Code != test.code {
+				t.Errorf("Expected error code %q, got %q", test.code, response.Code)
+			}
+		})
+	}
+}
+
+func TestWebSocketHandshakeErrors(t *testing.T) {
+	user, _ := login(t, "user1@test.dev")
+
+	tests := []struct {
+		name   string
+		header http.Header
+		status int
+		code   string
+	}{
+		{"not a websocket request", http.Header{}, http.StatusBadRequest, CodeBadRequest},
+		{"other origin", http.Header{"Origin": {"https://evil.example"}, "Connection": {"Upgrade"}, "Upgrade": {"websocket"},
+			"Sec-Websocket-Version": {"13"}, "Sec-Websocket-Key": {"dGhlIHNhbXBsZSBub25jZQ=="}},
+			http.StatusForbidden, CodeOriginNotAllowed},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			r := httptest.NewRequest(http.MethodGet, "/ws", nil)
+			r.Header = test.header
+			r.Header.Set("Authorization", "Bearer "+user.Token)
+			w := httptest.NewRecorder()
+			HandleWebSocket(w, r)
+
+			response := decodeResponse[errorResponse](t, w, test.status)
+			if response.Code != test.code {
+				t.Errorf("Expected error code %q, got %q", test.code, response.Code)
+			}
+		})
+	}
+}
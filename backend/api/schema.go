@@ -0,0 +1,194 @@
+package api
+
+import (
+	"encoding/json"
+	"reflect"
+	"strconv"
+	"strings"
+	"time"
+	"unicode"
+)
+
+// jsonSchema is the part of JSON Schema needed to describe the requests and responses of the
+// API, in the dialect of OpenAPI 3.0 (nullable instead of type lists)
+type jsonSchema struct {
+	Ref                  string                 `json:"$ref,omitempty"`
+	Type                 string                 `json:"type,omitempty"`
+	Format               string                 `json:"format,omitempty"`
+	Nullable             bool                   `json:"nullable,omitempty"`
+	Properties           map[string]*jsonSchema `json:"properties,omitempty"`
+	Required             []string               `json:"required,omitempty"`
+	AdditionalProperties *jsonSchema            `json:"additionalProperties,omitempty"`
+	Items                *jsonSchema            `json:"items,omitempty"`
+	Enum                 []string               `json:"enum,omitempty"`
+	Minimum              *int                   `json:"minimum,omitempty"`
+	Maximum              *int                   `json:"maximum,omitempty"`
+	MinLength            *int                   `json:"minLength,omitempty"`
+	MaxLength            *int                   `json:"maxLength,omitempty"`
+	MinItems             *int                   `json:"minItems,omitempty"`
+	MaxItems             *int                   `json:"maxItems,omitempty"`
+}
+
+var (
+	timeType       = reflect.TypeFor[time.Time]()
+	rawMessageType = reflect.TypeFor[json.RawMessage]()
+)
+
+// schemaBuilder turns Go types into JSON Schemas. Named structs are collected once under
+// their name and referenced with refPrefix, which also keeps recursive types finite.
+type schemaBuilder struct {
+	refPrefix string
+	schemas   map[string]*jsonSchema
+	names     map[reflect.Type]string
+}
+
+func newSchemaBuilder(refPrefix string) *schemaBuilder {
+	return &schemaBuilder{
+		refPrefix: refPrefix,
+		schemas:   map[string]*jsonSchema{},
+		names:     map[reflect.Type]string{},
+	}
+}
+
+// schema returns the schema of a type, a reference for named structs
+func (b *schemaBuilder) schema(t reflect.Type) *jsonSchema {
+	switch {
+	case t == timeType:
+		return &jsonSchema{Type: "string", Format: "date-time"}
+	case t == rawMessageType:
+		return &jsonSchema{} // any JSON
+	}
+
+	switch t.Kind() {
+	case reflect.Pointer:
+		schema := *b.schema(t.Elem())
+		if schema.Ref != "" {
+			return &schema // OpenAPI 3.0 ignores siblings of $ref
+		}
+		schema.Nullable = true
+		return &schema
+	case reflect.Bool:
+		return &jsonSchema{Type: "boolean"}
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64,
+		reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		return &jsonSchema{Type: "integer"}
+	case reflect.Float32, reflect.Float64:
+		return &jsonSchema{Type: "number"}
+	case reflect.String:
+		return &jsonSchema{Type: "string"}
+	case reflect.Slice, reflect.Array:
+		if t.Elem().Kind() == reflect.Uint8 {
+			return &jsonSchema{Type: "string", Format: "byte"} // base64, like encoding/json
+		}
+		return &jsonSchema{Type: "array", Items: b.schema(t.Elem())}
+	case reflect.Map:
+		return &jsonSchema{Type: "object", AdditionalProperties: b.schema(t.Elem())}
+	case reflect.Struct:
+		if t.Name() == "" {
+			return b.structSchema(t, nil)
+		}
+		return &jsonSchema{Ref: b.refPrefix + b.named(t)}
+	default:
+		return &jsonSchema{} // interfaces can hold anything
+	}
+}
+
+// named collects the schema of a named struct and returns its name
+func (b *schemaBuilder) named(t reflect.Type) string {
+	if name, ok := b.names[t]; ok {
+		return name
+	}
+
+	name := schemaName(t)
+	if _, taken := b.schemas[name]; taken {
+		name = schemaName(t) + strconv.Itoa(len(b.names)) // same name in another package
+	}
+
+	// Claim the name before building, the struct may refer to itself
+	b.names[t] = name
+	b.schemas[name] = &jsonSchema{}
+	*b.schemas[name] = *b.structSchema(t, nil)
+	return name
+}
+
+// structSchema returns the object schema of a struct, leaving out the JSON fields in omit
+func (b *schemaBuilder) structSchema(t reflect.Type, omit map[string]bool) *jsonSchema {
+	schema := &jsonSchema{Type: "object", Properties: map[string]*jsonSchema{}}
+	b.addFields(schema, t, omit)
+	return schema
+}
+
+func (b *schemaBuilder) addFields(schema *jsonSchema, t reflect.Type, omit map[string]bool) {
+	for i := 0; i < t.NumField(); i++ {
+		field := t.Field(i)
+		tag := field.Tag.Get("json")
+		if tag == "-" {
+			continue
+		}
+
+		// Fields of embedded structs are promoted, like encoding/json does
+		if field.Anonymous && tag == "" && field.Type.Kind() == reflect.Struct {
+			b.addFields(schema, field.Type, omit)
+			continue
+		}
+		if !field.IsExported() {
+			continue
+		}
+
+		name := jsonName(field)
+		if omit[name] {
+			continue
+		}
+
+		property := b.schema(field.Type)
+		if rules := field.Tag.Get("validate"); rules != "" {
+			if applyRules(property, rules) {
+				schema.Required = append(schema.Required, name)
+			}
+		}
+		schema.Properties[name] = property
+	}
+}
+
+// applyRules adds the validate rules to a schema as constraints, reporting whether the field is required
+func applyRules(schema *jsonSchema, rules string) (required bool) {
+	for _, rule := range strings.Split(rules, ",") {
+		rule, arg, _ := strings.Cut(strings.TrimSpace(rule), "=")
+		switch rule {
+		case "required":
+			required = true
+		case "email":
+			schema.Format = "email"
+		case "oneof":
+			schema.Enum = strings.Fields(arg)
+		case "min", "max":
+			limit, err := strconv.Atoi(arg)
+			if err != nil {
+				continue // validateRequest panics on these
+			}
+			switch {
+			case schema.Type == "integer" || schema.Type == "number":
+				schema.Minimum, schema.Maximum = setLimit(rule, limit, schema.Minimum, schema.Maximum)
+			case schema.Type == "string":
+				schema.MinLength, schema.MaxLength = setLimit(rule, limit, schema.MinLength, schema.MaxLength)
+			case schema.Type == "array":
+				schema.MinItems, schema.MaxItems = setLimit(rule, limit, schema.MinItems, schema.MaxItems)
+			}
+		}
+	}
+	return required
+}
+
+func setLimit(rule string, limit int, lower, upper *int) (*int, *int) {
+	if rule == "min" {
+		return &limit, upper
+	}
+	return lower, &limit
+}
+
+// schemaName is the name of a struct in the schemas, its Go name starting with a capital
+func schemaName(t reflect.Type) string {
+	name := []rune(t.Name())
+	name[0] = unicode.ToUpper(name[0])
+	return string(name)
+}
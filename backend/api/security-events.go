@@ -0,0 +1,163 @@
+package api
+
+import (
+	"backend/db"
+	"encoding/json"
+	"log"
+	"net/http"
+	"strings"
+	"time"
+)
+
+// Types of security events. They are stored, so don't rename them.
+const (
+	EventLoginSucceeded             = "login_succeeded"
+	EventLoginFailed                = "login_failed"
+	EventLoginThrottled             = "login_throttled"
+	EventPasswordCheckFailed        = "password_check_failed" // wrong password when confirming a sensitive change
+	EventLogout                     = "logout"
+	EventTokenRefreshed             = "token_refreshed"
+	EventPersonalAccessTokenUsed    = "personal_access_token_used"
+	EventPersonalAccessTokenCreated = "personal_access_token_created"
+	EventPersonalAccessTokenRevoked = "personal_access_token_revoked"
+	EventSessionRevoked             = "session_revoked"
+	EventOtherSessionsRevoked       = "other_sessions_revoked"
+	EventPasswordChanged            = "password_changed"
+	EventPasswordResetRequested     = "password_reset_requested"
+	EventPasswordReset              = "password_reset"
+	EventEmailChangeRequested       = "email_change_requested"
+	EventEmailVerified              = "email_verified"
+	EventTwoFactorEnabled           = "2fa_enabled"
+	EventTwoFactorDisabled          = "2fa_disabled"
+	EventAccountDeactivated         = "account_deactivated"
+	EventAccountDeletionScheduled   = "account_deletion_scheduled"
+	EventFollowRequestAccepted      = "follow_request_accepted"
+	EventFollowRequestDeclined      = "follow_request_declined"
+	EventUserSuspended              = "user_suspended"
+	EventUserUnsuspended            = "user_unsuspended"
+	EventRoleChanged                = "role_changed"
+	EventOIDCIdentityLinked         = "oidc_identity_linked"
+)
+
+const (
+	defaultSecurityEventsLimit = 50
+	maxSecurityEventsLimit     = 200
+	maxUserAgentLength         = 512
+)
+
+// recordSecurityEvent appends an event to the audit log with the IP and user agent of the request.
+// userID is 0 when the account is unknown. Failing to record is logged but doesn't fail the request.
+func recordSecurityEvent(r *http.Request, userID int, eventType string, details map[string]interface{}) {
+	recordSecurityEventIn(&db.Connection, r, userID, eventType, details)
+}
+
+// recordSecurityEventIn records an event in database, which is a transaction in atomic batches
+func recordSecurityEventIn(database *db.Database, r *http.Request, userID int, eventType string, details map[string]interface{}) {
+	var detailsJSON []byte
+	if len(details) > 0 {
+		var err error
+		if detailsJSON, err = json.Marshal(details); err != nil {
+			log.Printf("Error marshalling details of security event %s: %v", eventType, err)
+		}
+	}
+
+	userAgent := r.UserAgent()
+	if len(userAgent) > maxUserAgentLength {
+		userAgent = userAgent[:maxUserAgentLength]
+	}
+
+	if err := database.InsertSecurityEvent(userID, eventType, clientIP(r), userAgent, detailsJSON); err != nil {
+		log.Printf("Failed to record security event %s of user %d: %v", eventType, userID, err)
+	}
+}
+
+// recordEvent records a security event caused by this request
+func (ar *apiRequest) recordEvent(userID int, eventType string, details map[string]interface{}) {
+	recordSecurityEventIn(ar.db, ar.httpRequest, userID, eventType, details)
+}
+
+// getSecurityEvents returns the authenticated user's own security history, newest first
+func (ar *apiRequest) getSecurityEvents(request *GetSecurityEventsRequest) {
+	ar.respondWithSecurityEvents(db.SecurityEventFilter{
+		UserID:   ar.claims.Id,
+		BeforeID: request.BeforeID,
+		Limit:    request.Limit,
+	})
+}
+
+// querySecurityEvents lets admins search the security events of every user
+func (ar *apiRequest) querySecurityEvents(request *QuerySecurityEventsRequest) {
+	filter := db.SecurityEventFilter{
+		UserID:   request.UserID,
+		BeforeID: request.BeforeID,
+		Limit:    request.Limit,
+	}
+	for _, t := range request.Types {
+		if t = strings.TrimSpace(t); t != "" {
+			filter.Types = append(filter.Types, t)
+		}
+	}
+
+	var err error
+	if filter.Since, err = parseEventTime(request.Since); err != nil {
+		ar.setError(http.StatusBadRequest, "since must be an RFC 3339 time")
+		return
+	}
+	if filter.Until, err = parseEventTime(request.Until); err != nil {
+		ar.setError(http.StatusBadRequest, "until must be an RFC 3339 time")
+		return
+	}
+
+	log.Printf("User %d queried security events (user %d, types %v)", ar.claims.Id, request.UserID, filter.Types)
+	ar.respondWithSecurityEvents(filter)
+}
+
+func (ar *apiRequest) respondWithSecurityEvents(filter db.SecurityEventFilter) {
+	if filter.Limit <= 0 {
+		filter.Limit = defaultSecurityEventsLimit
+	}
+	filter.Limit = min(filter.Limit, maxSecurityEventsLimit)
+	if filter.BeforeID < 0 {
+		filter.BeforeID = 0
+	}
+
+	events, err := ar.db.QuerySecurityEvents(filter)
+	if err != nil {
+		log.Printf("Failed to query security events: %v", err)
+		ar.setError(http.StatusInternalServerError, "Internal server error")
+		return
+	}
+
+	response := map[string]interface{}{
+		"events": events,
+	}
+
+	responseJSON, err := json.Marshal(response)
+	if err != nil {
+		log.Printf("Error marshalling security events response: %v", err)
+		ar.setError(http.StatusInternalServerError, "Internal server error")
+		return
+	}
+
+	ar.response = string(responseJSON)
+}
+
+// parseEventTime parses an optional RFC 3339 time, "" is the zero time
+func parseEventTime(value string) (time.Time, error) {
+	if value == "" {
+		return time.Time{}, nil
+	}
+	return time.Parse(time.RFC3339, value)
+}
+
+// CleanupSecurityEvents removes security events past retention
+func CleanupSecurityEvents() {
+	count, err := db.Connection.DeleteExpiredSecurityEvents()
+	if err != nil {
+		log.Printf("Error cleaning up security events: %v", err)
+		return
+	}
+	if count > 0 {
+		log.Printf("Cleaned up %d expired security events", count)
+	}
+}
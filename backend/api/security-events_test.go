@@ -0,0 +1,116 @@
+package api
+
+import (
+	"encoding/json"
+	"net/http"
+	"testing"
+	"time"
+)
+
+func eventTypes(events securityEventsResponse) []string {
+	var types []string
+	for _, event := range events.Events {
+		types = append(types, event.Type)
+	}
+	return types
+}
+
+func TestSecurityEventsAreRecorded(t *testing.T) {
+	userID, email := newUser(t)
+
+	w := callAction(t, map[string]string{"action": "login", "email": email, "password": "wrong"}, nil)
+	decodeResponse[errorResponse](t, w, http.StatusUnauthorized)
+
+	user, session := login(t, email)
+	change := map[string]string{"action": "change_password", "currentPassword": "123", "newPassword": newPassword}
+	decodeResponse[messageResponse](t, callAction(t, change, sessionHeader(user), session), http.StatusOK)
+	decodeResponse[messageResponse](t, callAction(t, map[string]string{"action": "logout"}, sessionHeader(user), session), http.StatusOK)
+
+	w = callAction(t, map[string]string{"action": "login", "email": email, "password": newPassword}, nil)
+	user = decodeResponse[loginResponse](t, w, http.StatusOK)
+
+	// Another user's events are not part of the history
+	login(t, "user1@test.dev")
+
+	w = callAction(t, map[string]string{"action": "get_security_events"}, bearerHeader(user))
+	events := decodeResponse[securityEventsResponse](t, w, http.StatusOK)
+
+	want := []string{EventLoginSucceeded, EventLogout, EventPasswordChanged, EventLoginSucceeded, EventLoginFailed}
+	if got := eventTypes(events); len(got) != len(want) {
+		t.Fatalf("Expected the events %v, got %v", want, got)
+	}
+	for i, event := range events.Events {
+		if event.Type != want[i] || event.UserID != userID {
+			t.Errorf("Expected event %d to be %s of user %d, got %s of user %d", i, want[i], userID, event.Type, event.UserID)
+		}
+		if event.IP != "192.0.2.1" {
+			t.Errorf("Expected event %s to record the client IP, got %q", event.Type, event.IP)
+		}
+	}
+
+	var failed map[string]any
+	if err := json.Unmarshal(events.Events[len(events.Events)-1].Details, &failed); err != nil || failed["reason"] != "wrong_password" {
+		t.Errorf("Expected the failed login to record the reason, got %s", events.Events[len(events.Events)-1].Details)
+	}
+
+	// Paging continues with the events older than the last one
+	page := map[string]any{"action": "get_security_events", "limit": 2}
+	first := decodeResponse[securityEventsResponse](t, callAction(t, page, bearerHeader(user)), http.StatusOK)
+	page["beforeId"] = first.Events[1].ID
+	second := decodeResponse[securityEventsResponse](t, callAction(t, page, bearerHeader(user)), http.StatusOK)
+	if got := eventTypes(second); len(got) != 2 || got[0] != EventPasswordChanged || got[1] != EventLoginSucceeded {
+		t.Errorf("Expected the second page to continue the history, got %v", got)
+	}
+}
+
+func TestQuerySecurityEvents(t *testing.T) {
+	userID, email := newUser(t)
+	user, _ := login(t, email)
+	_, admin := userWithRole(t, RoleAdmin)
+
+	w := callAction(t, map[string]string{"action": "login", "email": email, "password": "wrong"}, nil)
+	decodeResponse[errorResponse](t, w, http.StatusUnauthorized)
+	Throttle.Succeeded(email)
+
+	hourAgo := time.Now().Add(-time.Hour).UTC().Format(time.RFC3339)
+	inHour := time.Now().Add(time.Hour).UTC().Format(time.RFC3339)
+
+	tests := []struct {
+		name  string
+		query map[string]any
+		want  []string
+	}{
+		{"user", map[string]any{"userId": userID}, []string{EventLoginFailed, EventLoginSucceeded}},
+		{"type", map[string]any{"userId": userID, "types": []string{EventLoginSucceeded, EventLogout}}, []string{EventLoginSucceeded}},
+		{"since", map[string]any{"userId": userID, "since": hourAgo}, []string{EventLoginFailed, EventLoginSucceeded}},
+		{"since later", map[string]any{"userId": userID, "since": inHour}, nil},
+		{"until earlier", map[string]any{"userId": userID, "until": hourAgo}, nil},
+		{"since and until", map[string]any{"userId": userID, "since": hourAgo, "until": inHour}, []string{EventLoginFailed, EventLoginSucceeded}},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			test.query["action"] = "query_security_events"
+			events := decodeResponse[securityEventsResponse](t, callAction(t, test.query, bearerHeader(admin)), http.StatusOK)
+
+			got := eventTypes(events)
+			if len(got) != len(test.want) {
+				t.Fatalf("Expected the events %v, got %v", test.want, got)
+			}
+			for i := range got {
+				if got[i] != test.want[i] {
+					t.Errorf("Expected the events %v, got %v", test.want, got)
+					break
+				}
+			}
+		})
+	}
+
+	w = callAction(t, map[string]string{"action": "query_security_events", "since": "yesterday"}, bearerHeader(admin))
+	decodeResponse[errorResponse](t, w, http.StatusBadRequest)
+
+	w = callAction(t, map[string]any{"action": "query_security_events", "userId": userID}, bearerHeader(user))
+	if response := decodeResponse[errorResponse](t, w, http.StatusForbidden); response.Code != CodeForbidden {
+		t.Errorf("Expected a user without the permission to get %s, got %s", CodeForbidden, response.Code)
+	}
+}
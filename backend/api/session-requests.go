@@ -0,0 +1,161 @@
+package api
+
+import (
+	"backend/db"
+	"encoding/json"
+	"log"
+	"net/http"
+	"time"
+)
+
+type sessionResponse struct {
+	ID         int       `json:"id"`
+	UserAgent  string    `json:"userAgent"`
+	IP         string    `json:"ip"`
+	CreatedAt  time.Time `json:"createdAt"`
+	LastSeenAt time.Time `json:"lastSeenAt"`
+	ExpiresAt  time.Time `json:"expiresAt"`
+	Current    bool      `json:"current"` // the session this request was made with
+}
+
+// currentSessionRecordID returns the record id of the session the request was made with, or 0
+func (ar *apiRequest) currentSessionRecordID() int {
+	if session, ok := currentSession(ar.httpRequest); ok {
+		return session.recordID
+	}
+	return 0
+}
+
+// listSessions returns the active sessions of the authenticated user
+func (ar *apiRequest) listSessions() {
+	sessions, err := Sessions.ListUserSessions(ar.claims.Id)
+	if err != nil {
+		log.Printf("Failed to list sessions: %v", err)
+		ar.setError(http.StatusInternalServerError, "Failed to list sessions")
+		return
+	}
+
+	currentID := ar.currentSessionRecordID()
+
+	responseSessions := []sessionResponse{}
+	for _, session := range sessions {
+		responseSessions = append(responseSessions, sessionResponse{
+			ID:         session.recordID,
+			UserAgent:  session.UserAgent,
+			IP:         session.IP,
+			CreatedAt:  session.CreatedAt,
+			LastSeenAt: session.LastSeenAt,
+			ExpiresAt:  session.ExpiresAt,
+			Current:    session.recordID == currentID,
+		})
+	}
+
+	response := map[string]interface{}{
+		"sessions": responseSessions,
+	}
+
+	responseJSON, err := json.Marshal(response)
+	if err != nil {
+		log.Printf("Error marshalling sessions response: %v", err)
+		ar.setError(http.StatusInternalServerError, "Internal server error")
+		return
+	}
+
+	ar.response = string(responseJSON)
+}
+
+// revokeSession logs out one session of the authenticated user
+func (ar *apiRequest) revokeSession(request *RevokeSessionRequest) {
+	revoked, err := Sessions.RevokeUserSession(ar.claims.Id, request.SessionID)
+	if err != nil {
+		log.Printf("Failed to revoke session: %v", err)
+		ar.setError(http.StatusInternalServerError, "Failed to revoke session")
+		return
+	}
+
+	if !revoked {
+		ar.setError(http.StatusNotFound, "Session not found")
+		return
+	}
+
+	hub.disconnectSession(request.SessionID)
+	if request.SessionID == ar.currentSessionRecordID() {
+		ClearSessionCookie(ar.httpWriter)
+	}
+	ar.recordEvent(ar.claims.Id, EventSessionRevoked, map[string]interface{}{"sessionId": request.SessionID})
+
+	response := map[string]string{
+		"message": "Session revoked",
+	}
+
+	responseJSON, err := json.Marshal(response)
+	if err != nil {
+		log.Printf("Error marshalling revoke session response: %v", err)
+		ar.setError(http.StatusInternalServerError, "Internal server error")
+		return
+	}
+
+	ar.response = string(responseJSON)
+}
+
+// revokeAllOtherSessions logs out every session of the authenticated user except the current one
+func (ar *apiRequest) revokeAllOtherSessions() {
+	keepRecordID := ar.currentSessionRecordID()
+	count, err := Sessions.RevokeUserSessions(ar.claims.Id, keepRecordID)
+	if err != nil {
+		log.Printf("Failed to revoke sessions: %v", err)
+		ar.setError(http.StatusInternalServerError, "Failed to revoke sessions")
+		return
+	}
+	hub.disconnectSessions(ar.claims.Id, keepRecordID)
+	ar.recordEvent(ar.claims.Id, EventOtherSessionsRevoked, map[string]interface{}{"revoked": count})
+
+	response := map[string]interface{}{
+		"message": "Other sessions revoked",
+		"revoked": count,
+	}
+
+	responseJSON, err := json.Marshal(response)
+	if err != nil {
+		log.Printf("Error marshalling revoke sessions response: %v", err)
+		ar.setError(http.StatusInternalServerError, "Internal server error")
+		return
+	}
+
+	ar.response = string(responseJSON)
+}
+
+// setUserPassword changes a user's password and logs out everywhere except
+// the session with keepRecordID (0 logs out everywhere).
+// Pending email changes are dropped, they may have been requested by whoever knew the old password.
+func setUserPassword(userID int, password string, keepRecordID int) error {
+	if err := db.Connection.UpdateUserPassword(userID, password); err != nil {
+		return err
+	}
+
+	if err := db.Connection.DeleteUserEmailVerificationTokens(userID); err != nil {
+		return err
+	}
+
+	return revokeOtherLogins(userID, keepRecordID)
+}
+
+// revokeOtherLogins revokes every session of a user except the one with keepRecordID, every
+// bearer and refresh token issued to the user so far and every personal access token, and closes
+// their websockets. Tokens an attacker created with a stolen login must not outlive a password reset.
+func revokeOtherLogins(userID int, keepRecordID int) error {
+	if err := db.Connection.InvalidateUserTokens(userID); err != nil {
+		return err
+	}
+
+	if _, err := db.Connection.DeleteUserPersonalAccessTokens(userID); err != nil {
+		return err
+	}
+
+	if _, err := Sessions.RevokeUserSessions(userID, keepRecordID); err != nil {
+		return err
+	}
+
+	hub.disconnectLogins(userID, keepRecordID)
+	return nil
+}
@@ -0,0 +1,137 @@
+package api
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+// device is a login of a user from one browser
+type device struct {
+	user    loginResponse
+	session *http.Cookie
+}
+
+// loginFrom logs a user in with the given user agent
+func loginFrom(t *testing.T, email string, userAgent string) device {
+	t.Helper()
+
+	w := callAction(t, map[string]string{"action": "login", "email": email, "password": "123"}, http.Header{"User-Agent": {userAgent}})
+	user := decodeResponse[loginResponse](t, w, http.StatusOK)
+	return device{user, responseCookie(w, "session_id")}
+}
+
+func (d device) call(t *testing.T, body any) *httptest.ResponseRecorder {
+	t.Helper()
+	return callAction(t, body, sessionHeader(d.user), d.session)
+}
+
+func (d device) sessions(t *testing.T) []sessionResponse {
+	t.Helper()
+	return decodeResponse[sessionsResponse](t, d.call(t, map[string]string{"action": "list_sessions"}), http.StatusOK).Sessions
+}
+
+// loggedOut reports whether a device's session no longer works
+func (d device) loggedOut(t *testing.T) bool {
+	t.Helper()
+	return d.call(t, map[string]string{"action": "list_sessions"}).Code == http.StatusUnauthorized
+}
+
+func TestListSessions(t *testing.T) {
+	_, email := newUser(t)
+	laptop := loginFrom(t, email, "Laptop Browser")
+	loginFrom(t, email, "Phone Browser")
+
+	sessions := laptop.sessions(t)
+	if len(sessions) != 2 {
+		t.Fatalf("Expected 2 sessions, got %+v", sessions)
+	}
+
+	agents := map[string]bool{}
+	for _, session := range sessions {
+		agents[session.UserAgent] = session.Current
+		if session.IP != "192.0.2.1" || session.ID == 0 || session.LastSeenAt.IsZero() || !session.ExpiresAt.After(session.CreatedAt) {
+			t.Errorf("Expected the device, times and id of the session, got %+v", session)
+		}
+	}
+	if current, ok := agents["Laptop Browser"]; !ok || !current {
+		t.Errorf("Expected the laptop session to be the current one, got %v", agents)
+	}
+	if current, ok := agents["Phone Browser"]; !ok || current {
+		t.Errorf("Expected the phone session to be listed as another one, got %v", agents)
+	}
+}
+
+func TestRevokeSession(t *testing.T) {
+	_, email := newUser(t)
+	laptop := loginFrom(t, email, "Laptop Browser")
+	phone := loginFrom(t, email, "Phone Browser")
+
+	var phoneID int
+	for _, session := range laptop.sessions(t) {
+		if !session.Current {
+			phoneID = session.ID
+		}
+	}
+
+	revoke := map[string]any{"action": "revoke_session", "sessionId": phoneID}
+	decodeResponse[messageResponse](t, laptop.call(t, revoke), http.StatusOK)
+	if !phone.loggedOut(t) {
+		t.Error("Expected the revoked session to be logged out")
+	}
+	if laptop.loggedOut(t) {
+		t.Error("Expected the session that revoked the other one to stay logged in")
+	}
+
+	decodeResponse[errorResponse](t, laptop.call(t, revoke), http.StatusNotFound)
+
+	// Sessions of other users can't be revoked
+	other := loginFrom(t, "user2@test.dev", "Other Browser")
+	var otherID int
+	for _, session := range other.sessions(t) {
+		if session.Current {
+			otherID = session.ID
+		}
+	}
+	decodeResponse[errorResponse](t, laptop.call(t, map[string]any{"action": "revoke_session", "sessionId": otherID}), http.StatusNotFound)
+	if other.loggedOut(t) {
+		t.Error("Expected the session of another user to stay logged in")
+	}
+}
+
+func TestRevokeAllOtherSessions(t *testing.T) {
+	_, email := newUser(t)
+	laptop := loginFrom(t, email, "Laptop Browser")
+	phone := loginFrom(t, email, "Phone Browser")
+	tablet := loginFrom(t, email, "Tablet Browser")
+
+	w := laptop.call(t, map[string]string{"action": "revoke_all_other_sessions"})
+	if response := decodeResponse[revokedSessionsResponse](t, w, http.StatusOK); response.Revoked != 2 {
+		t.Errorf("Expected 2 revoked sessions, got %d", response.Revoked)
+	}
+
+	if !phone.loggedOut(t) || !tablet.loggedOut(t) {
+		t.Error("Expected the other sessions to be logged out")
+	}
+	if sessions := laptop.sessions(t); len(sessions) != 1 || !sessions[0].Current {
+		t.Errorf("Expected only the current session to be left, got %+v", sessions)
+	}
+}
+
+func TestChangePasswordRevokesOtherSessions(t *testing.T) {
+	_, email := newUser(t)
+	laptop := loginFrom(t, email, "Laptop Browser")
+	phone := loginFrom(t, email, "Phone Browser")
+
+	change := map[string]string{"action": "change_password", "currentPassword": "123", "newPassword": "glacier-umbrella-tandem"}
+	if w := laptop.call(t, change); w.Code != http.StatusOK {
+		t.Fatalf("Expected the password to be changed, got %d: %s", w.Code, w.Body.String())
+	}
+
+	if !phone.loggedOut(t) {
+		t.Error("Expected the other session to be logged out by the password change")
+	}
+	if laptop.loggedOut(t) {
+		t.Error("Expected the session that changed the password to stay logged in")
+	}
+}
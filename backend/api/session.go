@@ -1,9 +1,15 @@
 package api
 
 import (
+	"backend/db"
 	"crypto/rand"
+	"crypto/sha256"
+	"database/sql"
 	"encoding/hex"
+	"encoding/json"
+	"fmt"
 	"log"
+	"net"
 	"net/http"
 	"sync"
 	"time"
@@ -11,23 +17,53 @@ import (
 
 // Session represents a user session
 type Session struct {
-	ID        string    `json:"id"`
-	UserID    int       `json:"user_id"`
-	Email     string    `json:"email"`
-	CreatedAt time.Time `json:"created_at"`
-	ExpiresAt time.Time `json:"expires_at"`
+	ID         string    `json:"id"`
+	UserID     int       `json:"user_id"`
+	Email      string    `json:"email"`
+	UserAgent  string    `json:"user_agent"`
+	IP         string    `json:"ip"`
+	CreatedAt  time.Time `json:"created_at"`
+	ExpiresAt  time.Time `json:"expires_at"`
+	LastSeenAt time.Time `json:"last_seen_at"`
+
+	recordID int // row id in the sessions table, used to list and revoke sessions
+}
+
+// sessionData is the part of a session stored in the data column of the sessions table
+type sessionData struct {
+	Email string `json:"email"`
+}
+
+// cachedSession is a session held in memory along with the time it was loaded
+type cachedSession struct {
+	session  *Session
+	cachedAt time.Time
 }
 
-// SessionManager manages user sessions
+const (
+	sessionLifetime    = 24 * time.Hour
+	sessionCacheTTL    = 5 * time.Minute // how long a cached session is trusted before re-reading the database
+	sessionTouchPeriod = time.Minute     // how often last seen is written for an active session
+)
+
+// SessionManager manages user sessions. Sessions are stored in the database,
+// with an optional in-memory cache in front of it keyed by the session ID hash.
 type SessionManager struct {
-	sessions map[string]*Session
-	mutex    sync.RWMutex
+	cache        map[string]*cachedSession
+	cacheEnabled bool
+	mutex        sync.RWMutex
 }
 
-var Sessions = &SessionManager{
-	sessions: make(map[string]*Session),
+// NewSessionManager creates a session manager, optionally caching sessions in memory
+func NewSessionManager(cacheEnabled bool) *SessionManager {
+	return &SessionManager{
+		cache:        make(map[string]*cachedSession),
+		cacheEnabled: cacheEnabled,
+	}
 }
 
+var Sessions = NewSessionManager(true)
+
 // GenerateSessionID creates a new random session ID
 func GenerateSessionID() string {
 	bytes := make([]byte, 32)
@@ -38,64 +74,254 @@ func GenerateSessionID() string {
 	return hex.EncodeToString(bytes)
 }
 
-// CreateSession creates a new session for a user
-func (sm *SessionManager) CreateSession(userID int, email string) *Session {
-	sm.mutex.Lock()
-	defer sm.mutex.Unlock()
+// hashSessionID returns the hash under which a session ID is stored in the database
+func hashSessionID(sessionID string) string {
+	sum := sha256.Sum256([]byte(sessionID))
+	return hex.EncodeToString(sum[:])
+}
+
+// clientIP returns the address the request came from, without the port
+func clientIP(r *http.Request) string {
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		return r.RemoteAddr
+	}
+	return host
+}
 
+// CreateSession creates a new session for a user, recording the device it was created from
+func (sm *SessionManager) CreateSession(userID int, email string, r *http.Request) (*Session, error) {
 	sessionID := GenerateSessionID()
+	if sessionID == "" {
+		return nil, fmt.Errorf("failed to generate session ID")
+	}
+
+	data, err := json.Marshal(sessionData{Email: email})
+	if err != nil {
+		return nil, fmt.Errorf("failed to marshal session data: %w", err)
+	}
+
+	now := time.Now()
 	session := &Session{
-		ID:        sessionID,
-		UserID:    userID,
-		Email:     email,
-		CreatedAt: time.Now(),
-		ExpiresAt: time.Now().Add(24 * time.Hour), // Session expires in 24 hours
+		ID:         sessionID,
+		UserID:     userID,
+		Email:      email,
+		UserAgent:  r.UserAgent(),
+		IP:         clientIP(r),
+		CreatedAt:  now,
+		ExpiresAt:  now.Add(sessionLifetime),
+		LastSeenAt: now,
+	}
+
+	recordID, err := db.Connection.CreateSession(hashSessionID(sessionID), userID, string(data), session.UserAgent, session.IP, session.ExpiresAt)
+	if err != nil {
+		return nil, err
 	}
+	session.recordID = recordID
 
-	sm.sessions[sessionID] = session
-	log.Printf("Created session %s for user %d", sessionID, userID)
-	return session
+	sm.cacheSession(session)
+	log.Printf("Created session for user %d", userID)
+	return session, nil
 }
 
 // GetSession retrieves a session by ID
 func (sm *SessionManager) GetSession(sessionID string) (*Session, bool) {
-	sm.mutex.RLock()
-	defer sm.mutex.RUnlock()
+	if sessionID == "" {
+		return nil, false
+	}
 
-	session, exists := sm.sessions[sessionID]
-	if !exists {
+	tokenHash := hashSessionID(sessionID)
+
+	if session, ok := sm.cachedSession(tokenHash); ok {
+		return session, true
+	}
+
+	// Expiry is enforced by the query, expired sessions are never returned
+	record, err := db.Connection.FetchSession(tokenHash)
+	if err != nil {
+		if err != sql.ErrNoRows {
+			log.Printf("Error fetching session: %v", err)
+		}
+		sm.uncacheSession(tokenHash)
 		return nil, false
 	}
 
-	// Check if session has expired
-	if time.Now().After(session.ExpiresAt) {
-		// Session expired, remove it
-		delete(sm.sessions, sessionID)
+	session, err := sessionFromRecord(sessionID, record)
+	if err != nil {
+		log.Printf("Error decoding session data: %v", err)
 		return nil, false
 	}
 
+	sm.cacheSession(session)
 	return session, true
 }
 
+// sessionFromRecord builds a session from its database row, sessionID may be empty
+// when the session is only listed and not used for authentication
+func sessionFromRecord(sessionID string, record *db.Session) (*Session, error) {
+	var data sessionData
+	if err := json.Unmarshal([]byte(record.Data), &data); err != nil {
+		return nil, err
+	}
+
+	return &Session{
+		ID:         sessionID,
+		UserID:     record.UserID,
+		Email:      data.Email,
+		UserAgent:  record.UserAgent,
+		IP:         record.IP,
+		CreatedAt:  record.CreatedAt,
+		ExpiresAt:  record.ExpiresAt,
+		LastSeenAt: record.LastSeenAt,
+		recordID:   record.ID,
+	}, nil
+}
+
+// Touch records that a session is in use, writing to the database at most once per sessionTouchPeriod
+func (sm *SessionManager) Touch(session *Session) {
+	sm.mutex.Lock()
+	now := time.Now()
+	if now.Sub(session.LastSeenAt) < sessionTouchPeriod {
+		sm.mutex.Unlock()
+		return
+	}
+	session.LastSeenAt = now
+	sm.mutex.Unlock()
+
+	if err := db.Connection.TouchSession(session.recordID); err != nil {
+		log.Printf("Error updating session last seen: %v", err)
+	}
+}
+
+// ListUserSessions returns every active session of a user
+func (sm *SessionManager) ListUserSessions(userID int) ([]*Session, error) {
+	records, err := db.Connection.FetchUserSessions(userID)
+	if err != nil {
+		return nil, err
+	}
+
+	sessions := make([]*Session, 0, len(records))
+	for i := range records {
+		session, err := sessionFromRecord("", &records[i])
+		if err != nil {
+			log.Printf("Error decoding session data: %v", err)
+			continue
+		}
+		sessions = append(sessions, session)
+	}
+
+	return sessions, nil
+}
+
 // DeleteSession removes a session
 func (sm *SessionManager) DeleteSession(sessionID string) {
-	sm.mutex.Lock()
-	defer sm.mutex.Unlock()
+	tokenHash := hashSessionID(sessionID)
+	sm.uncacheSession(tokenHash)
 
-	delete(sm.sessions, sessionID)
-	log.Printf("Deleted session %s", sessionID)
+	if err := db.Connection.DeleteSession(tokenHash); err != nil {
+		log.Printf("Error deleting session: %v", err)
+		return
+	}
+	log.Printf("Deleted session")
+}
+
+// RevokeUserSession removes one session of a user by its record ID.
+// Returns false if the user has no such session.
+func (sm *SessionManager) RevokeUserSession(userID int, recordID int) (bool, error) {
+	deleted, err := db.Connection.DeleteUserSession(userID, recordID)
+	if err != nil {
+		return false, err
+	}
+
+	sm.uncacheWhere(func(s *Session) bool { return s.recordID == recordID })
+	return deleted, nil
+}
+
+// RevokeUserSessions removes every session of a user except the one with exceptRecordID
+// (0 removes them all), e.g. after a password change
+func (sm *SessionManager) RevokeUserSessions(userID int, exceptRecordID int) (int64, error) {
+	count, err := db.Connection.DeleteUserSessions(userID, exceptRecordID)
+	if err != nil {
+		return 0, err
+	}
+
+	sm.uncacheWhere(func(s *Session) bool { return s.UserID == userID && s.recordID != exceptRecordID })
+	log.Printf("Revoked %d sessions of user %d", count, userID)
+	return count, nil
 }
 
 // CleanupExpiredSessions removes expired sessions
 func (sm *SessionManager) CleanupExpiredSessions() {
+	now := time.Now()
+	sm.uncacheWhere(func(s *Session) bool { return now.After(s.ExpiresAt) })
+
+	count, err := db.Connection.DeleteExpiredSessions()
+	if err != nil {
+		log.Printf("Error cleaning up expired sessions: %v", err)
+		return
+	}
+	if count > 0 {
+		log.Printf("Cleaned up %d expired sessions", count)
+	}
+}
+
+// cachedSession returns a session from the in-memory cache if it is still fresh
+func (sm *SessionManager) cachedSession(tokenHash string) (*Session, bool) {
+	if !sm.cacheEnabled {
+		return nil, false
+	}
+
+	sm.mutex.RLock()
+	entry, exists := sm.cache[tokenHash]
+	sm.mutex.RUnlock()
+
+	if !exists {
+		return nil, false
+	}
+
+	now := time.Now()
+	if now.After(entry.session.ExpiresAt) || now.Sub(entry.cachedAt) > sessionCacheTTL {
+		sm.uncacheSession(tokenHash)
+		return nil, false
+	}
+
+	return entry.session, true
+}
+
+func (sm *SessionManager) cacheSession(session *Session) {
+	if !sm.cacheEnabled {
+		return
+	}
+
 	sm.mutex.Lock()
 	defer sm.mutex.Unlock()
 
-	now := time.Now()
-	for sessionID, session := range sm.sessions {
-		if now.After(session.ExpiresAt) {
-			delete(sm.sessions, sessionID)
-			log.Printf("Cleaned up expired session %s", sessionID)
+	sm.cache[hashSessionID(session.ID)] = &cachedSession{session: session, cachedAt: time.Now()}
+}
+
+func (sm *SessionManager) uncacheSession(tokenHash string) {
+	sm.mutex.Lock()
+	defer sm.mutex.Unlock()
+
+	delete(sm.cache, tokenHash)
+}
+
+// cachedCount is how many sessions are cached, some may have expired since
+func (sm *SessionManager) cachedCount() int {
+	sm.mutex.RLock()
+	defer sm.mutex.RUnlock()
+
+	return len(sm.cache)
+}
+
+// uncacheWhere drops every cached session matching the predicate
+func (sm *SessionManager) uncacheWhere(match func(*Session) bool) {
+	sm.mutex.Lock()
+	defer sm.mutex.Unlock()
+
+	for tokenHash, entry := range sm.cache {
+		if match(entry.session) {
+			delete(sm.cache, tokenHash)
 		}
 	}
 }
@@ -109,10 +335,10 @@ func SetSessionCookie(w http.ResponseWriter, sessionID string) {
 		HttpOnly: true,
 		Secure:   false, // Set to true in production with HTTPS
 		SameSite: http.SameSiteLaxMode,
-		Expires:  time.Now().Add(24 * time.Hour),
+		Expires:  time.Now().Add(sessionLifetime),
 	}
 	http.SetCookie(w, cookie)
-	log.Printf("Set session cookie: %s", sessionID)
+	log.Printf("Set session cookie")
 }
 
 // ClearSessionCookie clears the session cookie
@@ -139,6 +365,15 @@ func GetSessionFromRequest(r *http.Request) (string, error) {
 	return cookie.Value, nil
 }
 
+// currentSession returns the session the request was made with, if any
+func currentSession(r *http.Request) (*Session, bool) {
+	sessionID, err := GetSessionFromRequest(r)
+	if err != nil {
+		return nil, false
+	}
+	return Sessions.GetSession(sessionID)
+}
+
 // ValidateSession validates a session and returns user claims
 func ValidateSession(r *http.Request) (*Claims, error) {
 	sessionID, err := GetSessionFromRequest(r)
@@ -148,26 +383,53 @@ func ValidateSession(r *http.Request) (*Claims, error) {
 
 	session, exists := Sessions.GetSession(sessionID)
 	if !exists {
-		return nil, err
+		return nil, fmt.Errorf("session not found or expired")
 	}
 
+	Sessions.Touch(session)
+
 	return &Claims{
 		Id:    session.UserID,
 		Email: session.Email,
 	}, nil
 }
 
-// StartSessionCleanup starts a goroutine to periodically clean up expired sessions
+// StartSessionCleanup starts a goroutine for the hourly housekeeping: it cleans up expired sessions,
+// token revocations, login attempts, one-time tokens, unfinished OIDC logins and old security events,
+// and deletes accounts whose grace period is over
 func StartSessionCleanup() {
+	stopCleanup = make(chan struct{})
+
 	go func() {
 		ticker := time.NewTicker(1 * time.Hour) // Clean up every hour
 		defer ticker.Stop()
 
 		for {
 			select {
+			case <-stopCleanup:
+				return
 			case <-ticker.C:
 				Sessions.CleanupExpiredSessions()
+				CleanupRevokedTokens()
+				Throttle.Cleanup()
+				CleanupPasswordResetTokens()
+				CleanupEmailVerificationTokens()
+				CleanupOIDCLoginStates()
+				DeleteScheduledAccounts()
+				CleanupSecurityEvents()
 			}
 		}
 	}()
 }
+
+// stopCleanup ends the housekeeping goroutine when closed
+var stopCleanup chan struct{}
+
+// StopSessionCleanup stops the housekeeping goroutine. A cleanup already running finishes,
+// its writes are waited for with db.Database.WaitForWrites.
+func StopSessionCleanup() {
+	if stopCleanup != nil {
+		close(stopCleanup)
+		stopCleanup = nil
+	}
+}
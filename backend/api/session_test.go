@@ -0,0 +1,68 @@
+package api
+
+import (
+	"backend/db"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+// restartSessions replaces the session manager with a new one for the rest of the test,
+// as a server restart would
+func restartSessions(t *testing.T) {
+	previous := Sessions
+	Sessions = NewSessionManager(true)
+	t.Cleanup(func() { Sessions = previous })
+}
+
+func TestSessionSurvivesRestart(t *testing.T) {
+	user, session := login(t, "user2@test.dev")
+	restartSessions(t)
+
+	w := callAction(t, map[string]string{"action": "list_sessions"}, sessionHeader(user), session)
+	decodeResponse[sessionsResponse](t, w, http.StatusOK)
+
+	restored, ok := Sessions.GetSession(session.Value)
+	if !ok {
+		t.Fatal("Expected the session to be loaded from the database")
+	}
+	if restored.UserID != user.User.Id || restored.Email != "user2@test.dev" || restored.recordID == 0 {
+		t.Errorf("Expected the session of user %d with its record, got %+v", user.User.Id, restored)
+	}
+}
+
+func TestSessionDeletedByAnotherManager(t *testing.T) {
+	uncached := NewSessionManager(false)
+	r := httptest.NewRequest(http.MethodPost, "/api", nil)
+
+	session, err := Sessions.CreateSession(2, "user2@test.dev", r)
+	if err != nil {
+		t.Fatalf("Failed to create session: %v", err)
+	}
+	if _, ok := uncached.GetSession(session.ID); !ok {
+		t.Fatal("Expected the session to be found by a manager that didn't create it")
+	}
+
+	Sessions.DeleteSession(session.ID)
+	if _, ok := uncached.GetSession(session.ID); ok {
+		t.Error("Expected the deleted session to be gone for every manager")
+	}
+}
+
+func TestExpiredSessionIsRefused(t *testing.T) {
+	sessionID := GenerateSessionID()
+	if _, err := db.Connection.CreateSession(hashSessionID(sessionID), 2, `{"email":"user2@test.dev"}`, "test", "192.0.2.1",
+		time.Now().Add(-time.Minute)); err != nil {
+		t.Fatalf("Failed to create session: %v", err)
+	}
+
+	if _, ok := NewSessionManager(false).GetSession(sessionID); ok {
+		t.Error("Expected an expired session to be refused")
+	}
+
+	Sessions.CleanupExpiredSessions()
+	if count, err := db.Connection.DeleteExpiredSessions(); err != nil || count != 0 {
+		t.Errorf("Expected the cleanup to delete every expired session, %d were left (%v)", count, err)
+	}
+}
@@ -2,80 +2,312 @@ package api
 
 import (
 	"crypto/ed25519"
+	"crypto/rand"
 	"encoding/hex"
+	"encoding/json"
 	"fmt"
 	"log"
 	"os"
+	"sync"
+	"time"
 )
 
+const keyringFile = "signing-keys.json"
+
+// The single key pair of servers from before the keyring. Tokens it signed have no key id.
 const (
-	pubKeyFile  = "id_ed25519.pub"
-	privKeyFile = "id_ed25519.pem"
+	legacyKeyFile = "id_ed25519.pem"
+	legacyKeyID   = "legacy"
 )
 
-var publicKey ed25519.PublicKey
-var privateKey ed25519.PrivateKey
+// DefaultKeyGracePeriod keeps a retired key verifying until every refresh token it signed has expired
+const DefaultKeyGracePeriod = refreshTokenLifetime
+
+// signingKey is one Ed25519 key in the keyring
+type signingKey struct {
+	ID          string             `json:"id"`
+	PrivateKey  ed25519.PrivateKey `json:"private_key"`
+	CreatedAt   time.Time          `json:"created_at"`
+	RetiredAt   *time.Time         `json:"retired_at,omitempty"`
+	VerifyUntil *time.Time         `json:"verify_until,omitempty"` // retired keys stop verifying after this
+}
+
+// keyring holds every signing key, new tokens are signed with the active one
+type keyring struct {
+	Active string       `json:"active"`
+	Keys   []signingKey `json:"keys"`
+}
+
+var (
+	keys        keyring
+	keysModTime time.Time
+	keysPath    string
+	keysMutex   sync.RWMutex
+)
 
 func GenOrLoadKey(dataDir string) {
-	path := dataDir + "/"
+	keysPath = dataDir + "/" + keyringFile
 
-	if err := loadKeys(path); err == nil {
-		log.Println("Key pair loaded from disk.")
+	if err := ReloadKeys(); err == nil {
+		log.Println("Signing keys loaded from disk.")
 		return
+	} else if !os.IsNotExist(err) {
+		log.Fatalf("Error loading signing keys: %v", err)
+	}
+
+	if err := importLegacyKey(dataDir); err != nil {
+		log.Fatalf("Error importing legacy signing key: %v", err)
 	}
 
-	log.Println("Generating new key pair...")
-	if err := generateKeys(path); err != nil {
+	log.Println("Generating new signing key...")
+	if _, err := RotateKeys(dataDir, DefaultKeyGracePeriod); err != nil {
 		log.Fatalf("Error generating keys: %v", err)
 	}
-	log.Println("Key pair generated and saved.")
+	if err := ReloadKeys(); err != nil {
+		log.Fatalf("Error loading signing keys: %v", err)
+	}
+	log.Println("Signing key generated and saved.")
 }
 
-func generateKeys(path string) error {
-	pub, priv, err := ed25519.GenerateKey(nil)
+// importLegacyKey starts the keyring with the key of a server from before the keyring, if there
+// is one, so the tokens it signed keep working. RotateKeys then retires it like any active key.
+func importLegacyKey(dataDir string) error {
+	info, err := os.Stat(dataDir + "/" + legacyKeyFile)
+	if os.IsNotExist(err) {
+		return nil
+	} else if err != nil {
+		return err
+	}
+
+	privateKey, err := os.ReadFile(dataDir + "/" + legacyKeyFile)
 	if err != nil {
-		log.Fatalf("Error generating key: %v", err)
+		return err
+	}
+	if len(privateKey) != ed25519.PrivateKeySize {
+		return fmt.Errorf("invalid private key size in %s", legacyKeyFile)
 	}
-	privateKey = priv
-	publicKey = pub
 
-	if err := os.WriteFile(path+pubKeyFile, publicKey, 0644); err != nil {
-		return fmt.Errorf("error writing public key: %w", err)
+	legacy := signingKey{
+		ID:         legacyKeyID,
+		PrivateKey: privateKey,
+		CreatedAt:  info.ModTime().UTC(),
 	}
-	if err := os.WriteFile(path+privKeyFile, privateKey, 0600); err != nil {
-		return fmt.Errorf("error writing private key: %w", err)
+	if err := writeKeyring(dataDir+"/"+keyringFile, keyring{Active: legacy.ID, Keys: []signingKey{legacy}}); err != nil {
+		return err
 	}
+
+	log.Printf("Imported %s into the keyring, tokens it signed are accepted for %s", legacyKeyFile, DefaultKeyGracePeriod)
 	return nil
 }
 
-func loadKeys(path string) error {
-	privBytes, err := os.ReadFile(path + privKeyFile)
+// RotateKeys generates a new active signing key in dataDir. The previously active key
+// is retired and keeps verifying tokens for the grace period, keys whose grace period
+// is over are dropped. A running server picks up the change through WatchKeys.
+func RotateKeys(dataDir string, grace time.Duration) (string, error) {
+	path := dataDir + "/" + keyringFile
+
+	ring, err := readKeyring(path)
+	if err != nil && !os.IsNotExist(err) {
+		return "", err
+	}
+
+	_, priv, err := ed25519.GenerateKey(nil)
+	if err != nil {
+		return "", fmt.Errorf("error generating key: %w", err)
+	}
+
+	idBytes := make([]byte, 8)
+	if _, err := rand.Read(idBytes); err != nil {
+		return "", fmt.Errorf("error generating key id: %w", err)
+	}
+
+	now := time.Now().UTC()
+	cutoff := now.Add(grace)
+
+	var kept []signingKey
+	for _, key := range ring.Keys {
+		if key.ID == ring.Active {
+			key.RetiredAt = &now
+			key.VerifyUntil = &cutoff
+		}
+		if key.VerifyUntil != nil && !now.Before(*key.VerifyUntil) {
+			continue // past its cutoff, drop it
+		}
+		kept = append(kept, key)
+	}
+
+	newKey := signingKey{
+		ID:         hex.EncodeToString(idBytes),
+		PrivateKey: priv,
+		CreatedAt:  now,
+	}
+
+	ring.Active = newKey.ID
+	ring.Keys = append(kept, newKey)
+
+	if err := writeKeyring(path, ring); err != nil {
+		return "", err
+	}
+
+	return newKey.ID, nil
+}
+
+// ReloadKeys reads the keyring from disk, replacing the keys in memory
+func ReloadKeys() error {
+	info, err := os.Stat(keysPath)
 	if err != nil {
 		return err
 	}
-	pubBytes, err := os.ReadFile(path + pubKeyFile)
+
+	ring, err := readKeyring(keysPath)
 	if err != nil {
 		return err
 	}
 
-	if len(privBytes) != ed25519.PrivateKeySize {
-		return fmt.Errorf("invalid private key size")
+	keysMutex.Lock()
+	defer keysMutex.Unlock()
+
+	keys = ring
+	keysModTime = info.ModTime()
+	return nil
+}
+
+// WatchKeys periodically reloads the keyring when it changes on disk,
+// so keys rotated by the rotate-keys command are used without a restart
+func WatchKeys(interval time.Duration) {
+	go func() {
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+
+		for range ticker.C {
+			info, err := os.Stat(keysPath)
+			if err != nil {
+				log.Printf("Error checking signing keys: %v", err)
+				continue
+			}
+
+			keysMutex.RLock()
+			changed := !info.ModTime().Equal(keysModTime)
+			keysMutex.RUnlock()
+
+			if !changed {
+				continue
+			}
+
+			if err := ReloadKeys(); err != nil {
+				log.Printf("Error reloading signing keys: %v", err)
+				continue
+			}
+			log.Printf("Signing keys reloaded, active key: %s", activeKeyID())
+		}
+	}()
+}
+
+func readKeyring(path string) (keyring, error) {
+	var ring keyring
+
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return ring, err
+	}
+
+	if err := json.Unmarshal(data, &ring); err != nil {
+		return ring, fmt.Errorf("invalid keyring file: %w", err)
+	}
+
+	for _, key := range ring.Keys {
+		if len(key.PrivateKey) != ed25519.PrivateKeySize {
+			return ring, fmt.Errorf("invalid private key size for key %s", key.ID)
+		}
+	}
+
+	if _, ok := ring.find(ring.Active); !ok {
+		return ring, fmt.Errorf("active key %q not found in keyring", ring.Active)
 	}
-	if len(pubBytes) != ed25519.PublicKeySize {
-		return fmt.Errorf("invalid public key size")
+
+	return ring, nil
+}
+
+// writeKeyring saves the keyring through a temporary file, so readers never see a partial file
+func writeKeyring(path string, ring keyring) error {
+	data, err := json.MarshalIndent(ring, "", "  ")
+	if err != nil {
+		return fmt.Errorf("error encoding keyring: %w", err)
 	}
 
-	privateKey = privBytes
-	publicKey = pubBytes
+	tmp := path + ".tmp"
+	if err := os.WriteFile(tmp, data, 0600); err != nil {
+		return fmt.Errorf("error writing keyring: %w", err)
+	}
+	if err := os.Rename(tmp, path); err != nil {
+		return fmt.Errorf("error writing keyring: %w", err)
+	}
 	return nil
 }
 
-func sign(dataBytes []byte) string {
-	sig := ed25519.Sign(privateKey, dataBytes)
-	return hex.EncodeToString(sig)
+func (ring keyring) find(id string) (signingKey, bool) {
+	for _, key := range ring.Keys {
+		if key.ID == id {
+			return key, true
+		}
+	}
+	return signingKey{}, false
+}
+
+// legacyCutoff returns when tokens signed before the keyring stop being accepted. They have no
+// expiry or id of their own, so they only work while the imported legacy key is retired and
+// verifying, and never longer than DefaultKeyGracePeriod after it was retired.
+func legacyCutoff() (time.Time, bool) {
+	keysMutex.RLock()
+	key, ok := keys.find(legacyKeyID)
+	keysMutex.RUnlock()
+
+	if !ok || key.RetiredAt == nil || key.VerifyUntil == nil {
+		return time.Time{}, false
+	}
+
+	cutoff := key.RetiredAt.Add(DefaultKeyGracePeriod)
+	if key.VerifyUntil.Before(cutoff) {
+		cutoff = *key.VerifyUntil
+	}
+	return cutoff, true
+}
+
+func activeKeyID() string {
+	keysMutex.RLock()
+	defer keysMutex.RUnlock()
+
+	return keys.Active
+}
+
+// sign signs data with the active key, returning the key id and the signature
+func sign(dataBytes []byte) (string, string) {
+	keysMutex.RLock()
+	defer keysMutex.RUnlock()
+
+	key, _ := keys.find(keys.Active)
+	sig := ed25519.Sign(key.PrivateKey, dataBytes)
+	return key.ID, hex.EncodeToString(sig)
 }
 
-func verify(data string, signature string) bool {
+// verify checks a signature against the key it was made with.
+// Retired keys are accepted until their cutoff.
+func verify(keyID string, data string, signature string) bool {
+	if keyID == "" {
+		keyID = legacyKeyID // signed before the keyring
+	}
+
+	keysMutex.RLock()
+	key, ok := keys.find(keyID)
+	keysMutex.RUnlock()
+
+	if !ok {
+		return false
+	}
+
+	if key.VerifyUntil != nil && !time.Now().Before(*key.VerifyUntil) {
+		return false
+	}
 
 	dataBytes := []byte(data)
 	signatureBytes, err := hex.DecodeString(signature)
@@ -84,5 +316,6 @@ func verify(data string, signature string) bool {
 		return false
 	}
 
+	publicKey := key.PrivateKey.Public().(ed25519.PublicKey)
 	return ed25519.Verify(publicKey, dataBytes, signatureBytes)
 }
@@ -0,0 +1,119 @@
+package api
+
+import (
+	"strings"
+	"testing"
+	"time"
+)
+
+// useKeyring switches to an empty keyring in a new data dir for the test and returns the dir
+func useKeyring(t *testing.T) string {
+	t.Helper()
+
+	dir := t.TempDir()
+
+	keysMutex.Lock()
+	savedKeys, savedPath := keys, keysPath
+	keysPath = dir + "/" + keyringFile
+	keysMutex.Unlock()
+
+	t.Cleanup(func() {
+		keysMutex.Lock()
+		keys, keysPath = savedKeys, savedPath
+		keysMutex.Unlock()
+	})
+	return dir
+}
+
+// rotate rotates the keys in dir and loads them like WatchKeys would
+func rotate(t *testing.T, dir string, grace time.Duration) string {
+	t.Helper()
+
+	keyID, err := RotateKeys(dir, grace)
+	if err != nil {
+		t.Fatalf("Failed to rotate keys: %v", err)
+	}
+	if err := ReloadKeys(); err != nil {
+		t.Fatalf("Failed to reload keys: %v", err)
+	}
+	return keyID
+}
+
+// bearerSignedWith issues an access token and checks which key signed it
+func bearerSignedWith(t *testing.T, keyID string) string {
+	t.Helper()
+
+	token, err := (&Claims{Id: 1, Email: "user1@test.dev"}).GetBearer()
+	if err != nil {
+		t.Fatalf("Failed to issue token: %v", err)
+	}
+
+	var sc signedClaims
+	if err := sc.unmarshal(token); err != nil {
+		t.Fatal(err)
+	}
+	if sc.KeyID != keyID {
+		t.Fatalf("Expected the token to be signed with %s, got %q", keyID, sc.KeyID)
+	}
+	return *token
+}
+
+func TestKeyRotation(t *testing.T) {
+	dir := useKeyring(t)
+	accepted := func(token string) bool {
+		_, err := UnmarshalBearer(&token)
+		if err != nil && !strings.Contains(err.Error(), "signature") {
+			t.Fatalf("Expected only signature errors, got %v", err)
+		}
+		return err == nil
+	}
+
+	first := rotate(t, dir, time.Hour)
+	signedFirst := bearerSignedWith(t, first)
+
+	second := rotate(t, dir, time.Hour)
+	if second == first || activeKeyID() != second {
+		t.Fatalf("Expected a new active key, got %s after %s", activeKeyID(), first)
+	}
+	signedSecond := bearerSignedWith(t, second)
+	if !accepted(signedFirst) {
+		t.Error("Expected a token of the retired key to be accepted during the grace period")
+	}
+
+	// Without a grace period the second key stops verifying right away, the first has an hour left
+	third := rotate(t, dir, 0)
+	bearerSignedWith(t, third)
+	if accepted(signedSecond) {
+		t.Error("Expected a token of a key past its cutoff to be refused")
+	}
+	if !accepted(signedFirst) {
+		t.Error("Expected a token of a key within its grace period to stay accepted")
+	}
+
+	ring, err := readKeyring(keysPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var ids []string
+	for _, key := range ring.Keys {
+		ids = append(ids, key.ID)
+	}
+	if strings.Join(ids, ",") != first+","+third {
+		t.Errorf("Expected the keyring to drop the key past its cutoff, got %v", ids)
+	}
+}
+
+func TestReloadKeysRefusesBrokenKeyring(t *testing.T) {
+	dir := useKeyring(t)
+	active := rotate(t, dir, time.Hour)
+
+	if err := writeKeyring(keysPath, keyring{Active: "missing", Keys: keys.Keys}); err != nil {
+		t.Fatal(err)
+	}
+	if err := ReloadKeys(); err == nil || !strings.Contains(err.Error(), "not found") {
+		t.Errorf("Expected a keyring without its active key to be refused, got %v", err)
+	}
+	if activeKeyID() != active {
+		t.Errorf("Expected the keys in memory to be kept, the active key is %s", activeKeyID())
+	}
+}
@@ -0,0 +1,138 @@
+package api
+
+import (
+	"backend/db"
+	"log"
+	"math"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// throttlePolicy decides how long a key (an email or an IP) must wait after failed logins
+type throttlePolicy struct {
+	freeAttempts     int           // failures allowed before any delay
+	baseDelay        time.Duration // delay after the first failure past freeAttempts, doubled for every further one
+	maxDelay         time.Duration
+	lockoutThreshold int           // failures that lock the key out completely
+	lockoutDuration  time.Duration // counted from the last failure
+	window           time.Duration // failures older than this are forgotten
+}
+
+// LoginThrottle limits login attempts per email and per IP
+type LoginThrottle struct {
+	byEmail throttlePolicy
+	byIP    throttlePolicy
+}
+
+// throttleResult describes why and for how long a login is refused
+type throttleResult struct {
+	retryAfter time.Duration
+	locked     bool // the account reached the lockout threshold, not just the backoff
+}
+
+var Throttle = &LoginThrottle{
+	byEmail: throttlePolicy{
+		freeAttempts:     3,
+		baseDelay:        time.Second,
+		maxDelay:         5 * time.Minute,
+		lockoutThreshold: 10,
+		lockoutDuration:  15 * time.Minute,
+		window:           time.Hour,
+	},
+	byIP: throttlePolicy{
+		freeAttempts:     20,
+		baseDelay:        time.Second,
+		maxDelay:         5 * time.Minute,
+		lockoutThreshold: 100,
+		lockoutDuration:  15 * time.Minute,
+		window:           time.Hour,
+	},
+}
+
+// wait returns how long a key with count failures, the last one sinceLast ago, has to wait
+func (p throttlePolicy) wait(count int, sinceLast time.Duration) throttleResult {
+	if count >= p.lockoutThreshold {
+		return throttleResult{retryAfter: p.lockoutDuration - sinceLast, locked: true}
+	}
+
+	if count <= p.freeAttempts {
+		return throttleResult{}
+	}
+
+	delay := time.Duration(float64(p.baseDelay) * math.Pow(2, float64(count-p.freeAttempts-1)))
+	if delay > p.maxDelay {
+		delay = p.maxDelay
+	}
+
+	return throttleResult{retryAfter: delay - sinceLast}
+}
+
+func normalizeEmail(email string) string {
+	return strings.ToLower(strings.TrimSpace(email))
+}
+
+// Check returns whether a login for email from ip has to wait, checked before the password is verified
+func (lt *LoginThrottle) Check(email string, ip string) throttleResult {
+	var result throttleResult
+
+	count, sinceLast, err := db.Connection.FailedLoginsByEmail(normalizeEmail(email), lt.byEmail.window)
+	if err != nil {
+		log.Printf("Failed to check login attempts for email: %v", err)
+	} else {
+		result = lt.byEmail.wait(count, sinceLast)
+	}
+
+	count, sinceLast, err = db.Connection.FailedLoginsByIP(ip, lt.byIP.window)
+	if err != nil {
+		log.Printf("Failed to check login attempts for ip: %v", err)
+	} else if byIP := lt.byIP.wait(count, sinceLast); byIP.retryAfter > result.retryAfter {
+		result = byIP
+	}
+
+	if result.retryAfter < 0 {
+		result.retryAfter = 0
+	}
+	return result
+}
+
+// Failed records a failed login
+func (lt *LoginThrottle) Failed(email string, ip string) {
+	if err := db.Connection.RecordFailedLogin(normalizeEmail(email), ip); err != nil {
+		log.Printf("Failed to record login attempt: %v", err)
+	}
+}
+
+// Succeeded clears the failed logins of an email
+func (lt *LoginThrottle) Succeeded(email string) {
+	if err := db.Connection.ClearFailedLogins(normalizeEmail(email)); err != nil {
+		log.Printf("Failed to clear login attempts: %v", err)
+	}
+}
+
+// Cleanup removes login attempts that no policy looks at anymore
+func (lt *LoginThrottle) Cleanup() {
+	window := max(lt.byEmail.window, lt.byIP.window)
+	count, err := db.Connection.DeleteOldLoginAttempts(window)
+	if err != nil {
+		log.Printf("Error cleaning up login attempts: %v", err)
+		return
+	}
+	if count > 0 {
+		log.Printf("Cleaned up %d old login attempts", count)
+	}
+}
+
+// setRetryAfter refuses a request with 429 Too Many Requests, telling the client when to retry
+func (ar *apiRequest) setRetryAfter(retryAfter time.Duration, code string, message string) {
+	seconds := int(math.Ceil(retryAfter.Seconds()))
+	if seconds < 1 {
+		seconds = 1
+	}
+
+	ar.httpWriter.Header().Set("Retry-After", strconv.Itoa(seconds))
+	response := newErrorResponse(ar.httpRequest, code, message)
+	response.RetryAfter = seconds
+	ar.setErrorResponse(http.StatusTooManyRequests, response)
+}
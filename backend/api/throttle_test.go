@@ -0,0 +1,108 @@
+package api
+
+import (
+	"fmt"
+	"net/http"
+	"testing"
+	"time"
+)
+
+var testPolicy = throttlePolicy{
+	freeAttempts:     3,
+	baseDelay:        time.Second,
+	maxDelay:         8 * time.Second,
+	lockoutThreshold: 10,
+	lockoutDuration:  15 * time.Minute,
+	window:           time.Hour,
+}
+
+func TestThrottlePolicyWait(t *testing.T) {
+	tests := []struct {
+		name      string
+		count     int
+		sinceLast time.Duration
+		want      throttleResult
+	}{
+		{"no failures", 0, 0, throttleResult{}},
+		{"free attempts", 3, 0, throttleResult{}},
+		{"first delay", 4, 0, throttleResult{retryAfter: time.Second}},
+		{"doubled", 5, 0, throttleResult{retryAfter: 2 * time.Second}},
+		{"doubled again", 6, 0, throttleResult{retryAfter: 4 * time.Second}},
+		{"at the cap", 7, 0, throttleResult{retryAfter: 8 * time.Second}},
+		{"capped", 9, 0, throttleResult{retryAfter: 8 * time.Second}},
+		{"time since the last failure counts", 6, 3 * time.Second, throttleResult{retryAfter: time.Second}},
+		{"delay over", 4, 2 * time.Second, throttleResult{retryAfter: -time.Second}},
+		{"locked out", 10, 0, throttleResult{retryAfter: 15 * time.Minute, locked: true}},
+		{"locked out a while ago", 12, 5 * time.Minute, throttleResult{retryAfter: 10 * time.Minute, locked: true}},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			if got := testPolicy.wait(test.count, test.sinceLast); got != test.want {
+				t.Errorf("wait(%d, %s) = %+v, want %+v", test.count, test.sinceLast, got, test.want)
+			}
+		})
+	}
+}
+
+func TestLoginThrottleCheck(t *testing.T) {
+	// Long delays, so the seconds between recording the failures and checking them don't matter
+	policy := testPolicy
+	policy.baseDelay, policy.maxDelay, policy.lockoutThreshold = time.Minute, time.Hour, 100
+	ipPolicy := policy
+	ipPolicy.freeAttempts = 5
+	lt := &LoginThrottle{byEmail: policy, byIP: ipPolicy}
+
+	run := time.Now().UnixNano()
+	tests := []struct {
+		name       string
+		emailFails int // failures of the email, each from its own IP
+		ipFails    int // failures from the IP, each for its own email
+		want       time.Duration
+	}{
+		{"nothing failed", 0, 0, 0},
+		{"email within its free attempts", 3, 0, 0},
+		{"email throttled", 5, 0, 2 * time.Minute},
+		{"ip within its free attempts", 0, 5, 0},
+		{"ip throttled", 0, 6, time.Minute},
+		{"longer email delay wins", 6, 6, 4 * time.Minute},
+		{"longer ip delay wins", 4, 8, 4 * time.Minute},
+	}
+
+	for i, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			email := fmt.Sprintf("throttled.%d.%d@test.dev", run, i)
+			ip := fmt.Sprintf("throttle-test-%d-%d", run, i)
+			for n := range test.emailFails {
+				lt.Failed(email, fmt.Sprintf("%s-%d", ip, n))
+			}
+			for n := range test.ipFails {
+				lt.Failed(fmt.Sprintf("other.%d.%s", n, email), ip)
+			}
+
+			got := lt.Check(email, ip).retryAfter
+			if got > test.want || got < test.want-2*time.Second {
+				t.Errorf("Check() waits %s, want %s", got, test.want)
+			}
+		})
+	}
+}
+
+func TestLoginThrottled(t *testing.T) {
+	_, email := newUser(t)
+	t.Cleanup(func() { Throttle.Succeeded(email) }) // the failures also count for the IP of every test
+
+	wrong := map[string]string{"action": "login", "email": email, "password": "wrong"}
+	for range Throttle.byEmail.freeAttempts + 1 {
+		if w := callAction(t, wrong, nil); w.Code != http.StatusUnauthorized {
+			t.Fatalf("Expected 401 for a wrong password, got %d: %s", w.Code, w.Body.String())
+		}
+	}
+
+	// Even the right password has to wait now
+	w := callAction(t, map[string]string{"action": "login", "email": email, "password": "123"}, nil)
+	response := decodeResponse[errorResponse](t, w, http.StatusTooManyRequests)
+	if response.Code != CodeLoginThrottled || response.RetryAfter < 1 || w.Header().Get("Retry-After") == "" {
+		t.Errorf("Expected %s with Retry-After, got %s (Retry-After %q)", CodeLoginThrottled, w.Body.String(), w.Header().Get("Retry-After"))
+	}
+}
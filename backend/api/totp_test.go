@@ -0,0 +1,80 @@
+package api
+
+import (
+	"testing"
+	"time"
+)
+
+// rfc6238Secret is the SHA-1 key of the test vectors in RFC 6238, appendix B
+const rfc6238Secret = "12345678901234567890"
+
+func TestTOTPCode(t *testing.T) {
+	// The RFC lists 8 digit codes, 6 digit codes are their last 6 digits
+	tests := []struct {
+		time int64
+		code string
+	}{
+		{59, "287082"},
+		{1111111109, "081804"},
+		{1111111111, "050471"},
+		{1234567890, "005924"},
+		{2000000000, "279037"},
+		{20000000000, "353130"},
+	}
+
+	for _, test := range tests {
+		if code := totpCode([]byte(rfc6238Secret), test.time/totpPeriod); code != test.code {
+			t.Errorf("Expected the code at %d to be %s, got %s", test.time, test.code, code)
+		}
+	}
+}
+
+func TestMatchTOTP(t *testing.T) {
+	secret := totpEncoding.EncodeToString([]byte(rfc6238Secret))
+	at := time.Unix(1111111111, 0)
+	step := at.Unix() / totpPeriod
+
+	tests := []struct {
+		name   string
+		secret string
+		code   string
+		step   int64 // the step the code matches, 0 if it is refused
+	}{
+		{"current step", secret, "050471", step},
+		{"previous step", secret, totpCode([]byte(rfc6238Secret), step-1), step - 1},
+		{"next step", secret, totpCode([]byte(rfc6238Secret), step+1), step + 1},
+		{"two steps ago", secret, totpCode([]byte(rfc6238Secret), step-2), 0},
+		{"two steps ahead", secret, totpCode([]byte(rfc6238Secret), step+2), 0},
+		{"with spaces", secret, " 050471 ", step},
+		{"lowercase secret", "gezdgnbvgy3tqojqgezdgnbvgy3tqojq", "050471", step},
+		{"8 digits", secret, "14050471", 0},
+		{"wrong code", secret, "050472", 0},
+		{"invalid secret", "not base32!", "050471", 0},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			matched, ok := matchTOTP(test.secret, test.code, at)
+			switch {
+			case test.step == 0 && ok:
+				t.Errorf("Expected the code to be refused, it matched step %d", matched)
+			case test.step != 0 && !ok:
+				t.Errorf("Expected the code to match step %d, it was refused", test.step)
+			case test.step != 0 && matched != test.step:
+				t.Errorf("Expected the code to match step %d, got %d", test.step, matched)
+			}
+		})
+	}
+}
+
+func TestHashRecoveryCode(t *testing.T) {
+	hash := hashRecoveryCode("a1b2c-3d4e5")
+	for _, typed := range []string{"A1B2C-3D4E5", "a1b2c3d4e5", "a1b2c 3d4e5"} {
+		if hashRecoveryCode(typed) != hash {
+			t.Errorf("Expected %q to hash like the recovery code it was typed from", typed)
+		}
+	}
+	if hashRecoveryCode("a1b2c-3d4e6") == hash {
+		t.Error("Expected different recovery codes to hash differently")
+	}
+}
@@ -0,0 +1,283 @@
+package api
+
+import (
+	"backend/db"
+	"database/sql"
+	"encoding/json"
+	"log"
+	"net/http"
+	"time"
+)
+
+// twoFactorChallenge answers a login with a correct password for a user with 2FA enabled
+func (ar *apiRequest) twoFactorChallenge(user *db.User) {
+	if ar.refuseSuspended(user) {
+		return
+	}
+
+	c := Claims{
+		Email: user.Email,
+		Id:    user.Id,
+	}
+
+	challenge, err := c.GetTwoFactorChallenge()
+	if err != nil {
+		log.Printf("failed to get 2FA challenge token: %v\n", err)
+		ar.setError(http.StatusInternalServerError, "Internal server error")
+		return
+	}
+
+	response := twoFactorChallengeResponse{
+		Status:         "2fa_required",
+		ChallengeToken: *challenge,
+		ExpiresIn:      int(twoFactorChallengeTokenLifetime.Seconds()),
+	}
+
+	responseJSON, err := json.Marshal(response)
+	if err != nil {
+		log.Printf("Error marshalling 2FA challenge response: %v", err)
+		ar.setError(http.StatusInternalServerError, "Internal server error")
+		return
+	}
+
+	log.Printf("User %s passed the password check, 2FA required\n", user.Email)
+	ar.response = string(responseJSON)
+}
+
+// login2FA finishes a login with the challenge token from login and a TOTP or recovery code
+func (ar *apiRequest) login2FA(request *TwoFactorLoginRequest) {
+	claims, err := UnmarshalTwoFactorChallenge(&request.ChallengeToken)
+	if err != nil {
+		log.Printf("Invalid 2FA challenge token: %v\n", err)
+		ar.setError(http.StatusUnauthorized, "Invalid or expired challenge token, log in again")
+		return
+	}
+
+	// Guessing codes is throttled like guessing passwords
+	ip := clientIP(ar.httpRequest)
+	if throttled := Throttle.Check(claims.Email, ip); throttled.retryAfter > 0 {
+		ar.recordEvent(claims.Id, EventLoginThrottled, nil)
+		ar.setRetryAfter(throttled.retryAfter, CodeLoginThrottled, "Too many failed login attempts, try again later")
+		return
+	}
+
+	ok, err := checkSecondFactor(claims.Id, request.Code)
+	if err != nil {
+		log.Printf("Failed to check 2FA code for user %d: %v", claims.Id, err)
+		ar.setError(http.StatusInternalServerError, "Internal server error")
+		return
+	}
+
+	if !ok {
+		Throttle.Failed(claims.Email, ip)
+		ar.recordEvent(claims.Id, EventLoginFailed, map[string]interface{}{"reason": "wrong_2fa_code"})
+		log.Printf("Invalid 2FA code for %s\n", claims.Email)
+		ar.setError(http.StatusUnauthorized, "Invalid code")
+		return
+	}
+
+	// The challenge can only be used once, revoking it is the check so concurrent logins can't share it
+	revoked, err := RevokeToken(claims)
+	if err != nil {
+		log.Printf("Failed to revoke 2FA challenge token: %v", err)
+		ar.setError(http.StatusInternalServerError, "Internal server error")
+		return
+	}
+	if !revoked {
+		log.Printf("2FA challenge of user %d was already used", claims.Id)
+		ar.setError(http.StatusUnauthorized, "Invalid or expired challenge token, log in again")
+		return
+	}
+
+	user, err := ar.db.FetchUser(claims.Id)
+	if err != nil {
+		log.Printf("Failed to fetch user %d: %v", claims.Id, err)
+		ar.setError(http.StatusInternalServerError, "Internal server error")
+		return
+	}
+
+	ar.completeLogin(user, "2fa")
+}
+
+// checkSecondFactor accepts a TOTP code that wasn't used before or an unused recovery code
+func checkSecondFactor(userID int, code string) (bool, error) {
+	totp, err := db.Connection.FetchTOTP(userID)
+	if err != nil {
+		if err == sql.ErrNoRows {
+			return false, nil
+		}
+		return false, err
+	}
+
+	if !totp.Confirmed {
+		return false, nil
+	}
+
+	if step, ok := matchTOTP(totp.Secret, code, time.Now()); ok {
+		return db.Connection.UseTOTPStep(userID, step)
+	}
+
+	used, err := db.Connection.UseRecoveryCode(userID, hashRecoveryCode(code))
+	if used {
+		log.Printf("User %d logged in with a recovery code", userID)
+	}
+	return used, err
+}
+
+// enroll2FA starts 2FA enrollment, returning a new secret for the authenticator app.
+// 2FA is only enabled once confirm_2fa receives a code generated from it.
+func (ar *apiRequest) enroll2FA() {
+	enabled, err := ar.db.IsTwoFactorEnabled(ar.claims.Id)
+	if err != nil {
+		log.Printf("Failed to check 2FA for user %d: %v", ar.claims.Id, err)
+		ar.setError(http.StatusInternalServerError, "Internal server error")
+		return
+	}
+
+	if enabled {
+		ar.setError(http.StatusConflict, "Two-factor authentication is already enabled")
+		return
+	}
+
+	secret, err := generateTOTPSecret()
+	if err != nil {
+		log.Printf("Failed to generate 2FA secret: %v", err)
+		ar.setError(http.StatusInternalServerError, "Internal server error")
+		return
+	}
+
+	if err := ar.db.SetPendingTOTP(ar.claims.Id, secret); err != nil {
+		log.Printf("Failed to store 2FA secret: %v", err)
+		ar.setError(http.StatusInternalServerError, "Internal server error")
+		return
+	}
+
+	response := map[string]string{
+		"secret":     secret,
+		"otpauthUri": totpURI(secret, ar.claims.Email),
+	}
+
+	responseJSON, err := json.Marshal(response)
+	if err != nil {
+		log.Printf("Error marshalling enroll 2FA response: %v", err)
+		ar.setError(http.StatusInternalServerError, "Internal server error")
+		return
+	}
+
+	ar.response = string(responseJSON)
+}
+
+// confirm2FA enables 2FA with the first code from the authenticator app
+// and returns the recovery codes, which are only shown this once
+func (ar *apiRequest) confirm2FA(request *TwoFactorCodeRequest) {
+	totp, err := ar.db.FetchTOTP(ar.claims.Id)
+	if err != nil {
+		if err == sql.ErrNoRows {
+			ar.setError(http.StatusBadRequest, "Start two-factor enrollment first")
+			return
+		}
+		log.Printf("Failed to fetch 2FA secret: %v", err)
+		ar.setError(http.StatusInternalServerError, "Internal server error")
+		return
+	}
+
+	if totp.Confirmed {
+		ar.setError(http.StatusConflict, "Two-factor authentication is already enabled")
+		return
+	}
+
+	step, ok := matchTOTP(totp.Secret, request.Code, time.Now())
+	if !ok {
+		ar.setError(http.StatusBadRequest, "Invalid code")
+		return
+	}
+
+	codes, err := generateRecoveryCodes()
+	if err != nil {
+		log.Printf("Failed to generate recovery codes: %v", err)
+		ar.setError(http.StatusInternalServerError, "Internal server error")
+		return
+	}
+
+	hashes := make([]string, len(codes))
+	for i, code := range codes {
+		hashes[i] = hashRecoveryCode(code)
+	}
+
+	if err := ar.db.ConfirmTOTP(ar.claims.Id, step, hashes); err != nil {
+		log.Printf("Failed to confirm 2FA: %v", err)
+		ar.setError(http.StatusInternalServerError, "Internal server error")
+		return
+	}
+
+	log.Printf("User %d enabled 2FA", ar.claims.Id)
+	ar.recordEvent(ar.claims.Id, EventTwoFactorEnabled, nil)
+
+	response := map[string]interface{}{
+		"message":       "Two-factor authentication enabled",
+		"recoveryCodes": codes,
+	}
+
+	responseJSON, err := json.Marshal(response)
+	if err != nil {
+		log.Printf("Error marshalling confirm 2FA response: %v", err)
+		ar.setError(http.StatusInternalServerError, "Internal server error")
+		return
+	}
+
+	ar.response = string(responseJSON)
+}
+
+// disable2FA turns off 2FA after checking the password again and, once 2FA is enabled, a second factor,
+// so a stolen password and session can't remove it
+func (ar *apiRequest) disable2FA(request *DisableTwoFactorRequest) {
+	user, ok := ar.reauthenticate(request.Password)
+	if !ok {
+		return
+	}
+
+	enabled, err := ar.db.IsTwoFactorEnabled(user.Id)
+	if err != nil {
+		log.Printf("Failed to check 2FA for user %d: %v", user.Id, err)
+		ar.setError(http.StatusInternalServerError, "Internal server error")
+		return
+	}
+
+	if enabled {
+		verified, err := checkSecondFactor(user.Id, request.Code)
+		if err != nil {
+			log.Printf("Failed to check 2FA code for user %d: %v", user.Id, err)
+			ar.setError(http.StatusInternalServerError, "Internal server error")
+			return
+		}
+
+		if !verified {
+			Throttle.Failed(user.Email, clientIP(ar.httpRequest))
+			ar.recordEvent(user.Id, EventPasswordCheckFailed, map[string]interface{}{"reason": "wrong_2fa_code"})
+			ar.setError(http.StatusUnauthorized, "Invalid code")
+			return
+		}
+	}
+
+	if err := ar.db.DeleteTOTP(user.Id); err != nil {
+		log.Printf("Failed to disable 2FA: %v", err)
+		ar.setError(http.StatusInternalServerError, "Internal server error")
+		return
+	}
+
+	log.Printf("User %d disabled 2FA", user.Id)
+	ar.recordEvent(user.Id, EventTwoFactorDisabled, nil)
+
+	response := map[string]string{
+		"message": "Two-factor authentication disabled",
+	}
+
+	responseJSON, err := json.Marshal(response)
+	if err != nil {
+		log.Printf("Error marshalling disable 2FA response: %v", err)
+		ar.setError(http.StatusInternalServerError, "Internal server error")
+		return
+	}
+
+	ar.response = string(responseJSON)
+}
@@ -0,0 +1,126 @@
+package api
+
+import (
+	"backend/db"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+// enableTwoFactor turns on 2FA for a new user and returns its email, TOTP secret, recovery codes
+// and session. The code of the current step was used to confirm it.
+func enableTwoFactor(t *testing.T) (string, string, []string, loginResponse, *http.Cookie) {
+	t.Helper()
+
+	_, email := newUser(t)
+	t.Cleanup(func() { Throttle.Succeeded(email) }) // the failures also count for the IP of every test
+	user, session := login(t, email)
+
+	w := callAction(t, map[string]string{"action": "enroll_2fa"}, sessionHeader(user), session)
+	enrolled := decodeResponse[enrollTwoFactorResponse](t, w, http.StatusOK)
+
+	confirm := map[string]string{"action": "confirm_2fa", "code": totpAt(t, enrolled.Secret, currentStep())}
+	w = callAction(t, confirm, sessionHeader(user), session)
+	confirmed := decodeResponse[recoveryCodesResponse](t, w, http.StatusOK)
+
+	return email, enrolled.Secret, confirmed.RecoveryCodes, user, session
+}
+
+func currentStep() int64 {
+	return time.Now().Unix() / totpPeriod
+}
+
+func totpAt(t *testing.T, secret string, step int64) string {
+	t.Helper()
+
+	key, err := totpEncoding.DecodeString(secret)
+	if err != nil {
+		t.Fatalf("Invalid TOTP secret: %v", err)
+	}
+	return totpCode(key, step)
+}
+
+// login2FAWith logs in with the password and answers the challenge with code
+func login2FAWith(t *testing.T, email string, code string) *httptest.ResponseRecorder {
+	t.Helper()
+
+	w := callAction(t, map[string]string{"action": "login", "email": email, "password": "123"}, nil)
+	challenge := decodeResponse[twoFactorChallengeResponse](t, w, http.StatusOK)
+	if challenge.Status != "2fa_required" {
+		t.Fatalf("Expected a 2FA challenge, got %s", w.Body.String())
+	}
+
+	return callAction(t, map[string]string{"action": "login_2fa", "challengeToken": challenge.ChallengeToken, "code": code}, nil)
+}
+
+func TestTOTPStepCanOnlyBeUsedOnce(t *testing.T) {
+	email, secret, _, _, _ := enableTwoFactor(t)
+	confirmed := currentStep()
+
+	if w := login2FAWith(t, email, totpAt(t, secret, confirmed)); w.Code != http.StatusUnauthorized {
+		t.Errorf("Expected the code that confirmed 2FA to be refused, got %d", w.Code)
+	}
+
+	next := totpAt(t, secret, confirmed+1)
+	decodeResponse[loginResponse](t, login2FAWith(t, email, next), http.StatusOK)
+
+	if w := login2FAWith(t, email, next); w.Code != http.StatusUnauthorized {
+		t.Errorf("Expected a code to be refused the second time, got %d", w.Code)
+	}
+}
+
+func TestRecoveryCodeCanOnlyBeUsedOnce(t *testing.T) {
+	email, _, codes, _, _ := enableTwoFactor(t)
+
+	decodeResponse[loginResponse](t, login2FAWith(t, email, codes[0]), http.StatusOK)
+
+	if w := login2FAWith(t, email, codes[0]); w.Code != http.StatusUnauthorized {
+		t.Errorf("Expected a recovery code to be refused the second time, got %d", w.Code)
+	}
+	decodeResponse[loginResponse](t, login2FAWith(t, email, codes[1]), http.StatusOK)
+}
+
+func TestDisableTwoFactorRequiresSecondFactor(t *testing.T) {
+	email, secret, codes, user, session := enableTwoFactor(t)
+	disable := func(password string, code string) *httptest.ResponseRecorder {
+		return callAction(t, map[string]string{"action": "disable_2fa", "password": password, "code": code}, sessionHeader(user), session)
+	}
+
+	tests := []struct {
+		name     string
+		password string
+		code     string
+	}{
+		{"without a code", "123", ""},
+		{"with a wrong code", "123", "000000"},
+		{"with the code that confirmed 2FA", "123", totpAt(t, secret, currentStep())},
+		{"with a wrong password", "wrong", codes[0]},
+	}
+	for _, test := range tests {
+		w := disable(test.password, test.code)
+		decodeResponse[errorResponse](t, w, http.StatusUnauthorized)
+		if enabled, _ := db.Connection.IsTwoFactorEnabled(user.User.Id); !enabled {
+			t.Fatalf("Expected 2FA to stay enabled %s", test.name)
+		}
+	}
+
+	Throttle.Succeeded(email) // wrong codes are throttled like wrong passwords
+
+	decodeResponse[messageResponse](t, disable("123", codes[0]), http.StatusOK)
+	if enabled, _ := db.Connection.IsTwoFactorEnabled(user.User.Id); enabled {
+		t.Error("Expected 2FA to be disabled with the password and a recovery code")
+	}
+}
+
+func TestDisableTwoFactorEnrollmentNeedsOnlyPassword(t *testing.T) {
+	_, email := newUser(t)
+	user, session := login(t, email)
+	decodeResponse[enrollTwoFactorResponse](t, callAction(t, map[string]string{"action": "enroll_2fa"}, sessionHeader(user), session), http.StatusOK)
+
+	w := callAction(t, map[string]string{"action": "disable_2fa", "password": "123"}, sessionHeader(user), session)
+	decodeResponse[messageResponse](t, w, http.StatusOK)
+	if _, err := db.Connection.FetchTOTP(user.User.Id); err == nil {
+		t.Error("Expected the unconfirmed secret to be deleted")
+	}
+}
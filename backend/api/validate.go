@@ -0,0 +1,184 @@
+package api
+
+import (
+	"fmt"
+	"net/mail"
+	"reflect"
+	"strconv"
+	"strings"
+)
+
+// validateRequest checks a decoded request against the `validate` tags of its fields.
+// The rules are comma separated:
+//
+//	required   strings must not be blank, numbers not zero, lists not empty
+//	min=N      the smallest number, or the shortest string or list
+//	max=N      the largest number, or the longest string or list
+//	email      an email address
+//	oneof=a b  one of the space separated values
+//
+// Apart from required, rules only apply to fields that are set. Nested structs are checked too.
+// The returned error is a validationErrors listing every invalid field by its name in the JSON,
+// meant to be shown to the user.
+func validateRequest(request interface{}) error {
+	value := reflect.ValueOf(request)
+	for value.Kind() == reflect.Pointer {
+		if value.IsNil() {
+			return nil
+		}
+		value = value.Elem()
+	}
+	if value.Kind() != reflect.Struct {
+		return nil
+	}
+
+	var invalid validationErrors
+	validateStruct(value, "", &invalid)
+	if len(invalid) > 0 {
+		return invalid
+	}
+	return nil
+}
+
+// fieldError is what is wrong with a field of a request
+type fieldError struct {
+	Field   string `json:"field"`   // path in the JSON, like user.email
+	Message string `json:"message"` // e.g. "is required"
+}
+
+// validationErrors are the invalid fields of a request
+type validationErrors []fieldError
+
+func (e validationErrors) Error() string {
+	messages := make([]string, len(e))
+	for i, field := range e {
+		messages[i] = field.Field + " " + field.Message
+	}
+	return strings.Join(messages, ", ")
+}
+
+func validateStruct(value reflect.Value, prefix string, invalid *validationErrors) {
+	t := value.Type()
+	for i := 0; i < t.NumField(); i++ {
+		field := t.Field(i)
+		fieldValue := value.Field(i)
+
+		// Fields of embedded structs are promoted to the parent in the JSON
+		if field.Anonymous && field.Tag.Get("json") == "" && fieldValue.Kind() == reflect.Struct {
+			validateStruct(fieldValue, prefix, invalid)
+			continue
+		}
+		if !field.IsExported() {
+			continue
+		}
+
+		name := prefix + jsonName(field)
+		if rules := field.Tag.Get("validate"); rules != "" {
+			for _, rule := range strings.Split(rules, ",") {
+				if message := validateRule(name, fieldValue, rule); message != "" {
+					*invalid = append(*invalid, fieldError{Field: name, Message: message})
+					break // one problem per field is enough
+				}
+			}
+		}
+
+		if fieldValue.Kind() == reflect.Struct {
+			validateStruct(fieldValue, name+".", invalid)
+		}
+	}
+}
+
+// validateRule returns what is wrong with a value, "" if it follows the rule
+func validateRule(name string, value reflect.Value, rule string) string {
+	rule, arg, _ := strings.Cut(strings.TrimSpace(rule), "=")
+
+	if rule == "required" {
+		if isBlank(value) {
+			return "is required"
+		}
+		return ""
+	}
+
+	if isBlank(value) {
+		return ""
+	}
+	for value.Kind() == reflect.Pointer {
+		value = value.Elem() // optional fields are checked by what they point to
+	}
+
+	switch rule {
+	case "min", "max":
+		limit, err := strconv.Atoi(arg)
+		if err != nil {
+			panic(fmt.Sprintf("validate: bad %s rule on %s: %q", rule, name, arg))
+		}
+
+		size, unit := measure(value)
+		if rule == "min" && size < limit {
+			return fmt.Sprintf("must be at least %d%s", limit, unit)
+		}
+		if rule == "max" && size > limit {
+			return fmt.Sprintf("must be at most %d%s", limit, unit)
+		}
+
+	case "email":
+		if address, err := mail.ParseAddress(value.String()); err != nil || address.Address != value.String() {
+			return "must be an email address"
+		}
+
+	case "oneof":
+		options := strings.Fields(arg)
+		for _, option := range options {
+			if fmt.Sprint(value.Interface()) == option {
+				return ""
+			}
+		}
+		return "must be one of " + strings.Join(options, ", ")
+
+	default:
+		panic(fmt.Sprintf("validate: unknown rule %q on %s", rule, name))
+	}
+
+	return ""
+}
+
+// isBlank reports whether a value counts as not given
+func isBlank(value reflect.Value) bool {
+	switch value.Kind() {
+	case reflect.String:
+		return strings.TrimSpace(value.String()) == ""
+	case reflect.Slice, reflect.Map:
+		return value.Len() == 0
+	case reflect.Pointer, reflect.Interface:
+		return value.IsNil()
+	case reflect.Bool, reflect.Struct:
+		return false
+	default:
+		return value.IsZero()
+	}
+}
+
+// measure returns what min and max compare: the value of numbers, the length of anything else
+func measure(value reflect.Value) (int, string) {
+	switch value.Kind() {
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		return int(value.Int()), ""
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		return int(value.Uint()), ""
+	case reflect.Float32, reflect.Float64:
+		return int(value.Float()), ""
+	case reflect.String:
+		return len([]rune(value.String())), " characters"
+	default:
+		return value.Len(), " items"
+	}
+}
+
+// jsonName is the name of a struct field in JSON
+func jsonName(field reflect.StructField) string {
+	name, _, _ := strings.Cut(field.Tag.Get("json"), ",")
+	if name == "" || name == "-" {
+		return field.Name
+	}
+	return name
+}
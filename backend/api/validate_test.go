@@ -0,0 +1,137 @@
+package api
+
+import (
+	"reflect"
+	"testing"
+)
+
+type validatedAddress struct {
+	City string `json:"city" validate:"required,max=10"`
+}
+
+type validatedRequest struct {
+	Name     string           `json:"name" validate:"required,min=2,max=5"`
+	Email    string           `json:"email,omitempty" validate:"email"`
+	Age      int              `json:"age" validate:"min=13,max=120"`
+	Privacy  string           `json:"privacy" validate:"oneof=public private"`
+	Level    int              `json:"level" validate:"oneof=1 2 3"`
+	Tags     []string         `json:"tags" validate:"max=2"`
+	Limit    *int             `json:"limit" validate:"min=1"`
+	Internal string           `json:"-" validate:"required"`
+	Address  validatedAddress `json:"address"`
+	pagination
+}
+
+type pagination struct {
+	Page int `json:"page" validate:"min=1"`
+}
+
+func TestValidateRequest(t *testing.T) {
+	zero, five := 0, 5
+	valid := func() validatedRequest {
+		return validatedRequest{Name: "Ada", Internal: "x", Address: validatedAddress{City: "Tallinn"}}
+	}
+
+	tests := []struct {
+		name   string
+		change func(*validatedRequest)
+		want   validationErrors
+	}{
+		{"valid with only required fields", func(r *validatedRequest) {}, nil},
+		{"valid with every field", func(r *validatedRequest) {
+			r.Email, r.Age, r.Privacy, r.Level, r.Tags, r.Limit, r.Page = "ada@test.dev", 36, "private", 2, []string{"a", "b"}, &five, 3
+		}, nil},
+		{"missing required", func(r *validatedRequest) { r.Name = "" },
+			validationErrors{{"name", "is required"}}},
+		{"blank required", func(r *validatedRequest) { r.Name = "  \t" },
+			validationErrors{{"name", "is required"}}},
+		{"string too short", func(r *validatedRequest) { r.Name = "A" },
+			validationErrors{{"name", "must be at least 2 characters"}}},
+		{"string too long", func(r *validatedRequest) { r.Name = "Adelaide" },
+			validationErrors{{"name", "must be at most 5 characters"}}},
+		{"length counts characters, not bytes", func(r *validatedRequest) { r.Name = "Ülöäõ" }, nil},
+		{"bad email", func(r *validatedRequest) { r.Email = "ada at test.dev" },
+			validationErrors{{"email", "must be an email address"}}},
+		{"email with display name", func(r *validatedRequest) { r.Email = "Ada <ada@test.dev>" },
+			validationErrors{{"email", "must be an email address"}}},
+		{"number too small", func(r *validatedRequest) { r.Age = 12 },
+			validationErrors{{"age", "must be at least 13"}}},
+		{"number too large", func(r *validatedRequest) { r.Age = 121 },
+			validationErrors{{"age", "must be at most 120"}}},
+		{"negative number", func(r *validatedRequest) { r.Age = -1 },
+			validationErrors{{"age", "must be at least 13"}}},
+		{"string not one of", func(r *validatedRequest) { r.Privacy = "secret" },
+			validationErrors{{"privacy", "must be one of public, private"}}},
+		{"number not one of", func(r *validatedRequest) { r.Level = 4 },
+			validationErrors{{"level", "must be one of 1, 2, 3"}}},
+		{"too many items", func(r *validatedRequest) { r.Tags = []string{"a", "b", "c"} },
+			validationErrors{{"tags", "must be at most 2 items"}}},
+		{"pointer too small", func(r *validatedRequest) { r.Limit = &zero },
+			validationErrors{{"limit", "must be at least 1"}}},
+		{"field without json name", func(r *validatedRequest) { r.Internal = "" },
+			validationErrors{{"Internal", "is required"}}},
+		{"nested struct", func(r *validatedRequest) { r.Address.City = "" },
+			validationErrors{{"address.city", "is required"}}},
+		{"embedded struct", func(r *validatedRequest) { r.Page = -2 },
+			validationErrors{{"page", "must be at least 1"}}},
+		{"every invalid field", func(r *validatedRequest) { r.Name, r.Age, r.Address.City = "", 200, "Llanfairpwllgwyngyll" },
+			validationErrors{{"name", "is required"}, {"age", "must be at most 120"}, {"address.city", "must be at most 10 characters"}}},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			request := valid()
+			test.change(&request)
+
+			err := validateRequest(&request)
+			if test.want == nil {
+				if err != nil {
+					t.Errorf("Expected no error, got %v", err)
+				}
+				return
+			}
+
+			invalid, ok := err.(validationErrors)
+			if !ok {
+				t.Fatalf("Expected validationErrors, got %T %v", err, err)
+			}
+			if !reflect.DeepEqual(invalid, test.want) {
+				t.Errorf("Expected %v, got %v", test.want, invalid)
+			}
+		})
+	}
+}
+
+func TestValidateRequestIgnoresNonStructs(t *testing.T) {
+	var nilRequest *validatedRequest
+	for _, request := range []any{nil, nilRequest, "text", 5} {
+		if err := validateRequest(request); err != nil {
+			t.Errorf("validateRequest(%#v) = %v, want nil", request, err)
+		}
+	}
+}
+
+func TestValidateRequestBadRules(t *testing.T) {
+	tests := []struct {
+		name    string
+		request any
+	}{
+		{"unknown rule", &struct {
+			Name string `validate:"lowercase"`
+		}{Name: "ada"}},
+		{"bad limit", &struct {
+			Name string `validate:"max=ten"`
+		}{Name: "ada"}},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			defer func() {
+				if recover() == nil {
+					t.Errorf("Expected a panic for a mistake in the validate tag")
+				}
+			}()
+			validateRequest(test.request)
+		})
+	}
+}
@@ -2,9 +2,9 @@ package api
 
 import (
 	"backend/db"
-	"fmt"
 	"log"
 	"sync"
+	"sync/atomic"
 	"time"
 
 	"github.com/gorilla/websocket"
@@ -13,16 +13,23 @@ import (
 type Hub struct {
 	clients map[*Client]bool
 	groups  map[int][]int // groupId -> userIds
+	goodbye []byte        // close frame of a server shutting down, see CloseHub
 	sync.Mutex
+
+	clientCount atomic.Int64 // len(clients), read by the metrics without taking the lock
 }
 
 type Client struct {
 	conn *websocket.Conn
 	id   int
+
+	// What the connection was authenticated with, so revoking it closes the connection
+	sessionRecordID int    // the session, 0 if it was a token
+	tokenID         string // the bearer token, "" for sessions and personal access tokens
 }
 
 type Message struct {
-	Type         string            `json:"type"` // "message", "conversation_list", "connect", "disconnect", "create_conversation"
+	Type         string            `json:"type"` // "message", "conversation_list", "connect", "disconnect", "create_conversation", "error"
 	From         int               `json:"from"`
 	To           int               `json:"to,omitempty"`      // For direct messages or conversation ID
 	Content      string            `json:"content,omitempty"` // The actual message
@@ -39,14 +46,22 @@ func (h *Hub) addClient(client *Client) {
 	h.Lock()
 	defer h.Unlock()
 
+	if h.goodbye != nil {
+		h.sayGoodbye(client)
+		return
+	}
 	h.clients[client] = true
+	h.clientCount.Add(1)
 }
 
 func (h *Hub) removeClient(client *Client) {
 	h.Lock()
 	defer h.Unlock()
 
-	delete(h.clients, client)
+	if h.clients[client] {
+		delete(h.clients, client)
+		h.clientCount.Add(-1)
+	}
 	client.conn.Close()
 }
 
@@ -73,7 +88,19 @@ func (h *Hub) handleChatMessage(msg Message) {
 	var err error
 	
 	if msg.ConversationID != 0 {
-		// Use existing conversation
+		// Use existing conversation, only its participants may write to it
+		participant, err := db.Connection.IsConversationParticipant(msg.ConversationID, msg.From)
+		if err != nil {
+			log.Printf("Failed to check participant of conversation %d: %v", msg.ConversationID, err)
+			return
+		}
+		if !participant {
+			log.Printf("Refused message of user %d to conversation %d they are not part of", msg.From, msg.ConversationID)
+			if client := h.getClientByID(msg.From); client != nil {
+				h.sendMessage(client, Message{Type: "error", Content: "You are not part of this conversation"})
+			}
+			return
+		}
 		conversationID = msg.ConversationID
 	} else if msg.To != 0 {
 		// Create or find direct conversation
@@ -186,6 +213,15 @@ func (h *Hub) getClientByID(userID int) *Client {
 	return nil
 }
 
+// welcome sends a new client its conversation list. Like every write to a client it holds
+// the lock, a connection can't be written to by two goroutines at once.
+func (h *Hub) welcome(client *Client) {
+	h.Lock()
+	defer h.Unlock()
+
+	h.sendConversationList(client)
+}
+
 func (h *Hub) sendConversationList(client *Client) {
 	if client == nil {
 		return
@@ -205,13 +241,85 @@ func (h *Hub) sendConversationList(client *Client) {
 	h.sendMessage(client, msg)
 }
 
+// disconnectUser closes every connection of a user, the read loops then remove the clients
+func (h *Hub) disconnectUser(userID int) {
+	h.disconnect(func(client *Client) bool { return client.id == userID })
+}
+
+// disconnectLogins closes every connection of a user except those of the session with keepRecordID,
+// for when all the sessions and tokens of the user are revoked
+func (h *Hub) disconnectLogins(userID int, keepRecordID int) {
+	h.disconnect(func(client *Client) bool {
+		return client.id == userID && (keepRecordID == 0 || client.sessionRecordID != keepRecordID)
+	})
+}
+
+// disconnectSessions closes the connections a user opened with a session other than keepRecordID
+func (h *Hub) disconnectSessions(userID int, keepRecordID int) {
+	h.disconnect(func(client *Client) bool {
+		return client.id == userID && client.sessionRecordID != 0 && client.sessionRecordID != keepRecordID
+	})
+}
+
+// disconnectSession closes the connections opened with a session
+func (h *Hub) disconnectSession(recordID int) {
+	h.disconnect(func(client *Client) bool { return recordID != 0 && client.sessionRecordID == recordID })
+}
+
+// disconnectToken closes the connections opened with a bearer token
+func (h *Hub) disconnectToken(tokenID string) {
+	h.disconnect(func(client *Client) bool { return tokenID != "" && client.tokenID == tokenID })
+}
+
+func (h *Hub) disconnect(match func(client *Client) bool) {
+	h.Lock()
+	defer h.Unlock()
+
+	for client := range h.clients {
+		if match(client) {
+			client.conn.Close()
+		}
+	}
+}
+
+// CloseHub says goodbye to every client with a close frame, e.g. "server restarting", and
+// closes their connections. Clients connecting later are turned away the same way.
+func CloseHub(reason string) {
+	hub.Lock()
+	defer hub.Unlock()
+
+	hub.goodbye = websocket.FormatCloseMessage(websocket.CloseServiceRestart, reason)
+	for client := range hub.clients {
+		hub.sayGoodbye(client)
+		delete(hub.clients, client)
+	}
+	hub.clientCount.Store(0)
+}
+
+func (h *Hub) sayGoodbye(client *Client) {
+	if err := client.conn.WriteControl(websocket.CloseMessage, h.goodbye, time.Now().Add(time.Second)); err != nil {
+		log.Printf("Failed to send close frame to client %d: %v", client.id, err)
+	}
+	client.conn.Close()
+}
+
+// sendError tells a client why its message was refused
+func (h *Hub) sendError(client *Client, text string) {
+	h.Lock()
+	defer h.Unlock()
+
+	h.sendMessage(client, Message{Type: "error", Content: text})
+}
+
 func (h *Hub) sendMessage(client *Client, msg Message) {
 	err := client.conn.WriteJSON(msg)
 	if err != nil {
 		log.Printf("error: %v", err)
 		client.conn.Close()
 		delete(h.clients, client)
+		return
 	}
+	wsMessagesRouted.Inc(msg.Type)
 }
 
 func contains[T comparable](slice []T, item T) bool {
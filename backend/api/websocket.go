@@ -1,7 +1,6 @@
 package api
 
 import (
-	"fmt"
 	"log"
 	"net/http"
 
@@ -11,13 +10,38 @@ import (
 var upgrader = websocket.Upgrader{
 	ReadBufferSize:  1024,
 	WriteBufferSize: 1024,
+	// The websocket is authenticated with the session cookie and can't carry a CSRF token,
+	// so other sites must not be able to open it
 	CheckOrigin: func(r *http.Request) bool {
-		log.Printf("ws addr %s, url %s", r.RemoteAddr, r.RequestURI)
-		return true
+		log.Printf("ws addr %s, url %s, origin %s", r.RemoteAddr, r.RequestURI, requestOrigin(r))
+		return originAllowed(r)
+	},
+	// Failed handshakes get the same error envelope as the API
+	Error: func(w http.ResponseWriter, r *http.Request, status int, reason error) {
+		code := statusErrorCode(status)
+		if !originAllowed(r) {
+			code = CodeOriginNotAllowed
+		}
+		writeError(w, r, status, code, reason.Error())
 	},
 }
 
 func HandleWebSocket(w http.ResponseWriter, r *http.Request) {
+	// Authenticate before upgrading, the connection is bound to this user for its lifetime
+	claims, err := authenticate(r)
+	if err != nil || claims == nil {
+		log.Printf("Rejected unauthenticated websocket connection from %s", r.RemoteAddr)
+		writeError(w, r, http.StatusUnauthorized, CodeUnauthorized, "Unauthorized")
+		return
+	}
+
+	noteRequest(r, claims.Id, "")
+
+	if !claims.allows(ScopeChatRead) {
+		writeError(w, r, http.StatusForbidden, CodeInsufficientScope, "Token lacks the chat:read scope")
+		return
+	}
+
 	ws, err := upgrader.Upgrade(w, r, nil)
 	if err != nil {
 		log.Printf("WebSocket upgrade error: %v", err)
@@ -32,32 +56,58 @@ func HandleWebSocket(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	client := &Client{conn: ws, id: msg.From}
+	if msg.From != 0 && msg.From != claims.Id {
+		log.Printf("Rejected connect as user %d from authenticated user %d", msg.From, claims.Id)
+		ws.WriteMessage(websocket.CloseMessage, websocket.FormatCloseMessage(websocket.ClosePolicyViolation, "user mismatch"))
+		return
+	}
+
+	client := &Client{conn: ws, id: claims.Id, tokenID: claims.TokenID}
+	if session, ok := currentSession(r); ok {
+		client.sessionRecordID = session.recordID // authenticate prefers the session
+	}
 	hub.addClient(client)
 
 	log.Printf("Client connected: %d", client.id)
 
 	// Send conversation list immediately after connection
-	hub.sendConversationList(client)
-
-	go func() {
-		defer func() {
-			hub.removeClient(client)
-			log.Printf("Client disconnected: %d", client.id)
-		}()
-
-		for {
-			var msg Message
-			err := ws.ReadJSON(&msg)
-			if err != nil {
-				if websocket.IsUnexpectedCloseError(err, websocket.CloseGoingAway, websocket.CloseAbnormalClosure) {
-					log.Printf("Unexpected close error: %v", err)
-				}
-				break
+	hub.welcome(client)
+
+	// Serve the connection on this goroutine, the deferred Close must not run before the client leaves
+	defer func() {
+		hub.removeClient(client)
+		log.Printf("Client disconnected: %d", client.id)
+	}()
+
+	for {
+		var msg Message
+		err := ws.ReadJSON(&msg)
+		if err != nil {
+			if websocket.IsUnexpectedCloseError(err, websocket.CloseGoingAway, websocket.CloseAbnormalClosure) {
+				log.Printf("Unexpected close error: %v", err)
 			}
+			break
+		}
 
-			log.Printf("Received message from %d: %+v", client.id, msg)
-			hub.processs(msg)
+		// Messages are always sent as the authenticated user
+		if msg.From != 0 && msg.From != client.id {
+			log.Printf("Ignored message from client %d claiming to be user %d", client.id, msg.From)
+			continue
 		}
-	}()
+		msg.From = client.id
+
+		if (msg.Type == "message" || msg.Type == "create_conversation") && !claims.allows(ScopeChatWrite) {
+			hub.sendError(client, "Sending messages needs a token with the chat:write scope")
+			continue
+		}
+
+		// Unverified accounts can read their conversations but not write to them
+		if (msg.Type == "message" || msg.Type == "create_conversation") && !isVerified(client.id) {
+			hub.sendError(client, "Verify your email address before sending messages")
+			continue
+		}
+
+		log.Printf("Received message from %d: %+v", client.id, msg)
+		hub.processs(msg)
+	}
 }
@@ -0,0 +1,219 @@
+package api
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gorilla/websocket"
+)
+
+// newHubServer serves the websocket for the test
+func newHubServer(t *testing.T) string {
+	server := httptest.NewServer(http.HandlerFunc(HandleWebSocket))
+	t.Cleanup(server.Close)
+	return "ws" + strings.TrimPrefix(server.URL, "http")
+}
+
+// connectHub opens a websocket with the credentials in header, sends the connect message
+// and reads the conversation list the hub answers with
+func connectHub(t *testing.T, url string, header http.Header) *websocket.Conn {
+	t.Helper()
+
+	conn, _, err := websocket.DefaultDialer.Dial(url, header)
+	if err != nil {
+		t.Fatalf("Failed to open websocket: %v", err)
+	}
+	t.Cleanup(func() { conn.Close() })
+
+	if err := conn.WriteJSON(Message{Type: "connect"}); err != nil {
+		t.Fatalf("Failed to send connect: %v", err)
+	}
+
+	var welcome Message
+	conn.SetReadDeadline(time.Now().Add(2 * time.Second))
+	if err := conn.ReadJSON(&welcome); err != nil || welcome.Type != "conversation_list" {
+		t.Fatalf("Expected the conversation list, got %+v, %v", welcome, err)
+	}
+	return conn
+}
+
+// connected reports whether the server still serves a websocket: it is closed if reading fails
+// before the deadline, and open if the read times out
+func connected(conn *websocket.Conn) bool {
+	conn.SetReadDeadline(time.Now().Add(200 * time.Millisecond))
+	_, _, err := conn.ReadMessage()
+	if netErr, ok := err.(interface{ Timeout() bool }); ok && netErr.Timeout() {
+		return true
+	}
+	return err == nil
+}
+
+func cookieHeader(cookie *http.Cookie) http.Header {
+	return http.Header{"Cookie": {cookie.String()}}
+}
+
+func bearerHeader(user loginResponse) http.Header {
+	return http.Header{"Authorization": {"Bearer " + user.Token}}
+}
+
+func TestWebSocketHandshake(t *testing.T) {
+	url := newHubServer(t)
+	user, session := login(t, "user1@test.dev")
+
+	tests := []struct {
+		name    string
+		header  http.Header
+		connect Message
+		status  int  // of the handshake, 101 if the websocket opens
+		closed  bool // the server closes it after the connect message
+	}{
+		{"no credentials", nil, Message{Type: "connect"}, http.StatusUnauthorized, false},
+		{"bad bearer token", http.Header{"Authorization": {"Bearer nope"}}, Message{Type: "connect"}, http.StatusUnauthorized, false},
+		{"session", cookieHeader(session), Message{Type: "connect"}, http.StatusSwitchingProtocols, false},
+		{"bearer token", bearerHeader(user), Message{Type: "connect", From: 1}, http.StatusSwitchingProtocols, false},
+		{"connect as another user", bearerHeader(user), Message{Type: "connect", From: 2}, http.StatusSwitchingProtocols, true},
+		{"no connect message", bearerHeader(user), Message{Type: "message", From: 1}, http.StatusSwitchingProtocols, true},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			conn, response, err := websocket.DefaultDialer.Dial(url, test.header)
+			if response == nil {
+				t.Fatalf("Handshake failed: %v", err)
+			}
+			if response.StatusCode != test.status {
+				t.Fatalf("Expected handshake status %d, got %d", test.status, response.StatusCode)
+			}
+			if conn == nil {
+				return
+			}
+			defer conn.Close()
+
+			if err := conn.WriteJSON(test.connect); err != nil {
+				t.Fatal(err)
+			}
+			conn.SetReadDeadline(time.Now().Add(2 * time.Second))
+			var welcome Message
+			err = conn.ReadJSON(&welcome)
+			if test.closed && err == nil {
+				t.Errorf("Expected the server to close the websocket, got %+v", welcome)
+			}
+			if !test.closed && (err != nil || welcome.Type != "conversation_list") {
+				t.Errorf("Expected the conversation list, got %+v, %v", welcome, err)
+			}
+		})
+	}
+}
+
+func TestWebSocketClosedWhenLoginRevoked(t *testing.T) {
+	url := newHubServer(t)
+
+	tests := []struct {
+		name string
+		// revoke revokes logins of a user with the other login, which must stay connected
+		revoke     func(t *testing.T, revoked loginResponse, revokedSession *http.Cookie, other loginResponse, otherSession *http.Cookie)
+		viaSession bool // the revoked websocket uses the session, else the bearer token
+	}{
+		{"logout of the session", func(t *testing.T, revoked loginResponse, session *http.Cookie, _ loginResponse, _ *http.Cookie) {
+			callAction(t, map[string]string{"action": "logout"}, sessionHeader(revoked), session)
+		}, true},
+		{"logout of the bearer token", func(t *testing.T, revoked loginResponse, _ *http.Cookie, _ loginResponse, _ *http.Cookie) {
+			callAction(t, map[string]string{"action": "logout"}, bearerHeader(revoked))
+		}, false},
+		{"revoke_session", func(t *testing.T, revoked loginResponse, session *http.Cookie, other loginResponse, otherSession *http.Cookie) {
+			sessions := decodeResponse[sessionsResponse](t,
+				callAction(t, map[string]string{"action": "list_sessions"}, sessionHeader(revoked), session), http.StatusOK)
+			for _, s := range sessions.Sessions {
+				if s.Current {
+					callAction(t, map[string]any{"action": "revoke_session", "sessionId": s.ID}, sessionHeader(other), otherSession)
+				}
+			}
+		}, true},
+		{"revoke_all_other_sessions", func(t *testing.T, _ loginResponse, _ *http.Cookie, other loginResponse, otherSession *http.Cookie) {
+			callAction(t, map[string]string{"action": "revoke_all_other_sessions"}, sessionHeader(other), otherSession)
+		}, true},
+		{"password change, session", func(t *testing.T, _ loginResponse, _ *http.Cookie, other loginResponse, otherSession *http.Cookie) {
+			callAction(t, map[string]string{"action": "change_password", "currentPassword": "123",
+				"newPassword": "a much stronger passphrase 42"}, sessionHeader(other), otherSession)
+		}, true},
+		{"password change, bearer token", func(t *testing.T, _ loginResponse, _ *http.Cookie, other loginResponse, otherSession *http.Cookie) {
+			callAction(t, map[string]string{"action": "change_password", "currentPassword": "123",
+				"newPassword": "a much stronger passphrase 42"}, sessionHeader(other), otherSession)
+		}, false},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			_, email := newUser(t)
+			revoked, revokedSession := login(t, email)
+			other, otherSession := login(t, email)
+
+			header := bearerHeader(revoked)
+			if test.viaSession {
+				header = cookieHeader(revokedSession)
+			}
+			conn := connectHub(t, url, header)
+			otherConn := connectHub(t, url, cookieHeader(otherSession))
+
+			test.revoke(t, revoked, revokedSession, other, otherSession)
+
+			if connected(conn) {
+				t.Errorf("Expected the websocket of the revoked login to be closed")
+			}
+			if !connected(otherConn) {
+				t.Errorf("Expected the websocket of the other login to stay open")
+			}
+		})
+	}
+}
+
+func TestCloseHub(t *testing.T) {
+	url := newHubServer(t)
+	user, session := login(t, "user1@test.dev")
+	t.Cleanup(func() {
+		hub.Lock()
+		hub.goodbye = nil
+		hub.Unlock()
+	})
+
+	conns := []*websocket.Conn{connectHub(t, url, bearerHeader(user)), connectHub(t, url, cookieHeader(session))}
+	CloseHub("server restarting")
+
+	// closedWith reads until the close frame of the server
+	closedWith := func(conn *websocket.Conn) error {
+		conn.SetReadDeadline(time.Now().Add(2 * time.Second))
+		for {
+			if _, _, err := conn.ReadMessage(); err != nil {
+				return err
+			}
+		}
+	}
+
+	for i, conn := range conns {
+		if err := closedWith(conn); !websocket.IsCloseError(err, websocket.CloseServiceRestart) || !strings.Contains(err.Error(), "server restarting") {
+			t.Errorf("Expected client %d to be told the server is restarting, got %v", i, err)
+		}
+	}
+	if count := hub.clientCount.Load(); count != 0 {
+		t.Errorf("Expected no clients left, got %d", count)
+	}
+
+	// Clients connecting during the shutdown are turned away the same way
+	conn, _, err := websocket.DefaultDialer.Dial(url, bearerHeader(user))
+	if err != nil {
+		t.Fatalf("Failed to open websocket: %v", err)
+	}
+	defer conn.Close()
+	if err := conn.WriteJSON(Message{Type: "connect"}); err != nil {
+		t.Fatalf("Failed to send connect: %v", err)
+	}
+	if err := closedWith(conn); !websocket.IsCloseError(err, websocket.CloseServiceRestart) {
+		t.Errorf("Expected a new client to be turned away, got %v", err)
+	}
+	if count := hub.clientCount.Load(); count != 0 {
+		t.Errorf("Expected the new client not to be added, got %d clients", count)
+	}
+}
@@ -1,6 +1,4 @@
 -- Revert notifications table to its old structure
-BEGIN TRANSACTION;
-
 DROP TABLE IF EXISTS notifications_temp;
 
 -- Old structure (no sender_id, related_id as TEXT, fewer types)
@@ -34,5 +32,3 @@ ALTER TABLE notifications_temp RENAME TO notifications;
 CREATE INDEX idx_notifications_user_id ON notifications(user_id);
 CREATE INDEX idx_notifications_is_read ON notifications(is_read);
 CREATE INDEX idx_notifications_created_at ON notifications(created_at);
-
-COMMIT;
@@ -1,6 +1,4 @@
 -- Update notifications table to support like and comment notification types
-BEGIN TRANSACTION;
-
 DROP TABLE IF EXISTS notifications_temp;
 
 CREATE TABLE notifications_temp (
@@ -36,5 +34,3 @@ CREATE INDEX idx_notifications_user_id ON notifications(user_id);
 CREATE INDEX idx_notifications_is_read ON notifications(is_read);
 CREATE INDEX idx_notifications_created_at ON notifications(created_at);
 CREATE INDEX idx_notifications_sender_id ON notifications(sender_id);
-
-COMMIT;
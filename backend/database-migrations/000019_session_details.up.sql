@@ -0,0 +1,3 @@
+ALTER TABLE sessions ADD COLUMN user_agent TEXT DEFAULT '';
+ALTER TABLE sessions ADD COLUMN ip TEXT DEFAULT '';
+ALTER TABLE sessions ADD COLUMN last_seen_at DATETIME;
@@ -0,0 +1,2 @@
+-- Bearer and refresh tokens issued before this time are rejected
+ALTER TABLE user ADD COLUMN tokens_valid_after DATETIME;
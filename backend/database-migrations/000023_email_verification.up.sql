@@ -0,0 +1,17 @@
+ALTER TABLE user ADD COLUMN verified BOOLEAN DEFAULT FALSE NOT NULL;
+
+-- Accounts created before verification existed keep working
+UPDATE user SET verified = TRUE;
+
+CREATE TABLE IF NOT EXISTS email_verification_tokens (
+    id         INTEGER PRIMARY KEY AUTOINCREMENT,
+    user_id    INTEGER NOT NULL,
+    email      TEXT NOT NULL, -- the address the token proves ownership of
+    token_hash TEXT NOT NULL UNIQUE,
+    expires_at DATETIME NOT NULL,
+    used_at    DATETIME,
+    created_at DATETIME DEFAULT CURRENT_TIMESTAMP,
+    FOREIGN KEY (user_id) REFERENCES user(id) ON DELETE CASCADE
+);
+
+CREATE INDEX idx_email_verification_tokens_user_id ON email_verification_tokens(user_id, created_at);
@@ -0,0 +1,18 @@
+CREATE TABLE IF NOT EXISTS user_totp (
+    user_id        INTEGER PRIMARY KEY,
+    secret         TEXT NOT NULL,           -- base32 TOTP secret
+    confirmed_at   DATETIME,                -- NULL while enrollment is pending
+    last_used_step INTEGER DEFAULT 0 NOT NULL, -- time step of the last accepted code, codes can't be reused
+    created_at     DATETIME DEFAULT CURRENT_TIMESTAMP,
+    FOREIGN KEY (user_id) REFERENCES user(id) ON DELETE CASCADE
+);
+
+CREATE TABLE IF NOT EXISTS recovery_codes (
+    id        INTEGER PRIMARY KEY AUTOINCREMENT,
+    user_id   INTEGER NOT NULL,
+    code_hash TEXT NOT NULL,
+    used_at   DATETIME,
+    FOREIGN KEY (user_id) REFERENCES user(id) ON DELETE CASCADE
+);
+
+CREATE INDEX idx_recovery_codes_user_id ON recovery_codes(user_id);
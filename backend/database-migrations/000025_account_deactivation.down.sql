@@ -0,0 +1,2 @@
+ALTER TABLE user DROP COLUMN delete_after;
+ALTER TABLE user DROP COLUMN deactivated_at;
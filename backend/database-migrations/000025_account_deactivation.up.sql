@@ -0,0 +1,5 @@
+-- Deactivated accounts are hidden until the user logs in again
+ALTER TABLE user ADD COLUMN deactivated_at DATETIME;
+
+-- Accounts are deleted for good once this time has passed, unless the user logs in before
+ALTER TABLE user ADD COLUMN delete_after DATETIME;
@@ -0,0 +1,22 @@
+-- Logins started with oidc_start, waiting for the provider to redirect back
+CREATE TABLE IF NOT EXISTS oidc_login_states (
+    state         TEXT PRIMARY KEY,
+    nonce         TEXT NOT NULL,
+    code_verifier TEXT NOT NULL, -- PKCE verifier, only its hash was sent to the provider
+    expires_at    DATETIME NOT NULL,
+    created_at    DATETIME DEFAULT CURRENT_TIMESTAMP
+);
+
+-- Provider accounts linked to users
+CREATE TABLE IF NOT EXISTS user_identities (
+    id         INTEGER PRIMARY KEY AUTOINCREMENT,
+    user_id    INTEGER NOT NULL,
+    issuer     TEXT NOT NULL,
+    subject    TEXT NOT NULL,
+    email      TEXT NOT NULL DEFAULT '',
+    created_at DATETIME DEFAULT CURRENT_TIMESTAMP,
+    FOREIGN KEY (user_id) REFERENCES user(id) ON DELETE CASCADE,
+    UNIQUE(issuer, subject)
+);
+
+CREATE INDEX idx_user_identities_user_id ON user_identities(user_id);
@@ -0,0 +1,2 @@
+ALTER TABLE user DROP COLUMN suspended_at;
+ALTER TABLE user DROP COLUMN role;
@@ -0,0 +1,5 @@
+-- One of 'user', 'moderator' or 'admin', see api/roles.go for what each may do
+ALTER TABLE user ADD COLUMN role TEXT NOT NULL DEFAULT 'user';
+
+-- Suspended users can't log in until a moderator or admin lifts the suspension
+ALTER TABLE user ADD COLUMN suspended_at DATETIME;
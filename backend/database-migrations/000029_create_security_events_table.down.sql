@@ -0,0 +1,5 @@
+DROP TRIGGER IF EXISTS security_events_no_delete;
+DROP TRIGGER IF EXISTS security_events_no_update;
+DROP INDEX IF EXISTS idx_security_events_type;
+DROP INDEX IF EXISTS idx_security_events_user_id;
+DROP TABLE IF EXISTS security_events;
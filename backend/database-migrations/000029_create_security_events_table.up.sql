@@ -0,0 +1,26 @@
+CREATE TABLE IF NOT EXISTS security_events (
+    id         INTEGER PRIMARY KEY AUTOINCREMENT,
+    user_id    INTEGER,       -- NULL for events about unknown accounts, e.g. a failed login
+    type       TEXT NOT NULL, -- e.g. "login_succeeded", see api/security-events.go
+    ip         TEXT,
+    user_agent TEXT,
+    details    TEXT,          -- JSON object with event specific details
+    created_at DATETIME DEFAULT CURRENT_TIMESTAMP
+);
+
+CREATE INDEX idx_security_events_user_id ON security_events(user_id, created_at);
+CREATE INDEX idx_security_events_type ON security_events(type, created_at);
+
+-- The audit log is append-only: events can't be changed, and only removed once they are past retention
+CREATE TRIGGER security_events_no_update
+BEFORE UPDATE ON security_events
+BEGIN
+    SELECT RAISE(ABORT, 'security events are append-only');
+END;
+
+CREATE TRIGGER security_events_no_delete
+BEFORE DELETE ON security_events
+WHEN OLD.created_at > datetime('now', '-365 days')
+BEGIN
+    SELECT RAISE(ABORT, 'security events are kept for 365 days');
+END;
@@ -0,0 +1,165 @@
+package db
+
+import (
+	"database/sql"
+	"fmt"
+	"time"
+)
+
+// DeactivateUser hides a user, their posts and their comments until they log in again
+func (db *Database) DeactivateUser(userID int) error {
+	_, err := db.db.Exec(`UPDATE user SET deactivated_at = datetime('now') WHERE id = ?`, userID)
+	if err != nil {
+		return fmt.Errorf("failed to deactivate user: %w", err)
+	}
+	return nil
+}
+
+// ScheduleUserDeletion deactivates a user and deletes the account for good after deleteAfter
+func (db *Database) ScheduleUserDeletion(userID int, deleteAfter time.Time) error {
+	_, err := db.db.Exec(`
+		UPDATE user SET deactivated_at = datetime('now'), delete_after = ? WHERE id = ?
+	`, sqlTime(deleteAfter), userID)
+	if err != nil {
+		return fmt.Errorf("failed to schedule user deletion: %w", err)
+	}
+	return nil
+}
+
+// ReactivateUser makes a deactivated user visible again and cancels a scheduled deletion.
+// Returns false if the user was not deactivated.
+func (db *Database) ReactivateUser(userID int) (bool, error) {
+	result, err := db.db.Exec(`
+		UPDATE user SET deactivated_at = NULL, delete_after = NULL WHERE id = ? AND deactivated_at IS NOT NULL
+	`, userID)
+	if err != nil {
+		return false, fmt.Errorf("failed to reactivate user: %w", err)
+	}
+
+	count, err := result.RowsAffected()
+	if err != nil {
+		return false, fmt.Errorf("failed to get rows affected: %w", err)
+	}
+
+	return count > 0, nil
+}
+
+// FetchUsersDueForDeletion returns the users whose deletion grace period is over
+func (db *Database) FetchUsersDueForDeletion() ([]int, error) {
+	rows, err := db.db.Query(`SELECT id FROM user WHERE delete_after IS NOT NULL AND delete_after <= datetime('now')`)
+	if err != nil {
+		return nil, fmt.Errorf("failed to query users due for deletion: %w", err)
+	}
+	defer rows.Close()
+
+	var ids []int
+	for rows.Next() {
+		var id int
+		if err := rows.Scan(&id); err != nil {
+			return nil, fmt.Errorf("failed to scan user id: %w", err)
+		}
+		ids = append(ids, id)
+	}
+
+	return ids, rows.Err()
+}
+
+// accountDeletionSteps remove everything that belongs to a user, in order.
+// Foreign keys are not enforced and several user columns are TEXT, so nothing cascades
+// and every table has to be cleaned explicitly. Files are found through the
+// "/file?id=N" image paths, so they go before the posts and comments pointing at them.
+// Security events are kept until they expire, the audit log is append-only.
+var accountDeletionSteps = []struct {
+	name  string
+	query string
+}{
+	{"profile picture", `
+		DELETE FROM file WHERE id = (SELECT CAST(profile_picture AS INTEGER) FROM user WHERE id = :user)`},
+	{"post images", `
+		DELETE FROM file WHERE id IN (
+			SELECT CAST(substr(image_path, 10) AS INTEGER) FROM posts
+			WHERE user_id = :user AND image_path LIKE '/file?id=%')`},
+	{"comment images", `
+		DELETE FROM file WHERE id IN (
+			SELECT CAST(substr(image_path, 10) AS INTEGER) FROM comments
+			WHERE (user_id = :user OR post_id IN (SELECT id FROM posts WHERE user_id = :user))
+				AND image_path LIKE '/file?id=%')`},
+	{"notifications", `
+		DELETE FROM notifications WHERE user_id = :user OR sender_id = :user`},
+	{"likes", `
+		DELETE FROM likes WHERE user_id = :user OR post_id IN (SELECT id FROM posts WHERE user_id = :user)`},
+	{"comments", `
+		DELETE FROM comments WHERE user_id = :user OR post_id IN (SELECT id FROM posts WHERE user_id = :user)`},
+	{"post permissions", `
+		DELETE FROM post_permissions WHERE user_id = :user OR post_id IN (SELECT id FROM posts WHERE user_id = :user)`},
+	{"posts", `
+		DELETE FROM posts WHERE user_id = :user`},
+	{"follows", `
+		DELETE FROM follows WHERE follower_id = :user OR followed_id = :user`},
+	// Groups go with their creator, and so do their events, members and notifications.
+	// Events the user created in other groups go too. There is no table of event responses yet.
+	{"group and event notifications", `
+		DELETE FROM notifications
+		WHERE (type IN ('group_invitation', 'group_request')
+				AND related_id IN (SELECT id FROM groups WHERE creator_id = :user))
+			OR (type = 'event_created' AND related_id IN (
+				SELECT id FROM events
+				WHERE creator_id = :user OR group_id IN (SELECT id FROM groups WHERE creator_id = :user)))`},
+	{"events", `
+		DELETE FROM events WHERE creator_id = :user OR group_id IN (SELECT id FROM groups WHERE creator_id = :user)`},
+	{"members of created groups", `
+		DELETE FROM group_members WHERE group_id IN (SELECT id FROM groups WHERE creator_id = :user)`},
+	{"groups", `
+		DELETE FROM groups WHERE creator_id = :user`},
+	{"group memberships", `
+		DELETE FROM group_members WHERE user_id = :user`},
+	{"group invitations", `
+		UPDATE group_members SET invited_by = NULL WHERE invited_by = :user`},
+	// Messages stay readable for the other participants, without a sender
+	{"sent messages", `
+		UPDATE message SET sender = NULL WHERE sender = :user`},
+	{"conversation participation", `
+		DELETE FROM conversation_participant WHERE user = :user`},
+	{"abandoned messages", `
+		DELETE FROM message WHERE conversation NOT IN (SELECT conversation FROM conversation_participant)`},
+	{"abandoned conversations", `
+		DELETE FROM conversation WHERE id NOT IN (SELECT conversation FROM conversation_participant)`},
+	{"sessions", `
+		DELETE FROM sessions WHERE user_id = :user`},
+	{"password reset tokens", `
+		DELETE FROM password_reset_tokens WHERE user_id = :user`},
+	{"email verification tokens", `
+		DELETE FROM email_verification_tokens WHERE user_id = :user`},
+	{"recovery codes", `
+		DELETE FROM recovery_codes WHERE user_id = :user`},
+	{"totp secret", `
+		DELETE FROM user_totp WHERE user_id = :user`},
+	{"personal access tokens", `
+		DELETE FROM personal_access_tokens WHERE user_id = :user`},
+	{"linked identities", `
+		DELETE FROM user_identities WHERE user_id = :user`},
+	{"login attempts", `
+		DELETE FROM login_attempts WHERE email = (SELECT lower(email) FROM user WHERE id = :user)`},
+	{"user", `
+		DELETE FROM user WHERE id = :user`},
+}
+
+// DeleteUserAccount permanently removes a user and everything they created, in one transaction
+func (db *Database) DeleteUserAccount(userID int) error {
+	tx, err := db.begin()
+	if err != nil {
+		return fmt.Errorf("failed to begin transaction: %w", err)
+	}
+	defer tx.Rollback()
+
+	for _, step := range accountDeletionSteps {
+		if _, err := tx.Exec(step.query, sql.Named("user", userID)); err != nil {
+			return fmt.Errorf("failed to delete %s: %w", step.name, err)
+		}
+	}
+
+	if err := tx.Commit(); err != nil {
+		return fmt.Errorf("failed to commit account deletion: %w", err)
+	}
+	return nil
+}
@@ -0,0 +1,97 @@
+package db
+
+import (
+	"fmt"
+	"time"
+)
+
+// UserSummary is a user as listed to moderators and admins
+type UserSummary struct {
+	Id          int       `json:"id"`
+	Email       string    `json:"email"`
+	FirstName   string    `json:"firstName"`
+	LastName    string    `json:"lastName"`
+	Nickname    string    `json:"nickname,omitempty"`
+	Role        string    `json:"role"`
+	Verified    bool      `json:"verified"`
+	Deactivated bool      `json:"deactivated"`
+	Suspended   bool      `json:"suspended"`
+	CreatedAt   time.Time `json:"createdAt"`
+}
+
+// FetchUserRole returns the role of a user
+func (db *Database) FetchUserRole(userID int) (string, error) {
+	var role string
+	err := db.db.QueryRow(`SELECT role FROM user WHERE id = ?`, userID).Scan(&role)
+	if err != nil {
+		return "", fmt.Errorf("failed to fetch user role: %w", err)
+	}
+	return role, nil
+}
+
+// SetUserRole changes the role of a user
+func (db *Database) SetUserRole(userID int, role string) error {
+	_, err := db.db.Exec(`UPDATE user SET role = ? WHERE id = ?`, role, userID)
+	if err != nil {
+		return fmt.Errorf("failed to set user role: %w", err)
+	}
+	return nil
+}
+
+// SetUserRoleByEmail changes the role of a user, returns false if there is no such user
+func (db *Database) SetUserRoleByEmail(email string, role string) (bool, error) {
+	result, err := db.db.Exec(`UPDATE user SET role = ? WHERE email = ?`, role, email)
+	if err != nil {
+		return false, fmt.Errorf("failed to set user role: %w", err)
+	}
+
+	count, err := result.RowsAffected()
+	if err != nil {
+		return false, fmt.Errorf("failed to get rows affected: %w", err)
+	}
+
+	return count > 0, nil
+}
+
+// SetUserSuspended suspends a user or lifts their suspension
+func (db *Database) SetUserSuspended(userID int, suspended bool) error {
+	query := `UPDATE user SET suspended_at = NULL WHERE id = ?`
+	if suspended {
+		query = `UPDATE user SET suspended_at = datetime('now') WHERE id = ? AND suspended_at IS NULL`
+	}
+
+	if _, err := db.db.Exec(query, userID); err != nil {
+		return fmt.Errorf("failed to update user suspension: %w", err)
+	}
+	return nil
+}
+
+// ListUsers returns users whose email or name contains search, newest first
+func (db *Database) ListUsers(search string, limit int, offset int) ([]UserSummary, error) {
+	pattern := "%" + search + "%"
+	rows, err := db.db.Query(`
+		SELECT id, email, first_name, last_name, COALESCE(nickname, ''), role, verified,
+			deactivated_at IS NOT NULL, suspended_at IS NOT NULL, created_at
+		FROM user
+		WHERE email LIKE ? OR first_name || ' ' || last_name LIKE ? OR nickname LIKE ?
+		ORDER BY id DESC
+		LIMIT ? OFFSET ?
+	`, pattern, pattern, pattern, limit, offset)
+	if err != nil {
+		return nil, fmt.Errorf("failed to query users: %w", err)
+	}
+	defer rows.Close()
+
+	users := []UserSummary{}
+	for rows.Next() {
+		var u UserSummary
+		err := rows.Scan(&u.Id, &u.Email, &u.FirstName, &u.LastName, &u.Nickname, &u.Role, &u.Verified,
+			&u.Deactivated, &u.Suspended, &u.CreatedAt)
+		if err != nil {
+			return nil, fmt.Errorf("failed to scan user: %w", err)
+		}
+		users = append(users, u)
+	}
+
+	return users, rows.Err()
+}
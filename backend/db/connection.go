@@ -15,7 +15,9 @@ import (
 
 // Database struct manages the database connection and table name.
 type Database struct {
-	db *sql.DB
+	db         handle  // where queries run, the pool or a transaction
+	pool       *sql.DB // nil for a Database bound to a transaction, see InTransaction
+	migrations string  // directory of the migration files, see MigrationStatus
 }
 
 func (d Database) GetUserPosts(param1 int) ([]map[string]interface{}, error) {
@@ -42,65 +44,67 @@ func (d *Database) OpenOrCreate(dbPath string, migrationsPath string) error {
 	_, err := os.Stat(dbPath)
 
 	if err == nil {
-		log.Printf("Database file '%s' already exists. Skipping migrations.", dbPath)
-		return d.Open(dbPath)
+		log.Printf("Database file '%s' already exists. Applying pending migrations...", dbPath)
+	} else if errors.Is(err, os.ErrNotExist) {
+		log.Printf("Database file '%s' not found. Attempting to create and migrate...", dbPath)
+	} else {
+		// Another error occurred during os.Stat (e.g., permission denied)
+		log.Fatalf("Error checking database file '%s': %v", dbPath, err)
 	}
 
-	if errors.Is(err, os.ErrNotExist) {
-		log.Printf("Database file '%s' not found. Attempting to create and migrate...", dbPath)
+	migrateUp(dbPath, migrationsPath)
+	d.migrations = migrationsPath
 
-		log.Println("Initializing migration instance...")
-		m, err := migrate.New("file://"+migrationsPath, "sqlite://"+dbPath)
-		if err != nil {
-			log.Fatalf("Failed to create migration instance: %v", err)
-		}
+	return d.Open(dbPath)
+}
 
-		log.Println("Applying migrations...")
-		err = m.Up()
-		if err != nil {
-			// Check if the error is "no change" - shouldn't happen on first run, but good practice
-			if errors.Is(err, migrate.ErrNoChange) {
-				log.Println("No migrations to apply (though DB file was initially missing).")
-			} else {
-				// For any other error, log fatally
-				log.Fatalf("Migration failed: %v", err)
-			}
-		} else {
-			log.Println("Database created and migrations applied successfully!")
-		}
+// migrateUp applies every migration that has not been applied to the database yet
+func migrateUp(dbPath string, migrationsPath string) {
+	log.Println("Initializing migration instance...")
+	m, err := migrate.New("file://"+migrationsPath, "sqlite://"+dbPath)
+	if err != nil {
+		log.Fatalf("Failed to create migration instance: %v", err)
+	}
 
-		// --- Check Migration Status (Optional but good after migrating) ---
-		version, dirty, err := m.Version()
-		if err != nil {
-			log.Printf("Warning: Could not get migration version after applying: %v", err)
+	log.Println("Applying migrations...")
+	err = m.Up()
+	if err != nil {
+		if errors.Is(err, migrate.ErrNoChange) {
+			log.Println("No migrations to apply, database is up to date.")
 		} else {
-			log.Printf("Current migration version: %d, Dirty state: %v", version, dirty)
-			if dirty {
-				log.Println("WARNING: Migration state is dirty. Manual intervention might be required.")
-			}
-		}
-
-		// Close resources
-		sourceErr, dbErr := m.Close()
-		if sourceErr != nil {
-			log.Printf("Warning: Error closing migration source: %v", sourceErr)
-		}
-		if dbErr != nil {
-			log.Printf("Warning: Error closing migration database connection: %v", dbErr)
+			// For any other error, log fatally
+			log.Fatalf("Migration failed: %v", err)
 		}
+	} else {
+		log.Println("Migrations applied successfully!")
+	}
 
+	// --- Check Migration Status (Optional but good after migrating) ---
+	version, dirty, err := m.Version()
+	if err != nil {
+		log.Printf("Warning: Could not get migration version after applying: %v", err)
 	} else {
-		// Another error occurred during os.Stat (e.g., permission denied)
-		log.Fatalf("Error checking database file '%s': %v", dbPath, err)
+		log.Printf("Current migration version: %d, Dirty state: %v", version, dirty)
+		if dirty {
+			log.Println("WARNING: Migration state is dirty. Manual intervention might be required.")
+		}
 	}
 
-	return d.Open(dbPath)
+	// Close resources
+	sourceErr, dbErr := m.Close()
+	if sourceErr != nil {
+		log.Printf("Warning: Error closing migration source: %v", sourceErr)
+	}
+	if dbErr != nil {
+		log.Printf("Warning: Error closing migration database connection: %v", dbErr)
+	}
 }
 
 // Open database connection
 func (d *Database) Open(dbPath string) error {
 
-	db, err := sql.Open("sqlite", dbPath)
+	// Wait for locks instead of failing at once, batch transactions hold the write lock for a while
+	db, err := sql.Open("sqlite", dbPath+"?_pragma=busy_timeout(5000)")
 	if err != nil {
 		return fmt.Errorf("failed to open database: %w", err)
 	}
@@ -109,13 +113,14 @@ func (d *Database) Open(dbPath string) error {
 		return fmt.Errorf("failed to ping database: %w", err)
 	}
 
-	d.db = db
+	d.db = timedHandle{db}
+	d.pool = db
 	return nil
 }
 
 // Close closes the database connection.
 func (d *Database) Close() error {
-	return d.db.Close()
+	return d.pool.Close()
 }
 
 // Query executes a query that returns rows
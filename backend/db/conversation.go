@@ -62,7 +62,7 @@ func (db *Database) CreateDirectConversation(user1ID, user2ID int) (int, error)
 		return 0, fmt.Errorf("cannot create a direct conversation with oneself (user ID: %d)", user1ID)
 	}
 
-	tx, err := db.db.Begin()
+	tx, err := db.begin()
 	if err != nil {
 		return 0, fmt.Errorf("failed to begin transaction: %w", err)
 	}
@@ -136,6 +136,18 @@ func (db *Database) CreateMessage(conversationID int, senderID int, content *str
 	return messageID, nil
 }
 
+// IsConversationParticipant reports whether a user is part of a conversation
+func (db *Database) IsConversationParticipant(conversationID int, userID int) (bool, error) {
+	var exists bool
+	err := db.db.QueryRow(`
+		SELECT EXISTS(SELECT 1 FROM conversation_participant WHERE conversation = ? AND user = ?)
+	`, conversationID, userID).Scan(&exists)
+	if err != nil {
+		return false, fmt.Errorf("failed to check conversation participant: %w", err)
+	}
+	return exists, nil
+}
+
 // UpdateMessageStatus updates the status of a specific message.
 // status must be one of 'sent', 'delivered', 'read'.
 func (db *Database) UpdateMessageStatus(messageID int, status string) error {
@@ -0,0 +1,83 @@
+package db
+
+import (
+	"database/sql"
+	"fmt"
+	"time"
+)
+
+// CreateEmailVerificationToken stores the hash of a token proving that a user owns an email address
+func (db *Database) CreateEmailVerificationToken(userID int, email string, tokenHash string, expiresAt time.Time) error {
+	_, err := db.db.Exec(`
+		INSERT INTO email_verification_tokens (user_id, email, token_hash, expires_at) VALUES (?, ?, ?, ?)
+	`, userID, email, tokenHash, sqlTime(expiresAt))
+	if err != nil {
+		return fmt.Errorf("failed to insert verification token: %w", err)
+	}
+	return nil
+}
+
+// ConsumeEmailVerificationToken marks a verification token as used and returns
+// the user and the email address it was sent to.
+// Returns sql.ErrNoRows if the token does not exist, has expired or was already used.
+func (db *Database) ConsumeEmailVerificationToken(tokenHash string) (int, string, error) {
+	var userID int
+	var email string
+	err := db.db.QueryRow(`
+		UPDATE email_verification_tokens
+		SET used_at = datetime('now')
+		WHERE token_hash = ? AND used_at IS NULL AND expires_at > datetime('now')
+		RETURNING user_id, email
+	`, tokenHash).Scan(&userID, &email)
+	if err != nil {
+		if err == sql.ErrNoRows {
+			return 0, "", err
+		}
+		return 0, "", fmt.Errorf("failed to consume verification token: %w", err)
+	}
+
+	return userID, email, nil
+}
+
+// EmailVerificationsSent counts the verification tokens created for a user within the window,
+// and returns how long ago the most recent one was
+func (db *Database) EmailVerificationsSent(userID int, window time.Duration) (int, time.Duration, error) {
+	var count int
+	var secondsAgo int64
+	err := db.db.QueryRow(`
+		SELECT COUNT(*),
+			COALESCE(CAST(strftime('%s', 'now') - strftime('%s', MAX(created_at)) AS INTEGER), 0)
+		FROM email_verification_tokens
+		WHERE user_id = ? AND created_at > ?
+	`, userID, sqlTime(time.Now().Add(-window))).Scan(&count, &secondsAgo)
+	if err != nil {
+		return 0, 0, fmt.Errorf("failed to count verification tokens: %w", err)
+	}
+
+	return count, time.Duration(secondsAgo) * time.Second, nil
+}
+
+// DeleteExpiredEmailVerificationTokens removes verification tokens that have expired and returns how many were removed
+func (db *Database) DeleteExpiredEmailVerificationTokens() (int64, error) {
+	result, err := db.db.Exec(`DELETE FROM email_verification_tokens WHERE expires_at <= datetime('now')`)
+	if err != nil {
+		return 0, fmt.Errorf("failed to delete expired verification tokens: %w", err)
+	}
+
+	count, err := result.RowsAffected()
+	if err != nil {
+		return 0, fmt.Errorf("failed to get rows affected: %w", err)
+	}
+
+	return count, nil
+}
+
+// DeleteUserEmailVerificationTokens removes the unused verification tokens of a user,
+// cancelling pending email changes
+func (db *Database) DeleteUserEmailVerificationTokens(userID int) error {
+	_, err := db.db.Exec(`DELETE FROM email_verification_tokens WHERE user_id = ? AND used_at IS NULL`, userID)
+	if err != nil {
+		return fmt.Errorf("failed to delete verification tokens: %w", err)
+	}
+	return nil
+}
@@ -4,7 +4,6 @@ import (
 	"database/sql"
 	"errors"
 	"fmt"
-	"log"
 )
 
 func (db *Database) UploadImage(filename string, mimetype string, imageData []byte) (int, error) {
@@ -49,13 +48,9 @@ func (db *Database) GetFileByID(id int) (*File, error) {
 	err := row.Scan(&file.ID, &file.Data, &file.Name, &file.Mimetype)
 	if err != nil {
 		if errors.Is(err, sql.ErrNoRows) {
-			log.Printf("file with id %d not found\n", id)
-		} else {
-			log.Printf("error scanning row: %w\n", err)
-
+			return nil, err
 		}
-
-		return nil, errors.New("file not found")
+		return nil, fmt.Errorf("failed to fetch file %d: %w", id, err)
 	}
 
 	return &file, nil
@@ -0,0 +1,68 @@
+package db
+
+import (
+	"fmt"
+	"time"
+)
+
+// RecordFailedLogin stores a failed login attempt for an email from an IP
+func (db *Database) RecordFailedLogin(email string, ip string) error {
+	_, err := db.db.Exec(`INSERT INTO login_attempts (email, ip) VALUES (?, ?)`, email, ip)
+	if err != nil {
+		return fmt.Errorf("failed to record login attempt: %w", err)
+	}
+	return nil
+}
+
+// FailedLoginsByEmail counts the failed logins for an email within the window,
+// and returns how long ago the most recent one was
+func (db *Database) FailedLoginsByEmail(email string, window time.Duration) (int, time.Duration, error) {
+	return db.failedLogins("email", email, window)
+}
+
+// FailedLoginsByIP counts the failed logins from an IP within the window,
+// and returns how long ago the most recent one was
+func (db *Database) FailedLoginsByIP(ip string, window time.Duration) (int, time.Duration, error) {
+	return db.failedLogins("ip", ip, window)
+}
+
+// failedLogins is shared by the email and ip lookups, column is never user input
+func (db *Database) failedLogins(column string, value string, window time.Duration) (int, time.Duration, error) {
+	var count int
+	var secondsAgo int64
+	err := db.db.QueryRow(`
+		SELECT COUNT(*),
+			COALESCE(CAST(strftime('%s', 'now') - strftime('%s', MAX(attempted_at)) AS INTEGER), 0)
+		FROM login_attempts
+		WHERE `+column+` = ? AND attempted_at > ?
+	`, value, sqlTime(time.Now().Add(-window))).Scan(&count, &secondsAgo)
+	if err != nil {
+		return 0, 0, fmt.Errorf("failed to count login attempts: %w", err)
+	}
+
+	return count, time.Duration(secondsAgo) * time.Second, nil
+}
+
+// ClearFailedLogins forgets the failed logins for an email, after a successful login
+func (db *Database) ClearFailedLogins(email string) error {
+	_, err := db.db.Exec(`DELETE FROM login_attempts WHERE email = ?`, email)
+	if err != nil {
+		return fmt.Errorf("failed to clear login attempts: %w", err)
+	}
+	return nil
+}
+
+// DeleteOldLoginAttempts removes login attempts older than the given age
+func (db *Database) DeleteOldLoginAttempts(age time.Duration) (int64, error) {
+	result, err := db.db.Exec(`DELETE FROM login_attempts WHERE attempted_at <= ?`, sqlTime(time.Now().Add(-age)))
+	if err != nil {
+		return 0, fmt.Errorf("failed to delete old login attempts: %w", err)
+	}
+
+	count, err := result.RowsAffected()
+	if err != nil {
+		return 0, fmt.Errorf("failed to get rows affected: %w", err)
+	}
+
+	return count, nil
+}
@@ -0,0 +1,66 @@
+package db
+
+import (
+	"backend/metrics"
+	"database/sql"
+	"strings"
+	"time"
+	"unicode"
+)
+
+var queryDuration = metrics.NewHistogram("socialnetwork_db_query_duration_seconds",
+	"How long the database took to answer a query, by kind of statement",
+	metrics.QueryBuckets, "statement")
+
+// timedHandle records how long the queries of a handle take. For Query it is the time
+// until the first rows are ready, reading them is not counted.
+type timedHandle struct {
+	handle
+}
+
+func (t timedHandle) Exec(query string, args ...any) (sql.Result, error) {
+	writes.add()
+	defer writes.done()
+	defer observeQuery(time.Now(), query)
+	return t.handle.Exec(query, args...)
+}
+
+func (t timedHandle) Query(query string, args ...any) (*sql.Rows, error) {
+	defer observeQuery(time.Now(), query)
+	return t.handle.Query(query, args...)
+}
+
+func (t timedHandle) QueryRow(query string, args ...any) *sql.Row {
+	defer observeQuery(time.Now(), query)
+	return t.handle.QueryRow(query, args...)
+}
+
+func (t timedHandle) Prepare(query string) (*sql.Stmt, error) {
+	defer observeQuery(time.Now(), query)
+	return t.handle.Prepare(query)
+}
+
+func observeQuery(start time.Time, query string) {
+	queryDuration.ObserveDuration(time.Since(start), statementKind(query))
+}
+
+// statementKind labels a query by its first keyword: select, insert, update, delete or other
+func statementKind(query string) string {
+	query = strings.TrimLeftFunc(query, func(r rune) bool { return unicode.IsSpace(r) || r == '(' })
+	if end := strings.IndexFunc(query, unicode.IsSpace); end >= 0 {
+		query = query[:end]
+	}
+
+	switch strings.ToUpper(query) {
+	case "SELECT":
+		return "select"
+	case "INSERT", "REPLACE":
+		return "insert"
+	case "UPDATE":
+		return "update"
+	case "DELETE":
+		return "delete"
+	default:
+		return "other"
+	}
+}
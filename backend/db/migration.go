@@ -0,0 +1,67 @@
+package db
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"strconv"
+	"strings"
+)
+
+// MigrationStatus is the schema version of the database next to the newest migration file
+type MigrationStatus struct {
+	Version uint // last migration applied, 0 if none
+	Dirty   bool // the last migration failed halfway
+	Latest  uint // newest migration in the migrations directory
+}
+
+// UpToDate reports whether every migration is applied cleanly
+func (s MigrationStatus) UpToDate() bool {
+	return s.Version == s.Latest && !s.Dirty
+}
+
+// Ping checks the database answers
+func (db *Database) Ping() error {
+	if db.pool == nil {
+		return errors.New("database is not open")
+	}
+	return db.pool.Ping()
+}
+
+// MigrationStatus reads the schema version from the table golang-migrate keeps it in
+// and compares it to the migration files the database was opened with
+func (db *Database) MigrationStatus() (MigrationStatus, error) {
+	var status MigrationStatus
+	err := db.db.QueryRow(`SELECT version, dirty FROM schema_migrations LIMIT 1`).Scan(&status.Version, &status.Dirty)
+	if err != nil {
+		return status, fmt.Errorf("failed to read schema version: %w", err)
+	}
+
+	if db.migrations == "" {
+		return status, errors.New("database was not opened with migrations")
+	}
+	status.Latest, err = latestMigration(db.migrations)
+	return status, err
+}
+
+// latestMigration returns the highest version among files named like 000029_create_table.up.sql
+func latestMigration(migrationsPath string) (uint, error) {
+	entries, err := os.ReadDir(migrationsPath)
+	if err != nil {
+		return 0, fmt.Errorf("failed to read migrations: %w", err)
+	}
+
+	var latest uint
+	for _, entry := range entries {
+		if !strings.HasSuffix(entry.Name(), ".up.sql") {
+			continue
+		}
+		prefix, _, _ := strings.Cut(entry.Name(), "_")
+		version, err := strconv.ParseUint(prefix, 10, 64)
+		if err != nil {
+			continue
+		}
+		latest = max(latest, uint(version))
+	}
+	return latest, nil
+}
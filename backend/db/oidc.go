@@ -0,0 +1,79 @@
+package db
+
+import (
+	"database/sql"
+	"fmt"
+	"time"
+)
+
+// CreateOIDCLoginState stores what is needed to finish an OIDC login when the provider redirects back
+func (db *Database) CreateOIDCLoginState(state string, nonce string, codeVerifier string, expiresAt time.Time) error {
+	_, err := db.db.Exec(`
+		INSERT INTO oidc_login_states (state, nonce, code_verifier, expires_at) VALUES (?, ?, ?, ?)
+	`, state, nonce, codeVerifier, sqlTime(expiresAt))
+	if err != nil {
+		return fmt.Errorf("failed to insert oidc login state: %w", err)
+	}
+	return nil
+}
+
+// ConsumeOIDCLoginState removes a login state and returns its nonce and PKCE verifier.
+// Returns sql.ErrNoRows if the state does not exist or has expired.
+func (db *Database) ConsumeOIDCLoginState(state string) (string, string, error) {
+	var nonce, codeVerifier string
+	err := db.db.QueryRow(`
+		DELETE FROM oidc_login_states
+		WHERE state = ? AND expires_at > datetime('now')
+		RETURNING nonce, code_verifier
+	`, state).Scan(&nonce, &codeVerifier)
+	if err != nil {
+		if err == sql.ErrNoRows {
+			return "", "", err
+		}
+		return "", "", fmt.Errorf("failed to consume oidc login state: %w", err)
+	}
+
+	return nonce, codeVerifier, nil
+}
+
+// DeleteExpiredOIDCLoginStates removes logins that were never finished and returns how many were removed
+func (db *Database) DeleteExpiredOIDCLoginStates() (int64, error) {
+	result, err := db.db.Exec(`DELETE FROM oidc_login_states WHERE expires_at <= datetime('now')`)
+	if err != nil {
+		return 0, fmt.Errorf("failed to delete expired oidc login states: %w", err)
+	}
+
+	count, err := result.RowsAffected()
+	if err != nil {
+		return 0, fmt.Errorf("failed to get rows affected: %w", err)
+	}
+
+	return count, nil
+}
+
+// FetchUserIDByIdentity finds the user a provider account is linked to.
+// Returns sql.ErrNoRows if it is not linked.
+func (db *Database) FetchUserIDByIdentity(issuer string, subject string) (int, error) {
+	var userID int
+	err := db.db.QueryRow(`
+		SELECT user_id FROM user_identities WHERE issuer = ? AND subject = ?
+	`, issuer, subject).Scan(&userID)
+	if err != nil {
+		if err == sql.ErrNoRows {
+			return 0, err
+		}
+		return 0, fmt.Errorf("failed to fetch identity: %w", err)
+	}
+	return userID, nil
+}
+
+// LinkIdentity links a provider account to a user
+func (db *Database) LinkIdentity(userID int, issuer string, subject string, email string) error {
+	_, err := db.db.Exec(`
+		INSERT INTO user_identities (user_id, issuer, subject, email) VALUES (?, ?, ?, ?)
+	`, userID, issuer, subject, email)
+	if err != nil {
+		return fmt.Errorf("failed to link identity: %w", err)
+	}
+	return nil
+}
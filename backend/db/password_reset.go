@@ -0,0 +1,84 @@
+package db
+
+import (
+	"database/sql"
+	"fmt"
+	"time"
+)
+
+// CreatePasswordResetToken stores the hash of a new reset token for a user.
+// Unused tokens the user requested earlier stop working, only the newest one is valid.
+func (db *Database) CreatePasswordResetToken(userID int, tokenHash string, expiresAt time.Time) error {
+	tx, err := db.begin()
+	if err != nil {
+		return fmt.Errorf("failed to begin transaction: %w", err)
+	}
+	defer tx.Rollback()
+
+	_, err = tx.Exec(`DELETE FROM password_reset_tokens WHERE user_id = ? AND used_at IS NULL`, userID)
+	if err != nil {
+		return fmt.Errorf("failed to delete old reset tokens: %w", err)
+	}
+
+	_, err = tx.Exec(`
+		INSERT INTO password_reset_tokens (user_id, token_hash, expires_at) VALUES (?, ?, ?)
+	`, userID, tokenHash, sqlTime(expiresAt))
+	if err != nil {
+		return fmt.Errorf("failed to insert reset token: %w", err)
+	}
+
+	return tx.Commit()
+}
+
+// PasswordResetTokenUser returns the user a usable reset token belongs to, without using it up.
+// Returns sql.ErrNoRows if the token does not exist, has expired or was already used.
+func (db *Database) PasswordResetTokenUser(tokenHash string) (int, error) {
+	var userID int
+	err := db.db.QueryRow(`
+		SELECT user_id FROM password_reset_tokens
+		WHERE token_hash = ? AND used_at IS NULL AND expires_at > datetime('now')
+	`, tokenHash).Scan(&userID)
+	if err != nil {
+		if err == sql.ErrNoRows {
+			return 0, err
+		}
+		return 0, fmt.Errorf("failed to check reset token: %w", err)
+	}
+
+	return userID, nil
+}
+
+// ConsumePasswordResetToken marks a reset token as used and returns the user it belongs to.
+// Returns sql.ErrNoRows if the token does not exist, has expired or was already used.
+func (db *Database) ConsumePasswordResetToken(tokenHash string) (int, error) {
+	var userID int
+	err := db.db.QueryRow(`
+		UPDATE password_reset_tokens
+		SET used_at = datetime('now')
+		WHERE token_hash = ? AND used_at IS NULL AND expires_at > datetime('now')
+		RETURNING user_id
+	`, tokenHash).Scan(&userID)
+	if err != nil {
+		if err == sql.ErrNoRows {
+			return 0, err
+		}
+		return 0, fmt.Errorf("failed to consume reset token: %w", err)
+	}
+
+	return userID, nil
+}
+
+// DeleteExpiredPasswordResetTokens removes reset tokens that have expired and returns how many were removed
+func (db *Database) DeleteExpiredPasswordResetTokens() (int64, error) {
+	result, err := db.db.Exec(`DELETE FROM password_reset_tokens WHERE expires_at <= datetime('now')`)
+	if err != nil {
+		return 0, fmt.Errorf("failed to delete expired reset tokens: %w", err)
+	}
+
+	count, err := result.RowsAffected()
+	if err != nil {
+		return 0, fmt.Errorf("failed to get rows affected: %w", err)
+	}
+
+	return count, nil
+}
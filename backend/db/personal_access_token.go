@@ -0,0 +1,160 @@
+package db
+
+import (
+	"database/sql"
+	"fmt"
+	"strings"
+	"time"
+)
+
+// PersonalAccessToken is a named token a user created for a script or bot.
+// Only the hash of the token is stored.
+type PersonalAccessToken struct {
+	ID         int        `json:"id"`
+	UserID     int        `json:"-"`
+	Email      string     `json:"-"` // email of the owner, filled in when authenticating
+	Name       string     `json:"name"`
+	Scopes     []string   `json:"scopes"`
+	ExpiresAt  *time.Time `json:"expiresAt"` // nil if the token doesn't expire
+	LastUsedAt *time.Time `json:"lastUsedAt"`
+	CreatedAt  time.Time  `json:"createdAt"`
+}
+
+// CreatePersonalAccessToken stores a new token and returns its row ID. A nil expiresAt never expires.
+func (db *Database) CreatePersonalAccessToken(userID int, name string, tokenHash string, scopes []string, expiresAt *time.Time) (int, error) {
+	var expires any
+	if expiresAt != nil {
+		expires = sqlTime(*expiresAt)
+	}
+
+	result, err := db.db.Exec(`
+		INSERT INTO personal_access_tokens (user_id, name, token_hash, scopes, expires_at) VALUES (?, ?, ?, ?, ?)
+	`, userID, name, tokenHash, strings.Join(scopes, " "), expires)
+	if err != nil {
+		return 0, fmt.Errorf("failed to insert personal access token: %w", err)
+	}
+
+	id, err := result.LastInsertId()
+	if err != nil {
+		return 0, fmt.Errorf("failed to get personal access token id: %w", err)
+	}
+	return int(id), nil
+}
+
+// FetchPersonalAccessToken finds an unexpired token of a user who isn't suspended or deactivated
+// and records that it was used.
+// Returns sql.ErrNoRows if there is no such token.
+func (db *Database) FetchPersonalAccessToken(tokenHash string) (*PersonalAccessToken, error) {
+	var t PersonalAccessToken
+	var scopes string
+	var expiresAt, lastUsedAt sql.NullTime
+	err := db.db.QueryRow(`
+		SELECT t.id, t.user_id, u.email, t.name, t.scopes, t.expires_at, t.last_used_at, t.created_at
+		FROM personal_access_tokens t
+		JOIN user u ON u.id = t.user_id
+		WHERE t.token_hash = ?
+			AND (t.expires_at IS NULL OR t.expires_at > datetime('now'))
+			AND u.suspended_at IS NULL
+			AND u.deactivated_at IS NULL
+	`, tokenHash).Scan(&t.ID, &t.UserID, &t.Email, &t.Name, &scopes, &expiresAt, &lastUsedAt, &t.CreatedAt)
+	if err != nil {
+		if err == sql.ErrNoRows {
+			return nil, err
+		}
+		return nil, fmt.Errorf("failed to fetch personal access token: %w", err)
+	}
+
+	t.Scopes = strings.Fields(scopes)
+	if expiresAt.Valid {
+		t.ExpiresAt = &expiresAt.Time
+	}
+	if lastUsedAt.Valid {
+		t.LastUsedAt = &lastUsedAt.Time
+	}
+
+	// Last used is only written once a minute, like session last seen
+	_, err = db.db.Exec(`
+		UPDATE personal_access_tokens SET last_used_at = datetime('now')
+		WHERE id = ? AND (last_used_at IS NULL OR last_used_at < datetime('now', '-1 minute'))
+	`, t.ID)
+	if err != nil {
+		return nil, fmt.Errorf("failed to update personal access token last used: %w", err)
+	}
+
+	return &t, nil
+}
+
+// FetchUserPersonalAccessTokens lists a user's tokens, newest first, including expired ones
+func (db *Database) FetchUserPersonalAccessTokens(userID int) ([]PersonalAccessToken, error) {
+	rows, err := db.db.Query(`
+		SELECT id, user_id, name, scopes, expires_at, last_used_at, created_at
+		FROM personal_access_tokens
+		WHERE user_id = ?
+		ORDER BY id DESC
+	`, userID)
+	if err != nil {
+		return nil, fmt.Errorf("failed to query personal access tokens: %w", err)
+	}
+	defer rows.Close()
+
+	tokens := []PersonalAccessToken{}
+	for rows.Next() {
+		var t PersonalAccessToken
+		var scopes string
+		var expiresAt, lastUsedAt sql.NullTime
+		if err := rows.Scan(&t.ID, &t.UserID, &t.Name, &scopes, &expiresAt, &lastUsedAt, &t.CreatedAt); err != nil {
+			return nil, fmt.Errorf("failed to scan personal access token: %w", err)
+		}
+
+		t.Scopes = strings.Fields(scopes)
+		if expiresAt.Valid {
+			t.ExpiresAt = &expiresAt.Time
+		}
+		if lastUsedAt.Valid {
+			t.LastUsedAt = &lastUsedAt.Time
+		}
+		tokens = append(tokens, t)
+	}
+
+	return tokens, rows.Err()
+}
+
+// CountUserPersonalAccessTokens returns how many tokens a user has
+func (db *Database) CountUserPersonalAccessTokens(userID int) (int, error) {
+	var count int
+	err := db.db.QueryRow(`SELECT COUNT(*) FROM personal_access_tokens WHERE user_id = ?`, userID).Scan(&count)
+	if err != nil {
+		return 0, fmt.Errorf("failed to count personal access tokens: %w", err)
+	}
+	return count, nil
+}
+
+// DeletePersonalAccessToken revokes a token of a user, returns false if the user has no such token
+func (db *Database) DeletePersonalAccessToken(userID int, tokenID int) (bool, error) {
+	result, err := db.db.Exec(`DELETE FROM personal_access_tokens WHERE id = ? AND user_id = ?`, tokenID, userID)
+	if err != nil {
+		return false, fmt.Errorf("failed to delete personal access token: %w", err)
+	}
+
+	count, err := result.RowsAffected()
+	if err != nil {
+		return false, fmt.Errorf("failed to get rows affected: %w", err)
+	}
+
+	return count > 0, nil
+}
+
+// DeleteUserPersonalAccessTokens revokes every token of a user, returns how many there were
+func (db *Database) DeleteUserPersonalAccessTokens(userID int) (int, error) {
+	result, err := db.db.Exec(`DELETE FROM personal_access_tokens WHERE user_id = ?`, userID)
+	if err != nil {
+		return 0, fmt.Errorf("failed to delete personal access tokens: %w", err)
+	}
+
+	count, err := result.RowsAffected()
+	if err != nil {
+		return 0, fmt.Errorf("failed to get rows affected: %w", err)
+	}
+
+	return int(count), nil
+}
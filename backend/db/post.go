@@ -105,6 +105,7 @@ func (db *Database) GetPosts(userID int) ([]Post, error) {
 			))
 			OR p.user_id = ?
 		)
+		AND u.deactivated_at IS NULL
 		GROUP BY p.id
 		ORDER BY p.created_at DESC
 	`
@@ -182,7 +183,7 @@ func (db *Database) GetComments(postID int) ([]Comment, error) {
 			u.profile_picture, c.content, COALESCE(c.image_path, '') as image_path, c.created_at
 		FROM comments c
 		JOIN user u ON c.user_id = u.id
-		WHERE c.post_id = ?
+		WHERE c.post_id = ? AND u.deactivated_at IS NULL
 		ORDER BY c.created_at ASC
 	`
 
@@ -288,6 +289,7 @@ func (db *Database) GetLikedPosts(userID int) ([]Post, error) {
 			))
 			OR p.user_id = ?
 		)
+		AND u.deactivated_at IS NULL
 		GROUP BY p.id
 		ORDER BY l.created_at DESC
 	`
@@ -333,7 +335,7 @@ func (db *Database) GetUserFollowers(userID int) ([]map[string]interface{}, erro
 		SELECT u.id, u.nickname, u.first_name, u.last_name, u.profile_picture
 		FROM follows f
 		JOIN user u ON f.follower_id = u.id
-		WHERE f.followed_id = ? AND f.status = 'accepted'
+		WHERE f.followed_id = ? AND f.status = 'accepted' AND u.deactivated_at IS NULL
 		ORDER BY u.first_name, u.last_name
 	`
 
@@ -0,0 +1,127 @@
+package db
+
+import (
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"strings"
+	"time"
+)
+
+// SecurityEventRetention is how long security events are kept. The table refuses
+// to delete younger events, see migration 000029.
+const SecurityEventRetention = 365 * 24 * time.Hour
+
+// SecurityEvent is an entry of the append-only security audit log
+type SecurityEvent struct {
+	ID        int             `json:"id"`
+	UserID    int             `json:"userId,omitempty"` // 0 for events about unknown accounts
+	Type      string          `json:"type"`
+	IP        string          `json:"ip"`
+	UserAgent string          `json:"userAgent"`
+	Details   json.RawMessage `json:"details,omitempty"`
+	CreatedAt time.Time       `json:"createdAt"`
+}
+
+// SecurityEventFilter selects security events, zero values don't filter
+type SecurityEventFilter struct {
+	UserID   int
+	Types    []string
+	Since    time.Time
+	Until    time.Time
+	BeforeID int // for paging, only events older than this one
+	Limit    int
+}
+
+// InsertSecurityEvent appends an event to the audit log. userID 0 is stored as NULL.
+func (db *Database) InsertSecurityEvent(userID int, eventType string, ip string, userAgent string, details []byte) error {
+	var user any
+	if userID != 0 {
+		user = userID
+	}
+
+	var detailsText any
+	if len(details) > 0 {
+		detailsText = string(details)
+	}
+
+	_, err := db.db.Exec(`
+		INSERT INTO security_events (user_id, type, ip, user_agent, details) VALUES (?, ?, ?, ?, ?)
+	`, user, eventType, ip, userAgent, detailsText)
+	if err != nil {
+		return fmt.Errorf("failed to insert security event: %w", err)
+	}
+	return nil
+}
+
+// QuerySecurityEvents returns the events matching the filter, newest first
+func (db *Database) QuerySecurityEvents(filter SecurityEventFilter) ([]SecurityEvent, error) {
+	var conditions []string
+	var args []any
+
+	if filter.UserID != 0 {
+		conditions = append(conditions, "user_id = ?")
+		args = append(args, filter.UserID)
+	}
+	if len(filter.Types) > 0 {
+		conditions = append(conditions, "type IN (?"+strings.Repeat(", ?", len(filter.Types)-1)+")")
+		for _, t := range filter.Types {
+			args = append(args, t)
+		}
+	}
+	if !filter.Since.IsZero() {
+		conditions = append(conditions, "created_at >= ?")
+		args = append(args, sqlTime(filter.Since))
+	}
+	if !filter.Until.IsZero() {
+		conditions = append(conditions, "created_at < ?")
+		args = append(args, sqlTime(filter.Until))
+	}
+	if filter.BeforeID != 0 {
+		conditions = append(conditions, "id < ?")
+		args = append(args, filter.BeforeID)
+	}
+
+	query := `SELECT id, COALESCE(user_id, 0), type, COALESCE(ip, ''), COALESCE(user_agent, ''), details, created_at FROM security_events`
+	if len(conditions) > 0 {
+		query += " WHERE " + strings.Join(conditions, " AND ")
+	}
+	query += " ORDER BY id DESC LIMIT ?"
+	args = append(args, filter.Limit)
+
+	rows, err := db.db.Query(query, args...)
+	if err != nil {
+		return nil, fmt.Errorf("failed to query security events: %w", err)
+	}
+	defer rows.Close()
+
+	events := []SecurityEvent{}
+	for rows.Next() {
+		var e SecurityEvent
+		var details sql.NullString
+		if err := rows.Scan(&e.ID, &e.UserID, &e.Type, &e.IP, &e.UserAgent, &details, &e.CreatedAt); err != nil {
+			return nil, fmt.Errorf("failed to scan security event: %w", err)
+		}
+		if details.Valid {
+			e.Details = json.RawMessage(details.String)
+		}
+		events = append(events, e)
+	}
+
+	return events, rows.Err()
+}
+
+// DeleteExpiredSecurityEvents removes events past retention and returns how many were removed
+func (db *Database) DeleteExpiredSecurityEvents() (int64, error) {
+	result, err := db.db.Exec(`DELETE FROM security_events WHERE created_at <= ?`, sqlTime(time.Now().Add(-SecurityEventRetention)))
+	if err != nil {
+		return 0, fmt.Errorf("failed to delete expired security events: %w", err)
+	}
+
+	count, err := result.RowsAffected()
+	if err != nil {
+		return 0, fmt.Errorf("failed to get rows affected: %w", err)
+	}
+
+	return count, nil
+}
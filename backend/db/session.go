@@ -0,0 +1,190 @@
+package db
+
+import (
+	"database/sql"
+	"fmt"
+	"time"
+)
+
+// sqliteTimeLayout matches the format produced by CURRENT_TIMESTAMP and datetime('now'),
+// so timestamps written from Go can be compared against them in SQL.
+const sqliteTimeLayout = "2006-01-02 15:04:05"
+
+// Session represents a row in the sessions table.
+// Only the hash of the session token is stored, never the token itself.
+type Session struct {
+	ID         int
+	TokenHash  string
+	UserID     int
+	Data       string // JSON-encoded session data
+	UserAgent  string
+	IP         string
+	ExpiresAt  time.Time
+	CreatedAt  time.Time
+	LastSeenAt time.Time
+}
+
+const sessionColumns = `id, token_hash, user_id, data, COALESCE(user_agent, ''), COALESCE(ip, ''),
+	expires_at, created_at, last_seen_at`
+
+// sqlTime formats a time the way SQLite stores DATETIME defaults (UTC, second precision)
+func sqlTime(t time.Time) string {
+	return t.UTC().Format(sqliteTimeLayout)
+}
+
+type rowScanner interface {
+	Scan(dest ...any) error
+}
+
+func scanSession(row rowScanner) (*Session, error) {
+	var s Session
+	var lastSeen sql.NullTime
+	err := row.Scan(&s.ID, &s.TokenHash, &s.UserID, &s.Data, &s.UserAgent, &s.IP, &s.ExpiresAt, &s.CreatedAt, &lastSeen)
+	if err != nil {
+		return nil, err
+	}
+
+	s.LastSeenAt = s.CreatedAt
+	if lastSeen.Valid {
+		s.LastSeenAt = lastSeen.Time
+	}
+	return &s, nil
+}
+
+// CreateSession stores a new session and returns its row ID.
+func (db *Database) CreateSession(tokenHash string, userID int, data string, userAgent string, ip string, expiresAt time.Time) (int, error) {
+	result, err := db.db.Exec(`
+		INSERT INTO sessions (token_hash, user_id, data, user_agent, ip, expires_at, last_seen_at)
+		VALUES (?, ?, ?, ?, ?, ?, datetime('now'))
+	`, tokenHash, userID, data, userAgent, ip, sqlTime(expiresAt))
+	if err != nil {
+		return 0, fmt.Errorf("failed to insert session: %w", err)
+	}
+
+	id, err := result.LastInsertId()
+	if err != nil {
+		return 0, fmt.Errorf("failed to get last insert ID: %w", err)
+	}
+
+	return int(id), nil
+}
+
+// FetchSession retrieves a session that has not expired yet by its token hash.
+// Returns sql.ErrNoRows if the session does not exist or has expired.
+func (db *Database) FetchSession(tokenHash string) (*Session, error) {
+	row := db.db.QueryRow(`
+		SELECT `+sessionColumns+`
+		FROM sessions
+		WHERE token_hash = ? AND expires_at > datetime('now')
+	`, tokenHash)
+
+	s, err := scanSession(row)
+	if err != nil {
+		if err == sql.ErrNoRows {
+			return nil, err
+		}
+		return nil, fmt.Errorf("failed to fetch session: %w", err)
+	}
+
+	return s, nil
+}
+
+// FetchUserSessions retrieves every active session of a user, most recently used first
+func (db *Database) FetchUserSessions(userID int) ([]Session, error) {
+	rows, err := db.db.Query(`
+		SELECT `+sessionColumns+`
+		FROM sessions
+		WHERE user_id = ? AND expires_at > datetime('now')
+		ORDER BY COALESCE(last_seen_at, created_at) DESC
+	`, userID)
+	if err != nil {
+		return nil, fmt.Errorf("failed to query sessions: %w", err)
+	}
+	defer rows.Close()
+
+	var sessions []Session
+	for rows.Next() {
+		s, err := scanSession(rows)
+		if err != nil {
+			return nil, fmt.Errorf("failed to scan session: %w", err)
+		}
+		sessions = append(sessions, *s)
+	}
+
+	return sessions, rows.Err()
+}
+
+// TouchSession records that a session was just used
+func (db *Database) TouchSession(sessionID int) error {
+	_, err := db.db.Exec(`UPDATE sessions SET last_seen_at = datetime('now') WHERE id = ?`, sessionID)
+	if err != nil {
+		return fmt.Errorf("failed to update session last seen: %w", err)
+	}
+	return nil
+}
+
+// DeleteSession removes a session by its token hash
+func (db *Database) DeleteSession(tokenHash string) error {
+	_, err := db.db.Exec(`DELETE FROM sessions WHERE token_hash = ?`, tokenHash)
+	if err != nil {
+		return fmt.Errorf("failed to delete session: %w", err)
+	}
+	return nil
+}
+
+// DeleteUserSession removes one session of a user by its row ID.
+// Returns false if the user has no such session.
+func (db *Database) DeleteUserSession(userID int, sessionID int) (bool, error) {
+	result, err := db.db.Exec(`DELETE FROM sessions WHERE id = ? AND user_id = ?`, sessionID, userID)
+	if err != nil {
+		return false, fmt.Errorf("failed to delete session: %w", err)
+	}
+
+	count, err := result.RowsAffected()
+	if err != nil {
+		return false, fmt.Errorf("failed to get rows affected: %w", err)
+	}
+
+	return count > 0, nil
+}
+
+// DeleteUserSessions removes every session of a user except the one with exceptID
+// (pass 0 to remove them all) and returns how many were removed
+func (db *Database) DeleteUserSessions(userID int, exceptID int) (int64, error) {
+	result, err := db.db.Exec(`DELETE FROM sessions WHERE user_id = ? AND id != ?`, userID, exceptID)
+	if err != nil {
+		return 0, fmt.Errorf("failed to delete sessions: %w", err)
+	}
+
+	count, err := result.RowsAffected()
+	if err != nil {
+		return 0, fmt.Errorf("failed to get rows affected: %w", err)
+	}
+
+	return count, nil
+}
+
+// DeleteExpiredSessions removes every expired session and returns how many were removed
+func (db *Database) DeleteExpiredSessions() (int64, error) {
+	result, err := db.db.Exec(`DELETE FROM sessions WHERE expires_at <= datetime('now')`)
+	if err != nil {
+		return 0, fmt.Errorf("failed to delete expired sessions: %w", err)
+	}
+
+	count, err := result.RowsAffected()
+	if err != nil {
+		return 0, fmt.Errorf("failed to get rows affected: %w", err)
+	}
+
+	return count, nil
+}
+
+// CountActiveSessions returns how many sessions have not expired
+func (db *Database) CountActiveSessions() (int, error) {
+	var count int
+	err := db.db.QueryRow(`SELECT COUNT(*) FROM sessions WHERE expires_at > datetime('now')`).Scan(&count)
+	if err != nil {
+		return 0, fmt.Errorf("failed to count sessions: %w", err)
+	}
+	return count, nil
+}
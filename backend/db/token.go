@@ -0,0 +1,47 @@
+package db
+
+import (
+	"fmt"
+	"time"
+)
+
+// RevokeToken adds a bearer token ID to the revocation list.
+// The entry is kept until the token would have expired anyway.
+func (db *Database) RevokeToken(jti string, expiresAt time.Time) (bool, error) {
+	result, err := db.db.Exec(`
+		INSERT OR IGNORE INTO revoked_tokens (jti, expires_at) VALUES (?, ?)
+	`, jti, sqlTime(expiresAt))
+	if err != nil {
+		return false, fmt.Errorf("failed to revoke token: %w", err)
+	}
+
+	count, err := result.RowsAffected()
+	if err != nil {
+		return false, fmt.Errorf("failed to get rows affected: %w", err)
+	}
+	return count > 0, nil
+}
+
+// IsTokenRevoked checks if a bearer token ID is on the revocation list
+func (db *Database) IsTokenRevoked(jti string) (bool, error) {
+	var exists bool
+	err := db.db.QueryRow(`
+		SELECT EXISTS(SELECT 1 FROM revoked_tokens WHERE jti = ?)
+	`, jti).Scan(&exists)
+	return exists, err
+}
+
+// DeleteExpiredRevocations removes revocation entries for tokens that have expired
+func (db *Database) DeleteExpiredRevocations() (int64, error) {
+	result, err := db.db.Exec(`DELETE FROM revoked_tokens WHERE expires_at <= datetime('now')`)
+	if err != nil {
+		return 0, fmt.Errorf("failed to delete expired revocations: %w", err)
+	}
+
+	count, err := result.RowsAffected()
+	if err != nil {
+		return 0, fmt.Errorf("failed to get rows affected: %w", err)
+	}
+
+	return count, nil
+}
@@ -0,0 +1,108 @@
+package db
+
+import (
+	"database/sql"
+	"fmt"
+)
+
+// handle runs queries, both *sql.DB and *sql.Tx are one
+type handle interface {
+	Exec(query string, args ...any) (sql.Result, error)
+	Query(query string, args ...any) (*sql.Rows, error)
+	QueryRow(query string, args ...any) *sql.Row
+	Prepare(query string) (*sql.Stmt, error)
+}
+
+// InTransaction runs fn with a Database whose queries all belong to one transaction. It is
+// committed if fn returns nil and rolled back if fn fails or panics. Inside a transaction,
+// fn joins it.
+func (db *Database) InTransaction(fn func(tx *Database) error) error {
+	if db.pool == nil {
+		return fn(db)
+	}
+
+	writes.add()
+	defer writes.done()
+
+	sqlTx, err := db.pool.Begin()
+	if err != nil {
+		return fmt.Errorf("failed to begin transaction: %w", err)
+	}
+	defer sqlTx.Rollback()
+
+	if err := fn(&Database{db: timedHandle{sqlTx}}); err != nil {
+		return err
+	}
+
+	if err := sqlTx.Commit(); err != nil {
+		return fmt.Errorf("failed to commit transaction: %w", err)
+	}
+	return nil
+}
+
+// tx is the transaction of a single method. In a Database that is already in a transaction
+// it is a savepoint, so the method's changes are still undone with the outer transaction.
+type tx struct {
+	handle
+	commit   func() error
+	rollback func() error
+	done     bool
+	counted  bool // in writes, a transaction of its own rather than a savepoint
+}
+
+// begin starts the transaction of a method
+func (db *Database) begin() (*tx, error) {
+	if db.pool != nil {
+		sqlTx, err := db.pool.Begin()
+		if err != nil {
+			return nil, err
+		}
+		writes.add() // until Commit or Rollback
+		return &tx{handle: timedHandle{sqlTx}, commit: sqlTx.Commit, rollback: sqlTx.Rollback, counted: true}, nil
+	}
+
+	// Savepoints with the same name nest, releasing or rolling back to one affects the innermost
+	if _, err := db.db.Exec(`SAVEPOINT nested`); err != nil {
+		return nil, err
+	}
+	return &tx{
+		handle: db.db,
+		commit: func() error {
+			_, err := db.db.Exec(`RELEASE nested`)
+			return err
+		},
+		rollback: func() error {
+			if _, err := db.db.Exec(`ROLLBACK TO nested`); err != nil {
+				return err
+			}
+			_, err := db.db.Exec(`RELEASE nested`)
+			return err
+		},
+	}, nil
+}
+
+func (t *tx) Commit() error {
+	if t.done {
+		return sql.ErrTxDone
+	}
+	t.done = true
+	defer t.release()
+	return t.commit()
+}
+
+// Rollback undoes the transaction, after Commit it does nothing, so it can be deferred
+func (t *tx) Rollback() error {
+	if t.done {
+		return sql.ErrTxDone
+	}
+	t.done = true
+	defer t.release()
+	return t.rollback()
+}
+
+// release stops counting the transaction as a write in progress
+func (t *tx) release() {
+	if t.counted {
+		writes.done()
+	}
+}
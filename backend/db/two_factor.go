@@ -0,0 +1,151 @@
+package db
+
+import (
+	"database/sql"
+	"fmt"
+)
+
+// TOTP is the authenticator app secret of a user
+type TOTP struct {
+	UserID       int
+	Secret       string
+	Confirmed    bool // false while enrollment waits for the first code
+	LastUsedStep int64
+}
+
+// SetPendingTOTP stores a new, unconfirmed secret for a user, replacing a pending one.
+// A confirmed secret is never replaced, it has to be removed with DeleteTOTP first.
+func (db *Database) SetPendingTOTP(userID int, secret string) error {
+	_, err := db.db.Exec(`
+		INSERT INTO user_totp (user_id, secret) VALUES (?, ?)
+		ON CONFLICT(user_id) DO UPDATE
+		SET secret = excluded.secret, last_used_step = 0, created_at = CURRENT_TIMESTAMP
+		WHERE confirmed_at IS NULL
+	`, userID, secret)
+	if err != nil {
+		return fmt.Errorf("failed to store totp secret: %w", err)
+	}
+	return nil
+}
+
+// FetchTOTP retrieves the secret of a user, returns sql.ErrNoRows if there is none
+func (db *Database) FetchTOTP(userID int) (*TOTP, error) {
+	var t TOTP
+	var confirmedAt sql.NullTime
+	err := db.db.QueryRow(`
+		SELECT user_id, secret, confirmed_at, last_used_step FROM user_totp WHERE user_id = ?
+	`, userID).Scan(&t.UserID, &t.Secret, &confirmedAt, &t.LastUsedStep)
+	if err != nil {
+		if err == sql.ErrNoRows {
+			return nil, err
+		}
+		return nil, fmt.Errorf("failed to fetch totp secret: %w", err)
+	}
+
+	t.Confirmed = confirmedAt.Valid
+	return &t, nil
+}
+
+// IsTwoFactorEnabled checks if a user has a confirmed TOTP secret
+func (db *Database) IsTwoFactorEnabled(userID int) (bool, error) {
+	var exists bool
+	err := db.db.QueryRow(`
+		SELECT EXISTS(SELECT 1 FROM user_totp WHERE user_id = ? AND confirmed_at IS NOT NULL)
+	`, userID).Scan(&exists)
+	return exists, err
+}
+
+// ConfirmTOTP enables 2FA for a user after the first code at step was accepted,
+// replacing the recovery codes with the given hashes
+func (db *Database) ConfirmTOTP(userID int, step int64, recoveryCodeHashes []string) error {
+	tx, err := db.begin()
+	if err != nil {
+		return fmt.Errorf("failed to begin transaction: %w", err)
+	}
+	defer tx.Rollback()
+
+	_, err = tx.Exec(`
+		UPDATE user_totp SET confirmed_at = datetime('now'), last_used_step = ? WHERE user_id = ?
+	`, step, userID)
+	if err != nil {
+		return fmt.Errorf("failed to confirm totp: %w", err)
+	}
+
+	_, err = tx.Exec(`DELETE FROM recovery_codes WHERE user_id = ?`, userID)
+	if err != nil {
+		return fmt.Errorf("failed to delete recovery codes: %w", err)
+	}
+
+	for _, codeHash := range recoveryCodeHashes {
+		_, err = tx.Exec(`INSERT INTO recovery_codes (user_id, code_hash) VALUES (?, ?)`, userID, codeHash)
+		if err != nil {
+			return fmt.Errorf("failed to insert recovery code: %w", err)
+		}
+	}
+
+	return tx.Commit()
+}
+
+// UseTOTPStep records that the code of a time step was used.
+// Returns false if a code of this or a later step was accepted before, so codes can't be replayed.
+func (db *Database) UseTOTPStep(userID int, step int64) (bool, error) {
+	result, err := db.db.Exec(`
+		UPDATE user_totp SET last_used_step = ? WHERE user_id = ? AND last_used_step < ?
+	`, step, userID, step)
+	if err != nil {
+		return false, fmt.Errorf("failed to update totp step: %w", err)
+	}
+
+	count, err := result.RowsAffected()
+	if err != nil {
+		return false, fmt.Errorf("failed to get rows affected: %w", err)
+	}
+
+	return count > 0, nil
+}
+
+// UseRecoveryCode marks an unused recovery code of a user as used.
+// Returns false if the user has no such unused code.
+func (db *Database) UseRecoveryCode(userID int, codeHash string) (bool, error) {
+	result, err := db.db.Exec(`
+		UPDATE recovery_codes SET used_at = datetime('now') WHERE user_id = ? AND code_hash = ? AND used_at IS NULL
+	`, userID, codeHash)
+	if err != nil {
+		return false, fmt.Errorf("failed to use recovery code: %w", err)
+	}
+
+	count, err := result.RowsAffected()
+	if err != nil {
+		return false, fmt.Errorf("failed to get rows affected: %w", err)
+	}
+
+	return count > 0, nil
+}
+
+// CountRecoveryCodes returns how many unused recovery codes a user has left
+func (db *Database) CountRecoveryCodes(userID int) (int, error) {
+	var count int
+	err := db.db.QueryRow(`
+		SELECT COUNT(*) FROM recovery_codes WHERE user_id = ? AND used_at IS NULL
+	`, userID).Scan(&count)
+	return count, err
+}
+
+// DeleteTOTP turns off 2FA for a user, removing the secret and the recovery codes
+func (db *Database) DeleteTOTP(userID int) error {
+	tx, err := db.begin()
+	if err != nil {
+		return fmt.Errorf("failed to begin transaction: %w", err)
+	}
+	defer tx.Rollback()
+
+	if _, err := tx.Exec(`DELETE FROM recovery_codes WHERE user_id = ?`, userID); err != nil {
+		return fmt.Errorf("failed to delete recovery codes: %w", err)
+	}
+
+	if _, err := tx.Exec(`DELETE FROM user_totp WHERE user_id = ?`, userID); err != nil {
+		return fmt.Errorf("failed to delete totp secret: %w", err)
+	}
+
+	return tx.Commit()
+}
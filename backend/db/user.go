@@ -5,6 +5,7 @@ import (
 	"encoding/json"
 	"fmt"
 	"strings"
+	"time"
 
 	"golang.org/x/crypto/bcrypt"
 )
@@ -21,6 +22,10 @@ type User struct {
 	Nickname       string `json:"nickname,omitempty"`
 	About          string `json:"about,omitempty"`
 	ProfilePicture int    `json:"profilePicture,omitempty"`
+	Verified       bool   `json:"verified"` // the user proved they own the email address
+	Deactivated    bool   `json:"-"`        // hidden until the user logs in again
+	Role           string `json:"role,omitempty"`
+	Suspended      bool   `json:"-"` // may not log in
 }
 
 // HashPassword hashes the given password using bcrypt.
@@ -72,7 +77,7 @@ func (db *Database) CreateUser(u User) (int, error) {
 
 // FetchUser retrieves a user record from the database by user ID.
 func (db *Database) FetchUser(userID int) (*User, error) {
-	row := db.db.QueryRow(`SELECT id, public, email, password, first_name, last_name, dob, nickname, about, profile_picture FROM user WHERE id = ?`, userID)
+	row := db.db.QueryRow(`SELECT id, public, email, password, first_name, last_name, dob, nickname, about, profile_picture, verified, deactivated_at IS NOT NULL, role, suspended_at IS NOT NULL FROM user WHERE id = ?`, userID)
 
 	if user, err := scanUserRecord(row); err != nil {
 		return nil, fmt.Errorf("failed to scan row: %w", err)
@@ -83,7 +88,7 @@ func (db *Database) FetchUser(userID int) (*User, error) {
 
 // FetchUserByEmail retrieves a user record from the database by email.
 func (db *Database) FetchUserByEmail(email string) (*User, error) {
-	row := db.db.QueryRow(`SELECT id, public, email, password, first_name, last_name, dob, nickname, about, profile_picture FROM user WHERE email = ?`, email)
+	row := db.db.QueryRow(`SELECT id, public, email, password, first_name, last_name, dob, nickname, about, profile_picture, verified, deactivated_at IS NOT NULL, role, suspended_at IS NOT NULL FROM user WHERE email = ?`, email)
 
 	if user, err := scanUserRecord(row); err != nil {
 		return nil, fmt.Errorf("failed to scan row: %w", err)
@@ -95,7 +100,7 @@ func (db *Database) FetchUserByEmail(email string) (*User, error) {
 func scanUserRecord(row *sql.Row) (*User, error) {
 	var u User
 
-	err := row.Scan(&u.Id, &u.Public, &u.Email, &u.Password, &u.FirstName, &u.LastName, &u.Dob, &u.Nickname, &u.About, &u.ProfilePicture)
+	err := row.Scan(&u.Id, &u.Public, &u.Email, &u.Password, &u.FirstName, &u.LastName, &u.Dob, &u.Nickname, &u.About, &u.ProfilePicture, &u.Verified, &u.Deactivated, &u.Role, &u.Suspended)
 	if err != nil {
 		if err == sql.ErrNoRows {
 			return nil, fmt.Errorf("user not found")
@@ -170,3 +175,93 @@ func (db *Database) UpdateUserProfilePicture(userID int, profilePictureID int) e
 
 	return nil
 }
+
+// UpdateUserPassword hashes and stores a new password for a user
+func (db *Database) UpdateUserPassword(userID int, password string) error {
+	hashedPassword, err := HashPassword(password)
+	if err != nil {
+		return err
+	}
+
+	_, err = db.db.Exec(`UPDATE user SET password = ? WHERE id = ?`, hashedPassword, userID)
+	if err != nil {
+		return fmt.Errorf("failed to update password: %w", err)
+	}
+
+	return nil
+}
+
+// InvalidateUserTokens makes every bearer and refresh token issued to a user until now invalid
+func (db *Database) InvalidateUserTokens(userID int) error {
+	// With milliseconds, so tokens issued right after, e.g. by a password change, are told apart from the revoked ones
+	_, err := db.db.Exec(`UPDATE user SET tokens_valid_after = strftime('%Y-%m-%d %H:%M:%f', 'now') WHERE id = ?`, userID)
+	if err != nil {
+		return fmt.Errorf("failed to invalidate tokens: %w", err)
+	}
+	return nil
+}
+
+// TokensValidAfter returns the time before which a user's tokens are invalid,
+// or the zero time if they were never invalidated. Fails if the user does not exist.
+func (db *Database) TokensValidAfter(userID int) (time.Time, error) {
+	var validAfter sql.NullTime
+	err := db.db.QueryRow(`SELECT tokens_valid_after FROM user WHERE id = ?`, userID).Scan(&validAfter)
+	if err != nil {
+		if err == sql.ErrNoRows {
+			return time.Time{}, fmt.Errorf("user not found")
+		}
+		return time.Time{}, fmt.Errorf("failed to fetch tokens valid after: %w", err)
+	}
+	return validAfter.Time, nil
+}
+
+// MarkUserVerified marks a user as verified if email is still their address.
+// Returns false if the user changed their email since the verification was sent.
+func (db *Database) MarkUserVerified(userID int, email string) (bool, error) {
+	result, err := db.db.Exec(`UPDATE user SET verified = TRUE WHERE id = ? AND email = ?`, userID, email)
+	if err != nil {
+		return false, fmt.Errorf("failed to mark user verified: %w", err)
+	}
+
+	count, err := result.RowsAffected()
+	if err != nil {
+		return false, fmt.Errorf("failed to get rows affected: %w", err)
+	}
+
+	return count > 0, nil
+}
+
+// IsUserVerified checks if a user has verified their email address
+func (db *Database) IsUserVerified(userID int) (bool, error) {
+	var verified bool
+	err := db.db.QueryRow(`SELECT verified FROM user WHERE id = ?`, userID).Scan(&verified)
+	if err != nil {
+		return false, fmt.Errorf("failed to check if user is verified: %w", err)
+	}
+	return verified, nil
+}
+
+// ChangeUserEmail sets a new, verified email address for a user.
+// Other pending verifications of the user are cancelled.
+func (db *Database) ChangeUserEmail(userID int, email string) error {
+	tx, err := db.begin()
+	if err != nil {
+		return fmt.Errorf("failed to begin transaction: %w", err)
+	}
+	defer tx.Rollback()
+
+	_, err = tx.Exec(`UPDATE user SET email = ?, verified = TRUE WHERE id = ?`, email, userID)
+	if err != nil {
+		if strings.Contains(err.Error(), "UNIQUE constraint failed") {
+			return fmt.Errorf("email already exists: %w", err)
+		}
+		return fmt.Errorf("failed to update email: %w", err)
+	}
+
+	_, err = tx.Exec(`DELETE FROM email_verification_tokens WHERE user_id = ? AND used_at IS NULL`, userID)
+	if err != nil {
+		return fmt.Errorf("failed to delete verification tokens: %w", err)
+	}
+
+	return tx.Commit()
+}
@@ -0,0 +1,54 @@
+package db
+
+import (
+	"context"
+	"sync"
+)
+
+// writes counts the writes in progress, statements and transactions, so the server can let
+// them finish before it closes the database
+var writes = &inflight{}
+
+type inflight struct {
+	count int
+	idle  chan struct{} // closed when count drops to 0
+	mutex sync.Mutex
+}
+
+func (f *inflight) add() {
+	f.mutex.Lock()
+	defer f.mutex.Unlock()
+
+	if f.count == 0 {
+		f.idle = make(chan struct{})
+	}
+	f.count++
+}
+
+func (f *inflight) done() {
+	f.mutex.Lock()
+	defer f.mutex.Unlock()
+
+	f.count--
+	if f.count == 0 {
+		close(f.idle)
+	}
+}
+
+// WaitForWrites waits until no write is in progress, or returns the error of ctx
+func (db *Database) WaitForWrites(ctx context.Context) error {
+	writes.mutex.Lock()
+	if writes.count == 0 {
+		writes.mutex.Unlock()
+		return nil
+	}
+	idle := writes.idle
+	writes.mutex.Unlock()
+
+	select {
+	case <-idle:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
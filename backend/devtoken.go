@@ -0,0 +1,20 @@
+//go:build !production
+
+package main
+
+import (
+	"backend/api"
+	"log"
+)
+
+// genDevToken logs a bearer token for user 1 to try the API during development.
+// Production builds (-tags production) leave it out.
+func genDevToken() {
+	c := api.Claims{
+		Email: "admin@test.fake",
+		Id:    1,
+	}
+
+	sig, _ := c.GetBearer()
+	log.Printf("Authorization: %s\n", *sig)
+}
@@ -0,0 +1,6 @@
+//go:build production
+
+package main
+
+// genDevToken does nothing in production builds, no token is logged
+func genDevToken() {}
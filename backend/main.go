@@ -3,12 +3,26 @@ package main
 import (
 	"backend/api"
 	"backend/db"
+	"context"
+	"flag"
 	"fmt"
 	"log"
+	"log/slog"
 	"net/http"
 	"os"
+	"os/signal"
+	"strconv"
+	"strings"
+	"syscall"
+	"time"
 )
 
+// shutdownTimeout is how long a stopping server waits for requests and database writes
+const shutdownTimeout = 15 * time.Second
+
+// defaultMetricsAddr serves /metrics on loopback when nothing says how to expose it
+const defaultMetricsAddr = "127.0.0.1:9090"
+
 // Configurable constants, can be loaded from environment variables
 var (
 	port      = ":8080"
@@ -18,8 +32,20 @@ var (
 
 func init() {
 
+	setupLogging()
 	loadEnvironment()
+
+	// Admin commands work on the data dir and exit without starting the server
+	if len(os.Args) > 1 {
+		runCommand(os.Args[1:])
+		os.Exit(0)
+	}
+
+	setupMailer()
+	setupOIDC()
+	setupPasswordPolicy()
 	api.GenOrLoadKey(dataDir)
+	api.WatchKeys(time.Minute)
 	genDevToken()
 
 	// Start session cleanup goroutine
@@ -32,7 +58,6 @@ func init() {
 
 func main() {
 
-	defer db.Connection.Close()
 	testDB()
 
 	// File server
@@ -40,12 +65,61 @@ func main() {
 
 	// Handlers
 	http.Handle("/", http.StripPrefix("/", fs)) // Serves files from the static directory
-	http.HandleFunc("/api", api.Router)
-	http.HandleFunc("/ws", api.HandleWebSocket)
-	http.HandleFunc("/file", api.File)
+	http.Handle("/api", api.Middleware(http.HandlerFunc(api.Router)))
+	http.Handle("/api/v1/", api.Middleware(http.HandlerFunc(api.REST)))
+	http.Handle("/ws", api.Middleware(http.HandlerFunc(api.HandleWebSocket)))
+	http.Handle("/file", api.Middleware(http.HandlerFunc(api.File)))
+	http.HandleFunc("/healthz", api.Healthz)
+	http.HandleFunc("/readyz", api.Readyz)
+	http.HandleFunc("/version", api.Version)
+	metricsServer := setupMetrics()
 
-	fmt.Printf("Server starting on port %s...\n", port)
-	log.Fatal(http.ListenAndServe(port, nil))
+	server := &http.Server{Addr: port}
+
+	ctx, stop := signal.NotifyContext(context.Background(), syscall.SIGINT, syscall.SIGTERM)
+	defer stop()
+
+	go func() {
+		fmt.Printf("Server starting on port %s...\n", port)
+		if err := server.ListenAndServe(); err != http.ErrServerClosed {
+			log.Fatal(err)
+		}
+	}()
+
+	<-ctx.Done()
+	stop() // a second signal kills the server without waiting
+	shutdown(server, metricsServer)
+}
+
+// shutdown stops the server without cutting off its clients: it stops accepting connections and
+// waits for the requests in progress, tells the websocket clients the server is restarting, lets
+// the database writes finish and closes the database. It gives up waiting after shutdownTimeout.
+func shutdown(server *http.Server, metricsServer *http.Server) {
+	log.Printf("Shutting down, waiting up to %s for requests to finish", shutdownTimeout)
+
+	ctx, cancel := context.WithTimeout(context.Background(), shutdownTimeout)
+	defer cancel()
+
+	if err := server.Shutdown(ctx); err != nil {
+		log.Printf("Failed to finish requests in progress: %v", err)
+	}
+	if metricsServer != nil {
+		if err := metricsServer.Shutdown(ctx); err != nil {
+			log.Printf("Failed to stop the metrics server: %v", err)
+		}
+	}
+
+	api.CloseHub("server restarting")
+	api.StopSessionCleanup()
+
+	if err := db.Connection.WaitForWrites(ctx); err != nil {
+		log.Printf("Failed to finish database writes in progress: %v", err)
+	}
+	if err := db.Connection.Close(); err != nil {
+		log.Printf("Failed to close database: %v", err)
+	}
+
+	log.Println("Server stopped")
 }
 
 func loadEnvironment() {
@@ -66,6 +140,25 @@ func loadEnvironment() {
 		staticDir = envStaticDir
 	}
 
+	api.MetricsToken = os.Getenv("METRICS_TOKEN")
+
+	envPublicURL := os.Getenv("PUBLIC_URL")
+	if envPublicURL != "" {
+		api.PublicURL = strings.TrimSuffix(envPublicURL, "/")
+		api.AllowedOrigins = []string{api.PublicURL}
+	}
+
+	// Comma separated, e.g. "https://example.com,https://www.example.com"
+	envAllowedOrigins := os.Getenv("ALLOWED_ORIGINS")
+	if envAllowedOrigins != "" {
+		api.AllowedOrigins = nil
+		for _, origin := range strings.Split(envAllowedOrigins, ",") {
+			if origin = strings.TrimSuffix(strings.TrimSpace(origin), "/"); origin != "" {
+				api.AllowedOrigins = append(api.AllowedOrigins, origin)
+			}
+		}
+	}
+
 	// this will be used later on in docker build, in dev it's not used
 	if _, err := os.Stat(staticDir); os.IsNotExist(err) {
 		if err := os.Mkdir(staticDir, 0755); err != nil {
@@ -81,14 +174,165 @@ func loadEnvironment() {
 	}
 }
 
-func genDevToken() {
-	c := api.Claims{
-		Email: "admin@test.fake",
-		Id:    1,
+// setupLogging logs JSON lines to stdout, at LOG_LEVEL (debug, info, warn or error, default info).
+// The log package goes through it too, and secret attributes are redacted.
+func setupLogging() {
+	var level slog.Level
+	if err := level.UnmarshalText([]byte(os.Getenv("LOG_LEVEL"))); err != nil {
+		level = slog.LevelInfo
+	}
+
+	slog.SetDefault(slog.New(slog.NewJSONHandler(os.Stdout, &slog.HandlerOptions{
+		Level:       level,
+		ReplaceAttr: api.RedactSecrets,
+	})))
+}
+
+// setupMetrics serves /metrics on METRICS_ADDR (e.g. 127.0.0.1:9090) when it is set, so it can be kept
+// off the public port. With only METRICS_TOKEN it is served on the API port to whoever has the token.
+// With neither it is served on defaultMetricsAddr, which only the machine itself can reach.
+func setupMetrics() *http.Server {
+	addr := os.Getenv("METRICS_ADDR")
+	if addr == "" && api.MetricsToken != "" {
+		http.HandleFunc("/metrics", api.Metrics)
+		return nil
+	}
+
+	fatal := addr != "" // the default address may be taken, that shouldn't stop the server
+	if addr == "" {
+		addr = defaultMetricsAddr
+	}
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("/metrics", api.Metrics)
+	server := &http.Server{Addr: addr, Handler: mux}
+	go func() {
+		log.Printf("Metrics served on %s", addr)
+		err := server.ListenAndServe()
+		if err != http.ErrServerClosed && fatal {
+			log.Fatal(err)
+		} else if err != http.ErrServerClosed {
+			log.Printf("Metrics not served: %v", err)
+		}
+	}()
+	return server
+}
+
+// setupMailer sends emails over SMTP when SMTP_HOST is set,
+// otherwise they are written to mail.log in the data dir
+func setupMailer() {
+	smtpHost := os.Getenv("SMTP_HOST")
+	if smtpHost == "" {
+		api.Mail = &api.FileMailer{Path: dataDir + "/mail.log"}
+		log.Printf("SMTP_HOST not set, emails are written to %s/mail.log", dataDir)
+		return
+	}
+
+	smtpPort := os.Getenv("SMTP_PORT")
+	if smtpPort == "" {
+		smtpPort = "587"
+	}
+
+	from := os.Getenv("MAIL_FROM")
+	if from == "" {
+		from = "no-reply@" + smtpHost
+	}
+
+	api.Mail = &api.SMTPMailer{
+		Host:     smtpHost,
+		Port:     smtpPort,
+		Username: os.Getenv("SMTP_USERNAME"),
+		Password: os.Getenv("SMTP_PASSWORD"),
+		From:     from,
+	}
+}
+
+// setupOIDC enables login with an OpenID Connect provider when OIDC_ISSUER is set
+func setupOIDC() {
+	issuer := os.Getenv("OIDC_ISSUER")
+	if issuer == "" {
+		return
 	}
 
-	sig, _ := c.GetBearer()
-	log.Printf("Authorization: %s\n", *sig)
+	clientID := os.Getenv("OIDC_CLIENT_ID")
+	if clientID == "" {
+		log.Fatal("OIDC_CLIENT_ID is required when OIDC_ISSUER is set")
+	}
+
+	redirectURL := os.Getenv("OIDC_REDIRECT_URL")
+	if redirectURL == "" {
+		redirectURL = api.PublicURL + "/oidc-callback"
+	}
+
+	api.OIDC = api.NewOIDCProvider(issuer, clientID, os.Getenv("OIDC_CLIENT_SECRET"), redirectURL)
+	log.Printf("OIDC login enabled with %s", issuer)
+}
+
+// setupPasswordPolicy applies PASSWORD_MIN_LENGTH and PASSWORD_MIN_SCORE and loads
+// the breached password list from the data dir, if there is one
+func setupPasswordPolicy() {
+	if minLength, err := strconv.Atoi(os.Getenv("PASSWORD_MIN_LENGTH")); err == nil {
+		api.Passwords.MinLength = minLength
+	}
+	if minScore, err := strconv.Atoi(os.Getenv("PASSWORD_MIN_SCORE")); err == nil {
+		api.Passwords.MinScore = minScore
+	}
+
+	path := dataDir + "/breached-passwords.txt"
+	list, err := api.LoadBreachedPasswords(path)
+	if err != nil {
+		if os.IsNotExist(err) {
+			log.Printf("No breached password list at %s, passwords are not checked against breaches", path)
+			return
+		}
+		log.Fatalf("Failed to load breached password list: %v", err)
+	}
+
+	api.BreachedPasswords = list
+	log.Printf("Loaded %d breached password hashes", list.Len())
+}
+
+// runCommand runs an admin command, e.g. `server rotate-keys -grace 24h` or `server set-role -email a@b.c -role admin`
+func runCommand(args []string) {
+	switch args[0] {
+	case "rotate-keys":
+		// Rotates the signing key, a running server picks up the new key within a minute
+		flags := flag.NewFlagSet("rotate-keys", flag.ExitOnError)
+		grace := flags.Duration("grace", api.DefaultKeyGracePeriod, "how long tokens signed with the retired key stay valid")
+		flags.Parse(args[1:])
+
+		keyID, err := api.RotateKeys(dataDir, *grace)
+		if err != nil {
+			log.Fatalf("Failed to rotate signing keys: %v", err)
+		}
+		fmt.Printf("New active signing key: %s (retired key valid for %s)\n", keyID, *grace)
+	case "set-role":
+		// Grants a role, e.g. to make the first admin who can then manage the others through the API
+		flags := flag.NewFlagSet("set-role", flag.ExitOnError)
+		email := flags.String("email", "", "email of the user")
+		role := flags.String("role", api.RoleAdmin, "user, moderator or admin")
+		flags.Parse(args[1:])
+
+		if *email == "" || !api.ValidRole(*role) {
+			log.Fatal("Usage: set-role -email user@example.com -role user|moderator|admin")
+		}
+
+		if err := db.Connection.OpenOrCreate(dataDir+"/social-backend.db", "database-migrations"); err != nil {
+			log.Fatalf("Failed to open database connection: %v", err)
+		}
+		defer db.Connection.Close()
+
+		found, err := db.Connection.SetUserRoleByEmail(*email, *role)
+		if err != nil {
+			log.Fatalf("Failed to set role: %v", err)
+		}
+		if !found {
+			log.Fatalf("No user with email %s", *email)
+		}
+		fmt.Printf("%s is now %s\n", *email, *role)
+	default:
+		log.Fatalf("Unknown command %q (available: rotate-keys, set-role)", args[0])
+	}
 }
 
 func testDB() {
@@ -0,0 +1,295 @@
+// Package metrics keeps counters, gauges and histograms and writes them in the Prometheus text format.
+//
+// It stands in for github.com/prometheus/client_golang, which would add protobuf and a dozen other
+// modules to a backend that otherwise only depends on its database driver, migrations and websocket
+// library, for the three metric types the server uses. It only writes the text format 0.0.4, which
+// every Prometheus version scrapes, and has no summaries, exemplars or native histograms.
+package metrics
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"math"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// DefaultBuckets are the upper bounds in seconds of the histograms of request latencies
+var DefaultBuckets = []float64{.005, .01, .025, .05, .1, .25, .5, 1, 2.5, 5, 10}
+
+// QueryBuckets are the upper bounds in seconds of the histograms of database latencies
+var QueryBuckets = []float64{.0001, .00025, .0005, .001, .0025, .005, .01, .025, .05, .1, .25, 1}
+
+// metric is a family of series with the same name
+type metric interface {
+	describe() *desc
+	write(w *bufio.Writer)
+}
+
+// Registry holds the metrics of the server
+type Registry struct {
+	metrics map[string]metric
+	mutex   sync.Mutex
+}
+
+// Default is the registry the New functions add to
+var Default = &Registry{metrics: make(map[string]metric)}
+
+// register adds a metric, two metrics with the same name are a programming error
+func (r *Registry) register(m metric) {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+
+	name := m.describe().name
+	if _, exists := r.metrics[name]; exists {
+		panic("metric registered twice: " + name)
+	}
+	r.metrics[name] = m
+}
+
+// WriteTo writes every metric in the Prometheus text format, sorted by name
+func (r *Registry) WriteTo(out io.Writer) (int64, error) {
+	r.mutex.Lock()
+	metrics := make([]metric, 0, len(r.metrics))
+	for _, m := range r.metrics {
+		metrics = append(metrics, m)
+	}
+	r.mutex.Unlock()
+
+	sort.Slice(metrics, func(i, j int) bool { return metrics[i].describe().name < metrics[j].describe().name })
+
+	counter := &countingWriter{out: out}
+	w := bufio.NewWriter(counter)
+	for _, m := range metrics {
+		d := m.describe()
+		fmt.Fprintf(w, "# HELP %s %s\n# TYPE %s %s\n", d.name, escapeHelp(d.help), d.name, d.kind)
+		m.write(w)
+	}
+	err := w.Flush()
+	return counter.n, err
+}
+
+// desc is what a family is: its name, help text, type and label names
+type desc struct {
+	name   string
+	help   string
+	kind   string
+	labels []string
+}
+
+func (d *desc) describe() *desc {
+	return d
+}
+
+// key identifies a series by its label values, checking there is one for each label
+func (d *desc) key(values []string) string {
+	if len(values) != len(d.labels) {
+		panic(fmt.Sprintf("metric %s has labels %v, got %d values", d.name, d.labels, len(values)))
+	}
+	return strings.Join(values, "\xff")
+}
+
+// labelPairs formats the labels of a series, e.g. {action="login",status="200"}, with extra
+// name and value pairs after them
+func (d *desc) labelPairs(values []string, extra ...string) string {
+	if len(values) == 0 && len(extra) == 0 {
+		return ""
+	}
+
+	var pairs []string
+	for i, value := range values {
+		pairs = append(pairs, d.labels[i]+`="`+escapeLabel(value)+`"`)
+	}
+	for i := 0; i+1 < len(extra); i += 2 {
+		pairs = append(pairs, extra[i]+`="`+escapeLabel(extra[i+1])+`"`)
+	}
+	return "{" + strings.Join(pairs, ",") + "}"
+}
+
+// Counter is a family of values that only go up, one per combination of label values
+type Counter struct {
+	desc
+	series map[string]*counterSeries
+	mutex  sync.Mutex
+}
+
+type counterSeries struct {
+	labels []string
+	value  float64
+}
+
+// NewCounter registers a counter in the default registry
+func NewCounter(name string, help string, labels ...string) *Counter {
+	c := &Counter{
+		desc:   desc{name: name, help: help, kind: "counter", labels: labels},
+		series: make(map[string]*counterSeries),
+	}
+	Default.register(c)
+	return c
+}
+
+// Inc adds one to the series with these label values
+func (c *Counter) Inc(labels ...string) {
+	c.Add(1, labels...)
+}
+
+// Add adds a value, which must not be negative, to the series with these label values
+func (c *Counter) Add(value float64, labels ...string) {
+	key := c.key(labels)
+
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+
+	s, exists := c.series[key]
+	if !exists {
+		s = &counterSeries{labels: labels}
+		c.series[key] = s
+	}
+	s.value += value
+}
+
+func (c *Counter) write(w *bufio.Writer) {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+
+	for _, key := range sortedKeys(c.series) {
+		s := c.series[key]
+		fmt.Fprintf(w, "%s%s %s\n", c.name, c.labelPairs(s.labels), formatValue(s.value))
+	}
+}
+
+// GaugeFunc is a value read when the metrics are scraped, like the number of connected clients
+type GaugeFunc struct {
+	desc
+	value func() float64
+}
+
+// NewGaugeFunc registers a gauge in the default registry
+func NewGaugeFunc(name string, help string, value func() float64) *GaugeFunc {
+	g := &GaugeFunc{
+		desc:  desc{name: name, help: help, kind: "gauge"},
+		value: value,
+	}
+	Default.register(g)
+	return g
+}
+
+func (g *GaugeFunc) write(w *bufio.Writer) {
+	fmt.Fprintf(w, "%s %s\n", g.name, formatValue(g.value()))
+}
+
+// Histogram is a family of distributions, like latencies, counted in buckets
+type Histogram struct {
+	desc
+	buckets []float64
+	series  map[string]*histogramSeries
+	mutex   sync.Mutex
+}
+
+type histogramSeries struct {
+	labels []string
+	counts []uint64 // per bucket, not cumulative
+	sum    float64
+	count  uint64
+}
+
+// NewHistogram registers a histogram in the default registry. buckets are upper bounds, in increasing order.
+func NewHistogram(name string, help string, buckets []float64, labels ...string) *Histogram {
+	h := &Histogram{
+		desc:    desc{name: name, help: help, kind: "histogram", labels: labels},
+		buckets: buckets,
+		series:  make(map[string]*histogramSeries),
+	}
+	Default.register(h)
+	return h
+}
+
+// Observe adds a value to the distribution with these label values
+func (h *Histogram) Observe(value float64, labels ...string) {
+	key := h.key(labels)
+	bucket := sort.SearchFloat64s(h.buckets, value) // the first bound >= value, len(buckets) for +Inf
+
+	h.mutex.Lock()
+	defer h.mutex.Unlock()
+
+	s, exists := h.series[key]
+	if !exists {
+		s = &histogramSeries{labels: labels, counts: make([]uint64, len(h.buckets)+1)}
+		h.series[key] = s
+	}
+	s.counts[bucket]++
+	s.sum += value
+	s.count++
+}
+
+// ObserveDuration adds a duration in seconds
+func (h *Histogram) ObserveDuration(duration time.Duration, labels ...string) {
+	h.Observe(duration.Seconds(), labels...)
+}
+
+func (h *Histogram) write(w *bufio.Writer) {
+	h.mutex.Lock()
+	defer h.mutex.Unlock()
+
+	for _, key := range sortedKeys(h.series) {
+		s := h.series[key]
+
+		var cumulative uint64
+		for i, bound := range h.buckets {
+			cumulative += s.counts[i]
+			fmt.Fprintf(w, "%s_bucket%s %d\n", h.name, h.labelPairs(s.labels, "le", formatValue(bound)), cumulative)
+		}
+		fmt.Fprintf(w, "%s_bucket%s %d\n", h.name, h.labelPairs(s.labels, "le", "+Inf"), s.count)
+		fmt.Fprintf(w, "%s_sum%s %s\n", h.name, h.labelPairs(s.labels), formatValue(s.sum))
+		fmt.Fprintf(w, "%s_count%s %d\n", h.name, h.labelPairs(s.labels), s.count)
+	}
+}
+
+func sortedKeys[V any](series map[string]V) []string {
+	keys := make([]string, 0, len(series))
+	for key := range series {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+func formatValue(value float64) string {
+	switch {
+	case math.IsNaN(value):
+		return "NaN"
+	case math.IsInf(value, 1):
+		return "+Inf"
+	case math.IsInf(value, -1):
+		return "-Inf"
+	}
+	return strconv.FormatFloat(value, 'g', -1, 64)
+}
+
+var (
+	helpEscaper  = strings.NewReplacer(`\`, `\\`, "\n", `\n`)
+	labelEscaper = strings.NewReplacer(`\`, `\\`, "\n", `\n`, `"`, `\"`)
+)
+
+func escapeHelp(help string) string {
+	return helpEscaper.Replace(help)
+}
+
+func escapeLabel(value string) string {
+	return labelEscaper.Replace(value)
+}
+
+type countingWriter struct {
+	out io.Writer
+	n   int64
+}
+
+func (c *countingWriter) Write(b []byte) (int, error) {
+	n, err := c.out.Write(b)
+	c.n += int64(n)
+	return n, err
+}
@@ -0,0 +1,165 @@
+package metrics
+
+import (
+	"math"
+	"strings"
+	"testing"
+	"time"
+)
+
+// useRegistry makes the New functions register in an empty registry for the test
+func useRegistry(t *testing.T) *Registry {
+	previous := Default
+	Default = &Registry{metrics: make(map[string]metric)}
+	t.Cleanup(func() { Default = previous })
+	return Default
+}
+
+func scrape(t *testing.T, r *Registry) string {
+	t.Helper()
+
+	var out strings.Builder
+	n, err := r.WriteTo(&out)
+	if err != nil {
+		t.Fatalf("WriteTo failed: %v", err)
+	}
+	if n != int64(out.Len()) {
+		t.Errorf("WriteTo reported %d bytes, wrote %d", n, out.Len())
+	}
+	return out.String()
+}
+
+func TestWriteTo(t *testing.T) {
+	tests := []struct {
+		name   string
+		record func()
+		want   string
+	}{
+		{"counter without series", func() {
+			NewCounter("requests_total", "Requests", "action")
+		}, `# HELP requests_total Requests
+# TYPE requests_total counter
+`},
+		{"counter", func() {
+			c := NewCounter("requests_total", "Requests by action and status", "action", "status")
+			c.Inc("login", "200")
+			c.Inc("login", "200")
+			c.Add(2.5, "get_posts", "500")
+			c.Inc("get_posts", "200")
+		}, `# HELP requests_total Requests by action and status
+# TYPE requests_total counter
+requests_total{action="get_posts",status="200"} 1
+requests_total{action="get_posts",status="500"} 2.5
+requests_total{action="login",status="200"} 2
+`},
+		{"counter without labels", func() {
+			NewCounter("restarts_total", "Restarts").Inc()
+		}, `# HELP restarts_total Restarts
+# TYPE restarts_total counter
+restarts_total 1
+`},
+		{"gauge", func() {
+			NewGaugeFunc("clients", "Connected clients", func() float64 { return 3 })
+		}, `# HELP clients Connected clients
+# TYPE clients gauge
+clients 3
+`},
+		{"special values", func() {
+			NewGaugeFunc("a_nan", "Not a number", math.NaN)
+			NewGaugeFunc("b_inf", "Infinite", func() float64 { return math.Inf(1) })
+			NewGaugeFunc("c_small", "Small", func() float64 { return 0.000125 })
+			NewGaugeFunc("d_large", "Large", func() float64 { return 12345678901 })
+		}, `# HELP a_nan Not a number
+# TYPE a_nan gauge
+a_nan NaN
+# HELP b_inf Infinite
+# TYPE b_inf gauge
+b_inf +Inf
+# HELP c_small Small
+# TYPE c_small gauge
+c_small 0.000125
+# HELP d_large Large
+# TYPE d_large gauge
+d_large 1.2345678901e+10
+`},
+		{"histogram", func() {
+			h := NewHistogram("duration_seconds", "Durations", []float64{.1, .5, 1}, "action")
+			h.Observe(.05, "login")
+			h.Observe(.1, "login") // a bucket includes its bound
+			h.ObserveDuration(700*time.Millisecond, "login")
+			h.Observe(3, "login")
+			h.Observe(.2, "get_posts")
+		}, `# HELP duration_seconds Durations
+# TYPE duration_seconds histogram
+duration_seconds_bucket{action="get_posts",le="0.1"} 0
+duration_seconds_bucket{action="get_posts",le="0.5"} 1
+duration_seconds_bucket{action="get_posts",le="1"} 1
+duration_seconds_bucket{action="get_posts",le="+Inf"} 1
+duration_seconds_sum{action="get_posts"} 0.2
+duration_seconds_count{action="get_posts"} 1
+duration_seconds_bucket{action="login",le="0.1"} 2
+duration_seconds_bucket{action="login",le="0.5"} 2
+duration_seconds_bucket{action="login",le="1"} 3
+duration_seconds_bucket{action="login",le="+Inf"} 4
+duration_seconds_sum{action="login"} 3.85
+duration_seconds_count{action="login"} 4
+`},
+		{"escaping", func() {
+			NewCounter("escaped_total", "Help with \\ and\na new line", "value").Inc("quote \" backslash \\ new line \n")
+		}, `# HELP escaped_total Help with \\ and\na new line
+# TYPE escaped_total counter
+escaped_total{value="quote \" backslash \\ new line \n"} 1
+`},
+		{"sorted by name", func() {
+			NewGaugeFunc("zeta", "Last", func() float64 { return 1 })
+			NewGaugeFunc("alpha", "First", func() float64 { return 2 })
+		}, `# HELP alpha First
+# TYPE alpha gauge
+alpha 2
+# HELP zeta Last
+# TYPE zeta gauge
+zeta 1
+`},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			r := useRegistry(t)
+			test.record()
+
+			if got := scrape(t, r); got != test.want {
+				t.Errorf("Got\n%s\nwant\n%s", got, test.want)
+			}
+		})
+	}
+}
+
+func TestMisuse(t *testing.T) {
+	tests := []struct {
+		name   string
+		misuse func()
+	}{
+		{"registered twice", func() {
+			NewCounter("twice_total", "Twice")
+			NewGaugeFunc("twice_total", "Twice", func() float64 { return 0 })
+		}},
+		{"missing label value", func() {
+			NewCounter("labels_total", "Labels", "action", "status").Inc("login")
+		}},
+		{"extra label value", func() {
+			NewHistogram("labels_seconds", "Labels", DefaultBuckets).Observe(1, "login")
+		}},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			useRegistry(t)
+			defer func() {
+				if recover() == nil {
+					t.Errorf("Expected a panic")
+				}
+			}()
+			test.misuse()
+		})
+	}
+}
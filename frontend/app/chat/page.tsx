@@ -2,7 +2,7 @@
 
 import React, { useState, useEffect, useRef } from 'react'
 import './chat-view.css'
-import { getUserInfo } from '@/hooks/Auth'
+import { csrfHeaders, getUserInfo } from '@/hooks/Auth'
 import Spinner from '@/components/Spinner'
 import { useAuth } from '@/components/AuthContext'
 
@@ -92,16 +92,20 @@ function ChatView() {
           method: 'POST',
           headers: {
             'Content-Type': 'application/json',
-            'Authorization': `Bearer ${authToken}`
+            'Authorization': `Bearer ${authToken}`,
+            ...csrfHeaders()
           },
+          credentials: 'include',
           body: JSON.stringify({ action: 'get_followers' })
         }),
         fetch('http://localhost:8080/api', {
           method: 'POST',
           headers: {
             'Content-Type': 'application/json',
-            'Authorization': `Bearer ${authToken}`
+            'Authorization': `Bearer ${authToken}`,
+            ...csrfHeaders()
           },
+          credentials: 'include',
           body: JSON.stringify({ action: 'get_following' })
         })
       ])
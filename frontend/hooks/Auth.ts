@@ -112,7 +112,7 @@ export function Logout(): void {
   // Call logout API to clear server session
   fetch(`${API_BASE_URL}/api`, {
     method: 'POST',
-    headers: {'Content-Type': 'application/json'},
+    headers: {'Content-Type': 'application/json', ...csrfHeaders()},
     credentials: 'include',
     body: JSON.stringify({action: 'logout'}),
   }).catch(error => {
@@ -155,6 +155,17 @@ export function getAuthToken(): string | null {
   return null
 }
 
+// Requests authenticated with the session cookie must carry the CSRF token from the login response
+export function csrfHeaders(): {[key: string]: string} {
+  if (typeof window == 'undefined') return {}
+  try {
+    const parsed = JSON.parse(localStorage.getItem('token') || '{}')
+    return parsed.csrfToken ? {'X-CSRF-Token': parsed.csrfToken} : {}
+  } catch (e) {
+    return {}
+  }
+}
+
 export function getUserInfo(): UserInfo | null {
   const storedData = localStorage.getItem('token')
   if (!storedData) return null
@@ -172,6 +183,7 @@ export async function apiRequest<T>(endpoint: string, body?: any, method: string
   const headers: {[key: string]: string} = {
     'Content-Type': 'application/json',
     ...(authToken ? {Authorization: `Bearer ${authToken}`} : {}),
+    ...csrfHeaders(),
   }
 
   try {
@@ -8,7 +8,7 @@
  */
 
 const API_BASE = 'http://localhost:8080/api';
-import { getAuthToken } from '@/hooks/Auth';
+import { csrfHeaders, getAuthToken } from '@/hooks/Auth';
 
 export interface Post {
   id: number;
@@ -139,7 +139,8 @@ export const api = {
         method: 'POST',
         headers: {
           'Content-Type': 'application/json',
-          'Authorization': `Bearer ${token}`
+          'Authorization': `Bearer ${token}`,
+          ...csrfHeaders()
         },
         credentials: 'include',
         body: JSON.stringify({
@@ -181,7 +182,8 @@ export const api = {
         method: 'POST',
         headers: {
           'Content-Type': 'application/json',
-          'Authorization': `Bearer ${token}`
+          'Authorization': `Bearer ${token}`,
+          ...csrfHeaders()
         },
         credentials: 'include', // Include cookies
         body: JSON.stringify({
@@ -222,7 +224,8 @@ export const api = {
         method: 'POST',
         headers: {
           'Content-Type': 'application/json',
-          'Authorization': `Bearer ${token}`
+          'Authorization': `Bearer ${token}`,
+          ...csrfHeaders()
         },
         credentials: 'include',
         body: JSON.stringify({
@@ -250,8 +253,10 @@ export const api = {
       method: 'POST',
       headers: {
         'Content-Type': 'application/json',
-        'Authorization': `Bearer ${api.getToken()}`
+        'Authorization': `Bearer ${api.getToken()}`,
+        ...csrfHeaders()
       },
+      credentials: 'include',
       body: JSON.stringify({
         action: 'toggle_like',
         postId
@@ -292,8 +297,10 @@ export const api = {
       method: 'POST',
       headers: {
         'Content-Type': 'application/json',
-        'Authorization': `Bearer ${api.getToken()}`
+        'Authorization': `Bearer ${api.getToken()}`,
+        ...csrfHeaders()
       },
+      credentials: 'include',
       body: JSON.stringify({
         action: 'create_comment',
         postId,
@@ -317,8 +324,10 @@ export const api = {
       method: 'POST',
       headers: {
         'Content-Type': 'application/json',
-        'Authorization': `Bearer ${api.getToken()}`
+        'Authorization': `Bearer ${api.getToken()}`,
+        ...csrfHeaders()
       },
+      credentials: 'include',
       body: JSON.stringify({
         action: 'get_comments',
         postId
@@ -338,8 +347,10 @@ export const api = {
       method: 'POST',
       headers: {
         'Content-Type': 'application/json',
-        'Authorization': `Bearer ${api.getToken()}`
+        'Authorization': `Bearer ${api.getToken()}`,
+        ...csrfHeaders()
       },
+      credentials: 'include',
       body: JSON.stringify({
         action: 'get_followers'
       })
@@ -358,8 +369,10 @@ export const api = {
       method: 'POST',
       headers: {
         'Content-Type': 'application/json',
-        'Authorization': `Bearer ${api.getToken()}`
+        'Authorization': `Bearer ${api.getToken()}`,
+        ...csrfHeaders()
       },
+      credentials: 'include',
       body: JSON.stringify({
         action: 'get_followers',
         ...(userId && { userId })
@@ -379,8 +392,10 @@ export const api = {
       method: 'POST',
       headers: {
         'Content-Type': 'application/json',
-        'Authorization': `Bearer ${api.getToken()}`
+        'Authorization': `Bearer ${api.getToken()}`,
+        ...csrfHeaders()
       },
+      credentials: 'include',
       body: JSON.stringify({
         action: 'get_following',
         ...(userId && { userId })
@@ -412,7 +427,8 @@ export const api = {
         method: 'POST',
         headers: {
           'Content-Type': 'application/json',
-          'Authorization': `Bearer ${token}`
+          'Authorization': `Bearer ${token}`,
+          ...csrfHeaders()
         },
         credentials: 'include',
         body: JSON.stringify({
@@ -454,7 +470,8 @@ export const api = {
             method: 'POST',
             headers: {
               'Content-Type': 'application/json',
-              'Authorization': `Bearer ${token}`
+              'Authorization': `Bearer ${token}`,
+              ...csrfHeaders()
             },
             credentials: 'include',
             body: JSON.stringify({
@@ -500,7 +517,8 @@ export const api = {
         method: 'POST',
         headers: {
           'Content-Type': 'application/json',
-          'Authorization': `Bearer ${token}`
+          'Authorization': `Bearer ${token}`,
+          ...csrfHeaders()
         },
         credentials: 'include',
         body: JSON.stringify({
@@ -535,7 +553,8 @@ export const api = {
         method: 'POST',
         headers: {
           'Content-Type': 'application/json',
-          'Authorization': `Bearer ${token}`
+          'Authorization': `Bearer ${token}`,
+          ...csrfHeaders()
         },
         credentials: 'include',
         body: JSON.stringify({
@@ -570,7 +589,8 @@ export const api = {
         method: 'POST',
         headers: {
           'Content-Type': 'application/json',
-          'Authorization': `Bearer ${token}`
+          'Authorization': `Bearer ${token}`,
+          ...csrfHeaders()
         },
         credentials: 'include',
         body: JSON.stringify({
@@ -605,7 +625,8 @@ export const api = {
         method: 'POST',
         headers: {
           'Content-Type': 'application/json',
-          'Authorization': `Bearer ${token}`
+          'Authorization': `Bearer ${token}`,
+          ...csrfHeaders()
         },
         credentials: 'include',
         body: JSON.stringify({
@@ -639,7 +660,8 @@ export const api = {
         method: 'POST',
         headers: {
           'Content-Type': 'application/json',
-          'Authorization': `Bearer ${token}`
+          'Authorization': `Bearer ${token}`,
+          ...csrfHeaders()
         },
         credentials: 'include',
         body: JSON.stringify({
@@ -674,7 +696,8 @@ export const api = {
         method: 'POST',
         headers: {
           'Content-Type': 'application/json',
-          'Authorization': `Bearer ${token}`
+          'Authorization': `Bearer ${token}`,
+          ...csrfHeaders()
         },
         credentials: 'include',
         body: JSON.stringify({
@@ -710,7 +733,8 @@ export const api = {
         method: 'POST',
         headers: {
           'Content-Type': 'application/json',
-          'Authorization': `Bearer ${token}`
+          'Authorization': `Bearer ${token}`,
+          ...csrfHeaders()
         },
         credentials: 'include',
         body: JSON.stringify({
@@ -758,7 +782,8 @@ export const api = {
         method: 'POST',
         headers: {
           'Content-Type': 'application/json',
-          'Authorization': `Bearer ${token}`
+          'Authorization': `Bearer ${token}`,
+          ...csrfHeaders()
         },
         credentials: 'include',
         body: JSON.stringify({